@@ -18,11 +18,11 @@ import (
 )
 
 const (
-	Version = uint32(70015)
+	Version = uint32(70016)
 )
 
 var (
-	Services uint64 = btc.SERVICE_SEGWIT | btc.SERVICE_NETWORK_LIMITED // It updates this value in InitConfig()
+	services uint64 = btc.SERVICE_SEGWIT | btc.SERVICE_NETWORK_LIMITED // It updates this value in InitConfig()
 
 	BlockChain     *chain.Chain
 	GenesisBlock   *btc.Uint256
@@ -61,6 +61,8 @@ var (
 
 	cfgRouteMinFeePerKB, cfgFeePerKB         uint64 // these are from the config file
 	minFeePerKB                              uint64 // this one is dynamic, controled by mempool limit size
+	dustRelayFeePerKB                        uint64
+	incrementalFeePerKB                      uint64 // BIP125 rule 4
 	maxMempoolSizeBytes                      uint64
 	MaxRejectedSizeBytes, MaxNoUtxoSizeBytes uint64
 
@@ -367,7 +369,7 @@ func AcceptTx() (res bool) {
 func UpdateScriptFlags(flags uint32) {
 	if flags == 0 {
 		// We pass timestamp of 0 to always use P2SH flag
-		flags = BlockChain.GetBlockFlags(Last.Block.Height, 0)
+		flags = BlockChain.GetBlockFlags(Last.Block.Height, 0) | BlockChain.DeploymentFlags(Last.Block)
 	}
 	atomic.StoreUint32(&Last.ScriptFlags, flags)
 }
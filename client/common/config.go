@@ -1,6 +1,7 @@
 package common
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/piotrnar/gocoin"
 	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
 	"github.com/piotrnar/gocoin/lib/others/memory"
 	"github.com/piotrnar/gocoin/lib/others/sys"
 	"github.com/piotrnar/gocoin/lib/utxo"
@@ -21,6 +23,8 @@ import (
 const LastTrustedBTCBlock = "000000000000000000002afe1e2f7e176047529419532b2a6773c45623a02c12" // #940000
 const LastTrustedTN3Block = "00000000000000fa9c23f20506e6c57b6dda928fb2110629bf5d29df2f737ad2" // #3800000
 const LastTrustedTN4Block = "0000000000000001ec9e940cf55cbd067839434d9b394710363d18182f8dc9f4" // #122940
+const LastTrustedRegBlock = "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206" // genesis
+const LastTrustedSigBlock = "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6" // genesis
 
 var (
 	ConfigFile string = "gocoin.conf"
@@ -40,12 +44,20 @@ var (
 	CFG struct { // Options that can come from either command line or common file
 		Testnet          bool
 		Testnet4         bool
+		Regtest          bool               // Testnet is also set when this one is
+		Signet           bool               // Testnet is also set when this one is
+		SignetChallenge  string             // hex encoded challenge script of a custom signet (empty for the default one)
+		SignetKey        string             // WIF private key used by "generate" commands to sign blocks on a custom signet
+		Deployments      []chain.Deployment // Additional BIP9/BIP8 soft fork deployments (only on regtest or a custom signet)
 		ConnectOnly      string
 		Datadir          string
 		UtxoSubdir       string
 		TextUI_Enabled   bool
 		UserAgent        string
 		LastTrustedBlock string
+		BlockFilters     bool // Keep BIP158 filters index and serve it to peers (BIP157)
+		TxIndex          bool // Keep index of all the transactions (getrawtransaction without block hash)
+		AddrIndex        bool // Keep history of all the addresses (getaddresshistory, addrhist.json)
 
 		WebUI struct {
 			Interface   string
@@ -58,10 +70,12 @@ var (
 			SSLPort     uint16
 		}
 		RPC struct {
-			Enabled  bool
-			Username string
-			Password string
-			TCPPort  uint32
+			Enabled   bool
+			Username  string
+			Password  string // if empty, only the cookie file and Auth entries can be used
+			TCPPort   uint32
+			Auth      []string // "user:salt$hash" entries, as made by Core's share/rpcauth/rpcauth.py
+			Whitelist []string // "user:method1,method2,..." - if present for the user, only these methods are allowed
 		}
 		Net struct {
 			ListenTCP      bool
@@ -73,19 +87,39 @@ var (
 			MaxDownKBps    uint
 			MaxBlockAtOnce uint32
 			ExternalIP     string
+			V2Transport    bool   // BIP324 encrypted transport
+			Proxy          string // SOCKS5 proxy (host:port) for outgoing connections - e.g. Tor at 127.0.0.1:9050
+			OnionProxy     string // SOCKS5 proxy for .onion peers (if empty, Proxy is used)
+			ProxyRandomize bool   // Use random credentials for each proxy connection (Tor stream isolation)
+			TorControl     string // Tor control port (host:port) - if set, publish our own onion service
+			TorPassword    string // Tor control port password (if HASHEDPASSWORD authentication is used)
+			TxRecon        bool   // Erlay (BIP330) - reconcile txs with the peers that support it, instead of flooding
+			PkgRelay       bool   // Package relay (BIP331) - fetch missing parents of txs as ancestor packages
 		}
 		TXPool struct {
-			Enabled        bool // Global on/off swicth
-			AllowMemInputs bool
-			FeePerByte     float64
-			MaxTxWeight    uint32
-			MaxSizeMB      uint
-			ExpireInDays   uint
-			MaxRejectMB    float64
-			MaxNoUtxoMB    float64
-			RejectRecCnt   uint16
-			SaveOnDisk     bool
-			NotFullRBF     bool
+			Enabled          bool // Global on/off swicth
+			AllowMemInputs   bool
+			FeePerByte       float64
+			MaxTxWeight      uint32
+			MaxSizeMB        uint
+			ExpireInDays     uint
+			MaxRejectMB      float64
+			MaxNoUtxoMB      float64
+			RejectRecCnt     uint16
+			SaveOnDisk       bool
+			NotFullRBF       bool
+			AcceptNonStd     bool    // Do not apply the standardness policy to the relayed txs
+			DustRelayFee     float64 // SPB rate used to calculate the dust threshold of outputs
+			IncrementalFee   float64 // SPB rate that a replacement must pay for its own size, on top of the replaced txs' fees
+			DataCarrier      bool    // Relay txs with OP_RETURN (data carrier) outputs
+			DataCarrierLen   uint32  // Max size of all OP_RETURN scripts in one tx
+			BareMultisig     bool    // Relay txs with bare (non-P2SH) multisig outputs
+			AncestorCount    uint32  // Max number of unconfirmed ancestors of a tx (including itself)
+			AncestorSizeKB   uint32  // Max total vsize (in kvB) of a tx with all its unconfirmed ancestors
+			DescendantCount  uint32  // Max number of unconfirmed descendants of a tx (including itself)
+			DescendantSizeKB uint32  // Max total vsize (in kvB) of a tx with all its unconfirmed descendants
+			ClusterCount     uint32  // Max number of txs in a cluster of connected unconfirmed txs
+			ClusterSizeKB    uint32  // Max total vsize (in kvB) of a cluster of connected unconfirmed txs
 			//CheckForErrors bool
 		}
 		TXRoute struct {
@@ -131,6 +165,11 @@ var (
 			SecondsToTake   uint   // zero for as fast as possible, 600 for do it in 10 minutes
 			BlocksToHold    uint32 // zero for immediatelly, one for every other block...
 			CompressRecords bool
+			SnapshotCheck   bool // validate history below a loaded UTXO snapshot in the background
+			DiskMode        bool // keep UTXO records on disk ("utxodisk" folder), for machines with little RAM
+			DiskCacheMB     uint // in DiskMode, cache this many MB of the recently used UTXO records
+			Journal         bool // journal each block's changes in UTXO.jrn (UTXO.db saved only to trim it) - ignored in DiskMode
+			JournalMaxMB    uint // save UTXO.db when UTXO.jrn grows over this size
 		}
 	}
 
@@ -171,6 +210,10 @@ func InitConfig() {
 	CFG.Net.MaxInCons = 20
 	CFG.Net.MaxBlockAtOnce = 3
 	CFG.Net.BindToIF = "0.0.0.0"
+	CFG.Net.V2Transport = true
+	CFG.Net.ProxyRandomize = true
+	CFG.Net.TxRecon = true
+	CFG.Net.PkgRelay = true
 
 	CFG.TextUI_Enabled = true
 
@@ -194,6 +237,17 @@ func InitConfig() {
 	CFG.TXPool.MaxNoUtxoMB = 5.0
 	CFG.TXPool.RejectRecCnt = 20000
 	CFG.TXPool.SaveOnDisk = true
+	CFG.TXPool.DustRelayFee = 3.0
+	CFG.TXPool.IncrementalFee = 1.0
+	CFG.TXPool.DataCarrier = true
+	CFG.TXPool.DataCarrierLen = 100000
+	CFG.TXPool.BareMultisig = true
+	CFG.TXPool.AncestorCount = 25
+	CFG.TXPool.AncestorSizeKB = 101
+	CFG.TXPool.DescendantCount = 25
+	CFG.TXPool.DescendantSizeKB = 101
+	CFG.TXPool.ClusterCount = 64
+	CFG.TXPool.ClusterSizeKB = 101
 
 	CFG.TXRoute.Enabled = true
 	CFG.TXRoute.FeePerByte = 0.1
@@ -225,6 +279,9 @@ func InitConfig() {
 
 	CFG.UTXOSave.SecondsToTake = 300
 	CFG.UTXOSave.BlocksToHold = 6
+	CFG.UTXOSave.SnapshotCheck = true
+	CFG.UTXOSave.DiskCacheMB = 500
+	CFG.UTXOSave.JournalMaxMB = 256
 
 	if cfgfn := os.Getenv("GOCOIN_CLIENT_CONFIG"); cfgfn != "" {
 		ConfigFile = cfgfn
@@ -259,14 +316,17 @@ func InitConfig() {
 	}
 
 	var _cfg_fn string
-	var testnet3, testnet4 bool
+	var testnet3, testnet4, regtest, signet bool
 	flag.StringVar(&_cfg_fn, "cfg", ConfigFile, "Specify name of the config file")
 	flag.BoolVar(&FLAG.Rescan, "r", false, "Rebuild UTXO database (fixes 'Unknown input TxID' errors)")
 	flag.BoolVar(&FLAG.VolatileUTXO, "v", false, "Use UTXO database in volatile mode (speeds up rebuilding)")
 	flag.BoolVar(&testnet4, "t", CFG.Testnet && CFG.Testnet4, "Use Testnet4")
-	flag.BoolVar(&testnet3, "t3", CFG.Testnet && !CFG.Testnet4, "Use Testnet3")
+	flag.BoolVar(&testnet3, "t3", CFG.Testnet && !CFG.Testnet4 && !CFG.Regtest && !CFG.Signet, "Use Testnet3")
+	flag.BoolVar(&regtest, "regtest", CFG.Regtest, "Use Regtest (local test chain with blocks generated on demand)")
+	flag.BoolVar(&signet, "signet", CFG.Signet, "Use Signet (see SignetChallenge in the config file for a custom one)")
 	flag.StringVar(&CFG.ConnectOnly, "c", CFG.ConnectOnly, "Connect only to this host and nowhere else")
 	flag.BoolVar(&CFG.Net.ListenTCP, "l", CFG.Net.ListenTCP, "Listen for incoming TCP connections (on default port)")
+	flag.StringVar(&CFG.Net.Proxy, "proxy", CFG.Net.Proxy, "Connect to peers via this SOCKS5 proxy (host:port)")
 	flag.StringVar(&CFG.Datadir, "d", CFG.Datadir, "Specify Gocoin's database root folder")
 	flag.UintVar(&CFG.Net.MaxUpKBps, "ul", CFG.Net.MaxUpKBps, "Upload limit in KB/s (0 for no limit)")
 	flag.UintVar(&CFG.Net.MaxDownKBps, "dl", CFG.Net.MaxDownKBps, "Download limit in KB/s (0 for no limit)")
@@ -293,22 +353,47 @@ func InitConfig() {
 	}
 	flag.Parse()
 
-	CFG.Testnet = testnet3 || testnet4
+	if (regtest || signet) && (testnet3 || testnet4 || regtest && signet) {
+		// regtest or signet from the config file gets overridden by another network switch, but not the other way around
+		regtest, signet = false, false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "regtest" {
+				regtest, signet = true, false
+				testnet3, testnet4 = false, false
+			} else if f.Name == "signet" {
+				regtest, signet = false, true
+				testnet3, testnet4 = false, false
+			}
+		})
+	}
+
+	CFG.Testnet = testnet3 || testnet4 || regtest || signet
 	CFG.Testnet4 = testnet4
+	CFG.Regtest = regtest
+	CFG.Signet = signet
+
+	if _, e := hex.DecodeString(CFG.SignetChallenge); CFG.Signet && e != nil {
+		println("Error in", ConfigFile, "SignetChallenge is not a valid hex string")
+		os.Exit(1)
+	}
 
 	// swap LastTrustedBlock if it's now from the other chain
-	if CFG.Testnet {
+	if CFG.Regtest {
+		CFG.LastTrustedBlock = LastTrustedRegBlock
+	} else if CFG.Signet {
+		CFG.LastTrustedBlock = LastTrustedSigBlock
+	} else if CFG.Testnet {
 		if CFG.Testnet4 {
-			if new_config_file || CFG.LastTrustedBlock == LastTrustedBTCBlock || CFG.LastTrustedBlock == LastTrustedTN4Block {
+			if new_config_file || CFG.LastTrustedBlock == LastTrustedBTCBlock || CFG.LastTrustedBlock == LastTrustedTN4Block || CFG.LastTrustedBlock == LastTrustedRegBlock || CFG.LastTrustedBlock == LastTrustedSigBlock {
 				CFG.LastTrustedBlock = LastTrustedTN4Block
 			}
 		} else {
-			if new_config_file || CFG.LastTrustedBlock == LastTrustedBTCBlock || CFG.LastTrustedBlock == LastTrustedTN3Block {
+			if new_config_file || CFG.LastTrustedBlock == LastTrustedBTCBlock || CFG.LastTrustedBlock == LastTrustedTN3Block || CFG.LastTrustedBlock == LastTrustedRegBlock || CFG.LastTrustedBlock == LastTrustedSigBlock {
 				CFG.LastTrustedBlock = LastTrustedTN3Block
 			}
 		}
 	} else {
-		if new_config_file || CFG.LastTrustedBlock == LastTrustedTN3Block || CFG.LastTrustedBlock == LastTrustedTN4Block {
+		if new_config_file || CFG.LastTrustedBlock == LastTrustedTN3Block || CFG.LastTrustedBlock == LastTrustedTN4Block || CFG.LastTrustedBlock == LastTrustedRegBlock || CFG.LastTrustedBlock == LastTrustedSigBlock {
 			CFG.LastTrustedBlock = LastTrustedBTCBlock
 		}
 	}
@@ -342,13 +427,23 @@ func InitConfig() {
 	}
 
 	if CFG.Memory.DataFilesKeep == 0 {
-		Services |= btc.SERVICE_NETWORK
+		SetServices(btc.SERVICE_NETWORK, true)
 	}
 
 	Reset()
 }
 
 func DataSubdir() string {
+	if CFG.Regtest {
+		return "regnet"
+	}
+	if CFG.Signet {
+		if CFG.SignetChallenge == "" {
+			return "sgnnet"
+		}
+		magic := chain.SignetMagic(SignetChallenge())
+		return "sgn" + hex.EncodeToString(magic[:]) // each custom signet needs its own folder
+	}
 	if CFG.Testnet {
 		if CFG.Testnet4 {
 			return "ts4net"
@@ -360,6 +455,16 @@ func DataSubdir() string {
 	}
 }
 
+// SignetChallenge returns the challenge script of the signet we are configured for.
+func SignetChallenge() []byte {
+	if CFG.SignetChallenge == "" {
+		challenge, _ := hex.DecodeString(chain.DefaultSignetChallengeHex)
+		return challenge
+	}
+	challenge, _ := hex.DecodeString(CFG.SignetChallenge)
+	return challenge
+}
+
 func SaveConfig() bool {
 	dat, _ := json.MarshalIndent(&CFG, "", "    ")
 	if dat == nil {
@@ -399,6 +504,12 @@ func Reset() {
 		fmt.Println("WARNING: TXPool config value MaxSizeMB is zero (unlimited mempool size)")
 	}
 	AssureValueInRange("TXPool.RejectRecCnt", &CFG.TXPool.RejectRecCnt, 100, 60000)
+	AssureValueInRange("TXPool.AncestorCount", &CFG.TXPool.AncestorCount, 1, 1000)
+	AssureValueInRange("TXPool.AncestorSizeKB", &CFG.TXPool.AncestorSizeKB, 1, 10000)
+	AssureValueInRange("TXPool.DescendantCount", &CFG.TXPool.DescendantCount, 1, 1000)
+	AssureValueInRange("TXPool.DescendantSizeKB", &CFG.TXPool.DescendantSizeKB, 1, 10000)
+	AssureValueInRange("TXPool.ClusterCount", &CFG.TXPool.ClusterCount, 1, 1000)
+	AssureValueInRange("TXPool.ClusterSizeKB", &CFG.TXPool.ClusterSizeKB, 1, 10000)
 	if CFG.TXPool.MaxRejectMB != 0 {
 		AssureValueInRange("TXPool.MaxRejectMB", &CFG.TXPool.MaxRejectMB, 0.3, 1e6)
 		atomic.StoreUint64(&MaxRejectedSizeBytes, uint64(CFG.TXPool.MaxRejectMB*1e6))
@@ -416,6 +527,8 @@ func Reset() {
 	}
 	atomic.StoreUint64(&minFeePerKB, uint64(CFG.TXPool.FeePerByte*1000))
 	atomic.StoreUint64(&cfgFeePerKB, MinFeePerKB())
+	atomic.StoreUint64(&dustRelayFeePerKB, uint64(CFG.TXPool.DustRelayFee*1000))
+	atomic.StoreUint64(&incrementalFeePerKB, uint64(CFG.TXPool.IncrementalFee*1000))
 
 	atomic.StoreUint64(&cfgRouteMinFeePerKB, uint64(CFG.TXRoute.FeePerByte*1000))
 
@@ -454,9 +567,11 @@ func Reset() {
 		println("WARNING: No IP is currently allowed at WebUI")
 	}
 	ListenTCP = CFG.Net.ListenTCP
+	SetServices(btc.SERVICE_P2P_V2, CFG.Net.V2Transport)
 
 	utxo.UTXO_WRITING_TIME_TARGET = time.Second * time.Duration(CFG.UTXOSave.SecondsToTake)
 	utxo.UTXO_SKIP_SAVE_BLOCKS = CFG.UTXOSave.BlocksToHold
+	utxo.UTXO_JOURNAL_MAX_SIZE = int64(CFG.UTXOSave.JournalMaxMB) << 20
 	utxo.UTXO_PURGE_UNSPENDABLE = CFG.Memory.PurgeUnspendableUTXO
 
 	if CFG.UserAgent != "" {
@@ -607,6 +722,25 @@ func Set[T bool | uint16 | uint32 | int64 | uint64 | time.Duration](addr *T, val
 	mutex_cfg.Unlock()
 }
 
+// GetServices returns the services that we advertise to other nodes.
+func GetServices() uint64 {
+	return atomic.LoadUint64(&services)
+}
+
+// SetServices sets (or clears) the given bits of the services that we advertise.
+func SetServices(mask uint64, on bool) {
+	for {
+		old := atomic.LoadUint64(&services)
+		val := old &^ mask
+		if on {
+			val |= mask
+		}
+		if atomic.CompareAndSwapUint64(&services, old, val) {
+			return
+		}
+	}
+}
+
 func AllBalMinVal() uint64 {
 	return atomic.LoadUint64(&allBalMinVal)
 }
@@ -631,6 +765,14 @@ func SetMinFeePerKB(val uint64) bool {
 	return true
 }
 
+func DustRelayFeePerKB() uint64 {
+	return atomic.LoadUint64(&dustRelayFeePerKB)
+}
+
+func IncrementalFeePerKB() uint64 {
+	return atomic.LoadUint64(&incrementalFeePerKB)
+}
+
 func RouteMinFeePerKB() uint64 {
 	return atomic.LoadUint64(&cfgRouteMinFeePerKB)
 }
@@ -656,3 +798,24 @@ func GetExternalIp() (res string) {
 	mutex_cfg.Unlock()
 	return
 }
+
+// GetTorControl returns Tor control port address and password ("" if onion service disabled).
+func GetTorControl() (addr, password string) {
+	mutex_cfg.Lock()
+	addr = CFG.Net.TorControl
+	password = CFG.Net.TorPassword
+	mutex_cfg.Unlock()
+	return
+}
+
+// GetProxy returns SOCKS5 proxy to be used for outgoing connections ("" for direct connections).
+func GetProxy(onion bool) (proxy string, randomize bool) {
+	mutex_cfg.Lock()
+	proxy = CFG.Net.Proxy
+	if onion && CFG.Net.OnionProxy != "" {
+		proxy = CFG.Net.OnionProxy
+	}
+	randomize = CFG.Net.ProxyRandomize
+	mutex_cfg.Unlock()
+	return
+}
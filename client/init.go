@@ -18,7 +18,15 @@ func host_init() {
 	common.GocoinHomeDir = common.CFG.Datadir + string(os.PathSeparator)
 
 	common.Testnet = common.CFG.Testnet // So chaging this value would will only affect the behaviour after restart
-	if common.CFG.Testnet {
+	if common.CFG.Regtest {
+		common.GenesisBlock = btc.NewUint256FromString("0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206")
+		common.Magic = [4]byte{0xFA, 0xBF, 0xB5, 0xDA}
+		common.DefaultTcpPort = 18444
+	} else if common.CFG.Signet {
+		common.GenesisBlock = btc.NewUint256FromString(chain.SignetGenesisHash)
+		common.Magic = chain.SignetMagic(common.SignetChallenge())
+		common.DefaultTcpPort = 38333
+	} else if common.CFG.Testnet {
 		if common.CFG.Testnet4 { // testnet4
 			common.GenesisBlock = btc.NewUint256FromString("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043")
 			common.Magic = [4]byte{0x1c, 0x16, 0x3f, 0x28}
@@ -79,7 +87,18 @@ func host_init() {
 		UTXOVolatileMode: common.FLAG.VolatileUTXO,
 		UndoBlocks:       common.FLAG.UndoBlocks,
 		BlockMinedCB:     blockMined, BlockUndoneCB: blockUndone,
-		DoNotRescan: true, CompressUTXO: common.CFG.UTXOSave.CompressRecords}
+		DoNotRescan: true, CompressUTXO: common.CFG.UTXOSave.CompressRecords,
+		UTXODiskMode: common.CFG.UTXOSave.DiskMode, UTXODiskCache: int(common.CFG.UTXOSave.DiskCacheMB) << 20,
+		UTXOJournal:  common.CFG.UTXOSave.Journal,
+		BlockFilters: common.CFG.BlockFilters, TxIndex: common.CFG.TxIndex,
+		AddrIndex: common.CFG.AddrIndex, SignetChallenge: common.SignetChallenge()}
+
+	if common.CFG.Regtest || common.CFG.Signet && common.CFG.SignetChallenge != "" {
+		for i := range common.CFG.Deployments {
+			d := common.CFG.Deployments[i]
+			ext.Deployments = append(ext.Deployments, &d)
+		}
+	}
 
 	if ext.UndoBlocks > 0 {
 		ext.BlockUndoneCB = nil // Do not call the callback if undoing blocks as it will panic
@@ -113,6 +132,14 @@ func host_init() {
 	}
 	common.UpdateScriptFlags(0)
 
+	if common.BlockChain.Filters != nil {
+		if common.BlockChain.Filters.Get(common.Last.Block.BlockHash) != nil {
+			common.SetServices(btc.SERVICE_COMPACT_FILTERS, true)
+		} else {
+			fmt.Println("Block filters index is behind the chain - it will be built in the background")
+		}
+	}
+
 	common.LockCfg()
 	common.ApplyLastTrustedBlock()
 	common.UnlockCfg()
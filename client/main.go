@@ -673,7 +673,6 @@ func main() {
 		reset_save_timer() // we wil do one save try after loading, in case if ther was a rescan
 
 		peersdb.ConnectOnly = common.CFG.ConnectOnly
-		peersdb.Services = common.Services
 		peersdb.InitPeers(common.GocoinHomeDir)
 		if common.FLAG.UnbanAllPeers {
 			var keys []qdb.KeyType
@@ -708,6 +707,23 @@ func main() {
 			network.LastCommitedHeader = common.Last.Block
 		}
 
+		if common.CFG.UTXOSave.SnapshotCheck {
+			network.ResumeSnapshotValidation()
+		}
+
+		if common.BlockChain.TxIndex != nil {
+			go common.BlockChain.BuildTxIndex()
+		}
+
+		if common.BlockChain.Filters != nil && (common.GetServices()&btc.SERVICE_COMPACT_FILTERS) == 0 {
+			go func() {
+				if common.BlockChain.BuildFilters() {
+					fmt.Println("Block filters index is complete - NODE_COMPACT_FILTERS service enabled")
+					common.SetServices(btc.SERVICE_COMPACT_FILTERS, true)
+				}
+			}()
+		}
+
 		if common.CFG.TXPool.SaveOnDisk && !common.FLAG.NoMempoolLoad {
 			txpool.MempoolLoad()
 		} else {
@@ -732,6 +748,8 @@ func main() {
 			}
 		}
 
+		usif.LocalAcceptBlock = LocalAcceptBlock
+
 		wallet.FetchingBalanceTick = func() bool {
 			select {
 			case rec := <-usif.LocksChan:
@@ -743,6 +761,10 @@ func main() {
 				common.CountSafe("DoMainNetTx")
 				txpool.HandleNetTx(newtx)
 
+			case newpkg := <-network.NetPkgs:
+				common.CountSafe("DoMainNetPkg")
+				txpool.SubmitPackage(newpkg)
+
 			case <-netTick:
 				common.CountSafe("DoMainNetTick")
 				if common.Last.ParseTill != nil {
@@ -834,7 +856,7 @@ func main() {
 				}
 
 				// Now check if the chain is synchronized...
-				if (network.HeadersReceived.Get() > int(common.Get(&common.CFG.Net.MaxOutCons)/2) ||
+				if (common.CFG.Regtest || network.HeadersReceived.Get() > int(common.Get(&common.CFG.Net.MaxOutCons)/2) ||
 					peersdb.ConnectOnly != "" && network.HeadersReceived.Get() >= 1) &&
 					network.BlocksToGetCnt() == 0 && len(network.NetBlocks) == 0 &&
 					network.CachedBlocksLen() == 0 {
@@ -865,6 +887,11 @@ func main() {
 				common.CountSafe("MainNetTx")
 				txpool.HandleNetTx(newtx)
 
+			case newpkg := <-network.NetPkgs:
+				common.Busy()
+				common.CountSafe("MainNetPkg")
+				txpool.SubmitPackage(newpkg)
+
 			case <-SaveBlockChain.C:
 				common.Busy()
 				common.CountSafe("SaveBlockChain")
@@ -900,9 +927,11 @@ func main() {
 		wallet.UpdateMapSizes()
 		fmt.Println("Shutting down PID", os.Getpid())
 		network.NetCloseAll()
+		rpcapi.DeleteCookie()
 	}
 
 	sta := time.Now()
+	network.StopSnapshotValidation()
 	common.CloseBlockChain()
 	fmt.Println("Blockchain closed in", time.Since(sta).String())
 	if common.FLAG.UndoBlocks == 0 {
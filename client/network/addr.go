@@ -12,7 +12,6 @@ import (
 	"github.com/piotrnar/gocoin/client/peersdb"
 	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/lib/others/qdb"
-	"github.com/piotrnar/gocoin/lib/others/sys"
 )
 
 var (
@@ -92,7 +91,7 @@ func BestExternalAddr() []byte {
 	}
 
 	res := make([]byte, 26)
-	binary.LittleEndian.PutUint64(res[0:8], common.Services)
+	binary.LittleEndian.PutUint64(res[0:8], common.GetServices())
 	// leave ip6 filled with zeros, except for the last 2 bytes:
 	res[18], res[19] = 0xff, 0xff
 	if len(arr) > 0 {
@@ -103,51 +102,108 @@ func BestExternalAddr() []byte {
 }
 
 // HandleGetaddr sends the response to "getaddr" message.
-// Sends addr message with up to 500 randomly selected peers from our database.
+// Sends addr (or addrv2) message with up to 500 randomly selected peers from our database.
 // Selects only peers that we have been seeing alive and have not been banned.
 func (c *OneConnection) HandleGetaddr() {
+	addrv2 := c.MutexGetBool(&c.Node.SendAddrV2)
 	pers := peersdb.GetRecentPeers(MaxAddrsPerMessage, false, func(p *peersdb.PeerAddr) bool {
-		return p.Banned != 0 || !p.SeenAlive // we only return addresses that we've seen alive
+		// we only return addresses that we've seen alive
+		return p.Banned != 0 || !p.SeenAlive || !addrv2 && !p.IsLegacy()
 	})
 	if len(pers) > 0 {
 		buf := new(bytes.Buffer)
 		btc.WriteVlen(buf, uint64(len(pers)))
 		for i := range pers {
 			binary.Write(buf, binary.LittleEndian, pers[i].Time)
-			buf.Write(pers[i].NetAddr.Bytes())
+			if addrv2 {
+				buf.Write(pers[i].NetAddr.BytesV2())
+			} else {
+				buf.Write(pers[i].NetAddr.Bytes())
+			}
+		}
+		if addrv2 {
+			c.SendRawMsg("addrv2", buf.Bytes(), false)
+		} else {
+			c.SendRawMsg("addr", buf.Bytes(), false)
 		}
-		c.SendRawMsg("addr", buf.Bytes(), false)
 	}
 }
 
 func (c *OneConnection) SendOwnAddr() {
-	if ExternalAddrLen() > 0 {
-		buf := new(bytes.Buffer)
-		btc.WriteVlen(buf, uint64(1))
+	var addrs []*btc.NetAddr
+	addrv2 := c.MutexGetBool(&c.Node.SendAddrV2)
+	if ExternalAddrLen() > 0 && common.IsListenTCP() {
+		addrs = append(addrs, btc.NewNetAddr(BestExternalAddr()))
+	}
+	if oa := OnionAddr(); oa != nil && addrv2 {
+		addrs = append(addrs, oa)
+	}
+	if len(addrs) == 0 {
+		return
+	}
+	buf := new(bytes.Buffer)
+	btc.WriteVlen(buf, uint64(len(addrs)))
+	for _, na := range addrs {
 		binary.Write(buf, binary.LittleEndian, uint32(time.Now().Unix()))
-		buf.Write(BestExternalAddr())
+		if addrv2 {
+			buf.Write(na.BytesV2())
+		} else {
+			buf.Write(na.Bytes())
+		}
+	}
+	if addrv2 {
+		c.SendRawMsg("addrv2", buf.Bytes(), false)
+	} else {
 		c.SendRawMsg("addr", buf.Bytes(), false)
 	}
 }
 
 // ParseAddr parses the network's "addr" message.
 func (c *OneConnection) ParseAddr(pl []byte) {
-	var c_ip_invalid, c_future, c_old, c_new_rejected, c_new_taken, c_stale, c_no_segwit uint64
+	c.parseAddr(pl, false)
+}
+
+// ParseAddrV2 parses the network's "addrv2" message (BIP155).
+func (c *OneConnection) ParseAddrV2(pl []byte) {
+	c.parseAddr(pl, true)
+}
+
+func (c *OneConnection) parseAddr(pl []byte, addrv2 bool) {
+	var c_ip_invalid, c_future, c_old, c_new_rejected, c_new_taken, c_stale, c_no_segwit, c_unknown uint64
 	have_enough := peersdb.PeerDB.Count() > peersdb.MinPeersInDB
 	b := bytes.NewBuffer(pl)
 	cnt, _ := btc.ReadVLen(b)
+	if cnt > 1000 {
+		c.DoS("AddrTooMany")
+		return
+	}
 	for i := 0; i < int(cnt); i++ {
-		var buf [30]byte
-		n, e := b.Read(buf[:])
-		if n != len(buf) || e != nil {
-			common.CountSafe("AddrError")
-			c.DoS("AddrError")
-			break
+		var a *peersdb.PeerAddr
+		if addrv2 {
+			na, tim, e := btc.ReadNetAddrV2(b)
+			if e != nil {
+				common.CountSafe("AddrV2Error")
+				c.DoS("AddrV2Error")
+				break
+			}
+			if na == nil {
+				c_unknown++
+				continue
+			}
+			a = peersdb.NewPeerFromNetAddr(na, tim)
+		} else {
+			var buf [30]byte
+			n, e := b.Read(buf[:])
+			if n != len(buf) || e != nil {
+				common.CountSafe("AddrError")
+				c.DoS("AddrError")
+				break
+			}
+			a = peersdb.NewPeer(buf[:])
 		}
-		a := peersdb.NewPeer(buf[:])
 		if (a.Services & btc.SERVICE_SEGWIT) == 0 {
 			c_no_segwit++
-		} else if !sys.ValidIp4(a.Ip4[:]) {
+		} else if !a.IsValid() {
 			c_ip_invalid++
 		} else {
 			now := uint32(time.Now().Unix())
@@ -208,6 +264,9 @@ func (c *OneConnection) ParseAddr(pl []byte) {
 	if c_no_segwit > 0 {
 		common.CountAdd("AddrNoSegWit", c_no_segwit)
 	}
+	if c_unknown > 0 {
+		common.CountAdd("AddrV2Unknown", c_unknown)
+	}
 	common.CounterMutex.Unlock()
 	c.Mutex.Lock()
 	c.X.AddrMsgsRcvd++
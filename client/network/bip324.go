@@ -0,0 +1,395 @@
+package network
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/others/chacha20"
+	"github.com/piotrnar/gocoin/lib/secp256k1"
+)
+
+// BIP324 - Version 2 P2P Encrypted Transport Protocol
+
+const (
+	V2_ELLSWIFT_LEN     = 64
+	V2_GARBAGE_TERM_LEN = 16
+	V2_MAX_GARBAGE_LEN  = 4095
+	V2_LENGTH_LEN       = 3
+	V2_HEADER_LEN       = 1
+	V2_IGNORE_BIT       = 0x80
+	V2_MSG_OVERHEAD     = V2_LENGTH_LEN + V2_HEADER_LEN + 13 + chacha20.TagSize
+
+	// The biggest message we accept is "getmp", plus some extra for xauth encryption
+	V2_MAX_CONTENTS = 13 + 9 + 8*MAX_GETMP_TXS + 64
+)
+
+const (
+	v2_DETECT  = iota // responder: waiting to see if it is not v1 peer
+	v2_KEY            // receiving peer's public key
+	v2_GARBAGE        // receiving garbage, looking for its terminator
+	v2_VERSION        // receiving version packet
+	v2_APP            // receiving application packets
+)
+
+// Index in this table is the short message type ID
+var v2_short_ids = []string{"",
+	"addr", "block", "blocktxn", "cmpctblock", "feefilter", "filteradd", "filterclear",
+	"filterload", "getblocks", "getblocktxn", "getdata", "getheaders", "headers", "inv",
+	"mempool", "merkleblock", "notfound", "ping", "pong", "sendcmpct", "tx", "getcfilters",
+	"cfilter", "getcfheaders", "cfheaders", "getcfcheckpt", "cfcheckpt", "addrv2"}
+
+var v2_short_id_map map[string]byte
+
+type v2PendingMsg struct {
+	cmd       string
+	pl        []byte
+	encrypted bool
+}
+
+type v2Transport struct {
+	privkey   [32]byte
+	ellswift  []byte
+	garbage   []byte // our garbage - used as aad of our version packet
+	initiator bool
+	ready     bool // keys are known - we can send packets now
+
+	sendL              *chacha20.FSChaCha20
+	sendP              *chacha20.FSChaCha20Poly1305
+	recvL              *chacha20.FSChaCha20
+	recvP              *chacha20.FSChaCha20Poly1305
+	sendTerm, recvTerm []byte
+
+	pending []v2PendingMsg // messages to send when the handshake is done (protected by c.Mutex)
+
+	// reception state machine (only accessed by the connection's thread)
+	state   int
+	rbuf    []byte
+	aad     []byte // their garbage - aad of the version packet
+	pkt_len int    // length of the current packet contents (-1 if not decrypted yet)
+}
+
+func newV2Transport(initiator bool) (v2 *v2Transport) {
+	v2 = new(v2Transport)
+	v2.initiator = initiator
+	if initiator {
+		v2.state = v2_KEY
+	} else {
+		v2.state = v2_DETECT
+	}
+	v2.pkt_len = -1
+	for {
+		rand.Read(v2.privkey[:])
+		var pub [33]byte
+		if secp256k1.BaseMultiply(v2.privkey[:], pub[:]) {
+			break
+		}
+	}
+	v2.ellswift = secp256k1.EllSwiftCreate(v2.privkey[:])
+	var l [2]byte
+	rand.Read(l[:])
+	v2.garbage = make([]byte, int(binary.LittleEndian.Uint16(l[:]))%(V2_MAX_GARBAGE_LEN+1))
+	rand.Read(v2.garbage)
+	return
+}
+
+func hkdf_sha256_expand(prk []byte, info string) []byte {
+	h := hmac.New(sha256.New, prk)
+	h.Write([]byte(info))
+	h.Write([]byte{1})
+	return h.Sum(nil)
+}
+
+// initKeys derives the session keys from the peer's public key.
+func (v2 *v2Transport) initKeys(peer_ells []byte) bool {
+	var secret []byte
+	if v2.initiator {
+		secret = secp256k1.EllSwiftXDH(v2.ellswift, peer_ells, v2.privkey[:], true)
+	} else {
+		secret = secp256k1.EllSwiftXDH(peer_ells, v2.ellswift, v2.privkey[:], false)
+	}
+	if secret == nil {
+		return false
+	}
+	h := hmac.New(sha256.New, append([]byte("bitcoin_v2_shared_secret"), common.Magic[:]...))
+	h.Write(secret)
+	prk := h.Sum(nil)
+
+	init_L := chacha20.NewFSChaCha20(hkdf_sha256_expand(prk, "initiator_L"))
+	init_P := chacha20.NewFSChaCha20Poly1305(hkdf_sha256_expand(prk, "initiator_P"))
+	resp_L := chacha20.NewFSChaCha20(hkdf_sha256_expand(prk, "responder_L"))
+	resp_P := chacha20.NewFSChaCha20Poly1305(hkdf_sha256_expand(prk, "responder_P"))
+	terms := hkdf_sha256_expand(prk, "garbage_terminators")
+	if v2.initiator {
+		v2.sendL, v2.sendP, v2.recvL, v2.recvP = init_L, init_P, resp_L, resp_P
+		v2.sendTerm, v2.recvTerm = terms[:16], terms[16:32]
+	} else {
+		v2.sendL, v2.sendP, v2.recvL, v2.recvP = resp_L, resp_P, init_L, init_P
+		v2.sendTerm, v2.recvTerm = terms[16:32], terms[:16]
+	}
+	return true
+}
+
+// encodePacket returns the encrypted packet. The first byte of plain must be the header.
+func (v2 *v2Transport) encodePacket(plain, aad []byte) (pkt []byte) {
+	pkt = make([]byte, V2_LENGTH_LEN, V2_LENGTH_LEN+len(plain)+chacha20.TagSize)
+	l := len(plain) - V2_HEADER_LEN
+	pkt[0], pkt[1], pkt[2] = byte(l), byte(l>>8), byte(l>>16)
+	v2.sendL.Crypt(pkt[:V2_LENGTH_LEN], pkt[:V2_LENGTH_LEN])
+	return v2.sendP.Seal(pkt, plain, aad)
+}
+
+// v2contents returns header byte followed by message type and the payload.
+func v2contents(cmd string, pl []byte, encrypted bool) (res []byte) {
+	if id, ok := v2_short_id_map[cmd]; ok && !encrypted {
+		res = make([]byte, 2, 2+len(pl))
+		res[1] = id
+	} else {
+		res = make([]byte, 2+12, 2+12+len(pl))
+		copy(res[2:], cmd)
+		if encrypted {
+			res[2] |= 0x80
+		}
+	}
+	return append(res, pl...)
+}
+
+// v2SendMsg is called from SendRawMsg, with c.Mutex locked.
+func (c *OneConnection) v2SendMsg(cmd string, pl []byte, encrypted bool) {
+	if !c.v2.ready {
+		c.v2.pending = append(c.v2.pending, v2PendingMsg{cmd: cmd, pl: pl, encrypted: encrypted})
+		return
+	}
+	c.append_to_send_buffer(c.v2.encodePacket(v2contents(cmd, pl, encrypted), nil))
+}
+
+// v2SendKey sends our public key and the garbage.
+func (c *OneConnection) v2SendKey() {
+	c.Mutex.Lock()
+	c.append_to_send_buffer(c.v2.ellswift)
+	c.append_to_send_buffer(c.v2.garbage)
+	c.Mutex.Unlock()
+	c.pushWritingThread()
+}
+
+// v2SendHandshake sends the garbage terminator, the version packet and all the pending messages.
+func (c *OneConnection) v2SendHandshake() {
+	c.Mutex.Lock()
+	v2 := c.v2
+	c.append_to_send_buffer(v2.sendTerm)
+	c.append_to_send_buffer(v2.encodePacket([]byte{0}, v2.garbage))
+	v2.garbage = nil
+	v2.ready = true
+	for _, m := range v2.pending {
+		pkt := v2.encodePacket(v2contents(m.cmd, m.pl, m.encrypted), nil)
+		if SendBufSize-c.BytesToSent() <= len(pkt) {
+			c.Mutex.Unlock()
+			c.DoS("SendBufferOverflow")
+			return
+		}
+		c.append_to_send_buffer(pkt)
+	}
+	v2.pending = nil
+	c.Mutex.Unlock()
+	c.pushWritingThread()
+}
+
+// v2Fill reads from the socket, until there is n bytes in c.v2.rbuf.
+// Returns true if the buffer has been filled.
+func (c *OneConnection) v2Fill(n int, timeout_or_data *bool) bool {
+	v2 := c.v2
+	if len(v2.rbuf) >= n {
+		return true
+	}
+	if cap(v2.rbuf) < n {
+		nb := make([]byte, len(v2.rbuf), n)
+		copy(nb, v2.rbuf)
+		v2.rbuf = nb
+	}
+	cnt, e := common.SockRead(c.Conn, v2.rbuf[len(v2.rbuf):n])
+	if cnt < 0 {
+		cnt = 0
+	} else {
+		*timeout_or_data = true
+	}
+	if cnt > 0 {
+		v2.rbuf = v2.rbuf[:len(v2.rbuf)+cnt]
+		c.Mutex.Lock()
+		c.X.BytesReceived += uint64(cnt)
+		c.X.LastDataGot = time.Now()
+		c.Mutex.Unlock()
+	}
+	if e != nil {
+		c.HandleError(e)
+		return false
+	}
+	return len(v2.rbuf) >= n
+}
+
+// v2Consume removes n bytes from the beginning of the receive buffer.
+func (v2 *v2Transport) consume(n int) {
+	v2.rbuf = append([]byte{}, v2.rbuf[n:]...)
+}
+
+// v2FetchMessage is FetchMessage for BIP324 connections.
+func (c *OneConnection) v2FetchMessage() (ret *BCmsg, timeout_or_data bool) {
+	v2 := c.v2
+	switch v2.state {
+	case v2_DETECT:
+		if !c.v2Fill(16, &timeout_or_data) {
+			return
+		}
+		if bytes.Equal(v2.rbuf[:4], common.Magic[:]) && bytes.Equal(v2.rbuf[4:16], []byte("version\000\000\000\000\000")) {
+			// v1 peer - continue the old way
+			c.Mutex.Lock()
+			copy(c.recv.hdr[:], v2.rbuf[:16])
+			c.recv.hdr_len = 16
+			c.recv.magicok = true
+			c.v2 = nil
+			c.Mutex.Unlock()
+			common.CountSafe("V2DetectedV1")
+			return
+		}
+		v2.state = v2_KEY
+		fallthrough
+
+	case v2_KEY:
+		if !c.v2Fill(V2_ELLSWIFT_LEN, &timeout_or_data) {
+			return
+		}
+		if !v2.initiator {
+			c.v2SendKey()
+		}
+		if !v2.initKeys(v2.rbuf[:V2_ELLSWIFT_LEN]) {
+			c.DoS("V2BadKey")
+			return
+		}
+		v2.consume(V2_ELLSWIFT_LEN)
+		c.v2SendHandshake()
+		v2.state = v2_GARBAGE
+		fallthrough
+
+	case v2_GARBAGE:
+		max := V2_MAX_GARBAGE_LEN + V2_GARBAGE_TERM_LEN
+		for {
+			if idx := bytes.Index(v2.rbuf, v2.recvTerm); idx >= 0 {
+				v2.aad = append([]byte{}, v2.rbuf[:idx]...)
+				v2.consume(idx + V2_GARBAGE_TERM_LEN)
+				v2.state = v2_VERSION
+				break
+			}
+			if len(v2.rbuf) >= max {
+				c.DoS("V2NoGarbageTerm")
+				return
+			}
+			le := len(v2.rbuf)
+			c.v2Fill(max, &timeout_or_data)
+			if len(v2.rbuf) == le {
+				return // no new data
+			}
+		}
+	}
+
+	// v2_VERSION or v2_APP
+	if v2.pkt_len < 0 {
+		if !c.v2Fill(V2_LENGTH_LEN, &timeout_or_data) {
+			return
+		}
+		v2.recvL.Crypt(v2.rbuf[:V2_LENGTH_LEN], v2.rbuf[:V2_LENGTH_LEN])
+		v2.pkt_len = int(v2.rbuf[0]) | int(v2.rbuf[1])<<8 | int(v2.rbuf[2])<<16
+		if v2.pkt_len > V2_MAX_CONTENTS {
+			c.DoS("V2BigPacket")
+			return
+		}
+	}
+
+	tot_len := V2_LENGTH_LEN + V2_HEADER_LEN + v2.pkt_len + chacha20.TagSize
+	if !c.v2Fill(tot_len, &timeout_or_data) {
+		return
+	}
+	plain, er := v2.recvP.Open(nil, v2.rbuf[V2_LENGTH_LEN:tot_len], v2.aad)
+	v2.consume(tot_len)
+	v2.pkt_len = -1
+	v2.aad = nil
+	if er != nil {
+		println(c.PeerAddr.Ip(), "- v2 decryption error:", er.Error())
+		c.DoS("V2AuthError")
+		return
+	}
+
+	if (plain[0] & V2_IGNORE_BIT) != 0 {
+		common.CountSafe("V2IgnoredPacket")
+		return
+	}
+
+	if v2.state == v2_VERSION {
+		// the contents of version packet is reserved for future extensions
+		v2.state = v2_APP
+		c.Mutex.Lock()
+		c.X.V2Transport = true
+		c.Mutex.Unlock()
+		return
+	}
+
+	plain = plain[V2_HEADER_LEN:]
+	if len(plain) == 0 {
+		c.DoS("V2EmptyMsg")
+		return
+	}
+
+	ret = new(BCmsg)
+	if plain[0] != 0 {
+		if int(plain[0]) >= len(v2_short_ids) {
+			common.CountSafe("V2UnknownShortID")
+			return nil, timeout_or_data
+		}
+		ret.cmd = v2_short_ids[plain[0]]
+		ret.pl = plain[1:]
+	} else {
+		if len(plain) < 13 {
+			c.DoS("V2BadMsgType")
+			return nil, timeout_or_data
+		}
+		ret.crypted = (plain[1] & 0x80) != 0
+		plain[1] &= 0x7f
+		ret.cmd = strings.TrimRight(string(plain[1:13]), "\000")
+		ret.pl = plain[13:]
+	}
+
+	msi := maxmsgsize(ret.cmd)
+	if ret.crypted {
+		if c.aesData == nil {
+			println(c.PeerAddr.Ip(), "- got encrypted msg", ret.cmd, "but have no key")
+			c.DoS("MsgNoKey")
+			return nil, timeout_or_data
+		}
+		msi += 16 + uint(c.aesData.nonceSize)
+	}
+	if uint(len(ret.pl)) > msi {
+		c.DoS("Big-" + ret.cmd)
+		return nil, timeout_or_data
+	}
+	if ret.crypted {
+		if ret.pl, er = c.Decrypt(ret.pl); er != nil {
+			println(c.PeerAddr.Ip(), "- decryption error:", er.Error())
+			c.DoS("MsgAuthError")
+			return nil, timeout_or_data
+		}
+		ret.trusted = c.X.Authorized
+	}
+
+	c.msgReceived(ret)
+	return
+}
+
+func init() {
+	v2_short_id_map = make(map[string]byte, len(v2_short_ids))
+	for i := 1; i < len(v2_short_ids); i++ {
+		v2_short_id_map[v2_short_ids[i]] = byte(i)
+	}
+}
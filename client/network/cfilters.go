@@ -0,0 +1,188 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
+)
+
+// BIP157 - serving compact block filters
+
+const (
+	MAX_GETCFILTERS_SIZE  = 1000
+	MAX_GETCFHEADERS_SIZE = 2000
+	CFCHECKPT_INTERVAL    = 1000
+)
+
+type cfPending struct {
+	hash *btc.Uint256
+	rec  *chain.FilterRec
+}
+
+// cfBlocks returns the filter records (and the hashes) of the blocks from start_height to stop_hash.
+// If any of them is unknown, it returns nil (and it may disconnect the peer).
+func (c *OneConnection) cfBlocks(pl []byte, max_cnt uint32, lab string) (res []cfPending) {
+	if len(pl) != 37 {
+		c.DoS(lab + "Len")
+		return
+	}
+	if (common.GetServices()&btc.SERVICE_COMPACT_FILTERS) == 0 || common.BlockChain.Filters == nil {
+		c.Disconnect(false, lab+"NotServed")
+		return
+	}
+	if pl[0] != btc.BLOCK_FILTER_BASIC {
+		c.Disconnect(false, lab+"Type")
+		return
+	}
+	start := binary.LittleEndian.Uint32(pl[1:5])
+	stop := btc.NewUint256(pl[5:37])
+
+	common.BlockChain.BlockIndexAccess.Lock()
+	node := common.BlockChain.BlockIndex[stop.BIdx()]
+	if node == nil || start > node.Height || node.Height-start >= max_cnt {
+		common.BlockChain.BlockIndexAccess.Unlock()
+		c.Disconnect(false, lab+"Range")
+		return
+	}
+	res = make([]cfPending, node.Height-start+1)
+	for i := len(res) - 1; i >= 0; i-- {
+		res[i].hash = node.BlockHash
+		node = node.Parent
+	}
+	common.BlockChain.BlockIndexAccess.Unlock()
+
+	for i := range res {
+		if res[i].rec = common.BlockChain.Filters.Get(res[i].hash); res[i].rec == nil {
+			common.CountSafe(lab + "Missing")
+			return nil
+		}
+	}
+	return
+}
+
+// ProcessGetCFilters handles "getcfilters" by sending one "cfilter" message per block.
+func (c *OneConnection) ProcessGetCFilters(pl []byte) {
+	recs := c.cfBlocks(pl, MAX_GETCFILTERS_SIZE, "GetCFilters")
+	if recs == nil {
+		return
+	}
+	if len(c.cfilters)+len(recs) > 2*MAX_GETCFILTERS_SIZE {
+		c.DoS("GetCFiltersTooMany")
+		return
+	}
+	c.cfilters = append(c.cfilters, recs...)
+	c.sendCFilters()
+}
+
+// sendCFilters sends the pending "cfilter" messages, as long as the send buffer is not too full.
+func (c *OneConnection) sendCFilters() {
+	for len(c.cfilters) > 0 {
+		if c.SendingPaused() {
+			common.CountSafe("CFiltersPaused")
+			return
+		}
+		p := c.cfilters[0]
+		c.cfilters = c.cfilters[1:]
+		filter, er := common.BlockChain.Filters.GetFilter(p.rec)
+		if er != nil {
+			println("GetFilter:", er.Error())
+			continue
+		}
+		b := new(bytes.Buffer)
+		b.WriteByte(btc.BLOCK_FILTER_BASIC)
+		b.Write(p.hash.Hash[:])
+		btc.WriteVlen(b, uint64(len(filter)))
+		b.Write(filter)
+		c.SendRawMsg("cfilter", b.Bytes(), false)
+	}
+	c.cfilters = nil
+}
+
+// ProcessGetCFHeaders handles "getcfheaders".
+func (c *OneConnection) ProcessGetCFHeaders(pl []byte) {
+	recs := c.cfBlocks(pl, MAX_GETCFHEADERS_SIZE, "GetCFHeaders")
+	if recs == nil {
+		return
+	}
+	var prev btc.Uint256
+	if recs[0].rec.Height > 0 {
+		par := cfParent(recs[0].hash)
+		if par == nil {
+			common.CountSafe("GetCFHeadersMissing")
+			return
+		}
+		prev = par.Header
+	}
+	b := new(bytes.Buffer)
+	b.WriteByte(btc.BLOCK_FILTER_BASIC)
+	b.Write(pl[5:37])
+	b.Write(prev.Hash[:])
+	btc.WriteVlen(b, uint64(len(recs)))
+	for _, r := range recs {
+		b.Write(r.rec.Hash.Hash[:])
+	}
+	c.SendRawMsg("cfheaders", b.Bytes(), false)
+}
+
+// cfParent returns the filter record of the parent of the given block.
+func cfParent(hash *btc.Uint256) *chain.FilterRec {
+	var parent *btc.Uint256
+	common.BlockChain.BlockIndexAccess.Lock()
+	if node := common.BlockChain.BlockIndex[hash.BIdx()]; node != nil && node.Parent != nil {
+		parent = node.Parent.BlockHash
+	}
+	common.BlockChain.BlockIndexAccess.Unlock()
+	if parent == nil {
+		return nil
+	}
+	return common.BlockChain.Filters.Get(parent)
+}
+
+// ProcessGetCFCheckpt handles "getcfcheckpt" - filter headers at every 1000th block up to the stop hash.
+func (c *OneConnection) ProcessGetCFCheckpt(pl []byte) {
+	if len(pl) != 33 {
+		c.DoS("GetCFCheckptLen")
+		return
+	}
+	if (common.GetServices()&btc.SERVICE_COMPACT_FILTERS) == 0 || common.BlockChain.Filters == nil {
+		c.Disconnect(false, "GetCFCheckptNotServed")
+		return
+	}
+	if pl[0] != btc.BLOCK_FILTER_BASIC {
+		c.Disconnect(false, "GetCFCheckptType")
+		return
+	}
+	stop := btc.NewUint256(pl[1:33])
+
+	common.BlockChain.BlockIndexAccess.Lock()
+	node := common.BlockChain.BlockIndex[stop.BIdx()]
+	if node == nil {
+		common.BlockChain.BlockIndexAccess.Unlock()
+		c.Disconnect(false, "GetCFCheckptUnknown")
+		return
+	}
+	hashes := make([]*btc.Uint256, node.Height/CFCHECKPT_INTERVAL)
+	for ; node.Height >= CFCHECKPT_INTERVAL; node = node.Parent {
+		if node.Height%CFCHECKPT_INTERVAL == 0 {
+			hashes[node.Height/CFCHECKPT_INTERVAL-1] = node.BlockHash
+		}
+	}
+	common.BlockChain.BlockIndexAccess.Unlock()
+
+	b := new(bytes.Buffer)
+	b.WriteByte(btc.BLOCK_FILTER_BASIC)
+	b.Write(stop.Hash[:])
+	btc.WriteVlen(b, uint64(len(hashes)))
+	for _, h := range hashes {
+		rec := common.BlockChain.Filters.Get(h)
+		if rec == nil {
+			common.CountSafe("GetCFCheckptMissing")
+			return
+		}
+		b.Write(rec.Header.Hash[:])
+	}
+	c.SendRawMsg("cfcheckpt", b.Bytes(), false)
+}
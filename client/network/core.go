@@ -16,6 +16,7 @@ import (
 
 	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/client/peersdb"
+	"github.com/piotrnar/gocoin/client/txpool"
 	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/lib/others/sys"
 )
@@ -90,6 +91,8 @@ type NetworkNodeStruct struct {
 	DoNotRelayTxs bool
 	SendHeaders   bool
 	HighBandwidth bool
+	SendAddrV2    bool // BIP155
+	WtxidRelay    bool // BIP339
 }
 
 type ConnectionStatus struct {
@@ -132,6 +135,7 @@ type ConnectionStatus struct {
 	GetBlocksDataNow     bool
 	Incomming            bool
 	VersionReceived      bool
+	VerackReceived       bool
 	OurGetAddrDone       bool // Whether we shoudl issue another "getaddr"
 	AllHeadersReceived   bool // keep sending getheaders until this is not set
 	LastHeadersEmpty     bool
@@ -144,6 +148,10 @@ type ConnectionStatus struct {
 	AuthMsgGot           bool
 	AuthAckGot           bool
 	ChainSynchronized    bool // Initiated by "auth" or "autack" message (gocoin specific commmands)
+	V2Transport          bool // BIP324 handshake completed
+	IsOnion              bool // Incoming connection via our onion service
+	TxRecon              bool // BIP330 reconciliation registered
+	PkgRelay             bool // BIP331 ancestor package relay negotiated
 }
 
 type ConnInfo struct {
@@ -185,6 +193,10 @@ type OneConnection struct {
 	*aesData // used for sending secured messages to/from authenticated hosts
 	*peersdb.PeerAddr
 	unfinished_getdata *bytes.Buffer
+	v2                 *v2Transport // BIP324 encrypted transport (nil for v1 connections)
+	recon              *txRecon     // BIP330 reconciliation state (nil if not negotiated)
+	pkg                *pkgRelay    // BIP331 package relay state (nil if not negotiated)
+	cfilters           []cfPending  // BIP157 "cfilter" messages waiting for the send buffer
 
 	GetMP              chan bool
 	counters           map[string]uint64
@@ -237,6 +249,8 @@ type OneConnection struct {
 	dead   bool // If set the alive time in PeersDB will ne moved back
 	banit  bool // Ban this client after disconnecting
 	drop   bool // disconenct this peer when all blocks finish downloading
+
+	viaProxy bool // connected via SOCKS5 proxy
 }
 
 type oneBlockDl struct {
@@ -388,10 +402,14 @@ func (c *OneConnection) SendRawMsg(cmd string, pl []byte, encrypt bool) (e error
 
 	if !c.broken {
 		// we never allow the buffer to be totally full because then producer would be equal consumer
-		if bytes_left := SendBufSize - c.BytesToSent(); bytes_left <= len(pl)+24 {
+		overhead := 24
+		if c.v2 != nil {
+			overhead = V2_MSG_OVERHEAD
+		}
+		if bytes_left := SendBufSize - c.BytesToSent(); bytes_left <= len(pl)+overhead {
 			c.Mutex.Unlock()
 			println(c.PeerAddr.Ip(), c.Node.Version, c.Node.Agent, "Peer Send Buffer Overflow @",
-				cmd, bytes_left, len(pl)+24, c.SendBufProd, c.SendBufCons, c.BytesToSent())
+				cmd, bytes_left, len(pl)+overhead, c.SendBufProd, c.SendBufCons, c.BytesToSent())
 			c.DoS("SendBufferOverflow")
 			common.CountSafe("PeerSendOverflow")
 			return errors.New("send buffer overflow")
@@ -410,6 +428,21 @@ func (c *OneConnection) SendRawMsg(cmd string, pl []byte, encrypt bool) (e error
 		c.X.LastCmdSent = cmd
 		c.X.LastBtsSent = uint32(len(pl))
 
+		if c.v2 != nil {
+			if encrypt {
+				var er error
+				if pl, er = c.Encrypt(pl); er != nil {
+					c.Mutex.Unlock()
+					println("Encryption failed:", er.Error())
+					return
+				}
+			}
+			c.v2SendMsg(cmd, pl, encrypt)
+			c.Mutex.Unlock()
+			c.pushWritingThread()
+			return
+		}
+
 		binary.LittleEndian.PutUint32(sbuf[0:4], common.Version)
 		copy(sbuf[0:4], common.Magic[:])
 		copy(sbuf[4:16], cmd)
@@ -436,11 +469,15 @@ func (c *OneConnection) SendRawMsg(cmd string, pl []byte, encrypt bool) (e error
 		}
 	}
 	c.Mutex.Unlock()
+	c.pushWritingThread()
+	return
+}
+
+func (c *OneConnection) pushWritingThread() {
 	select {
 	case c.writing_thread_push <- true:
 	default:
 	}
-	return
 }
 
 // append_to_send_buffer assumes that there is enough room inside sendBuf.
@@ -562,6 +599,10 @@ func (c *OneConnection) FetchMessage() (ret *BCmsg, timeout_or_data bool) {
 	var e error
 	var n int
 
+	if c.v2 != nil {
+		return c.v2FetchMessage()
+	}
+
 	for c.recv.hdr_len < 24 {
 		n, e = common.SockRead(c.Conn, c.recv.hdr[c.recv.hdr_len:24])
 		if n < 0 {
@@ -694,7 +735,15 @@ func (c *OneConnection) FetchMessage() (ret *BCmsg, timeout_or_data bool) {
 	c.recv.hdr_len = 0
 	c.recv.dat = nil
 	c.recv.magicok = false
+	c.Mutex.Unlock()
 
+	c.msgReceived(ret)
+	return
+}
+
+// msgReceived updates the stats after a new message has been received.
+func (c *OneConnection) msgReceived(ret *BCmsg) {
+	c.Mutex.Lock()
 	c.X.LastCmdRcvd = ret.cmd
 	c.X.LastBtsRcvd = uint32(len(ret.pl))
 
@@ -715,8 +764,6 @@ func (c *OneConnection) FetchMessage() (ret *BCmsg, timeout_or_data bool) {
 	/*if c.X.Debug {
 		fmt.Println(c.ConnID, "rcvd", cmd.cmd, len(cmd.pl))
 	}*/
-
-	return
 }
 
 // Check c.X.AuthAckGot before calling this function
@@ -788,6 +835,8 @@ func maxmsgsize(cmd string) uint {
 		return 500e3 // max segwit tx size 500KB
 	case "addr":
 		return 9 + 1000*30 // max 1000 addrs
+	case "addrv2":
+		return 9 + 1000*(4+9+1+3+btc.MAX_ADDRV2_SIZE+2) // max 1000 addrs
 	case "block":
 		return 4e6 // max segwit block size 4MB
 	case "getblocks":
@@ -808,6 +857,14 @@ func maxmsgsize(cmd string) uint {
 		return 9 + 50000*36 // same as maximum size of getdata
 	case "getmp":
 		return 9 + 8*MAX_GETMP_TXS
+	case "sketch":
+		return 4 * MAX_SKETCH_CAPACITY
+	case "reconcildiff":
+		return 1 + 9 + 4*MAX_SKETCH_CAPACITY
+	case "ancpkginfo", "getpkgtxns":
+		return 9 + 32*txpool.MAX_PACKAGE_COUNT
+	case "pkgtxns":
+		return 9 + txpool.MAX_PACKAGE_WEIGHT
 	default:
 		return 1024 // Any other type of block: maximum 1KB payload limit
 	}
@@ -87,6 +87,20 @@ func (c *OneConnection) processGetData(b *bytes.Reader) {
 				txpool.TxMutex.Unlock()
 				//notfound = append(notfound, h[:]...)
 			}
+		} else if typ == MSG_WTX { // BIP339
+			common.CountSafe("GetdataTxW")
+			txpool.TxMutex.Lock()
+			if tx := txpool.FindByWTxID(btc.NewUint256(h[4:])); tx != nil && tx.Blocked == 0 {
+				tx.SentCnt++
+				tx.Lastsent = time.Now()
+				txpool.TxMutex.Unlock()
+				c.SendRawMsg("tx", tx.Raw, c.X.AuthAckGot)
+			} else {
+				txpool.TxMutex.Unlock()
+			}
+		} else if typ == MSG_ANCPKGINFO { // BIP331
+			common.CountSafe("GetdataAncPkg")
+			c.sendAncPkgInfo(btc.NewUint256(h[4:]))
 		} else if typ == MSG_CMPCT_BLOCK {
 			common.CountSafe("GetdataCmpctBlk")
 			if !c.SendCmpctBlk(btc.NewUint256(h[4:])) {
@@ -115,6 +129,11 @@ func (c *OneConnection) netBlockReceived(cmd *BCmsg) {
 
 	MutexRcv.Lock()
 
+	if c.snapshotBlockReceived(idx, b) {
+		MutexRcv.Unlock()
+		return
+	}
+
 	// the blocks seems to be fine
 	if rb, got := ReceivedBlocks[idx]; got {
 		rb.DownloadCnt++
@@ -346,6 +365,10 @@ func (c *OneConnection) GetBlockData() (yes bool) {
 	defer MutexRcv.Unlock()
 
 	if LowestIndexToBlocksToGet.Load() == 0 || len(BlocksToGet) == 0 {
+		if c.getSnapshotBlocks() {
+			yes = true
+			return
+		}
 		Fetch.NoBlocksToGet++
 		// wake up in one minute, just in case
 		c.nextGetData = time.Now().Add(60 * time.Second)
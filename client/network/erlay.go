@@ -0,0 +1,327 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/others/minisketch"
+	"github.com/piotrnar/gocoin/lib/others/siphash"
+)
+
+// BIP330 - Transaction announcements reconciliation (Erlay)
+
+const (
+	TXRECONCILIATION_VERSION = 1
+
+	RECON_REQUEST_INTERVAL = 8 * time.Second  // how often we ask each of our outbound peers to reconcile
+	RECON_RESPONSE_TIMEOUT = 60 * time.Second // after that we give up waiting for "sketch" and flood the set
+	RECON_DEFAULT_Q        = 0.25
+	RECON_Q_PRECISION      = 1<<15 - 1
+	MAX_RECONSET_SIZE      = 3000 // if the set is full, we flood the tx to the peer
+	MAX_SKETCH_CAPACITY    = 2 << 12
+)
+
+var reconSaltTag = func() []byte {
+	h := sha256.Sum256([]byte("Tx Relay Salting"))
+	return h[:]
+}()
+
+type txRecon struct {
+	salt      uint64 // our salt, from "sendtxrcncl"
+	k0, k1    uint64 // for short ids, computed from both the salts
+	initiator bool   // we send "reqrecon" (outbound connections)
+	gotSalt   bool   // peer's "sendtxrcncl" received
+
+	set      map[uint32]*btc.Uint256 // wtxids to be reconciled, by short id
+	snapshot map[uint32]*btc.Uint256 // the set being reconciled right now (nil if none)
+	q        float64                 // estimated fraction of the set that is not common (initiator only)
+	nextReq  time.Time
+	reqSent  time.Time
+}
+
+// shortID computes the 32 bit id of a tx, which goes into the sketch.
+func (r *txRecon) shortID(wid *btc.Uint256) uint32 {
+	return 1 + uint32(siphash.Hash(r.k0, r.k1, wid.Hash[:]))
+}
+
+// reconCapacity returns the sketch capacity needed to reconcile the given sets.
+func reconCapacity(local, remote int, q float64) (capacity int) {
+	diff, mi := local-remote, remote
+	if diff < 0 {
+		diff, mi = -diff, local
+	}
+	capacity = diff + int(q*float64(mi)) + 1
+	if capacity > MAX_SKETCH_CAPACITY {
+		capacity = MAX_SKETCH_CAPACITY
+	}
+	return
+}
+
+// sendTxRecon is called while handling peer's version, as "sendtxrcncl" must be sent before verack.
+func (c *OneConnection) sendTxRecon() {
+	if !common.Get(&common.CFG.Net.TxRecon) || !common.Get(&common.CFG.TXPool.Enabled) ||
+		c.Node.Version < 70016 || c.Node.DoNotRelayTxs {
+		return
+	}
+	r := &txRecon{initiator: !c.X.Incomming, q: RECON_DEFAULT_Q}
+	var b [12]byte
+	rand.Read(b[4:12])
+	binary.LittleEndian.PutUint32(b[0:4], TXRECONCILIATION_VERSION)
+	r.salt = binary.LittleEndian.Uint64(b[4:12])
+	c.Mutex.Lock()
+	c.recon = r
+	c.Mutex.Unlock()
+	c.SendRawMsg("sendtxrcncl", b[:], false)
+}
+
+// handleSendTxRecon processes "sendtxrcncl" message.
+func (c *OneConnection) handleSendTxRecon(pl []byte) {
+	if c.X.VerackReceived {
+		c.Disconnect(false, "TxRcnclLate") // must come before verack
+		return
+	}
+	if len(pl) < 12 {
+		c.DoS("TxRcnclShort")
+		return
+	}
+	if binary.LittleEndian.Uint32(pl[0:4]) < 1 {
+		c.Disconnect(false, "TxRcnclVer")
+		return
+	}
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	r := c.recon
+	if r == nil || r.gotSalt {
+		c.cntInc("TxRcnclIgnored")
+		return
+	}
+	salt1, salt2 := r.salt, binary.LittleEndian.Uint64(pl[4:12])
+	if salt1 > salt2 {
+		salt1, salt2 = salt2, salt1
+	}
+	sha := sha256.New()
+	sha.Write(reconSaltTag)
+	sha.Write(reconSaltTag)
+	binary.Write(sha, binary.LittleEndian, salt1)
+	binary.Write(sha, binary.LittleEndian, salt2)
+	h := sha.Sum(nil)
+	r.k0 = binary.LittleEndian.Uint64(h[0:8])
+	r.k1 = binary.LittleEndian.Uint64(h[8:16])
+	r.gotSalt = true
+}
+
+// reconRegister is called on verack. Reconciliation is only used if both sides
+// sent "sendtxrcncl" and the wtxid relay has been negotiated.
+func (c *OneConnection) reconRegister() {
+	c.Mutex.Lock()
+	if r := c.recon; r != nil {
+		if r.gotSalt && c.Node.WtxidRelay {
+			r.set = make(map[uint32]*btc.Uint256)
+			r.nextReq = time.Now().Add(RECON_REQUEST_INTERVAL)
+			c.X.TxRecon = true
+			common.CountSafe("TxReconRegistered")
+		} else {
+			c.recon = nil
+		}
+	}
+	c.Mutex.Unlock()
+}
+
+// reconAddTx adds the tx to the peer's reconciliation set, instead of announcing it.
+// Returns false if the tx needs to be flooded instead. Make sure c.Mutex is locked.
+func (c *OneConnection) reconAddTx(wid *btc.Uint256) bool {
+	r := c.recon
+	if !c.X.TxRecon || len(r.set) >= MAX_RECONSET_SIZE {
+		return false
+	}
+	r.set[r.shortID(wid)] = wid
+	return true
+}
+
+// reconFlood announces all the txs from the map. Make sure c.Mutex is locked.
+func (c *OneConnection) reconFlood(set map[uint32]*btc.Uint256) {
+	for _, wid := range set {
+		c.reconAnnounce(wid)
+	}
+}
+
+// Make sure c.Mutex is locked.
+func (c *OneConnection) reconAnnounce(wid *btc.Uint256) {
+	inv := new([36]byte)
+	binary.LittleEndian.PutUint32(inv[0:4], MSG_WTX)
+	copy(inv[4:36], wid.Hash[:])
+	c.PendingInvs = append(c.PendingInvs, inv)
+}
+
+// reconTick sends "reqrecon" to the outbound peers, from time to time.
+func (c *OneConnection) reconTick(now time.Time) {
+	var b [4]byte
+	c.Mutex.Lock()
+	r := c.recon
+	if !c.X.TxRecon || !r.initiator {
+		c.Mutex.Unlock()
+		return
+	}
+	if r.snapshot != nil {
+		if now.Sub(r.reqSent) > RECON_RESPONSE_TIMEOUT {
+			c.cntInc("TxReconTimeout")
+			c.reconFlood(r.snapshot)
+			r.snapshot = nil
+			r.nextReq = now.Add(RECON_REQUEST_INTERVAL)
+		}
+		c.Mutex.Unlock()
+		return
+	}
+	if now.Before(r.nextReq) {
+		c.Mutex.Unlock()
+		return
+	}
+	r.snapshot, r.set = r.set, make(map[uint32]*btc.Uint256)
+	r.reqSent = now
+	binary.LittleEndian.PutUint16(b[0:2], uint16(len(r.snapshot)))
+	binary.LittleEndian.PutUint16(b[2:4], uint16(r.q*RECON_Q_PRECISION))
+	c.Mutex.Unlock()
+	c.SendRawMsg("reqrecon", b[:], false)
+}
+
+// handleReqRecon responds to "reqrecon" with a sketch of our set.
+func (c *OneConnection) handleReqRecon(pl []byte) {
+	if len(pl) < 4 {
+		c.DoS("ReqReconShort")
+		return
+	}
+	c.Mutex.Lock()
+	r := c.recon
+	if !c.X.TxRecon || r.initiator {
+		c.Mutex.Unlock()
+		c.DoS("ReqReconUnexp")
+		return
+	}
+	if r.snapshot != nil {
+		c.cntInc("ReqReconBusy")
+		c.Mutex.Unlock()
+		return
+	}
+	r.snapshot, r.set = r.set, make(map[uint32]*btc.Uint256)
+	remote_size := int(binary.LittleEndian.Uint16(pl[0:2]))
+	q := float64(binary.LittleEndian.Uint16(pl[2:4])) / RECON_Q_PRECISION
+	var raw []byte
+	if len(r.snapshot) > 0 {
+		sketch := minisketch.New(reconCapacity(len(r.snapshot), remote_size, q))
+		for id := range r.snapshot {
+			sketch.Add(id)
+		}
+		raw = sketch.Serialize()
+	} // with our set empty, the empty sketch tells the peer to just announce all its txs
+	c.Mutex.Unlock()
+	c.SendRawMsg("sketch", raw, false)
+}
+
+// handleSketch decodes the difference between the sets and sends "reconcildiff".
+func (c *OneConnection) handleSketch(pl []byte) {
+	if len(pl)%minisketch.ElementSize != 0 || len(pl) > MAX_SKETCH_CAPACITY*minisketch.ElementSize {
+		c.DoS("SketchBad")
+		return
+	}
+	c.Mutex.Lock()
+	r := c.recon
+	if !c.X.TxRecon || !r.initiator || r.snapshot == nil {
+		c.Mutex.Unlock()
+		c.DoS("SketchUnexp")
+		return
+	}
+	snapshot := r.snapshot
+	r.snapshot = nil
+	r.nextReq = time.Now().Add(RECON_REQUEST_INTERVAL)
+	c.Mutex.Unlock()
+
+	var diff []uint32
+	var ok bool
+	if len(pl) > 0 {
+		remote, _ := minisketch.Deserialize(pl)
+		sketch := minisketch.New(remote.Capacity())
+		for id := range snapshot {
+			sketch.Add(id)
+		}
+		sketch.Merge(remote)
+		diff, ok = sketch.Decode(remote.Capacity())
+	}
+
+	b := new(bytes.Buffer)
+	c.Mutex.Lock()
+	if !ok {
+		common.CountSafe("TxReconFailed")
+		c.reconFlood(snapshot)
+		c.Mutex.Unlock()
+		b.WriteByte(0)
+		btc.WriteVlen(b, 0)
+		c.SendRawMsg("reconcildiff", b.Bytes(), false)
+		return
+	}
+	common.CountSafe("TxReconOK")
+	var ask []uint32
+	var ours int
+	for _, id := range diff {
+		if wid, ok := snapshot[id]; ok {
+			c.reconAnnounce(wid)
+			ours++
+		} else {
+			ask = append(ask, id)
+		}
+	}
+	// update q, using the actual size of the peer's set
+	d, mi := ours-len(ask), len(snapshot)-ours+len(ask)
+	if d < 0 {
+		d, mi = -d, len(snapshot)
+	}
+	if mi > 0 {
+		if r.q = float64(len(diff)-d) / float64(mi); r.q > 2.0 {
+			r.q = 2.0
+		}
+	}
+	c.Mutex.Unlock()
+
+	b.WriteByte(1)
+	btc.WriteVlen(b, uint64(len(ask)))
+	for _, id := range ask {
+		binary.Write(b, binary.LittleEndian, id)
+	}
+	c.SendRawMsg("reconcildiff", b.Bytes(), false)
+}
+
+// handleReconcilDiff announces the txs that the peer asked for (or all of them, if the reconciliation failed).
+func (c *OneConnection) handleReconcilDiff(pl []byte) {
+	if len(pl) < 2 {
+		c.DoS("ReconDiffShort")
+		return
+	}
+	cnt, of := btc.VLen(pl[1:])
+	if of == 0 || len(pl) != 1+of+4*cnt {
+		c.DoS("ReconDiffBad")
+		return
+	}
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	r := c.recon
+	if !c.X.TxRecon || r.initiator || r.snapshot == nil {
+		c.cntInc("ReconDiffUnexp")
+		return
+	}
+	if pl[0] == 0 {
+		c.cntInc("ReconDiffFailed")
+		c.reconFlood(r.snapshot)
+	} else {
+		for of++; cnt > 0; cnt-- {
+			if wid, ok := r.snapshot[binary.LittleEndian.Uint32(pl[of:of+4])]; ok {
+				c.reconAnnounce(wid)
+			}
+			of += 4
+		}
+	}
+	r.snapshot = nil
+}
@@ -71,7 +71,7 @@ func (c *OneConnection) ProcessNewHeader(hdr []byte) (int, *OneBlockToGet) {
 	node := common.BlockChain.AcceptHeader(bl)
 	b2g = &OneBlockToGet{Started: c.LastMsgTime, Block: bl, BlockTreeNode: node, InProgress: 0}
 	AddB2G(b2g)
-	if node.Height > LastCommitedHeader.Height {
+	if !node.Invalid && node.Height > LastCommitedHeader.Height {
 		LastCommitedHeader = node
 		//println("LastCommitedHeader:", LastCommitedHeader.Height, "-change to", LastCommitedHeader.BlockHash.String())
 	} else {
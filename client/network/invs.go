@@ -18,6 +18,8 @@ const (
 	MSG_TX            = uint32(1)
 	MSG_BLOCK         = uint32(2)
 	MSG_CMPCT_BLOCK   = uint32(4)
+	MSG_WTX           = uint32(5) // BIP339
+	MSG_ANCPKGINFO    = uint32(6) // BIP331
 	MSG_WITNESS_TX    = uint32(MSG_TX | MSG_WITNESS_FLAG)
 	MSG_WITNESS_BLOCK = uint32(MSG_BLOCK | MSG_WITNESS_FLAG)
 )
@@ -99,11 +101,13 @@ func (c *OneConnection) ProcessInv(pl []byte) {
 					common.CountSafe("InvBlockOld")
 				}
 			}
-		case MSG_TX:
-			if common.AcceptTx() {
-				c.TxInvNotify(pl[of+4 : of+36])
-			} else {
+		case MSG_TX, MSG_WTX:
+			if !common.AcceptTx() {
 				common.CountSafe("InvTxIgnored")
+			} else if (typ == MSG_WTX) != c.MutexGetBool(&c.Node.WtxidRelay) {
+				common.CountSafePar("InvTxWrongType-", typ) // BIP339 says to ignore these
+			} else {
+				c.TxInvNotify(typ, pl[of+4:of+36])
 			}
 		default:
 			common.CountSafePar("InvUnknTyp-", typ)
@@ -114,20 +118,23 @@ func (c *OneConnection) ProcessInv(pl []byte) {
 
 func NetRouteInv(typ uint32, h *btc.Uint256, fromConn *OneConnection) uint32 {
 	var fee_spkb uint64
+	var wid *btc.Uint256
 	if typ == MSG_TX {
 		txpool.TxMutex.Lock()
 		if tx, ok := txpool.TransactionsToSend[h.BIdx()]; ok {
 			fee_spkb = (4000 * tx.Fee) / uint64(tx.Weight())
+			wid = tx.WTxID()
 		} else {
 			println("NetRouteInv: txid", h.String(), "not in mempool")
 		}
 		txpool.TxMutex.Unlock()
 	}
-	return NetRouteInvExt(typ, h, fromConn, fee_spkb)
+	return NetRouteInvExt(typ, h, wid, fromConn, fee_spkb)
 }
 
 // NetRouteInvExt is called from the main thread (or from a UI).
-func NetRouteInvExt(typ uint32, h *btc.Uint256, fromConn *OneConnection, fee_spkb uint64) (cnt uint32) {
+// For MSG_TX, wid is the tx's wtxid, which is announced instead of txid to the peers that asked for it (BIP339).
+func NetRouteInvExt(typ uint32, h, wid *btc.Uint256, fromConn *OneConnection, fee_spkb uint64) (cnt uint32) {
 	common.CountSafePar("NetRouteInv-", typ)
 
 	// Prepare the inv
@@ -135,6 +142,14 @@ func NetRouteInvExt(typ uint32, h *btc.Uint256, fromConn *OneConnection, fee_spk
 	binary.LittleEndian.PutUint32(inv[0:4], typ)
 	copy(inv[4:36], h.Bytes())
 
+	winv := inv
+	to_recon := typ == MSG_TX && wid != nil
+	if to_recon {
+		winv = new([36]byte)
+		binary.LittleEndian.PutUint32(winv[0:4], MSG_WTX)
+		copy(winv[4:36], wid.Bytes())
+	}
+
 	// Append it to PendingInvs in each open connection
 	Mutex_net.Lock()
 	for _, v := range OpenCons {
@@ -151,9 +166,16 @@ func NetRouteInvExt(typ uint32, h *btc.Uint256, fromConn *OneConnection, fee_spk
 				}
 			}
 			if send_inv {
+				inv := inv
+				if v.Node.WtxidRelay {
+					inv = winv
+				}
 				if len(v.PendingInvs) < 500 {
 					if typ, ok := v.InvDone.Map[hash2invid(inv[4:36])]; ok {
 						common.CountSafePar("SendInvSame-", typ)
+					} else if to_recon && v.reconAddTx(wid) {
+						cnt++
+						common.CountSafe("SendInvRecon")
 					} else {
 						v.PendingInvs = append(v.PendingInvs, inv)
 						cnt++
@@ -0,0 +1,163 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/peersdb"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/others/sys"
+	"github.com/piotrnar/gocoin/lib/others/torctl"
+)
+
+const (
+	OnionKeyFile    = "onion_v3_private_key" // in the data folder
+	OnionRetryEvery = 5 * time.Minute
+)
+
+var (
+	OnionStarted sys.SyncBool
+	nextOnionTry time.Time
+
+	onionMutex sync.Mutex
+	onionAddr  *btc.NetAddr
+)
+
+// OnionAddr returns the address of our onion service, or nil if it is not running.
+func OnionAddr() (res *btc.NetAddr) {
+	onionMutex.Lock()
+	res = onionAddr
+	onionMutex.Unlock()
+	return
+}
+
+func setOnionAddr(na *btc.NetAddr) {
+	onionMutex.Lock()
+	onionAddr = na
+	onionMutex.Unlock()
+}
+
+// onion_service publishes our onion service via Tor control port and accepts
+// the incoming connections from it, until the control connection gets broken.
+func onion_service() {
+	defer OnionStarted.Clr()
+
+	ctl, pass := common.GetTorControl()
+	t, e := torctl.Dial(ctl, 10*time.Second)
+	if e != nil {
+		println("Tor control:", e.Error())
+		return
+	}
+	defer t.Close()
+
+	if e = t.Authenticate(pass); e != nil {
+		println("Tor control:", e.Error())
+		return
+	}
+
+	// Tor connects to this local port, which is separate from our regular one,
+	// so we can tell the onion peers from the others
+	lis, e := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(common.ConfiguredTcpPort()) + 1})
+	if e != nil {
+		println("Onion ListenTCP", e.Error())
+		return
+	}
+	defer lis.Close()
+
+	keyfn := common.GocoinHomeDir + OnionKeyFile
+	key, _ := os.ReadFile(keyfn)
+	onion, newkey, e := t.AddOnion(strings.TrimSpace(string(key)), common.DefaultTcpPort, lis.Addr().String())
+	if e != nil {
+		println("Tor control:", e.Error())
+		return
+	}
+	if newkey != "" {
+		if e = os.WriteFile(keyfn, []byte(newkey), 0600); e != nil {
+			println("Cannot save onion key:", e.Error())
+		}
+	}
+	na := btc.NewNetAddrFromHost(onion, common.DefaultTcpPort)
+	if na == nil {
+		println("Tor control: bad onion address", onion)
+		return
+	}
+	na.Services = common.GetServices()
+	setOnionAddr(na)
+	defer setOnionAddr(nil)
+	fmt.Println("Onion service published at", na.String())
+
+	// Tor removes the service when the control connection gets closed
+	ctl_done := make(chan bool, 1)
+	go func() {
+		for {
+			if _, _, e := t.ReadReply(); e != nil {
+				break
+			}
+		}
+		ctl_done <- true
+	}()
+
+	for {
+		select {
+		case <-ctl_done:
+			println("Tor control connection closed - onion service stopped")
+			return
+		default:
+		}
+		if ctl, _ := common.GetTorControl(); ctl == "" {
+			fmt.Println("Onion service disabled")
+			return
+		}
+		lis.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		if tc, e := lis.AcceptTCP(); e == nil {
+			onion_incoming(tc)
+		}
+	}
+}
+
+// onion_incoming starts a new connection that came via our onion service.
+func onion_incoming(tc *net.TCPConn) {
+	Mutex_net.Lock()
+	ica := InConsActive
+	Mutex_net.Unlock()
+	if ica >= common.Get(&common.CFG.Net.MaxInCons) {
+		common.CountSafe("OnionInDenied")
+		tc.Close()
+		return
+	}
+
+	// All these peers come from the localhost, so use the source port to tell one from another.
+	// Such records are never stored in the peers DB.
+	ad := peersdb.NewPeer(nil)
+	ad.NoSave = true
+	ad.Ip4 = [4]byte{127, 0, 0, 1}
+	if ta, ok := tc.RemoteAddr().(*net.TCPAddr); ok {
+		ad.Port = uint16(ta.Port)
+	}
+
+	conn := NewConnection(ad)
+	conn.X.ConnectedAt = time.Now()
+	conn.X.Incomming = true
+	conn.X.IsOnion = true
+	conn.Conn = tc
+	if common.Get(&common.CFG.Net.V2Transport) {
+		conn.v2 = newV2Transport(false)
+	}
+	common.CountSafe("OnionInConnection")
+	Mutex_net.Lock()
+	conn.addToList()
+	InConsActive++
+	Mutex_net.Unlock()
+	go func() {
+		conn.Run()
+		Mutex_net.Lock()
+		conn.delFromList()
+		InConsActive--
+		Mutex_net.Unlock()
+	}()
+}
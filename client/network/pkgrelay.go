@@ -0,0 +1,299 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/txpool"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// BIP331 - Ancestor package relay
+
+const (
+	PKG_RELAY_ANCPKG    = 1 << 0
+	PKG_REQUEST_TIMEOUT = time.Minute // after that we can ask the peer for another package
+)
+
+type pkgRelay struct {
+	versions uint64 // from peer's "sendpackages"
+
+	// The package being fetched right now (reqTime is zero if none)
+	reqTime time.Time
+	req     *btc.Uint256   // wtxid of the tx that we asked "ancpkginfo" for
+	info    []*btc.Uint256 // the package, as received in "ancpkginfo"
+	missing []*btc.Uint256 // wtxids asked for with "getpkgtxns"
+}
+
+func writeWTxIDs(b *bytes.Buffer, ids []*btc.Uint256) {
+	btc.WriteVlen(b, uint64(len(ids)))
+	for _, id := range ids {
+		b.Write(id.Hash[:])
+	}
+}
+
+func readWTxIDs(pl []byte) (ids []*btc.Uint256, ok bool) {
+	b := bytes.NewReader(pl)
+	cnt, er := btc.ReadVLen(b)
+	if er != nil || cnt == 0 || cnt > txpool.MAX_PACKAGE_COUNT || b.Len() != 32*int(cnt) {
+		return
+	}
+	ids = make([]*btc.Uint256, cnt)
+	for i := range ids {
+		ids[i] = new(btc.Uint256)
+		b.Read(ids[i].Hash[:])
+	}
+	ok = true
+	return
+}
+
+// sendPackages is called while handling peer's version, as "sendpackages" must be sent before verack.
+func (c *OneConnection) sendPackages() {
+	if !common.Get(&common.CFG.Net.PkgRelay) || !common.Get(&common.CFG.TXPool.Enabled) ||
+		c.Node.Version < 70016 || c.Node.DoNotRelayTxs {
+		return
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], PKG_RELAY_ANCPKG)
+	c.Mutex.Lock()
+	c.pkg = new(pkgRelay)
+	c.Mutex.Unlock()
+	c.SendRawMsg("sendpackages", b[:], false)
+}
+
+// handleSendPackages processes "sendpackages" message.
+func (c *OneConnection) handleSendPackages(pl []byte) {
+	if c.X.VerackReceived {
+		c.Disconnect(false, "SendPkgsLate") // must come before verack
+		return
+	}
+	if len(pl) < 8 {
+		c.DoS("SendPkgsShort")
+		return
+	}
+	c.Mutex.Lock()
+	if c.pkg != nil {
+		c.pkg.versions = binary.LittleEndian.Uint64(pl[:8])
+	}
+	c.Mutex.Unlock()
+}
+
+// pkgRelayRegister is called on verack. Package relay is only used if both sides
+// sent "sendpackages" with the ancestor package version and the wtxid relay has been negotiated.
+func (c *OneConnection) pkgRelayRegister() {
+	c.Mutex.Lock()
+	if c.pkg != nil {
+		if c.pkg.versions&PKG_RELAY_ANCPKG != 0 && c.Node.WtxidRelay {
+			c.X.PkgRelay = true
+			common.CountSafe("PkgRelayRegistered")
+		} else {
+			c.pkg = nil
+		}
+	}
+	c.Mutex.Unlock()
+}
+
+// requestAncPkg asks the peer for the ancestor package of the tx that has some parents missing.
+// Returns false if the package cannot be requested right now (so the parents should be asked for).
+func (c *OneConnection) requestAncPkg(tx *btc.Tx) bool {
+	c.Mutex.Lock()
+	if !c.X.PkgRelay || !c.pkg.reqTime.IsZero() && time.Since(c.pkg.reqTime) < PKG_REQUEST_TIMEOUT {
+		c.Mutex.Unlock()
+		return false
+	}
+	c.pkg.reqTime = time.Now()
+	c.pkg.req = tx.WTxID()
+	c.pkg.info = nil
+	c.pkg.missing = nil
+	c.Mutex.Unlock()
+
+	var b [1 + 4 + 32]byte
+	b[0] = 1 // One inv
+	binary.LittleEndian.PutUint32(b[1:5], MSG_ANCPKGINFO)
+	copy(b[5:37], tx.WTxID().Hash[:])
+	c.SendRawMsg("getdata", b[:], false)
+	common.CountSafe("PkgInfoRequested")
+	return true
+}
+
+// sendAncPkgInfo responds to getdata(MSG_ANCPKGINFO) with the list of wtxids of
+// the tx's unconfirmed ancestors (sorted, the tx itself at the end).
+func (c *OneConnection) sendAncPkgInfo(wid *btc.Uint256) {
+	b := new(bytes.Buffer)
+	txpool.TxMutex.Lock()
+	if t2s := txpool.FindByWTxID(wid); t2s != nil && t2s.Blocked == 0 {
+		if pkg := t2s.GetAncestorPackage(); len(pkg) <= txpool.MAX_PACKAGE_COUNT {
+			ids := make([]*btc.Uint256, len(pkg))
+			for i, t := range pkg {
+				ids[i] = t.WTxID()
+			}
+			writeWTxIDs(b, ids)
+		}
+	}
+	txpool.TxMutex.Unlock()
+	if b.Len() == 0 {
+		common.CountSafe("AncPkgInfoMissing")
+		return
+	}
+	c.SendRawMsg("ancpkginfo", b.Bytes(), false)
+}
+
+// handleAncPkgInfo processes "ancpkginfo" message - it asks for the txs that we do not have yet.
+func (c *OneConnection) handleAncPkgInfo(pl []byte) {
+	ids, ok := readWTxIDs(pl)
+	if !ok {
+		c.DoS("AncPkgInfoBad")
+		return
+	}
+	c.Mutex.Lock()
+	if !c.X.PkgRelay || c.pkg.req == nil || c.pkg.info != nil || !c.pkg.req.Equal(ids[len(ids)-1]) {
+		c.cntInc("AncPkgInfoUnexp")
+		c.Mutex.Unlock()
+		return
+	}
+	c.pkg.info = ids
+	c.Mutex.Unlock()
+
+	var missing []*btc.Uint256
+	txpool.TxMutex.Lock()
+	for _, id := range ids {
+		if txpool.FindByWTxID(id) == nil {
+			missing = append(missing, id)
+		}
+	}
+	txpool.TxMutex.Unlock()
+	c.Mutex.Lock()
+	if len(missing) == 0 {
+		c.pkg.reqTime = time.Time{}
+		c.pkg.req, c.pkg.info = nil, nil
+		c.Mutex.Unlock()
+		common.CountSafe("AncPkgInfoHaveAll")
+		return
+	}
+	c.pkg.missing = missing
+	c.Mutex.Unlock()
+	b := new(bytes.Buffer)
+	writeWTxIDs(b, missing)
+	c.SendRawMsg("getpkgtxns", b.Bytes(), false)
+}
+
+// handleGetPkgTxns processes "getpkgtxns" message - we only respond if we have all the txs.
+func (c *OneConnection) handleGetPkgTxns(pl []byte) {
+	ids, ok := readWTxIDs(pl)
+	if !ok {
+		c.DoS("GetPkgTxnsBad")
+		return
+	}
+	b := new(bytes.Buffer)
+	btc.WriteVlen(b, uint64(len(ids)))
+	txpool.TxMutex.Lock()
+	for _, id := range ids {
+		t2s := txpool.FindByWTxID(id)
+		// low fee parents are fine here, as the package is supposed to pay for them
+		if t2s == nil || t2s.Blocked != 0 && t2s.Blocked != txpool.TX_REJECTED_LOW_FEE {
+			txpool.TxMutex.Unlock()
+			common.CountSafe("GetPkgTxnsMissing")
+			return
+		}
+		t2s.SentCnt++
+		t2s.Lastsent = time.Now()
+		b.Write(t2s.Raw)
+	}
+	txpool.TxMutex.Unlock()
+	c.SendRawMsg("pkgtxns", b.Bytes(), c.X.AuthAckGot)
+}
+
+// handlePkgTxns processes "pkgtxns" message - the txs must be exactly these that we asked for.
+func (c *OneConnection) handlePkgTxns(pl []byte) {
+	c.Mutex.Lock()
+	if !c.X.PkgRelay || c.pkg.missing == nil {
+		c.cntInc("PkgTxnsUnexp")
+		c.Mutex.Unlock()
+		return
+	}
+	missing := c.pkg.missing
+	c.pkg.reqTime = time.Time{}
+	c.pkg.req, c.pkg.info, c.pkg.missing = nil, nil, nil
+	c.Mutex.Unlock()
+
+	b := bytes.NewReader(pl)
+	cnt, er := btc.ReadVLen(b)
+	if er != nil || int(cnt) != len(missing) {
+		c.DoS("PkgTxnsBadCnt")
+		return
+	}
+	pkg := &txpool.PkgRcvd{FeedbackCB: pkgPoolCB, FromCID: c.ConnID}
+	offs := len(pl) - b.Len()
+	for _, wid := range missing {
+		tx, le := btc.NewTx(pl[offs:])
+		if tx == nil || len(tx.TxIn) == 0 {
+			c.DoS("PkgTxnsBroken")
+			return
+		}
+		tx.SetHash(pl[offs : offs+le])
+		offs += le
+		if !tx.WTxID().Equal(wid) {
+			c.DoS("PkgTxnsWrongTx")
+			return
+		}
+		pkg.Txs = append(pkg.Txs, tx)
+	}
+	if offs != len(pl) {
+		c.DoS("PkgTxnsLenMismatch")
+		return
+	}
+
+	select {
+	case NetPkgs <- pkg:
+		common.CountSafe("PkgTxnsQueued")
+	default:
+		common.CountSafe("PkgChannelFULL")
+	}
+}
+
+func pkgPoolCB(pkg *txpool.PkgRcvd) {
+	c := GetConnFromID(pkg.FromCID)
+	if c == nil {
+		// the connection has been closed since
+		return
+	}
+
+	for i, res := range pkg.TxResults {
+		switch res.Result {
+		case txpool.TX_REJECTED_OVERSPEND:
+			c.DoS("TxOversend")
+			return
+		case txpool.TX_REJECTED_SCRIPT_FAIL:
+			c.DoS("TxScriptFail")
+			return
+		}
+		if !res.Accepted || res.InMempool {
+			continue
+		}
+
+		txpool.TxMutex.Lock()
+		t2s := txpool.TransactionsToSend[pkg.Txs[i].Hash.BIdx()]
+		txpool.TxMutex.Unlock()
+		if t2s == nil {
+			continue // it might have been removed in the meantime
+		}
+		c.Mutex.Lock()
+		c.txsCha[c.txsCurIdx]++
+		c.X.TxsReceived++
+		c.Mutex.Unlock()
+
+		if yes, spkb := isRoutable(t2s); yes {
+			if cnt := NetRouteInvExt(MSG_TX, &t2s.Hash, t2s.WTxID(), c, spkb); cnt > 0 {
+				atomic.AddUint32(&t2s.Invsentcnt, 1)
+			}
+		}
+	}
+	if pkg.Result != 0 {
+		c.Mutex.Lock()
+		c.cntInc("PkgRej-" + txpool.PkgReasonToString(pkg.Result))
+		c.Mutex.Unlock()
+	}
+}
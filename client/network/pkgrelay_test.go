@@ -0,0 +1,170 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/piotrnar/gocoin/client/txpool"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// testPkgConn returns a new connection with package relay negotiated.
+func testPkgConn() (c *OneConnection) {
+	c = NewConnection(nil)
+	c.X.PkgRelay = true
+	c.pkg = new(pkgRelay)
+	return
+}
+
+// testLastSent returns the command and payload of the only message sent since the previous call.
+func testLastSent(c *OneConnection) (cmd string, pl []byte) {
+	if cmd = c.X.LastCmdSent; cmd != "" {
+		pl = append([]byte{}, c.sendBuf[24:c.SendBufProd]...)
+	}
+	c.X.LastCmdSent = ""
+	c.SendBufProd, c.SendBufCons = 0, 0
+	return
+}
+
+// testPkgTx returns a new segwit tx spending the given outputs.
+func testPkgTx(ins ...*btc.TxPrevOut) *btc.Tx {
+	tx := &btc.Tx{Version: 2}
+	for _, in := range ins {
+		tx.TxIn = append(tx.TxIn, &btc.TxIn{Input: *in, Sequence: 0xffffffff})
+		tx.SegWit = append(tx.SegWit, [][]byte{{btc.OP_TRUE}})
+	}
+	tx.TxOut = []*btc.TxOut{{Value: 1000, Pk_script: []byte{btc.OP_TRUE}}}
+	raw := tx.SerializeNew()
+	tx, _ = btc.NewTx(raw)
+	tx.SetHash(raw)
+	return tx
+}
+
+func testWTxIDsPayload(ids ...*btc.Uint256) []byte {
+	b := new(bytes.Buffer)
+	writeWTxIDs(b, ids)
+	return b.Bytes()
+}
+
+func TestWTxIDs(t *testing.T) {
+	var ids []*btc.Uint256
+	for i := 0; i <= txpool.MAX_PACKAGE_COUNT; i++ {
+		ids = append(ids, btc.NewSha2Hash([]byte{byte(i)}))
+	}
+	for _, cnt := range []int{1, 2, txpool.MAX_PACKAGE_COUNT} {
+		res, ok := readWTxIDs(testWTxIDsPayload(ids[:cnt]...))
+		if !ok || len(res) != cnt {
+			t.Fatal("Cannot read", cnt, "wtxids")
+		}
+		for i := range res {
+			if !res[i].Equal(ids[i]) {
+				t.Error("Bad wtxid", i, "of", cnt)
+			}
+		}
+	}
+
+	pl := testWTxIDsPayload(ids[:2]...)
+	bad := map[string][]byte{
+		"empty":     nil,
+		"zero":      testWTxIDsPayload(),
+		"too many":  testWTxIDsPayload(ids...),
+		"too short": pl[:len(pl)-1],
+		"too long":  append(append([]byte{}, pl...), 0),
+	}
+	for name, pl := range bad {
+		if _, ok := readWTxIDs(pl); ok {
+			t.Error("Accepted", name)
+		}
+	}
+}
+
+func TestPkgRelayFlow(t *testing.T) {
+	par := testPkgTx(&btc.TxPrevOut{Vout: 1})
+	child := testPkgTx(&btc.TxPrevOut{Hash: par.Hash.Hash})
+	c := testPkgConn()
+
+	if !c.requestAncPkg(child) {
+		t.Fatal("Package not requested")
+	}
+	cmd, pl := testLastSent(c)
+	if cmd != "getdata" || len(pl) != 37 || pl[0] != 1 || binary.LittleEndian.Uint32(pl[1:5]) != MSG_ANCPKGINFO ||
+		!bytes.Equal(pl[5:], child.WTxID().Hash[:]) {
+		t.Fatal("Bad package request", cmd, pl)
+	}
+	if c.requestAncPkg(par) {
+		t.Error("Another package requested while waiting for the first one")
+	}
+
+	// the package must end with the tx that we asked for
+	c.handleAncPkgInfo(testWTxIDsPayload(child.WTxID(), par.WTxID()))
+	if cmd, _ := testLastSent(c); cmd != "" || c.pkg.info != nil {
+		t.Fatal("Unexpected ancpkginfo processed")
+	}
+
+	// we have none of the txs, so we ask for both
+	info := testWTxIDsPayload(par.WTxID(), child.WTxID())
+	c.handleAncPkgInfo(info)
+	if cmd, pl := testLastSent(c); cmd != "getpkgtxns" || !bytes.Equal(pl, info) {
+		t.Fatal("Bad getpkgtxns", cmd, pl)
+	}
+
+	b := new(bytes.Buffer)
+	btc.WriteVlen(b, 2)
+	b.Write(par.Raw)
+	b.Write(child.Raw)
+	c.handlePkgTxns(b.Bytes())
+	if c.banit {
+		t.Fatal("Peer banned for the right txs:", c.ban_reason)
+	}
+	select {
+	case pkg := <-NetPkgs:
+		if len(pkg.Txs) != 2 || !pkg.Txs[0].Hash.Equal(&par.Hash) || !pkg.Txs[1].Hash.Equal(&child.Hash) || pkg.FromCID != c.ConnID {
+			t.Error("Bad package received", pkg.Txs)
+		}
+	default:
+		t.Fatal("Package not queued")
+	}
+	if !c.pkg.reqTime.IsZero() || c.pkg.missing != nil {
+		t.Error("Package request not finished")
+	}
+
+	// nothing has been asked for now
+	c.handlePkgTxns(b.Bytes())
+	if c.banit || len(NetPkgs) != 0 {
+		t.Error("Unexpected pkgtxns processed")
+	}
+}
+
+func TestPkgRelayWrongTx(t *testing.T) {
+	par := testPkgTx(&btc.TxPrevOut{Vout: 1})
+	child := testPkgTx(&btc.TxPrevOut{Hash: par.Hash.Hash})
+	other := testPkgTx(&btc.TxPrevOut{Vout: 2})
+
+	c := testPkgConn()
+	c.requestAncPkg(child)
+	c.handleAncPkgInfo(testWTxIDsPayload(par.WTxID(), child.WTxID()))
+	testLastSent(c)
+
+	b := new(bytes.Buffer)
+	btc.WriteVlen(b, 2)
+	b.Write(other.Raw)
+	b.Write(child.Raw)
+	c.handlePkgTxns(b.Bytes())
+	if !c.banit || c.ban_reason != "PkgTxnsWrongTx" || len(NetPkgs) != 0 {
+		t.Error("Peer not banned for a wrong tx:", c.ban_reason)
+	}
+
+	// and for a wrong number of txs
+	c = testPkgConn()
+	c.requestAncPkg(child)
+	c.handleAncPkgInfo(testWTxIDsPayload(par.WTxID(), child.WTxID()))
+	testLastSent(c)
+	b.Reset()
+	btc.WriteVlen(b, 1)
+	b.Write(child.Raw)
+	c.handlePkgTxns(b.Bytes())
+	if !c.banit || c.ban_reason != "PkgTxnsBadCnt" {
+		t.Error("Peer not banned for a wrong txs count:", c.ban_reason)
+	}
+}
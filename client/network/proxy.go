@@ -0,0 +1,52 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/peersdb"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/others/socks5"
+)
+
+// proxyAuth returns random credentials, so Tor would use a separate circuit for each connection.
+func proxyAuth(randomize bool) *socks5.Auth {
+	if !randomize {
+		return nil
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return &socks5.Auth{User: hex.EncodeToString(b[:]), Pass: hex.EncodeToString(b[:])}
+}
+
+// canConnectTo returns false for addresses we do not know how to reach.
+func canConnectTo(ad *peersdb.PeerAddr) bool {
+	switch ad.Network() {
+	case btc.NETID_IPV4:
+		return true
+	case btc.NETID_TORV3:
+		proxy, _ := common.GetProxy(true)
+		return proxy != ""
+	}
+	return false
+}
+
+// dial opens TCP connection to the peer - directly or via the configured SOCKS5 proxy.
+func (c *OneConnection) dial() (net.Conn, error) {
+	ad := c.PeerAddr
+	onion := ad.Network() == btc.NETID_TORV3
+	proxy, randomize := common.GetProxy(onion)
+	addr := fmt.Sprint(ad.HostString(), ":", ad.Port)
+	if proxy == "" {
+		if onion {
+			return nil, fmt.Errorf("no proxy for %s", addr)
+		}
+		return net.DialTimeout("tcp4", addr, TCPDialTimeout)
+	}
+	common.CountSafe("ProxyDial")
+	c.viaProxy = true
+	return socks5.Dial(proxy, addr, proxyAuth(randomize), TCPDialTimeout)
+}
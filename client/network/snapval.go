@@ -0,0 +1,341 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
+)
+
+/*
+Background validation of a loaded UTXO snapshot (assumeutxo).
+When there are no other blocks to fetch, the blocks from genesis up to the
+snapshot's base are being downloaded and applied to a separate chain, kept
+in the "snapval" folder (only the UTXO set and the headers are stored there).
+When it reaches the base block, its UTXO set's hash must match the snapshot's.
+*/
+
+const (
+	SNAPVAL_DIR          = "snapval"
+	SNAPVAL_INFO_FILE    = "snapshot.txt" // base block hash and hash_serialized_3 of the snapshot
+	SNAPVAL_MAX_BLOCKS   = 256            // blocks being downloaded or waiting to be applied
+	SNAPVAL_MAX_PER_PEER = 16
+)
+
+type snapValReq struct {
+	sent   time.Time // zero to request it again
+	height uint32
+}
+
+type snapshotValidator struct {
+	sync.Mutex
+	ch       *chain.Chain
+	nodes    []*chain.BlockTreeNode // main chain's blocks up to the snapshot's base (index is the height)
+	hash     []byte                 // hash_serialized_3 of the snapshot
+	inprog   map[btc.BIDX]*snapValReq
+	rcvd     map[uint32]*btc.Block
+	next     uint32 // lowest height not requested yet
+	newblk   chan struct{}
+	quit     chan struct{}
+	done     sync.WaitGroup
+	height   atomic.Uint32 // last validated block
+	finished bool
+	err      error
+}
+
+// make sure to access it with MutexRcv locked
+var snapVal *snapshotValidator
+
+func snapValDir() string {
+	return common.GocoinHomeDir + SNAPVAL_DIR + string(os.PathSeparator)
+}
+
+// StartSnapshotValidation begins the background validation of the UTXO snapshot that has
+// been loaded at the given block. Call it from the main thread.
+func StartSnapshotValidation(base *chain.BlockTreeNode, hash []byte) (e error) {
+	StopSnapshotValidation()
+	os.RemoveAll(snapValDir())
+	if e = os.MkdirAll(snapValDir(), 0770); e != nil {
+		return
+	}
+	info := base.BlockHash.String() + "\n" + btc.NewUint256(hash).String() + "\n"
+	if e = os.WriteFile(snapValDir()+SNAPVAL_INFO_FILE, []byte(info), 0600); e != nil {
+		return
+	}
+	openSnapshotValidation(base, hash)
+	return
+}
+
+// ResumeSnapshotValidation continues the background validation after a restart, if there is one pending.
+func ResumeSnapshotValidation() {
+	d, er := os.ReadFile(snapValDir() + SNAPVAL_INFO_FILE)
+	if er != nil {
+		return
+	}
+	ls := strings.Fields(string(d))
+	var hash []byte
+	var base *chain.BlockTreeNode
+	if len(ls) == 2 {
+		if bh := btc.NewUint256FromString(ls[0]); bh != nil {
+			common.BlockChain.BlockIndexAccess.Lock()
+			base = common.BlockChain.BlockIndex[bh.BIdx()]
+			common.BlockChain.BlockIndexAccess.Unlock()
+		}
+		if h := btc.NewUint256FromString(ls[1]); h != nil {
+			hash = h.Hash[:]
+		}
+	}
+	if base == nil || hash == nil {
+		fmt.Println("Snapshot validation: corrupt", SNAPVAL_INFO_FILE, "- remove", snapValDir(), "folder")
+		return
+	}
+	openSnapshotValidation(base, hash)
+}
+
+func openSnapshotValidation(base *chain.BlockTreeNode, hash []byte) {
+	sv := &snapshotValidator{hash: hash, inprog: make(map[btc.BIDX]*snapValReq),
+		rcvd: make(map[uint32]*btc.Block), newblk: make(chan struct{}, 1), quit: make(chan struct{})}
+	sv.nodes = make([]*chain.BlockTreeNode, base.Height+1)
+	for n := base; n != nil; n = n.Parent {
+		sv.nodes[n.Height] = n
+	}
+	sv.ch = chain.NewChainExt(snapValDir(), common.GenesisBlock, false,
+		&chain.NewChanOpts{DoNotRescan: true, CompressUTXO: common.CFG.UTXOSave.CompressRecords,
+			UTXODiskMode: common.CFG.UTXOSave.DiskMode, UTXODiskCache: int(common.CFG.UTXOSave.DiskCacheMB) << 20},
+		&chain.BlockDBOpts{MaxCachedBlocks: 1})
+	sv.height.Store(sv.ch.LastBlock().Height)
+	sv.next = sv.ch.LastBlock().Height + 1
+	fmt.Println("Snapshot validation: at block", sv.ch.LastBlock().Height, "of", base.Height)
+
+	sv.done.Add(1)
+	go sv.run()
+
+	MutexRcv.Lock()
+	snapVal = sv
+	MutexRcv.Unlock()
+}
+
+// StopSnapshotValidation closes the background validation's chain (it will continue after a restart).
+func StopSnapshotValidation() {
+	MutexRcv.Lock()
+	sv := snapVal
+	snapVal = nil
+	MutexRcv.Unlock()
+	if sv == nil {
+		return
+	}
+	close(sv.quit)
+	sv.done.Wait()
+	if sv.ch != nil {
+		sv.ch.Close()
+	}
+}
+
+// SnapshotValidationStatus returns a text describing the background validation of the UTXO snapshot.
+func SnapshotValidationStatus() (s string) {
+	MutexRcv.Lock()
+	sv := snapVal
+	MutexRcv.Unlock()
+	if sv == nil {
+		return "not in progress"
+	}
+	sv.Lock()
+	defer sv.Unlock()
+	if sv.err != nil {
+		return "FAILED: " + sv.err.Error()
+	}
+	base := len(sv.nodes) - 1
+	if sv.finished {
+		return fmt.Sprint("snapshot at block ", base, " validated OK")
+	}
+	return fmt.Sprint("block ", sv.height.Load(), " of ", base, ", ", len(sv.inprog), " downloading, ",
+		len(sv.rcvd), " waiting")
+}
+
+// getSnapshotBlocks requests blocks for the background validation. Call it with MutexRcv locked.
+func (c *OneConnection) getSnapshotBlocks() (yes bool) {
+	sv := snapVal
+	if sv == nil || (c.Node.Services&btc.SERVICE_NETWORK) == 0 {
+		return
+	}
+	c.Mutex.Lock()
+	cbip := len(c.GetBlockInProgress)
+	expired := c.X.BlocksExpired
+	c.Mutex.Unlock()
+	if expired > 0 || cbip >= SNAPVAL_MAX_PER_PEER {
+		return
+	}
+
+	sv.Lock()
+	defer sv.Unlock()
+	if sv.finished || sv.err != nil {
+		return
+	}
+
+	var cnt uint64
+	invs := new(bytes.Buffer)
+	now := time.Now()
+	request := func(n *chain.BlockTreeNode, r *snapValReq) {
+		binary.Write(invs, binary.LittleEndian, MSG_WITNESS_BLOCK)
+		invs.Write(n.BlockHash.Hash[:])
+		r.sent = now
+		sv.inprog[n.BlockHash.BIdx()] = r
+		c.Mutex.Lock()
+		c.GetBlockInProgress[n.BlockHash.BIdx()] = &oneBlockDl{hash: n.BlockHash, start: now, SentAtPingCnt: c.X.PingSentCnt}
+		c.Mutex.Unlock()
+		cnt++
+	}
+
+	// first the ones that had not arrived on time
+	for _, r := range sv.inprog {
+		if cbip+int(cnt) >= SNAPVAL_MAX_PER_PEER {
+			break
+		}
+		if now.Sub(r.sent) > BlockDownloadTimeout && r.height <= c.Node.Height {
+			request(sv.nodes[r.height], r)
+		}
+	}
+	for cbip+int(cnt) < SNAPVAL_MAX_PER_PEER && len(sv.inprog)+len(sv.rcvd) < SNAPVAL_MAX_BLOCKS &&
+		sv.next < uint32(len(sv.nodes)) && sv.next <= c.Node.Height {
+		request(sv.nodes[sv.next], &snapValReq{height: sv.next})
+		sv.next++
+	}
+	if cnt == 0 {
+		return
+	}
+
+	bu := new(bytes.Buffer)
+	btc.WriteVlen(bu, cnt)
+	c.SendRawMsg("getdata", append(bu.Bytes(), invs.Bytes()...), false)
+	c.Mutex.Lock()
+	c.cntInc("SnapValGetData")
+	c.keepBlocksOver = 3 * len(c.GetBlockInProgress) / 4
+	c.Mutex.Unlock()
+	yes = true
+	return
+}
+
+// snapshotBlockReceived takes the block, if it was requested for the background validation.
+// Call it with MutexRcv locked.
+func (c *OneConnection) snapshotBlockReceived(idx btc.BIDX, b []byte) bool {
+	sv := snapVal
+	if sv == nil {
+		return false
+	}
+	sv.Lock()
+	r, ok := sv.inprog[idx]
+	if ok {
+		delete(sv.inprog, idx)
+	}
+	sv.Unlock()
+	if !ok {
+		return false
+	}
+
+	c.Mutex.Lock()
+	delete(c.GetBlockInProgress, idx)
+	c.cntInc("SnapValBlock")
+	c.Mutex.Unlock()
+
+	bl, er := btc.NewBlock(b)
+	sv.Lock()
+	if er == nil {
+		sv.rcvd[r.height] = bl
+	} else {
+		r.sent = time.Time{}
+		sv.inprog[idx] = r
+	}
+	sv.Unlock()
+	select {
+	case sv.newblk <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// run applies the downloaded blocks to the validation chain, in order.
+func (sv *snapshotValidator) run() {
+	var last_idle time.Time
+	defer sv.done.Done()
+	base := sv.nodes[len(sv.nodes)-1]
+	for {
+		height := sv.ch.LastBlock().Height + 1
+		if height > base.Height {
+			sv.finish()
+			return
+		}
+
+		sv.Lock()
+		bl := sv.rcvd[height]
+		delete(sv.rcvd, height)
+		sv.Unlock()
+
+		if bl == nil {
+			if time.Since(last_idle) > time.Minute {
+				sv.ch.Idle()
+				last_idle = time.Now()
+			}
+			select {
+			case <-sv.newblk:
+			case <-sv.quit:
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		bl.Trusted.Store(sv.nodes[height].Trusted.Get())
+		if er := sv.ch.ConnectBlockNoData(bl, base.Height); er != nil {
+			if !bl.MerkleRootMatch() || strings.Contains(er.Error(), "RPC_Result:bad-witness") {
+				// it was a corrupt copy of the block, so ask for it again
+				common.CountSafe("SnapValBadBlock")
+				sv.Lock()
+				sv.inprog[bl.Hash.BIdx()] = &snapValReq{height: height}
+				sv.Unlock()
+				continue
+			}
+			sv.Lock()
+			sv.err = fmt.Errorf("block %d %s: %s", height, bl.Hash.String(), er.Error())
+			sv.Unlock()
+			fmt.Println("WARNING! Snapshot validation failed at", sv.err.Error())
+			return
+		}
+		sv.height.Store(height)
+		common.CountSafe("SnapValBlockOK")
+
+		select {
+		case <-sv.quit:
+			return
+		default:
+		}
+	}
+}
+
+// finish compares the UTXO set of the validation chain with the snapshot.
+func (sv *snapshotValidator) finish() {
+	fmt.Println("Snapshot validation: calculating UTXO set hash at block", sv.ch.LastBlock().Height, "...")
+	hash := sv.ch.Unspent.SnapshotHash()
+	sv.Lock()
+	if !bytes.Equal(hash, sv.hash) {
+		sv.err = errors.New("UTXO set hash " + btc.NewUint256(hash).String() + " does not match the snapshot's " +
+			btc.NewUint256(sv.hash).String())
+		sv.Unlock()
+		fmt.Println("WARNING! Snapshot validation failed:", sv.err.Error())
+		return
+	}
+	sv.finished = true
+	sv.ch.Close()
+	sv.ch = nil
+	sv.Unlock()
+	os.RemoveAll(snapValDir())
+	fmt.Println("Snapshot validation: UTXO snapshot at block", len(sv.nodes)-1, "validated OK")
+}
@@ -172,6 +172,8 @@ func (c *OneConnection) Tick(now time.Time) {
 
 		c.expire_misbehave(tck)
 
+		c.reconTick(now)
+
 		// Tick the recent transactions counter
 		if now.After(c.txsNxt) {
 			c.Mutex.Lock()
@@ -250,6 +252,9 @@ func DoNetwork(ad *peersdb.PeerAddr) {
 	if ad.Friend || ad.Manual {
 		conn.MutexSetBool(&conn.X.IsSpecial, true)
 	}
+	if common.Get(&common.CFG.Net.V2Transport) && (ad.Services&btc.SERVICE_P2P_V2) != 0 {
+		conn.v2 = newV2Transport(true)
+	}
 	conn.addToList()
 	OutConsActive++
 	Mutex_net.Unlock()
@@ -258,11 +263,11 @@ func DoNetwork(ad *peersdb.PeerAddr) {
 		var e error
 		con_done := make(chan bool, 1)
 
-		go func(addr string) {
+		go func() {
 			// we do net.Dial() in paralell routine, so we can abort quickly upon request
-			con, e = net.DialTimeout("tcp4", addr, TCPDialTimeout)
+			con, e = conn.dial()
 			con_done <- true
-		}(fmt.Sprintf("%d.%d.%d.%d:%d", ad.Ip4[0], ad.Ip4[1], ad.Ip4[2], ad.Ip4[3], ad.Port))
+		}()
 
 		for {
 			select {
@@ -288,6 +293,12 @@ func DoNetwork(ad *peersdb.PeerAddr) {
 		conn.delFromList()
 		OutConsActive--
 		Mutex_net.Unlock()
+		if conn.v2 != nil && conn.Conn != nil && !conn.X.V2Transport {
+			// v2 handshake failed - next time try to connect using v1
+			common.CountSafe("V2HandshakeFailed")
+			ad.Services &^= btc.SERVICE_P2P_V2
+			conn.dead = false
+		}
 		if conn.dead {
 			ad.Dead()
 		} else {
@@ -347,6 +358,9 @@ func tcp_server() {
 						conn.X.ConnectedAt = time.Now()
 						conn.X.Incomming = true
 						conn.Conn = tc
+						if common.Get(&common.CFG.Net.V2Transport) {
+							conn.v2 = newV2Transport(false)
+						}
 						Mutex_net.Lock()
 						if _, ok := OpenCons[ad.UniqID()]; ok {
 							//fmt.Println(ad.Ip(), "already connected")
@@ -519,6 +533,14 @@ func NetworkTick() {
 
 	now := time.Now()
 
+	if !OnionStarted.Get() && now.After(nextOnionTry) {
+		if ctl, _ := common.GetTorControl(); ctl != "" {
+			OnionStarted.Set()
+			nextOnionTry = now.Add(OnionRetryEvery)
+			go onion_service()
+		}
+	}
+
 	// Push GetHeaders if not in progress
 	Mutex_net.Lock()
 	var cnt_headers_in_progress int
@@ -652,7 +674,8 @@ func NetworkTick() {
 			required_mask |= btc.SERVICE_NETWORK // for IBD, only take nodes that serve all blocks
 		}
 		adrs := peersdb.GetRecentPeers(128, false, func(ad *peersdb.PeerAddr) bool {
-			return ad.Banned != 0 || !ad.SeenAlive || (ad.Services&required_mask) != required_mask || ConnectionActive(ad)
+			return ad.Banned != 0 || !ad.SeenAlive || (ad.Services&required_mask) != required_mask ||
+				!canConnectTo(ad) || ConnectionActive(ad)
 		})
 		// now fetch another 32 never tried peers (this time sorted)
 		new_cnt := int(32)
@@ -660,7 +683,8 @@ func NetworkTick() {
 			new_cnt = len(adrs)
 		}
 		adrs2 := peersdb.GetRecentPeers(uint(new_cnt), true, func(ad *peersdb.PeerAddr) bool {
-			return ad.Banned != 0 || ad.SeenAlive || (ad.Services&required_mask) != required_mask // ignore those that have been seen alive
+			return ad.Banned != 0 || ad.SeenAlive || (ad.Services&required_mask) != required_mask || // ignore those that have been seen alive
+				!canConnectTo(ad)
 		})
 		adrs = append(adrs, adrs2...)
 		// Now we should have 128 peers known to be alive and 32 never tried ones
@@ -742,6 +766,9 @@ func (c *OneConnection) Run() {
 	c.writing_thread_push = make(chan bool, 1)
 
 	if !c.X.Incomming {
+		if c.v2 != nil {
+			c.v2SendKey()
+		}
 		c.SendVersion()
 	}
 
@@ -786,6 +813,10 @@ func (c *OneConnection) Run() {
 				c.processGetData(bytes.NewReader(tmp))
 			}
 
+			if c.cfilters != nil && !c.SendingPaused() {
+				c.sendCFilters()
+			}
+
 			if !read_tried {
 				// it will end up here if we did not even try to read anything because of BW limit
 				time.Sleep(10 * time.Millisecond)
@@ -850,7 +881,7 @@ func (c *OneConnection) Run() {
 			c.PeerAddr.NodeAgent = c.Node.Agent
 			c.PeerAddr.Alive()
 
-			if common.IsListenTCP() {
+			if common.IsListenTCP() || OnionAddr() != nil {
 				c.SendOwnAddr()
 			}
 			continue
@@ -872,6 +903,56 @@ func (c *OneConnection) Run() {
 		case "addr":
 			c.ParseAddr(cmd.pl)
 
+		case "addrv2":
+			c.ParseAddrV2(cmd.pl)
+
+		case "sendaddrv2":
+			if c.X.VerackReceived {
+				c.Misbehave("SendAddrV2Late", 1000/10) // BIP155: it must come before verack
+				break
+			}
+			c.MutexSetBool(&c.Node.SendAddrV2, true)
+
+		case "verack":
+			c.X.VerackReceived = true
+			c.reconRegister()
+			c.pkgRelayRegister()
+
+		case "sendtxrcncl":
+			c.handleSendTxRecon(cmd.pl)
+
+		case "reqrecon":
+			c.handleReqRecon(cmd.pl)
+
+		case "sketch":
+			c.handleSketch(cmd.pl)
+
+		case "reconcildiff":
+			c.handleReconcilDiff(cmd.pl)
+
+		case "sendpackages":
+			c.handleSendPackages(cmd.pl)
+
+		case "ancpkginfo":
+			c.handleAncPkgInfo(cmd.pl)
+
+		case "getpkgtxns":
+			c.handleGetPkgTxns(cmd.pl)
+
+		case "pkgtxns":
+			if common.AcceptTx() {
+				c.handlePkgTxns(cmd.pl)
+			}
+
+		case "wtxidrelay":
+			if c.X.VerackReceived {
+				c.Disconnect(false, "WtxidRelayLate") // BIP339: it must come before verack
+				break
+			}
+			if c.Node.Version >= 70016 {
+				c.MutexSetBool(&c.Node.WtxidRelay, true)
+			}
+
 		case "block": //block received
 			c.netBlockReceived(cmd)
 			c.MutexSetBool(&c.X.GetBlocksDataNow, true) // ask for more blocks during next tick
@@ -984,6 +1065,15 @@ func (c *OneConnection) Run() {
 		case "getmpdone":
 			c.GetMPDone(cmd.pl)
 
+		case "getcfilters":
+			c.ProcessGetCFilters(cmd.pl)
+
+		case "getcfheaders":
+			c.ProcessGetCFHeaders(cmd.pl)
+
+		case "getcfcheckpt":
+			c.ProcessGetCFCheckpt(cmd.pl)
+
 		case "filterload", "filteradd", "filterclear", "merkleblock":
 			c.DoS("SPV")
 
@@ -1014,7 +1104,7 @@ func (c *OneConnection) Run() {
 		if ban {
 			c.PeerAddr.Ban(c.ban_reason)
 			common.CountSafe("PeersBanned")
-		} else if c.X.Incomming && !c.MutexGetBool(&c.X.Authorized) {
+		} else if c.X.Incomming && !c.X.IsOnion && !c.MutexGetBool(&c.X.Authorized) {
 			var rd *RecentlyDisconenctedType
 			HammeringMutex.Lock()
 			rd = RecentlyDisconencted[c.PeerAddr.NetAddr.Ip4]
@@ -54,11 +54,19 @@ func (c *OneConnection) SendGetMP() error {
 }
 
 // TxInvNotify handles tx-inv notifications.
-func (c *OneConnection) TxInvNotify(hash []byte) (res bool) {
-	if txpool.NeedThisTxExt(btc.NewUint256(hash), nil) == 0 {
+// The typ is either MSG_TX (hash is txid) or MSG_WTX (hash is wtxid).
+func (c *OneConnection) TxInvNotify(typ uint32, hash []byte) (res bool) {
+	var why_not int
+	if typ == MSG_WTX {
+		why_not = txpool.NeedThisWTx(btc.NewUint256(hash))
+	} else {
+		why_not = txpool.NeedThisTxExt(btc.NewUint256(hash), nil)
+		typ = MSG_WITNESS_TX // SegWit Tx
+	}
+	if why_not == 0 {
 		var b [1 + 4 + 32]byte
-		b[0] = 1                                              // One inv
-		binary.LittleEndian.PutUint32(b[1:5], MSG_WITNESS_TX) // SegWit Tx
+		b[0] = 1 // One inv
+		binary.LittleEndian.PutUint32(b[1:5], typ)
 		copy(b[5:37], hash)
 		c.SendRawMsg("getdata", b[:], false)
 		res = true
@@ -110,6 +118,9 @@ func txPoolCB(ntx *txpool.TxRcvd, t2s *txpool.OneTxToSend) {
 	if result := ntx.Result; result != 0 {
 		switch result {
 		case txpool.TX_REJECTED_NO_TXOU:
+			if c.requestAncPkg(ntx.Tx) {
+				break // the missing parents will come with the package
+			}
 			alreadyin := make(map[[32]byte]struct{}, len(ntx.TxIn))
 			missing_inputs := make([]int, 0, len(ntx.TxIn))
 			for txinidx, txin := range ntx.TxIn {
@@ -151,7 +162,7 @@ func txPoolCB(ntx *txpool.TxRcvd, t2s *txpool.OneTxToSend) {
 	c.Mutex.Unlock()
 
 	if yes, spkb := isRoutable(t2s); yes {
-		if cnt := NetRouteInvExt(MSG_TX, &t2s.Hash, c, spkb); cnt > 0 {
+		if cnt := NetRouteInvExt(MSG_TX, &t2s.Hash, t2s.WTxID(), c, spkb); cnt > 0 {
 			atomic.AddUint32(&t2s.Invsentcnt, 1)
 		}
 	}
@@ -176,7 +187,7 @@ func (c *OneConnection) ParseTxNet(cmd *BCmsg) {
 
 	tx.SetHash(cmd.pl)
 
-	txpool.NeedThisTxExt(&tx.Hash, func() {
+	txpool.NeedThisNetTx(tx, func() {
 		// This body is called with a locked TxMutex
 		select {
 		case NetTxs <- &txpool.TxRcvd{FeedbackCB: txPoolCB, FromCID: c.ConnID, Tx: tx, Trusted: cmd.trusted}:
@@ -216,7 +227,7 @@ func (c *OneConnection) ProcessGetMP(pl []byte) {
 	var redo [1]byte
 
 	txpool.TxMutex.Lock()
-	txs := txpool.GetSortedMempoolRBF() // we want to send parent txs first, thus the sorting
+	txs := txpool.GetSortedMempool() // we want to send parent txs first, thus the sorting
 	for _, v := range txs {
 		c.Mutex.Lock()
 		bts := c.BytesToSent()
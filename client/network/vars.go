@@ -56,8 +56,9 @@ var (
 	LastCommitedHeader       *chain.BlockTreeNode
 	MutexRcv                 sync.Mutex
 
-	NetBlocks chan *BlockRcvd     = make(chan *BlockRcvd, 512)
-	NetTxs    chan *txpool.TxRcvd = make(chan *txpool.TxRcvd, 2048)
+	NetBlocks chan *BlockRcvd      = make(chan *BlockRcvd, 512)
+	NetTxs    chan *txpool.TxRcvd  = make(chan *txpool.TxRcvd, 2048)
+	NetPkgs   chan *txpool.PkgRcvd = make(chan *txpool.PkgRcvd, 64)
 
 	CachedBlocksMutex   sync.Mutex
 	CachedBlocksIdx     map[uint32][]*BlockRcvd = make(map[uint32][]*BlockRcvd, MAX_BLOCKS_FORWARD_CNT)
@@ -225,3 +226,41 @@ func DelB2G(idx btc.BIDX) {
 
 	delete(BlocksToGet, idx)
 }
+
+// DropBlocksBelow forgets about the blocks, not higher than the given one, that are
+// yet to be fetched or committed (used after the chain's tip has been moved forward).
+func DropBlocksBelow(height uint32) {
+	var dropped []btc.BIDX
+	MutexRcv.Lock()
+	for idx, b2g := range BlocksToGet {
+		if b2g.BlockTreeNode.Height <= height {
+			dropped = append(dropped, idx)
+		}
+	}
+	for _, idx := range dropped {
+		DelB2G(idx)
+	}
+
+	var cached []*BlockRcvd
+	CachedBlocksMutex.Lock()
+	for h, bls := range CachedBlocksIdx {
+		if h <= height {
+			cached = append(cached, bls...)
+		}
+	}
+	CachedBlocksMutex.Unlock()
+	for _, bl := range cached {
+		CachedBlocksDel(bl)
+	}
+	MutexRcv.Unlock()
+
+	Mutex_net.Lock()
+	for _, c := range OpenCons {
+		c.Mutex.Lock()
+		for _, idx := range dropped {
+			delete(c.GetBlockInProgress, idx)
+		}
+		c.Mutex.Unlock()
+	}
+	Mutex_net.Unlock()
+}
@@ -22,7 +22,7 @@ func (c *OneConnection) SendVersion() {
 	b := bytes.NewBuffer([]byte{})
 
 	binary.Write(b, binary.LittleEndian, uint32(common.Version))
-	binary.Write(b, binary.LittleEndian, uint64(common.Services))
+	binary.Write(b, binary.LittleEndian, common.GetServices())
 	binary.Write(b, binary.LittleEndian, uint64(time.Now().Unix()))
 
 	b.Write(c.PeerAddr.NetAddr.Bytes())
@@ -140,6 +140,11 @@ func (c *OneConnection) HandleVersion(pl []byte) error {
 	}
 	Mutex_net.Unlock()
 
+	if use_this_ip && (c.viaProxy || c.X.IsOnion) {
+		common.CountSafe("IgnoreExtIP-P") // the peer can only see our proxy's IP
+		use_this_ip = false
+	}
+
 	if use_this_ip {
 		if bytes.Equal(pl[40:44], c.PeerAddr.Ip4[:]) {
 			common.CountSafe("IgnoreExtIP-O")
@@ -174,6 +179,12 @@ func (c *OneConnection) HandleVersion(pl []byte) error {
 		ExternalIpMutex.Unlock()
 	}
 
+	if c.Node.Version >= 70016 {
+		c.SendRawMsg("wtxidrelay", nil, false) // BIP339 requires it to be sent before verack
+	}
+	c.SendRawMsg("sendaddrv2", nil, false) // BIP155 requires it to be sent before verack
+	c.sendTxRecon()
+	c.sendPackages()
 	c.SendRawMsg("verack", []byte{}, false)
 	return nil
 }
@@ -19,6 +19,7 @@ import (
 	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/lib/others/qdb"
+	"github.com/piotrnar/gocoin/lib/others/socks5"
 	"github.com/piotrnar/gocoin/lib/others/sys"
 )
 
@@ -46,7 +47,8 @@ Serialized peer record (all values are LSB unless specified otherwise):
     bit(0) - Indicates BanReadon present (byte_len followed by the string)
     bit(1) - Indicates CameFromIP present (for IP4: len byte 4 followed by 4 bytes of IP)
     bit(2) - Agent string from the Version message
-	bits(2-7) - reserved
+    bit(3) - BIP155 address (network ID byte followed by the address) - IPv6 and IPv4 fields are zero then
+	bits(4-7) - reserved
 
   Extra fields are always present int the order defined by the flags (from bit 0 to 7).
   Each extra field is one byte of length followed by the length bytes of data.
@@ -59,7 +61,6 @@ var (
 	peerdb_mutex sync.Mutex
 
 	ConnectOnly string
-	Services    uint64 = 1
 
 	crctab = crc64.MakeTable(crc64.ISO)
 )
@@ -77,6 +78,7 @@ type PeerAddr struct {
 	key_set   bool // cached to avid key crc64 re-calculations
 	Manual    bool // Manually connected (from UI)
 	Friend    bool // Connected from friends.txt
+	NoSave    bool // Never store this record in the DB (e.g. inbound connection via onion service)
 
 }
 
@@ -143,6 +145,11 @@ func NewPeer(v []byte) (p *PeerAddr) {
 							p.CameFromIP = dat
 						case 2:
 							p.NodeAgent = string(dat)
+						case 3:
+							if len(dat) > 1 {
+								p.NetID = dat[0]
+								p.AddrV2 = dat[1:]
+							}
 						}
 					}
 					extra_fields >>= 1
@@ -167,6 +174,9 @@ func (p *PeerAddr) Bytes() (res []byte) {
 	if p.NodeAgent != "" {
 		x_flags |= 0x04
 	}
+	if p.AddrV2 != nil {
+		x_flags |= 0x08
+	}
 	b := new(bytes.Buffer)
 	binary.Write(b, binary.LittleEndian, p.Time)
 	binary.Write(b, binary.LittleEndian, p.Services)
@@ -192,6 +202,9 @@ func (p *PeerAddr) Bytes() (res []byte) {
 	if (x_flags & 0x04) != 0 {
 		write_extra_field(b, []byte(p.NodeAgent))
 	}
+	if (x_flags & 0x08) != 0 {
+		write_extra_field(b, append([]byte{p.NetID}, p.AddrV2...))
+	}
 	res = b.Bytes()
 	return
 }
@@ -202,6 +215,10 @@ func (p *PeerAddr) UniqID() uint64 {
 		h.Write(p.Ip6[:])
 		h.Write(p.Ip4[:])
 		h.Write([]byte{byte(p.Port >> 8), byte(p.Port)})
+		if p.AddrV2 != nil {
+			h.Write([]byte{p.NetID})
+			h.Write(p.AddrV2)
+		}
 		p.key_set = true
 		p.key_val = h.Sum64()
 	}
@@ -226,13 +243,21 @@ func NewAddrFromString(ipstr string, force_default_port bool) (p *PeerAddr, e er
 		}
 		ipstr = ipstr[:x] // remove port number
 	}
+	if na := btc.NewNetAddrFromHost(ipstr, port); na != nil {
+		na.Services = common.GetServices()
+		p = NewPeerFromNetAddr(na, uint32(time.Now().Unix()))
+		if dbp := PeerDB.Get(qdb.KeyType(p.UniqID())); dbp != nil {
+			p = NewPeer(dbp) // if we already had it, take the previous record
+		}
+		return
+	}
 	ipa, er := net.ResolveIPAddr("ip", ipstr)
 	if er == nil {
 		if ipa == nil || len(ipa.IP) != 4 && len(ipa.IP) != 16 {
 			e = errors.New("peerdb.NewAddrFromString(" + ipstr + ") - address error")
 		} else {
 			p = NewPeer(nil)
-			p.Services = Services
+			p.Services = common.GetServices()
 			p.Port = port
 			if len(ipa.IP) == 4 {
 				copy(p.Ip4[:], ipa.IP[:])
@@ -371,6 +396,9 @@ func ExpirePeers() {
 }
 
 func (p *PeerAddr) Save() {
+	if p.NoSave {
+		return
+	}
 	if p.Banned > p.Time {
 		p.lastSaved = int64(p.Banned)
 	} else {
@@ -401,6 +429,9 @@ func (p *PeerAddr) Alive() {
 }
 
 func (p *PeerAddr) Dead() {
+	if p.NoSave {
+		return
+	}
 	peerdb_mutex.Lock()
 	if !p.SeenAlive && p.Banned == 0 && PeerDB.Count() > MinPeersInDB {
 		PeerDB.Del(qdb.KeyType(p.UniqID()))
@@ -413,9 +444,31 @@ func (p *PeerAddr) Dead() {
 }
 
 func (p *PeerAddr) Ip() string {
+	if p.AddrV2 != nil {
+		return p.NetAddr.String()
+	}
 	return fmt.Sprintf("%d.%d.%d.%d:%d", p.Ip4[0], p.Ip4[1], p.Ip4[2], p.Ip4[3], p.Port)
 }
 
+// IsValid checks if the address is worth to be kept in the DB and relayed to other peers.
+func (p *PeerAddr) IsValid() bool {
+	switch p.Network() {
+	case btc.NETID_IPV4:
+		return sys.ValidIp4(p.Ip4[:])
+	case btc.NETID_TORV3, btc.NETID_I2P, btc.NETID_CJDNS:
+		return len(p.AddrV2) == btc.AddrV2Len(p.NetID)
+	}
+	return false // we do not support IPv6
+}
+
+// NewPeerFromNetAddr makes a new peer record from the given address and time.
+func NewPeerFromNetAddr(na *btc.NetAddr, tim uint32) (p *PeerAddr) {
+	p = new(PeerAddr)
+	p.NetAddr = *na
+	p.Time = tim
+	return
+}
+
 func secs_to_str(t int) string {
 	if t < 0 {
 		return fmt.Sprint(t)
@@ -498,7 +551,7 @@ func GetRecentPeers(limit uint, sort_result bool, ignorePeer func(*PeerAddr) boo
 	peerdb_mutex.Lock()
 	PeerDB.Browse(func(k qdb.KeyType, v []byte) uint32 {
 		ad := NewPeer(v)
-		if sys.ValidIp4(ad.Ip4[:]) && !sys.IsIPBlocked(ad.Ip4[:]) {
+		if ad.IsValid() && !sys.IsIPBlocked(ad.Ip4[:]) {
 			if ignorePeer == nil || !ignorePeer(ad) {
 				res = append(res, ad)
 				if !sort_result && len(res) >= int(limit) {
@@ -519,8 +572,19 @@ func GetRecentPeers(limit uint, sort_result bool, ignorePeer func(*PeerAddr) boo
 }
 
 func initSeeds(seeds []string, port uint16) {
+	proxy, _ := common.GetProxy(false)
 	for i := range seeds {
-		ad, er := net.LookupHost(seeds[i])
+		var ad []string
+		var er error
+		if proxy != "" {
+			// do not use local DNS when going via proxy - let the proxy resolve it
+			var ip net.IP
+			if ip, er = socks5.Resolve(proxy, seeds[i], nil, 30*time.Second); er == nil {
+				ad = []string{ip.String()}
+			}
+		} else {
+			ad, er = net.LookupHost(seeds[i])
+		}
 		if er == nil {
 			//println(len(ad), "addrs from", seeds[i])
 			for j := range ad {
@@ -565,7 +629,7 @@ func InitPeers(dir string) {
 			os.Exit(1)
 		}
 		proxyPeer = NewPeer(nil)
-		proxyPeer.Services = Services
+		proxyPeer.Services = common.GetServices()
 		copy(proxyPeer.Ip4[:], oa.IP[12:16])
 		proxyPeer.Port = uint16(oa.Port)
 		fmt.Printf("Connect to bitcoin network via %d.%d.%d.%d:%d\n",
@@ -598,6 +662,13 @@ func InitPeers(dir string) {
 					"seed.testnet4.bitcoin.sprovoost.nl",
 					"seed.testnet4.wiz.biz",
 				}, 48333)
+			case 38333: // signet
+				if common.CFG.SignetChallenge == "" { // there are no seeds for custom signets
+					initSeeds([]string{
+						"seed.signet.bitcoin.sprovoost.nl",
+						"seed.signet.achownodes.xyz",
+					}, 38333)
+				}
 			}
 		}()
 	}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/lib/others/qdb"
 )
 
@@ -19,7 +20,7 @@ func test_one_addr(t *testing.T, host string, ip [4]byte, port uint16) {
 	if p.Ip4 != ip {
 		t.Error("Bad IP4 returned", host, port)
 	}
-	if p.Services != Services {
+	if p.Services != common.GetServices() {
 		t.Error("Bad Services returned", host, port)
 	}
 	if p.Port != port {
@@ -33,7 +34,7 @@ func test_one_addr(t *testing.T, host string, ip [4]byte, port uint16) {
 	if p.Ip4 != ip {
 		t.Error("Bad IP4 returned", host, port)
 	}
-	if p.Services != Services {
+	if p.Services != common.GetServices() {
 		t.Error("Bad Services returned", host, port)
 	}
 	if p.Port != port {
@@ -47,7 +48,7 @@ func test_one_addr(t *testing.T, host string, ip [4]byte, port uint16) {
 	if p.Ip4 != ip {
 		t.Error("Bad IP4 returned", host, port)
 	}
-	if p.Services != Services {
+	if p.Services != common.GetServices() {
 		t.Error("Bad Services returned", host, port)
 	}
 	if p.Port != 1234 {
@@ -91,3 +92,55 @@ func TestNewAddrFromString(t *testing.T) {
 	PeerDB.Close()
 	os.RemoveAll("tmpdir")
 }
+
+func TestAddrV2Record(t *testing.T) {
+	na := btc.NewNetAddrFromHost("pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", 8333)
+	p := NewPeerFromNetAddr(na, 1234567)
+	p.NodeAgent = "/Satoshi:27.0.0/"
+	p.SeenAlive = true
+
+	rec := p.Bytes()
+	if rec[34] != 0x0c {
+		t.Error("Bad extra fields flags", rec[34])
+	}
+	r := NewPeer(rec)
+	if r.Ip() != "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion:8333" {
+		t.Error("Bad address", r.Ip())
+	}
+	if r.NodeAgent != p.NodeAgent || !r.SeenAlive || r.Time != p.Time || !r.IsValid() {
+		t.Error("Bad record fields")
+	}
+	if r.UniqID() != p.UniqID() {
+		t.Error("UniqID mismatch")
+	}
+
+	// legacy IPv4 record must not change its format nor key
+	l := NewPeer(nil)
+	l.Ip4 = [4]byte{1, 2, 3, 4}
+	l.Port = 8333
+	if len(l.Bytes()) != 30 || l.UniqID() == p.UniqID() || !l.IsValid() {
+		t.Error("Legacy record broken")
+	}
+}
+
+func TestNoSave(t *testing.T) {
+	PeerDB, _ = qdb.NewDB(t.TempDir()+string(os.PathSeparator), true)
+	defer PeerDB.Close()
+
+	p, e := NewAddrFromString("pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion:18333", false)
+	if e != nil || p.Network() != btc.NETID_TORV3 || p.Port != 18333 {
+		t.Fatal("Onion address not parsed", e)
+	}
+	p.NoSave = true
+	p.Save()
+	p.Alive()
+	p.Dead()
+	if PeerDB.Count() != 0 {
+		t.Error("NoSave record stored in DB")
+	}
+	p.NoSave = false
+	p.Save()
+	if PeerDB.Count() != 1 {
+		t.Error("Record not stored in DB")
+	}
+}
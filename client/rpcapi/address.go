@@ -2,8 +2,9 @@ package rpcapi
 
 import (
 	"encoding/hex"
+
+	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/lib/btc"
-	//"github.com/piotrnar/gocoin/client/common"
 )
 
 /*
@@ -45,3 +46,64 @@ func ValidateAddress(addr string) interface{} {
 	//res.IsWatchOnly = false
 	//res.IsScript = false
 }
+
+func rpcValidateAddress(p rpcParams) (interface{}, *RpcError) {
+	addr, er := p.str(0, "address")
+	if er != nil {
+		return nil, er
+	}
+	return ValidateAddress(addr), nil
+}
+
+type addrHistEntry struct {
+	TxID     string    `json:"txid"`
+	Height   uint32    `json:"height"`
+	Index    uint32    `json:"index"` // vout, or vin if spending
+	Spending bool      `json:"spending"`
+	Value    btcAmount `json:"value"`
+}
+
+// rpcGetAddressHistory is a gocoin extension, returning all the funding and spending
+// transactions of the address (from the address index).
+func rpcGetAddressHistory(p rpcParams) (interface{}, *RpcError) {
+	addr, er := p.str(0, "address")
+	if er != nil {
+		return nil, er
+	}
+	if common.BlockChain.AddrIndex == nil {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "Address index not enabled (set AddrIndex in the config)"}
+	}
+	a, e := btc.NewAddrFromString(addr)
+	if e != nil {
+		return nil, &RpcError{Code: RPC_INVALID_ADDRESS_OR_KEY, Message: "Invalid address"}
+	}
+	recs, e := common.BlockChain.AddrIndex.Get(a.OutScript())
+	if e != nil {
+		return nil, &RpcError{Code: RPC_INVALID_ADDRESS_OR_KEY, Message: e.Error()}
+	}
+
+	type addrHistResp struct {
+		Address      string          `json:"address"`
+		CompleteFrom uint32          `json:"complete_from"` // history from lower heights may be missing
+		Received     btcAmount       `json:"received"`
+		Spent        btcAmount       `json:"spent"`
+		Balance      btcAmount       `json:"balance"`
+		History      []addrHistEntry `json:"history"`
+	}
+	res := &addrHistResp{Address: addr, History: make([]addrHistEntry, len(recs))}
+	common.BlockChain.AddrIndex.Lock()
+	res.CompleteFrom = common.BlockChain.AddrIndex.From
+	common.BlockChain.AddrIndex.Unlock()
+	for i, r := range recs {
+		res.History[i] = addrHistEntry{TxID: r.TxID.String(), Height: r.Height, Index: r.Index, Spending: r.Spend, Value: btcAmount(r.Value)}
+		if r.Spend {
+			res.Spent += btcAmount(r.Value)
+		} else {
+			res.Received += btcAmount(r.Value)
+		}
+	}
+	if res.Received > res.Spent {
+		res.Balance = res.Received - res.Spent
+	}
+	return res, nil
+}
@@ -0,0 +1,116 @@
+package rpcapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/piotrnar/gocoin/client/common"
+)
+
+const (
+	COOKIE_FILE_NAME = ".cookie"
+	COOKIE_USER      = "__cookie__"
+)
+
+var (
+	cookiePass  string
+	cookieMutex sync.Mutex
+)
+
+func cookieFile() string {
+	return common.GocoinHomeDir + COOKIE_FILE_NAME
+}
+
+// WriteCookie generates a random password for COOKIE_USER and stores it in the data folder.
+func WriteCookie() {
+	var b [32]byte
+	rand.Read(b[:])
+	pass := hex.EncodeToString(b[:])
+	if e := os.WriteFile(cookieFile(), []byte(COOKIE_USER+":"+pass), 0600); e != nil {
+		println("RPC cookie file:", e.Error())
+		return
+	}
+	cookieMutex.Lock()
+	cookiePass = pass
+	cookieMutex.Unlock()
+}
+
+// DeleteCookie removes the cookie file (if we have created it).
+func DeleteCookie() {
+	cookieMutex.Lock()
+	if cookiePass != "" {
+		os.Remove(cookieFile())
+		cookiePass = ""
+	}
+	cookieMutex.Unlock()
+}
+
+func sameString(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// checkRpcAuth verifies the password against the "user:salt$hash" entries from the config
+// (the same format as Core's -rpcauth, where hash is HMAC-SHA256 of the password, with salt as key).
+func checkRpcAuth(user, pass string) bool {
+	for _, a := range common.CFG.RPC.Auth {
+		uh := strings.SplitN(a, ":", 2)
+		if len(uh) != 2 || uh[0] != user {
+			continue
+		}
+		sh := strings.SplitN(uh[1], "$", 2)
+		if len(sh) != 2 {
+			continue
+		}
+		mac := hmac.New(sha256.New, []byte(sh[0]))
+		mac.Write([]byte(pass))
+		if sameString(hex.EncodeToString(mac.Sum(nil)), strings.ToLower(sh[1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAuth returns the name of the user, if the request is properly authenticated.
+func checkAuth(r *http.Request) (user string, ok bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return
+	}
+	if user == COOKIE_USER {
+		cookieMutex.Lock()
+		ok = cookiePass != "" && sameString(pass, cookiePass)
+		cookieMutex.Unlock()
+		return
+	}
+	if common.CFG.RPC.Password != "" && user == common.CFG.RPC.Username && sameString(pass, common.CFG.RPC.Password) {
+		return
+	}
+	ok = checkRpcAuth(user, pass)
+	return
+}
+
+// methodAllowed checks the method against the user's whitelist (if there is one).
+// Whitelist entries have the format of "user:method1,method2,..." and they add up.
+func methodAllowed(user, method string) bool {
+	var listed bool
+	for _, wl := range common.CFG.RPC.Whitelist {
+		um := strings.SplitN(wl, ":", 2)
+		if len(um) != 2 || strings.TrimSpace(um[0]) != user {
+			continue
+		}
+		listed = true
+		for _, m := range strings.Split(um[1], ",") {
+			if strings.TrimSpace(m) == method {
+				return true
+			}
+		}
+	}
+	return !listed
+}
@@ -21,47 +21,40 @@ type BlockSubmited struct {
 
 var RpcBlocks chan *BlockSubmited = make(chan *BlockSubmited, 1)
 
-func SubmitBlock(cmd *RpcCommand, resp *RpcResponse, b []byte) {
+func SubmitBlock(p rpcParams) (interface{}, *RpcError) {
 	var bd []byte
 	var er error
 
-	switch uu := cmd.Params.(type) {
-	case []interface{}:
-		if len(uu) < 1 {
-			resp.Error = RpcError{Code: -1, Message: "empty params array"}
-			return
-		}
-		str := uu[0].(string)
-		if str[0] == '@' {
-			/*
-				gocoin special case: if the string starts with @, it's a name of the file with block's binary data
-					curl --user gocoinrpc:gocoinpwd --data-binary \
-						'{"jsonrpc": "1.0", "id":"curltest", "method": "submitblock", "params": \
-							["@450529_000000000000000000cf208f521de0424677f7a87f2f278a1042f38d159565f5.bin"] }' \
-						-H 'content-type: text/plain;' http://127.0.0.1:8332/
-			*/
-			//println("jade z koksem", str[1:])
-			bd, er = os.ReadFile(str[1:])
-		} else {
-			bd, er = hex.DecodeString(str)
-		}
-		if er != nil {
-			resp.Error = RpcError{Code: -3, Message: er.Error()}
-			return
-		}
-
-	default:
-		resp.Error = RpcError{Code: -2, Message: "incorrect params type"}
-		return
+	str, rer := p.str(0, "hexdata")
+	if rer != nil {
+		return nil, rer
+	}
+	if len(str) > 0 && str[0] == '@' {
+		/*
+			gocoin special case: if the string starts with @, it's a name of the file with block's binary data
+				curl --user gocoinrpc:gocoinpwd --data-binary \
+					'{"jsonrpc": "1.0", "id":"curltest", "method": "submitblock", "params": \
+						["@450529_000000000000000000cf208f521de0424677f7a87f2f278a1042f38d159565f5.bin"] }' \
+					-H 'content-type: text/plain;' http://127.0.0.1:8332/
+		*/
+		bd, er = os.ReadFile(str[1:])
+	} else {
+		bd, er = hex.DecodeString(str)
+	}
+	if er != nil {
+		return nil, &RpcError{Code: RPC_DESERIALIZATION_ERROR, Message: er.Error()}
 	}
 
 	bl, er := btc.NewBlock(bd)
 	if er != nil {
-		resp.Error = RpcError{Code: -4, Message: er.Error()}
-		return
+		return nil, &RpcError{Code: RPC_DESERIALIZATION_ERROR, Message: "Block decode failed"}
 	}
 
-	resp.Result = submitBlockInt(bl)
+	println("_________________________SH__________________________________")
+	if res := submitBlockInt(bl); res != "" {
+		return res, nil
+	}
+	return nil, nil // null means the block has been accepted
 }
 
 func SubmitWork(bl *btc.Block) {
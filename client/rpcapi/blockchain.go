@@ -0,0 +1,422 @@
+package rpcapi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/client/usif"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
+)
+
+const CHAINWORK_CACHE_INTERVAL = 1000
+
+var (
+	chainWorkCache      = make(map[*chain.BlockTreeNode]*big.Int)
+	chainWorkCacheMutex sync.Mutex
+)
+
+// chainName returns the name of the chain, as used by Bitcoin Core.
+func chainName() string {
+	if common.CFG.Regtest {
+		return "regtest"
+	}
+	if common.CFG.Signet {
+		return "signet"
+	}
+	if common.CFG.Testnet {
+		if common.CFG.Testnet4 {
+			return "testnet4"
+		}
+		return "test"
+	}
+	return "main"
+}
+
+// blockWork returns the expected number of hashes needed to mine a block with the given bits.
+func blockWork(bits uint32) *big.Int {
+	target := btc.SetCompact(bits)
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	res := new(big.Int).Lsh(big.NewInt(1), 256)
+	return res.Div(res, target.Add(target, big.NewInt(1)))
+}
+
+// chainWork returns the total work of the chain up to (and including) the given block.
+// Make sure to call it with BlockIndexAccess locked.
+func chainWork(n *chain.BlockTreeNode) *big.Int {
+	type pending struct {
+		node  *chain.BlockTreeNode
+		above *big.Int // work of the blocks above this one
+	}
+	var todo []pending
+	sum := new(big.Int)
+	chainWorkCacheMutex.Lock()
+	defer chainWorkCacheMutex.Unlock()
+	for ; n != nil; n = n.Parent {
+		if n.Height%CHAINWORK_CACHE_INTERVAL == 0 {
+			if cw, ok := chainWorkCache[n]; ok {
+				sum.Add(sum, cw)
+				break
+			}
+			todo = append(todo, pending{node: n, above: new(big.Int).Set(sum)})
+		}
+		sum.Add(sum, blockWork(n.Bits()))
+	}
+	for _, t := range todo {
+		chainWorkCache[t.node] = t.above.Sub(sum, t.above)
+	}
+	return sum
+}
+
+// activeNodeAtHeight returns the block from the active branch at the given height.
+// Make sure to call it with BlockIndexAccess locked.
+func activeNodeAtHeight(height uint32) (n *chain.BlockTreeNode) {
+	n = common.BlockChain.LastBlock()
+	if height > n.Height {
+		return nil
+	}
+	for n.Height > height {
+		n = n.Parent
+	}
+	return
+}
+
+func blockNotFound() *RpcError {
+	return &RpcError{Code: RPC_INVALID_ADDRESS_OR_KEY, Message: "Block not found"}
+}
+
+type blockHeaderResp struct {
+	Hash              string  `json:"hash"`
+	Confirmations     int     `json:"confirmations"`
+	Height            uint32  `json:"height"`
+	Version           uint32  `json:"version"`
+	VersionHex        string  `json:"versionHex"`
+	MerkleRoot        string  `json:"merkleroot"`
+	Time              uint32  `json:"time"`
+	MedianTime        uint32  `json:"mediantime"`
+	Nonce             uint32  `json:"nonce"`
+	Bits              string  `json:"bits"`
+	Difficulty        float64 `json:"difficulty"`
+	ChainWork         string  `json:"chainwork"`
+	NTx               uint32  `json:"nTx"`
+	PreviousBlockHash string  `json:"previousblockhash,omitempty"`
+	NextBlockHash     string  `json:"nextblockhash,omitempty"`
+}
+
+// newBlockHeaderResp fills in the block header info. Make sure to call it with BlockIndexAccess locked.
+func newBlockHeaderResp(n *chain.BlockTreeNode) (res *blockHeaderResp) {
+	res = new(blockHeaderResp)
+	res.Hash = n.BlockHash.String()
+	res.Confirmations = -1
+	if common.BlockChain.OnActiveBranch(n) {
+		top := common.BlockChain.LastBlock()
+		res.Confirmations = int(top.Height-n.Height) + 1
+		if top != n {
+			res.NextBlockHash = activeNodeAtHeight(n.Height + 1).BlockHash.String()
+		}
+	}
+	res.Height = n.Height
+	res.Version = n.BlockVersion()
+	res.VersionHex = fmt.Sprintf("%08x", res.Version)
+	res.MerkleRoot = btc.NewUint256(n.BlockHeader[36:68]).String()
+	res.Time = n.Timestamp()
+	res.MedianTime = n.GetMedianTimePast()
+	res.Nonce = binary.LittleEndian.Uint32(n.BlockHeader[76:80])
+	res.Bits = fmt.Sprintf("%08x", n.Bits())
+	res.Difficulty = btc.GetDifficulty(n.Bits())
+	res.ChainWork = fmt.Sprintf("%064x", chainWork(n))
+	res.NTx = n.TxCount
+	if n.Parent != nil {
+		res.PreviousBlockHash = n.Parent.BlockHash.String()
+	}
+	return
+}
+
+func rpcGetBlockchainInfo(p rpcParams) (interface{}, *RpcError) {
+	type chainInfo struct {
+		Chain                string  `json:"chain"`
+		Blocks               uint32  `json:"blocks"`
+		Headers              uint32  `json:"headers"`
+		BestBlockHash        string  `json:"bestblockhash"`
+		Difficulty           float64 `json:"difficulty"`
+		Time                 uint32  `json:"time"`
+		MedianTime           uint32  `json:"mediantime"`
+		VerificationProgress float64 `json:"verificationprogress"`
+		InitialBlockDownload bool    `json:"initialblockdownload"`
+		ChainWork            string  `json:"chainwork"`
+		Pruned               bool    `json:"pruned"`
+		Warnings             string  `json:"warnings"`
+	}
+	res := new(chainInfo)
+	res.Chain = chainName()
+
+	network.MutexRcv.Lock()
+	res.Headers = network.LastCommitedHeader.Height
+	network.MutexRcv.Unlock()
+
+	common.BlockChain.BlockIndexAccess.Lock()
+	top := common.BlockChain.LastBlock()
+	res.Blocks = top.Height
+	res.BestBlockHash = top.BlockHash.String()
+	res.Difficulty = btc.GetDifficulty(top.Bits())
+	res.Time = top.Timestamp()
+	res.MedianTime = top.GetMedianTimePast()
+	res.ChainWork = fmt.Sprintf("%064x", chainWork(top))
+	common.BlockChain.BlockIndexAccess.Unlock()
+
+	res.InitialBlockDownload = !common.BlockChainSynchronized.Load()
+	if res.Headers > res.Blocks {
+		res.VerificationProgress = float64(res.Blocks) / float64(res.Headers)
+	} else {
+		res.VerificationProgress = 1.0
+		res.Headers = res.Blocks
+	}
+	return res, nil
+}
+
+type deploymentStats struct {
+	Period    uint32 `json:"period"`
+	Threshold uint32 `json:"threshold"`
+	Elapsed   uint32 `json:"elapsed"`
+	Count     uint32 `json:"count"`
+	Possible  bool   `json:"possible"`
+}
+
+type deploymentBIP9 struct {
+	Bit                 uint8            `json:"bit"`
+	StartTime           *int64           `json:"start_time,omitempty"`
+	Timeout             *int64           `json:"timeout,omitempty"`
+	StartHeight         *uint32          `json:"start_height,omitempty"`
+	TimeoutHeight       *uint32          `json:"timeout_height,omitempty"`
+	LockinOnTimeout     *bool            `json:"lockinontimeout,omitempty"`
+	MinActivationHeight uint32           `json:"min_activation_height"`
+	Status              string           `json:"status"`
+	Since               uint32           `json:"since"`
+	StatusNext          string           `json:"status_next"`
+	Statistics          *deploymentStats `json:"statistics,omitempty"`
+	Signalling          string           `json:"signalling,omitempty"`
+}
+
+type deploymentInfo struct {
+	Type   string          `json:"type"`
+	Active bool            `json:"active"`
+	Height *uint32         `json:"height,omitempty"`
+	BIP9   *deploymentBIP9 `json:"bip9,omitempty"`
+}
+
+// rpcGetDeploymentInfo returns the states of the soft fork deployments, for the block after the given one (or the tip).
+func rpcGetDeploymentInfo(p rpcParams) (interface{}, *RpcError) {
+	var n *chain.BlockTreeNode
+	if p.has(0) {
+		var er *RpcError
+		if n, er = findBlock(p); er != nil {
+			return nil, er
+		}
+	} else {
+		n = common.BlockChain.LastBlock()
+	}
+	ch := common.BlockChain
+	deps := make(map[string]*deploymentInfo)
+	buried := func(name string, height uint32) {
+		h := height
+		deps[name] = &deploymentInfo{Type: "buried", Active: n.Height+1 >= height, Height: &h}
+	}
+	buried("bip34", ch.Consensus.BIP34Height)
+	buried("bip66", ch.Consensus.BIP66Height)
+	buried("bip65", ch.Consensus.BIP65Height)
+	buried("csv", ch.Consensus.Enforce_CSV)
+	buried("segwit", ch.Consensus.Enforce_SEGWIT)
+	buried("taproot", ch.Consensus.Enforce_Taproot)
+
+	for _, d := range ch.Consensus.Deployments {
+		b := &deploymentBIP9{Bit: d.Bit, MinActivationHeight: d.MinActivationHeight}
+		di := &deploymentInfo{Type: "bip9", BIP9: b}
+		if d.BIP8 {
+			di.Type = "bip8"
+			b.StartHeight, b.TimeoutHeight, b.LockinOnTimeout = &d.StartHeight, &d.TimeoutHeight, &d.LockinOnTimeout
+		} else {
+			b.StartTime, b.Timeout = &d.StartTime, &d.Timeout
+		}
+		cur := ch.DeploymentState(d, n.Parent)
+		next := ch.DeploymentState(d, n)
+		b.Status, b.StatusNext = cur.String(), next.String()
+		b.Since = ch.DeploymentSince(d, n.Parent)
+		di.Active = next == chain.THRESHOLD_ACTIVE
+		if cur == chain.THRESHOLD_STARTED || cur == chain.THRESHOLD_MUST_SIGNAL {
+			st := ch.DeploymentStatistics(d, n)
+			b.Statistics = &deploymentStats{Period: st.Period, Threshold: st.Threshold,
+				Elapsed: st.Elapsed, Count: st.Count, Possible: st.Possible}
+			sig := make([]byte, st.Elapsed)
+			for i, bn := int(st.Elapsed)-1, n; i >= 0 && bn != nil; i, bn = i-1, bn.Parent {
+				if d.Signals(bn.BlockVersion()) {
+					sig[i] = '#'
+				} else {
+					sig[i] = '-'
+				}
+			}
+			b.Signalling = string(sig)
+		}
+		deps[d.Name] = di
+	}
+
+	return map[string]interface{}{"hash": n.BlockHash.String(), "height": n.Height, "deployments": deps}, nil
+}
+
+func rpcGetBestBlockHash(p rpcParams) (interface{}, *RpcError) {
+	return common.BlockChain.LastBlock().BlockHash.String(), nil
+}
+
+func rpcGetBlockCount(p rpcParams) (interface{}, *RpcError) {
+	return common.BlockChain.LastBlock().Height, nil
+}
+
+func rpcGetBlockHash(p rpcParams) (interface{}, *RpcError) {
+	if !p.has(0) {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "Missing required parameter height"}
+	}
+	height, er := p.int(0, "height", 0)
+	if er != nil {
+		return nil, er
+	}
+	var n *chain.BlockTreeNode
+	common.BlockChain.BlockIndexAccess.Lock()
+	if height >= 0 && height <= 0xffffffff {
+		n = activeNodeAtHeight(uint32(height))
+	}
+	common.BlockChain.BlockIndexAccess.Unlock()
+	if n == nil {
+		return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: "Block height out of range"}
+	}
+	return n.BlockHash.String(), nil
+}
+
+// findBlock returns the node of the block with the given hash (the hash being the first parameter).
+func findBlock(p rpcParams) (n *chain.BlockTreeNode, er *RpcError) {
+	hash, er := p.hash(0, "blockhash")
+	if er != nil {
+		return
+	}
+	common.BlockChain.BlockIndexAccess.Lock()
+	n = common.BlockChain.BlockIndex[hash.BIdx()]
+	common.BlockChain.BlockIndexAccess.Unlock()
+	if n == nil {
+		er = blockNotFound()
+	}
+	return
+}
+
+func rpcGetBlockHeader(p rpcParams) (interface{}, *RpcError) {
+	n, er := findBlock(p)
+	if er != nil {
+		return nil, er
+	}
+	verbose, er := p.bool(1, "verbose", true)
+	if er != nil {
+		return nil, er
+	}
+	if !verbose {
+		return hex.EncodeToString(n.BlockHeader[:]), nil
+	}
+	common.BlockChain.BlockIndexAccess.Lock()
+	res := newBlockHeaderResp(n)
+	common.BlockChain.BlockIndexAccess.Unlock()
+	return res, nil
+}
+
+func rpcGetBlock(p rpcParams) (interface{}, *RpcError) {
+	n, er := findBlock(p)
+	if er != nil {
+		return nil, er
+	}
+	var verbosity int64 = 1
+	if p.has(1) {
+		if v, ok := p[1].(bool); ok { // older versions of Core used "verbose" bool here
+			if !v {
+				verbosity = 0
+			}
+		} else if verbosity, er = p.int(1, "verbosity", 1); er != nil {
+			return nil, er
+		}
+	}
+
+	if n.BlockSize == 0 {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "Block not available (not fully downloaded)"}
+	}
+	raw, _, e := common.BlockChain.Blocks.BlockGet(n.BlockHash)
+	if e != nil {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "Block not found on disk"}
+	}
+	if verbosity <= 0 {
+		return hex.EncodeToString(raw), nil
+	}
+
+	bl, e := btc.NewBlock(raw)
+	if e == nil {
+		e = bl.BuildTxList()
+	}
+	if e != nil {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "Block data corrupt: " + e.Error()}
+	}
+
+	type blockResp struct {
+		*blockHeaderResp
+		StrippedSize int           `json:"strippedsize"`
+		Size         int           `json:"size"`
+		Weight       uint          `json:"weight"`
+		Tx           []interface{} `json:"tx"`
+	}
+	res := new(blockResp)
+	common.BlockChain.BlockIndexAccess.Lock()
+	res.blockHeaderResp = newBlockHeaderResp(n)
+	common.BlockChain.BlockIndexAccess.Unlock()
+	res.Size = len(raw)
+	res.Weight = bl.BlockWeight
+	res.StrippedSize = (int(bl.BlockWeight) - res.Size) / 3
+	res.Tx = make([]interface{}, len(bl.Txs))
+	for i, tx := range bl.Txs {
+		if verbosity == 1 {
+			res.Tx[i] = tx.Hash.String()
+		} else {
+			res.Tx[i] = newTxResp(tx)
+		}
+	}
+	return res, nil
+}
+
+// changeChain calls one of the chain management functions in sync with the main thread.
+func changeChain(p rpcParams, op func(*btc.Uint256) error) (interface{}, *RpcError) {
+	n, er := findBlock(p)
+	if er != nil {
+		return nil, er
+	}
+	lck := new(usif.OneLock)
+	lck.In.Add(1)
+	lck.Out.Add(1)
+	usif.LocksChan <- lck
+	lck.In.Wait()
+	e := op(n.BlockHash)
+	lck.Out.Done()
+	if e != nil {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: e.Error()}
+	}
+	return nil, nil
+}
+
+func rpcInvalidateBlock(p rpcParams) (interface{}, *RpcError) {
+	return changeChain(p, usif.InvalidateBlock)
+}
+
+func rpcReconsiderBlock(p rpcParams) (interface{}, *RpcError) {
+	return changeChain(p, usif.ReconsiderBlock)
+}
+
+func rpcPreciousBlock(p rpcParams) (interface{}, *RpcError) {
+	return changeChain(p, usif.PreciousBlock)
+}
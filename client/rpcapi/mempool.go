@@ -0,0 +1,187 @@
+package rpcapi
+
+import (
+	"strings"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/txpool"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+const MAX_CONF_TARGET = 1008
+
+type mempoolEntryResp struct {
+	VSize           int    `json:"vsize"`
+	Weight          int    `json:"weight"`
+	Time            int64  `json:"time"`
+	Height          uint32 `json:"height"`
+	DescendantCount int    `json:"descendantcount"`
+	DescendantSize  int    `json:"descendantsize"`
+	AncestorCount   int    `json:"ancestorcount"`
+	AncestorSize    int    `json:"ancestorsize"`
+	WTxID           string `json:"wtxid"`
+	Fees            struct {
+		Base       btcAmount `json:"base"`
+		Modified   btcAmount `json:"modified"`
+		Ancestor   btcAmount `json:"ancestor"`
+		Descendant btcAmount `json:"descendant"`
+	} `json:"fees"`
+	Depends     []string `json:"depends"`
+	SpentBy     []string `json:"spentby"`
+	Replaceable bool     `json:"bip125-replaceable"`
+	Unbroadcast bool     `json:"unbroadcast"`
+}
+
+// signalsRBF returns true if any of the tx's inputs signals BIP125 replaceability.
+func signalsRBF(tx *btc.Tx) bool {
+	for _, in := range tx.TxIn {
+		if in.Sequence < 0xfffffffe {
+			return true
+		}
+	}
+	return false
+}
+
+// newMempoolEntryResp must be called with TxMutex locked.
+func newMempoolEntryResp(t2s *txpool.OneTxToSend, height uint32) (res *mempoolEntryResp) {
+	res = new(mempoolEntryResp)
+	res.VSize = t2s.VSize()
+	res.Weight = t2s.Weight()
+	res.Time = t2s.Firstseen.Unix()
+	res.Height = height
+	res.WTxID = t2s.WTxID().String()
+	res.Fees.Base = btcAmount(t2s.Fee)
+	res.Fees.Modified = res.Fees.Base
+
+	// ancestor and descendant stats include the tx itself
+	res.AncestorCount, res.AncestorSize, res.Fees.Ancestor = 1, res.VSize, res.Fees.Base
+	res.Replaceable = signalsRBF(t2s.Tx)
+	for _, par := range t2s.GetAllParents() {
+		res.AncestorCount++
+		res.AncestorSize += par.VSize()
+		res.Fees.Ancestor += btcAmount(par.Fee)
+		if !res.Replaceable {
+			res.Replaceable = signalsRBF(par.Tx)
+		}
+	}
+	res.DescendantCount, res.DescendantSize, res.Fees.Descendant = 1, res.VSize, res.Fees.Base
+	for _, ch := range t2s.GetAllChildren() {
+		res.DescendantCount++
+		res.DescendantSize += ch.VSize()
+		res.Fees.Descendant += btcAmount(ch.Fee)
+	}
+
+	res.Depends = []string{}
+	for i, in := range t2s.TxIn {
+		if t2s.MemInputs != nil && t2s.MemInputs[i] {
+			txid := btc.NewUint256(in.Input.Hash[:]).String()
+			var dup bool
+			for _, d := range res.Depends {
+				if d == txid {
+					dup = true
+					break
+				}
+			}
+			if !dup {
+				res.Depends = append(res.Depends, txid)
+			}
+		}
+	}
+	res.SpentBy = []string{}
+	for _, ch := range t2s.GetChildren() {
+		res.SpentBy = append(res.SpentBy, ch.Hash.String())
+	}
+	res.Unbroadcast = t2s.Local && t2s.Invsentcnt == 0
+	return
+}
+
+func rpcGetRawMempool(p rpcParams) (interface{}, *RpcError) {
+	verbose, er := p.bool(0, "verbose", false)
+	if er != nil {
+		return nil, er
+	}
+	height := common.BlockChain.LastBlock().Height
+
+	txpool.TxMutex.Lock()
+	defer txpool.TxMutex.Unlock()
+	if !verbose {
+		res := make([]string, 0, len(txpool.TransactionsToSend))
+		for _, t2s := range txpool.TransactionsToSend {
+			res = append(res, t2s.Hash.String())
+		}
+		return res, nil
+	}
+	res := make(map[string]*mempoolEntryResp, len(txpool.TransactionsToSend))
+	for _, t2s := range txpool.TransactionsToSend {
+		res[t2s.Hash.String()] = newMempoolEntryResp(t2s, height)
+	}
+	return res, nil
+}
+
+func rpcGetMempoolEntry(p rpcParams) (interface{}, *RpcError) {
+	txid, er := p.hash(0, "txid")
+	if er != nil {
+		return nil, er
+	}
+	height := common.BlockChain.LastBlock().Height
+
+	txpool.TxMutex.Lock()
+	defer txpool.TxMutex.Unlock()
+	t2s, ok := txpool.TransactionsToSend[txid.BIdx()]
+	if !ok {
+		return nil, &RpcError{Code: RPC_INVALID_ADDRESS_OR_KEY, Message: "Transaction not in mempool"}
+	}
+	return newMempoolEntryResp(t2s, height), nil
+}
+
+// rpcEstimateSmartFee returns the fee rate needed to get into the first conf_target blocks,
+// as they would be mined from the current mempool (but never less than our minimal relay fee).
+func rpcEstimateSmartFee(p rpcParams) (interface{}, *RpcError) {
+	if !p.has(0) {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "Missing required parameter conf_target"}
+	}
+	target, er := p.int(0, "conf_target", 0)
+	if er != nil {
+		return nil, er
+	}
+	if target < 1 || target > MAX_CONF_TARGET {
+		return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: "Invalid conf_target, must be between 1 and 1008"}
+	}
+	if p.has(1) {
+		mode, er := p.str(1, "estimate_mode")
+		if er != nil {
+			return nil, er
+		}
+		switch strings.ToLower(mode) {
+		case "unset", "economical", "conservative":
+		default:
+			return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: `Invalid estimate_mode parameter, must be one of: "unset", "economical", "conservative"`}
+		}
+	}
+	if target == 1 {
+		target = 2 // same as Core
+	}
+
+	maxweight := uint64(target) * btc.MAX_BLOCK_WEIGHT
+	var spkb, weight uint64
+	txpool.TxMutex.Lock()
+	for _, rec := range txpool.GetMempoolFees(maxweight) {
+		if weight += rec.Weight; weight > maxweight {
+			break
+		}
+		spkb = 4000 * rec.Fee / rec.Weight
+	}
+	txpool.TxMutex.Unlock()
+	if weight <= maxweight {
+		spkb = 0 // all the mempool fits into the blocks
+	}
+	if min := common.MinFeePerKB(); spkb < min {
+		spkb = min
+	}
+
+	type feeEstimate struct {
+		FeeRate btcAmount `json:"feerate"`
+		Blocks  int64     `json:"blocks"`
+	}
+	return &feeEstimate{FeeRate: btcAmount(spkb), Blocks: target}, nil
+}
@@ -11,7 +11,9 @@ import (
 
 	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/client/txpool"
+	"github.com/piotrnar/gocoin/client/usif"
 	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
 	"github.com/piotrnar/gocoin/lib/script"
 )
 
@@ -53,6 +55,8 @@ type GetBlockTemplateResp struct {
 	Curtime       uint             `json:"curtime"`
 	Height        uint             `json:"height"`
 	Version       uint32           `json:"version"`
+	VbAvailable   map[string]uint8 `json:"vbavailable"`
+	SignetChal    string           `json:"signet_challenge,omitempty"`
 }
 
 type GetWorkTemplateResp struct {
@@ -160,17 +164,18 @@ func GetWork(r *RpcGetWorkResp) {
 	height := common.Last.Block.Height + 1
 	curtime := get_testnet_timestamp()
 	bits := common.BlockChain.GetNextWorkRequired(common.Last.Block, uint32(curtime))
+	version := common.BlockChain.ComputeBlockVersion(common.Last.Block)
 	common.Last.Mutex.Unlock()
 
 	bl.Txs = make([]*btc.Tx, 1)
 	bl.Txs[0] = make_coinbase_tx(height)
 
-	cpfp := txpool.GetSortedMempoolRBF()
-	//println(len(cpfp), "transactions")
+	sorted := txpool.GetSortedMempool()
+	//println(len(sorted), "transactions")
 	bl.Txs[0].SetHash(bl.Txs[0].SerializeNew()) // this will not be the final hash, but to get a propoer weight in the next line
 	cur_tx_weight := bl.Txs[0].Weight()
 
-	for _, v := range cpfp {
+	for _, v := range sorted {
 		tx := v.Tx
 		if !tx.IsFinal(height, uint32(curtime)) {
 			continue
@@ -195,6 +200,15 @@ func GetWork(r *RpcGetWorkResp) {
 	update_witness_merkle(bl)
 
 	bl.Txs[0].SetHash(bl.Txs[0].SerializeNew())
+	if common.CFG.Signet {
+		var hdr [80]byte
+		binary.LittleEndian.PutUint32(hdr[0:4], version)
+		copy(hdr[4:36], common.Last.Block.BlockHash.Hash[:])
+		binary.LittleEndian.PutUint32(hdr[68:72], uint32(curtime))
+		if e := usif.SignetSignBlock(hdr[:], bl.Txs); e != nil {
+			println("getwork:", e.Error())
+		}
+	}
 	merkle, _ := bl.GetMerkle()
 
 	var zer [32]byte
@@ -207,7 +221,7 @@ func GetWork(r *RpcGetWorkResp) {
 	target := append(zer[:32-len(target_)], target_...)
 	swap256(target) // getwork is expected to return the target as little endian
 	r.Result.Target = hex.EncodeToString(target)
-	binary.LittleEndian.PutUint32(data[0:4], 0x20000000)
+	binary.LittleEndian.PutUint32(data[0:4], version)
 	copy(data[4:36], common.Last.Block.BlockHash.Hash[:])
 	copy(data[36:36+32], merkle)
 	binary.LittleEndian.PutUint32(data[68:72], uint32(curtime))
@@ -239,15 +253,21 @@ func GetNextBlockTemplate(r *GetBlockTemplateResp) {
 	height := common.Last.Block.Height + 1
 	bits := common.BlockChain.GetNextWorkRequired(common.Last.Block, uint32(r.Curtime))
 	r.PreviousBlockHash = common.Last.Block.BlockHash.String()
+	r.Version = common.BlockChain.ComputeBlockVersion(common.Last.Block)
+	r.VbAvailable = make(map[string]uint8)
+	for _, d := range common.BlockChain.Consensus.Deployments {
+		if st := common.BlockChain.DeploymentState(d, common.Last.Block); st >= chain.THRESHOLD_STARTED && st <= chain.THRESHOLD_LOCKED_IN {
+			r.VbAvailable[d.Name] = d.Bit
+		}
+	}
 	common.Last.Mutex.Unlock()
 
 	target := btc.SetCompact(bits).Bytes()
 
 	r.Capabilities = []string{"proposal"}
-	r.Version = 0x20000000
 
 	r.Transactions, r.Coinbasevalue = GetTransactions(height, uint32(r.Mintime))
-	r.Coinbasevalue += btc.GetBlockReward(height)
+	r.Coinbasevalue += common.BlockChain.BlockReward(height)
 	r.Coinbaseaux.Flags = ""
 	r.Longpollid = r.PreviousBlockHash
 	r.Target = hex.EncodeToString(append(zer[:32-len(target)], target...))
@@ -257,6 +277,9 @@ func GetNextBlockTemplate(r *GetBlockTemplateResp) {
 	r.Sizelimit = 1e6
 	r.Bits = fmt.Sprintf("%08x", bits)
 	r.Height = uint(height)
+	if common.CFG.Signet {
+		r.SignetChal = hex.EncodeToString(common.SignetChallenge())
+	}
 
 	last_given_time = uint32(r.Curtime)
 	last_given_mintime = uint32(r.Mintime)
@@ -379,3 +402,82 @@ func GetTransactions(height, timestamp uint32) (res []OneTransaction, totfees ui
 	//println("returning transacitons:", totlen, len(res))
 	return
 }
+
+func rpcGetBlockTemplate(p rpcParams) (interface{}, *RpcError) {
+	res := new(GetBlockTemplateResp)
+	GetNextBlockTemplate(res)
+	return res, nil
+}
+
+func rpcGetWork(p rpcParams) (interface{}, *RpcError) {
+	if p.has(0) {
+		s, er := p.str(0, "data")
+		if er != nil {
+			return nil, er
+		}
+		if currently_worked_block == nil {
+			println("work submited, but no work in progress")
+			return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "No work in progress"}
+		}
+		d, err := hex.DecodeString(s)
+		if err != nil || len(d) < 80 {
+			return nil, &RpcError{Code: RPC_DESERIALIZATION_ERROR, Message: "Block decode failed"}
+		}
+		swap32(d)
+		currently_worked_block.Raw = d[:80]
+		SubmitWork(currently_worked_block)
+		currently_worked_block = nil
+		return true, nil
+	}
+	var resp RpcGetWorkResp
+	GetWork(&resp)
+	return &resp.Result, nil
+}
+
+func rpcGetMiningInfo(p rpcParams) (interface{}, *RpcError) {
+	return mining_info, nil
+}
+
+// rpcGenerateToAddress mines blocks in-process (practical on regtest or own signet only) and returns their hashes.
+func rpcGenerateToAddress(p rpcParams) (interface{}, *RpcError) {
+	if !p.has(0) {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "Missing required parameter nblocks"}
+	}
+	nblocks, er := p.int(0, "nblocks", 0)
+	if er != nil {
+		return nil, er
+	}
+	str, er := p.str(1, "address")
+	if er != nil {
+		return nil, er
+	}
+	maxtries, er := p.int(2, "maxtries", usif.GENERATE_DEFAULT_TRIES)
+	if er != nil {
+		return nil, er
+	}
+	addr, e := btc.NewAddrFromString(str)
+	if e != nil {
+		return nil, &RpcError{Code: RPC_INVALID_ADDRESS_OR_KEY, Message: "Error: Invalid address"}
+	}
+	if nblocks < 0 || maxtries < 0 {
+		return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: "Negative nblocks or maxtries"}
+	}
+
+	// blocks must be committed in sync with the main thread
+	lck := new(usif.OneLock)
+	lck.In.Add(1)
+	lck.Out.Add(1)
+	usif.LocksChan <- lck
+	lck.In.Wait()
+	hashes, e := usif.GenerateBlocks(int(nblocks), addr.OutScript(), uint64(maxtries))
+	lck.Out.Done()
+	if e != nil {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "Block not accepted: " + e.Error()}
+	}
+
+	res := make([]string, len(hashes))
+	for i, h := range hashes {
+		res[i] = h.String()
+	}
+	return res, nil
+}
@@ -0,0 +1,211 @@
+package rpcapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/piotrnar/gocoin"
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+var serviceNames = []struct {
+	bit  uint64
+	name string
+}{
+	{btc.SERVICE_NETWORK, "NETWORK"},
+	{1 << 2, "BLOOM"},
+	{btc.SERVICE_SEGWIT, "WITNESS"},
+	{btc.SERVICE_COMPACT_FILTERS, "COMPACT_FILTERS"},
+	{btc.SERVICE_NETWORK_LIMITED, "NETWORK_LIMITED"},
+	{btc.SERVICE_P2P_V2, "P2P_V2"},
+}
+
+func servicesNames(services uint64) (res []string) {
+	res = []string{}
+	for _, s := range serviceNames {
+		if services&s.bit != 0 {
+			res = append(res, s.name)
+		}
+	}
+	return
+}
+
+// peerNetwork returns the network of the peer's address, as named by Core.
+func peerNetwork(addr string) string {
+	switch {
+	case strings.Contains(addr, ".onion"):
+		return "onion"
+	case strings.Contains(addr, ".i2p"):
+		return "i2p"
+	case strings.HasPrefix(addr, "[fc"):
+		return "cjdns"
+	case strings.HasPrefix(addr, "["):
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// clientVersion returns gocoin's version, encoded the way Core does it (e.g. 1.11.1 -> 1110100).
+func clientVersion() (res int) {
+	var a, b, c int
+	fmt.Sscanf(gocoin.Version, "%d.%d.%d", &a, &b, &c)
+	return 1000000*a + 10000*b + 100*c
+}
+
+type peerInfoResp struct {
+	ID                    uint32    `json:"id"`
+	Addr                  string    `json:"addr"`
+	AddrBind              string    `json:"addrbind,omitempty"`
+	Network               string    `json:"network"`
+	Services              string    `json:"services"`
+	ServicesNames         []string  `json:"servicesnames"`
+	RelayTxes             bool      `json:"relaytxes"`
+	LastSend              int64     `json:"lastsend"`
+	LastRecv              int64     `json:"lastrecv"`
+	BytesSent             uint64    `json:"bytessent"`
+	BytesRecv             uint64    `json:"bytesrecv"`
+	ConnTime              int64     `json:"conntime"`
+	PingTime              float64   `json:"pingtime,omitempty"`
+	Version               uint32    `json:"version"`
+	SubVer                string    `json:"subver"`
+	Inbound               bool      `json:"inbound"`
+	BIP152HBTo            bool      `json:"bip152_hb_to"`
+	StartingHeight        uint32    `json:"startingheight"`
+	Inflight              int       `json:"inflight"`
+	MinFeeFilter          btcAmount `json:"minfeefilter"`
+	ConnectionType        string    `json:"connection_type"`
+	TransportProtocolType string    `json:"transport_protocol_type"`
+}
+
+func rpcGetPeerInfo(p rpcParams) (interface{}, *RpcError) {
+	var conns []*network.OneConnection
+	network.Mutex_net.Lock()
+	for _, c := range network.OpenCons {
+		conns = append(conns, c)
+	}
+	network.Mutex_net.Unlock()
+
+	res := make([]*peerInfoResp, 0, len(conns))
+	for _, c := range conns {
+		var ci network.ConnInfo
+		c.GetStats(&ci)
+		if !ci.VersionReceived {
+			continue
+		}
+		r := new(peerInfoResp)
+		r.ID = ci.ID
+		r.Addr = ci.PeerIp
+		r.AddrBind = ci.LocalAddr
+		r.Network = peerNetwork(ci.PeerIp)
+		if ci.IsOnion {
+			r.Network = "onion"
+		}
+		r.Services = fmt.Sprintf("%016x", ci.Services)
+		r.ServicesNames = servicesNames(ci.Services)
+		r.RelayTxes = !ci.DoNotRelayTxs
+		r.LastSend = ci.LastSent.Unix()
+		r.LastRecv = ci.LastDataGot.Unix()
+		r.BytesSent = ci.BytesSent
+		r.BytesRecv = ci.BytesReceived
+		r.ConnTime = ci.ConnectedAt.Unix()
+		r.PingTime = float64(ci.AveragePing) / 1000.0
+		r.Version = ci.Version
+		r.SubVer = ci.Agent
+		r.Inbound = ci.Incomming
+		r.BIP152HBTo = ci.HighBandwidth
+		r.StartingHeight = ci.Height
+		r.Inflight = ci.BlocksInProgress
+		if ci.MinFeeSPKB > 0 {
+			r.MinFeeFilter = btcAmount(ci.MinFeeSPKB)
+		}
+		if ci.Incomming {
+			r.ConnectionType = "inbound"
+		} else if ci.IsSpecial {
+			r.ConnectionType = "manual"
+		} else {
+			r.ConnectionType = "outbound-full-relay"
+		}
+		if ci.V2Transport {
+			r.TransportProtocolType = "v2"
+		} else {
+			r.TransportProtocolType = "v1"
+		}
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+func rpcGetNetworkInfo(p rpcParams) (interface{}, *RpcError) {
+	type networkResp struct {
+		Name                      string `json:"name"`
+		Limited                   bool   `json:"limited"`
+		Reachable                 bool   `json:"reachable"`
+		Proxy                     string `json:"proxy"`
+		ProxyRandomizeCredentials bool   `json:"proxy_randomize_credentials"`
+	}
+	type localAddrResp struct {
+		Address string `json:"address"`
+		Port    uint16 `json:"port"`
+		Score   uint   `json:"score"`
+	}
+	type networkInfoResp struct {
+		Version            int             `json:"version"`
+		SubVersion         string          `json:"subversion"`
+		ProtocolVersion    uint32          `json:"protocolversion"`
+		LocalServices      string          `json:"localservices"`
+		LocalServicesNames []string        `json:"localservicesnames"`
+		LocalRelay         bool            `json:"localrelay"`
+		TimeOffset         int             `json:"timeoffset"`
+		NetworkActive      bool            `json:"networkactive"`
+		Connections        uint32          `json:"connections"`
+		ConnectionsIn      uint32          `json:"connections_in"`
+		ConnectionsOut     uint32          `json:"connections_out"`
+		Networks           []networkResp   `json:"networks"`
+		RelayFee           btcAmount       `json:"relayfee"`
+		IncrementalFee     btcAmount       `json:"incrementalfee"`
+		LocalAddresses     []localAddrResp `json:"localaddresses"`
+		Warnings           string          `json:"warnings"`
+	}
+	res := new(networkInfoResp)
+	res.Version = clientVersion()
+	res.SubVersion = common.UserAgent
+	res.ProtocolVersion = common.Version
+	services := common.GetServices()
+	res.LocalServices = fmt.Sprintf("%016x", services)
+	res.LocalServicesNames = servicesNames(services)
+	res.LocalRelay = common.Get(&common.CFG.TXPool.Enabled)
+	res.NetworkActive = true
+
+	network.Mutex_net.Lock()
+	res.ConnectionsIn, res.ConnectionsOut = network.InConsActive, network.OutConsActive
+	network.Mutex_net.Unlock()
+	res.Connections = res.ConnectionsIn + res.ConnectionsOut
+
+	for _, n := range []string{"ipv4", "ipv6", "onion"} {
+		proxy, randomize := common.GetProxy(n == "onion")
+		nr := networkResp{Name: n, Proxy: proxy, ProxyRandomizeCredentials: proxy != "" && randomize}
+		nr.Reachable = n != "onion" || proxy != ""
+		nr.Limited = !nr.Reachable
+		res.Networks = append(res.Networks, nr)
+	}
+
+	res.RelayFee = btcAmount(common.MinFeePerKB())
+	res.IncrementalFee = btcAmount(common.IncrementalFeePerKB())
+
+	res.LocalAddresses = []localAddrResp{}
+	if common.IsListenTCP() {
+		for _, ip := range network.GetExternalIPs() {
+			res.LocalAddresses = append(res.LocalAddresses, localAddrResp{
+				Address: fmt.Sprintf("%d.%d.%d.%d", byte(ip.IP>>24), byte(ip.IP>>16), byte(ip.IP>>8), byte(ip.IP)),
+				Port:    common.ConfiguredTcpPort(), Score: ip.Cnt})
+		}
+	}
+	if oa := network.OnionAddr(); oa != nil {
+		res.LocalAddresses = append(res.LocalAddresses, localAddrResp{Address: oa.HostString(), Port: oa.Port, Score: 4})
+	}
+	return res, nil
+}
@@ -0,0 +1,97 @@
+package rpcapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// rpcParams are positional parameters of a command (the named ones get converted to it).
+type rpcParams []interface{}
+
+func typeError(name, exp string) *RpcError {
+	return &RpcError{Code: RPC_TYPE_ERROR, Message: fmt.Sprint("JSON value of parameter ", name, " is not of expected type ", exp)}
+}
+
+// has returns true if the parameter is present (and not null).
+func (p rpcParams) has(i int) bool {
+	return i < len(p) && p[i] != nil
+}
+
+func (p rpcParams) str(i int, name string) (string, *RpcError) {
+	if !p.has(i) {
+		return "", &RpcError{Code: RPC_MISC_ERROR, Message: "Missing required parameter " + name}
+	}
+	s, ok := p[i].(string)
+	if !ok {
+		return "", typeError(name, "string")
+	}
+	return s, nil
+}
+
+// hash decodes a block hash or a txid, given as 64 hex characters.
+func (p rpcParams) hash(i int, name string) (*btc.Uint256, *RpcError) {
+	s, er := p.str(i, name)
+	if er != nil {
+		return nil, er
+	}
+	if len(s) != 64 {
+		return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: fmt.Sprint(name, " must be of length 64 (not ", len(s), ", for '", s, "')")}
+	}
+	if _, e := hex.DecodeString(s); e != nil {
+		return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: fmt.Sprint(name, " must be hexadecimal string (not '", s, "')")}
+	}
+	return btc.NewUint256FromString(s), nil
+}
+
+// int returns the integer parameter, or def if it is not present.
+func (p rpcParams) int(i int, name string, def int64) (int64, *RpcError) {
+	if !p.has(i) {
+		return def, nil
+	}
+	if n, ok := p[i].(json.Number); ok {
+		if v, e := n.Int64(); e == nil {
+			return v, nil
+		}
+	}
+	return 0, typeError(name, "number")
+}
+
+// bool returns the boolean parameter, or def if it is not present. Numbers are accepted as well.
+func (p rpcParams) bool(i int, name string, def bool) (bool, *RpcError) {
+	if !p.has(i) {
+		return def, nil
+	}
+	switch v := p[i].(type) {
+	case bool:
+		return v, nil
+	case json.Number:
+		if n, e := v.Int64(); e == nil {
+			return n != 0, nil
+		}
+	}
+	return false, typeError(name, "bool")
+}
+
+// float returns the numeric parameter, or def if it is not present.
+func (p rpcParams) float(i int, name string, def float64) (float64, *RpcError) {
+	if !p.has(i) {
+		return def, nil
+	}
+	if n, ok := p[i].(json.Number); ok {
+		if v, e := n.Float64(); e == nil && !math.IsNaN(v) {
+			return v, nil
+		}
+	}
+	return 0, typeError(name, "number")
+}
+
+// btcAmount is a value in satoshis, which gets marshaled as BTC with 8 decimal places.
+type btcAmount uint64
+
+func (a btcAmount) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d.%08d", uint64(a)/1e8, uint64(a)%1e8)), nil
+}
@@ -0,0 +1,666 @@
+package rpcapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/client/txpool"
+	"github.com/piotrnar/gocoin/client/usif"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
+	"github.com/piotrnar/gocoin/lib/script"
+)
+
+const DEFAULT_MAX_RAW_TX_FEE_RATE = 0.10 // BTC/kvB, as in Core
+
+// opcode names, starting from OP_NOP (0x61)
+var opNames = strings.Fields(`OP_NOP OP_VER OP_IF OP_NOTIF OP_VERIF OP_VERNOTIF OP_ELSE OP_ENDIF OP_VERIFY OP_RETURN
+	OP_TOALTSTACK OP_FROMALTSTACK OP_2DROP OP_2DUP OP_3DUP OP_2OVER OP_2ROT OP_2SWAP OP_IFDUP OP_DEPTH OP_DROP OP_DUP
+	OP_NIP OP_OVER OP_PICK OP_ROLL OP_ROT OP_SWAP OP_TUCK OP_CAT OP_SUBSTR OP_LEFT OP_RIGHT OP_SIZE OP_INVERT OP_AND
+	OP_OR OP_XOR OP_EQUAL OP_EQUALVERIFY OP_RESERVED1 OP_RESERVED2 OP_1ADD OP_1SUB OP_2MUL OP_2DIV OP_NEGATE OP_ABS
+	OP_NOT OP_0NOTEQUAL OP_ADD OP_SUB OP_MUL OP_DIV OP_MOD OP_LSHIFT OP_RSHIFT OP_BOOLAND OP_BOOLOR OP_NUMEQUAL
+	OP_NUMEQUALVERIFY OP_NUMNOTEQUAL OP_LESSTHAN OP_GREATERTHAN OP_LESSTHANOREQUAL OP_GREATERTHANOREQUAL OP_MIN OP_MAX
+	OP_WITHIN OP_RIPEMD160 OP_SHA1 OP_SHA256 OP_HASH160 OP_HASH256 OP_CODESEPARATOR OP_CHECKSIG OP_CHECKSIGVERIFY
+	OP_CHECKMULTISIG OP_CHECKMULTISIGVERIFY OP_NOP1 OP_CHECKLOCKTIMEVERIFY OP_CHECKSEQUENCEVERIFY OP_NOP4 OP_NOP5
+	OP_NOP6 OP_NOP7 OP_NOP8 OP_NOP9 OP_NOP10 OP_CHECKSIGADD`)
+
+var sigHashNames = map[byte]string{
+	btc.SIGHASH_ALL:    "ALL",
+	btc.SIGHASH_NONE:   "NONE",
+	btc.SIGHASH_SINGLE: "SINGLE",
+	btc.SIGHASH_ALL | btc.SIGHASH_ANYONECANPAY:    "ALL|ANYONECANPAY",
+	btc.SIGHASH_NONE | btc.SIGHASH_ANYONECANPAY:   "NONE|ANYONECANPAY",
+	btc.SIGHASH_SINGLE | btc.SIGHASH_ANYONECANPAY: "SINGLE|ANYONECANPAY",
+}
+
+// scriptAsm returns the script in the same format as Core's ScriptToAsmStr().
+func scriptAsm(scr []byte, decode_sighash bool) string {
+	var out []string
+	for idx := 0; idx < len(scr); {
+		opcode, push, n, er := btc.GetOpcode(scr[idx:])
+		if er != nil {
+			out = append(out, "[error]")
+			break
+		}
+		idx += n
+		switch {
+		case opcode <= btc.OP_PUSHDATA4:
+			if len(push) <= 4 {
+				out = append(out, fmt.Sprint(scriptNum(push)))
+			} else if decode_sighash && script.IsValidSignatureEncoding(push) && sigHashNames[push[len(push)-1]] != "" {
+				out = append(out, hex.EncodeToString(push[:len(push)-1])+"["+sigHashNames[push[len(push)-1]]+"]")
+			} else {
+				out = append(out, hex.EncodeToString(push))
+			}
+		case opcode == btc.OP_1NEGATE:
+			out = append(out, "-1")
+		case opcode == 0x50:
+			out = append(out, "OP_RESERVED")
+		case opcode >= btc.OP_1 && opcode <= btc.OP_16:
+			out = append(out, fmt.Sprint(opcode-btc.OP_1+1))
+		case opcode-0x61 < len(opNames):
+			out = append(out, opNames[opcode-0x61])
+		default:
+			out = append(out, "OP_UNKNOWN")
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// scriptNum decodes a little endian, sign-magnitude number (up to 4 bytes).
+func scriptNum(d []byte) (res int64) {
+	if len(d) == 0 {
+		return
+	}
+	for i := range d {
+		res |= int64(d[i]) << (8 * i)
+	}
+	if d[len(d)-1]&0x80 != 0 {
+		return -(res & ^(int64(0x80) << (8 * (len(d) - 1))))
+	}
+	return
+}
+
+// scriptType returns the type of the output script, as named by Core.
+func scriptType(scr []byte) string {
+	if script.IsP2KH(scr) {
+		return "pubkeyhash"
+	}
+	if script.IsP2SH(scr) {
+		return "scripthash"
+	}
+	if script.IsP2WPKH(scr) {
+		return "witness_v0_keyhash"
+	}
+	if script.IsP2WSH(scr) {
+		return "witness_v0_scripthash"
+	}
+	if script.IsP2TAP(scr) {
+		return "witness_v1_taproot"
+	}
+	if len(scr) == 4 && scr[0] == btc.OP_1 && scr[1] == 2 && scr[2] == 0x4e && scr[3] == 0x73 {
+		return "anchor"
+	}
+	if ver, prog := btc.IsWitnessProgram(scr); prog != nil && ver != 0 {
+		return "witness_unknown"
+	}
+	if ok, _ := script.IsP2PK(scr); ok {
+		return "pubkey"
+	}
+	if len(scr) > 0 && scr[0] == btc.OP_RETURN {
+		return "nulldata"
+	}
+	if len(scr) >= 3 && scr[len(scr)-1] == btc.OP_CHECKMULTISIG &&
+		scr[0] >= btc.OP_1 && scr[0] <= btc.OP_16 && scr[len(scr)-2] >= btc.OP_1 && scr[len(scr)-2] <= btc.OP_16 {
+		return "multisig"
+	}
+	return "nonstandard"
+}
+
+type scriptPubKeyResp struct {
+	Asm     string `json:"asm"`
+	Hex     string `json:"hex"`
+	Address string `json:"address,omitempty"`
+	Type    string `json:"type"`
+}
+
+func newScriptPubKeyResp(scr []byte) (res *scriptPubKeyResp) {
+	res = &scriptPubKeyResp{Asm: scriptAsm(scr, false), Hex: hex.EncodeToString(scr), Type: scriptType(scr)}
+	switch res.Type {
+	case "pubkey", "multisig", "nulldata", "nonstandard":
+	default:
+		if a := btc.NewAddrFromPkScript(scr, common.Testnet); a != nil {
+			res.Address = a.String()
+		}
+	}
+	return
+}
+
+type txInResp struct {
+	Coinbase  string  `json:"coinbase,omitempty"`
+	TxID      string  `json:"txid,omitempty"`
+	Vout      *uint32 `json:"vout,omitempty"`
+	ScriptSig *struct {
+		Asm string `json:"asm"`
+		Hex string `json:"hex"`
+	} `json:"scriptSig,omitempty"`
+	TxInWitness []string `json:"txinwitness,omitempty"`
+	Sequence    uint32   `json:"sequence"`
+}
+
+type txOutResp struct {
+	Value        btcAmount         `json:"value"`
+	N            int               `json:"n"`
+	ScriptPubKey *scriptPubKeyResp `json:"scriptPubKey"`
+}
+
+type txResp struct {
+	TxID     string      `json:"txid"`
+	Hash     string      `json:"hash"`
+	Version  int32       `json:"version"`
+	Size     uint32      `json:"size"`
+	VSize    int         `json:"vsize"`
+	Weight   int         `json:"weight"`
+	LockTime uint32      `json:"locktime"`
+	Vin      []*txInResp `json:"vin"`
+	Vout     []txOutResp `json:"vout"`
+	Hex      string      `json:"hex"`
+
+	// these are only set for the transactions in blocks
+	BlockHash     string `json:"blockhash,omitempty"`
+	InActiveChain *bool  `json:"in_active_chain,omitempty"`
+	Confirmations int    `json:"confirmations,omitempty"`
+	Time          uint32 `json:"time,omitempty"`
+	BlockTime     uint32 `json:"blocktime,omitempty"`
+}
+
+// newTxResp returns the decoded tx, the way Core's TxToUniv() does it.
+func newTxResp(tx *btc.Tx) (res *txResp) {
+	res = new(txResp)
+	res.TxID = tx.Hash.String()
+	res.Hash = tx.WTxID().String()
+	res.Version = int32(tx.Version)
+	res.Size = tx.Size
+	res.VSize = tx.VSize()
+	res.Weight = tx.Weight()
+	res.LockTime = tx.Lock_time
+	res.Vin = make([]*txInResp, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		vin := &txInResp{Sequence: in.Sequence}
+		if tx.IsCoinBase() {
+			vin.Coinbase = hex.EncodeToString(in.ScriptSig)
+		} else {
+			vin.TxID = btc.NewUint256(in.Input.Hash[:]).String()
+			vin.Vout = &tx.TxIn[i].Input.Vout
+			vin.ScriptSig = &struct {
+				Asm string `json:"asm"`
+				Hex string `json:"hex"`
+			}{Asm: scriptAsm(in.ScriptSig, true), Hex: hex.EncodeToString(in.ScriptSig)}
+		}
+		if tx.SegWit != nil && len(tx.SegWit[i]) > 0 {
+			vin.TxInWitness = make([]string, len(tx.SegWit[i]))
+			for j, w := range tx.SegWit[i] {
+				vin.TxInWitness[j] = hex.EncodeToString(w)
+			}
+		}
+		res.Vin[i] = vin
+	}
+	res.Vout = make([]txOutResp, len(tx.TxOut))
+	for i, out := range tx.TxOut {
+		res.Vout[i] = txOutResp{Value: btcAmount(out.Value), N: i, ScriptPubKey: newScriptPubKeyResp(out.Pk_script)}
+	}
+	res.Hex = hex.EncodeToString(tx.Raw)
+	return
+}
+
+// txFromBlock looks for the transaction in the given block.
+func txFromBlock(n *chain.BlockTreeNode, txid *btc.Uint256) *btc.Tx {
+	raw, _, e := common.BlockChain.Blocks.BlockGet(n.BlockHash)
+	if e != nil {
+		return nil
+	}
+	bl, e := btc.NewBlock(raw)
+	if e != nil || bl.BuildTxList() != nil {
+		return nil
+	}
+	for _, tx := range bl.Txs {
+		if tx.Hash.Equal(txid) {
+			return tx
+		}
+	}
+	return nil
+}
+
+func rpcGetRawTransaction(p rpcParams) (interface{}, *RpcError) {
+	txid, er := p.hash(0, "txid")
+	if er != nil {
+		return nil, er
+	}
+	verbose, er := p.bool(1, "verbose", false)
+	if er != nil {
+		return nil, er
+	}
+
+	var tx *btc.Tx
+	var node *chain.BlockTreeNode
+	if p.has(2) {
+		// gocoin extension: instead of the block hash, the block height can be given
+		if _, ok := p[2].(json.Number); ok {
+			height, er := p.int(2, "blockhash", 0)
+			if er != nil {
+				return nil, er
+			}
+			common.BlockChain.BlockIndexAccess.Lock()
+			if height >= 0 && height <= 0xffffffff {
+				node = activeNodeAtHeight(uint32(height))
+			}
+			common.BlockChain.BlockIndexAccess.Unlock()
+			if node == nil {
+				return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: "Block height out of range"}
+			}
+		} else {
+			hash, er := p.hash(2, "blockhash")
+			if er != nil {
+				return nil, er
+			}
+			common.BlockChain.BlockIndexAccess.Lock()
+			node = common.BlockChain.BlockIndex[hash.BIdx()]
+			common.BlockChain.BlockIndexAccess.Unlock()
+			if node == nil {
+				return nil, &RpcError{Code: RPC_INVALID_ADDRESS_OR_KEY, Message: "Block hash not found"}
+			}
+		}
+		if tx = txFromBlock(node, txid); tx == nil {
+			return nil, &RpcError{Code: RPC_INVALID_ADDRESS_OR_KEY, Message: "No such transaction found in the provided block"}
+		}
+	} else {
+		txpool.TxMutex.Lock()
+		if t2s, ok := txpool.TransactionsToSend[txid.BIdx()]; ok {
+			tx = t2s.Tx
+		}
+		txpool.TxMutex.Unlock()
+		if tx == nil && common.BlockChain.TxIndex != nil {
+			var e error
+			if tx, node, e = common.BlockChain.GetIndexedTx(txid); e != nil {
+				msg := "No such mempool or blockchain transaction."
+				if e == chain.ErrTxPruned {
+					msg = "Block not available (pruned data)"
+				} else if !common.BlockChain.TxIndex.Complete() {
+					msg += " Blockchain transactions are still in the process of being indexed."
+				}
+				return nil, &RpcError{Code: RPC_INVALID_ADDRESS_OR_KEY, Message: msg}
+			}
+		}
+		if tx == nil {
+			return nil, &RpcError{Code: RPC_INVALID_ADDRESS_OR_KEY, Message: "No such mempool transaction. " +
+				"Set TxIndex in the config or provide a block hash (or height) to enable blockchain transaction queries."}
+		}
+	}
+
+	if !verbose {
+		return hex.EncodeToString(tx.Raw), nil
+	}
+	res := newTxResp(tx)
+	if node != nil {
+		res.BlockHash = node.BlockHash.String()
+		common.BlockChain.BlockIndexAccess.Lock()
+		active := common.BlockChain.OnActiveBranch(node)
+		if active {
+			res.Confirmations = int(common.BlockChain.LastBlock().Height-node.Height) + 1
+		}
+		common.BlockChain.BlockIndexAccess.Unlock()
+		res.InActiveChain = &active
+		res.Time = node.Timestamp()
+		res.BlockTime = res.Time
+	}
+	return res, nil
+}
+
+// rejectError converts the mempool's reject reason to Core's error.
+func rejectError(reason byte) *RpcError {
+	var msg string
+	switch reason {
+	case txpool.TX_REJECTED_NO_TXOU, txpool.TX_REJECTED_BAD_INPUT, txpool.TX_REJECTED_BAD_PARENT:
+		return &RpcError{Code: RPC_VERIFY_ERROR, Message: "bad-txns-inputs-missingorspent"}
+	case txpool.TX_REJECTED_TOO_BIG:
+		msg = "tx-size"
+	case txpool.TX_REJECTED_OVERSPEND:
+		msg = "bad-txns-in-belowout"
+	case txpool.TX_REJECTED_SCRIPT_FAIL:
+		msg = "mandatory-script-verify-flag-failed"
+	case txpool.TX_REJECTED_LOW_FEE:
+		msg = "min relay fee not met"
+	case txpool.TX_REJECTED_CB_INMATURE:
+		msg = "bad-txns-premature-spend-of-coinbase"
+	case txpool.TX_REJECTED_RBF_LOWFEE:
+		msg = "insufficient fee"
+	case txpool.TX_REJECTED_RBF_FINAL:
+		msg = "txn-mempool-conflict"
+	case txpool.TX_REJECTED_RBF_100:
+		msg = "too many potential replacements"
+	case txpool.TX_REJECTED_ANCESTORS, txpool.TX_REJECTED_DESCENDANTS:
+		msg = "too-long-mempool-chain"
+	case txpool.TX_REJECTED_CLUSTER:
+		msg = "too-large-cluster"
+	case txpool.TX_REJECTED_VERSION:
+		msg = "version"
+	case txpool.TX_REJECTED_TX_SIZE_SMALL:
+		msg = "tx-size-small"
+	case txpool.TX_REJECTED_SCRIPTSIG_SIZE:
+		msg = "scriptsig-size"
+	case txpool.TX_REJECTED_SCRIPTSIG_PUSHONLY:
+		msg = "scriptsig-not-pushonly"
+	case txpool.TX_REJECTED_SCRIPTPUBKEY:
+		msg = "scriptpubkey"
+	case txpool.TX_REJECTED_DUST:
+		msg = "dust"
+	case txpool.TX_REJECTED_DATACARRIER:
+		msg = "datacarrier"
+	case txpool.TX_REJECTED_BARE_MULTISIG:
+		msg = "bare-multisig"
+	case txpool.TX_REJECTED_NONSTD_INPUTS:
+		msg = "bad-txns-nonstandard-inputs"
+	case txpool.TX_REJECTED_NONSTD_WITNESS:
+		msg = "bad-witness-nonstandard"
+	case txpool.TX_REJECTED_SIGOPS:
+		msg = "bad-txns-too-many-sigops"
+	case txpool.TX_REJECTED_EPHEMERAL_FEE:
+		msg = "dust"
+	case txpool.TX_REJECTED_TRUC_INHERIT, txpool.TX_REJECTED_TRUC_SIZE, txpool.TX_REJECTED_TRUC_ANCESTORS, txpool.TX_REJECTED_TRUC_DESCENDANTS:
+		msg = "TRUC-violation"
+	case txpool.TX_REJECTED_EPHEMERAL_SPENDS:
+		msg = "missing-ephemeral-spends"
+	default:
+		msg = strings.ToLower(txpool.ReasonToString(reason))
+	}
+	return &RpcError{Code: RPC_VERIFY_REJECTED, Message: msg}
+}
+
+// txFee returns the fee of the tx, if all its inputs are known.
+// The inputs can also be taken from the given package (if not nil).
+func txFee(tx *btc.Tx, pkg map[btc.BIDX]*btc.Tx) (fee uint64, ok bool) {
+	var totin, totout uint64
+	for _, in := range tx.TxIn {
+		var out *btc.TxOut
+		if ptx, ok := pkg[btc.BIdx(in.Input.Hash[:])]; ok && int(in.Input.Vout) < len(ptx.TxOut) {
+			totin += ptx.TxOut[in.Input.Vout].Value
+			continue
+		}
+		txpool.TxMutex.Lock()
+		if t2s, ok := txpool.TransactionsToSend[btc.BIdx(in.Input.Hash[:])]; ok && int(in.Input.Vout) < len(t2s.TxOut) {
+			out = t2s.TxOut[in.Input.Vout]
+		}
+		txpool.TxMutex.Unlock()
+		if out == nil {
+			if out = common.BlockChain.Unspent.UnspentGet(&in.Input); out == nil {
+				return
+			}
+		}
+		totin += out.Value
+	}
+	for _, out := range tx.TxOut {
+		totout += out.Value
+	}
+	if totin < totout {
+		return
+	}
+	return totin - totout, true
+}
+
+func rpcSendRawTransaction(p rpcParams) (interface{}, *RpcError) {
+	s, er := p.str(0, "hexstring")
+	if er != nil {
+		return nil, er
+	}
+	maxfeerate, er := p.float(1, "maxfeerate", DEFAULT_MAX_RAW_TX_FEE_RATE)
+	if er != nil {
+		return nil, er
+	}
+	raw, e := hex.DecodeString(s)
+	if e != nil {
+		return nil, &RpcError{Code: RPC_DESERIALIZATION_ERROR, Message: "TX decode failed. Make sure the tx has at least one input."}
+	}
+	tx, le := btc.NewTx(raw)
+	if tx == nil || le != len(raw) || len(tx.TxIn) == 0 {
+		return nil, &RpcError{Code: RPC_DESERIALIZATION_ERROR, Message: "TX decode failed. Make sure the tx has at least one input."}
+	}
+	tx.SetHash(raw)
+	txid := tx.Hash.String()
+
+	txpool.TxMutex.Lock()
+	_, inmem := txpool.TransactionsToSend[tx.Hash.BIdx()]
+	txpool.TxMutex.Unlock()
+
+	if !inmem {
+		for i := range tx.TxOut {
+			if common.BlockChain.Unspent.UnspentGet(&btc.TxPrevOut{Hash: tx.Hash.Hash, Vout: uint32(i)}) != nil {
+				return nil, &RpcError{Code: RPC_VERIFY_ALREADY_IN_CHAIN, Message: "Transaction outputs already in utxo set"}
+			}
+		}
+		if maxfeerate > 0 {
+			if fee, ok := txFee(tx, nil); ok && float64(fee)/1e5 > maxfeerate*float64(tx.VSize()) {
+				return nil, &RpcError{Code: RPC_VERIFY_ERROR, Message: "Fee exceeds maximum configured by user (maxfeerate)"}
+			}
+		}
+
+		// the tx must be processed in sync with the main thread
+		lck := new(usif.OneLock)
+		lck.In.Add(1)
+		lck.Out.Add(1)
+		usif.LocksChan <- lck
+		lck.In.Wait()
+		reason := txpool.SubmitLocalTxExt(tx, raw)
+		lck.Out.Done()
+		if reason != 0 {
+			return nil, rejectError(reason)
+		}
+	}
+
+	txpool.TxMutex.Lock()
+	t2s, ok := txpool.TransactionsToSend[tx.Hash.BIdx()]
+	txpool.TxMutex.Unlock()
+	if ok {
+		cnt := network.NetRouteInv(network.MSG_TX, &tx.Hash, nil)
+		atomic.AddUint32(&t2s.Invsentcnt, cnt)
+	}
+	return txid, nil
+}
+
+func rpcSubmitPackage(p rpcParams) (interface{}, *RpcError) {
+	if !p.has(0) {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "Missing required parameter package"}
+	}
+	arr, ok := p[0].([]interface{})
+	if !ok {
+		return nil, typeError("package", "array")
+	}
+	if len(arr) == 0 || len(arr) > txpool.MAX_PACKAGE_COUNT {
+		return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: fmt.Sprint("Array must contain between 1 and ", txpool.MAX_PACKAGE_COUNT, " transactions.")}
+	}
+	maxfeerate, er := p.float(1, "maxfeerate", DEFAULT_MAX_RAW_TX_FEE_RATE)
+	if er != nil {
+		return nil, er
+	}
+
+	pkg := &txpool.PkgRcvd{Trusted: true, Local: true}
+	pkgtxs := make(map[btc.BIDX]*btc.Tx, len(arr))
+	for _, v := range arr {
+		s, _ := v.(string)
+		raw, e := hex.DecodeString(s)
+		if e != nil {
+			return nil, &RpcError{Code: RPC_DESERIALIZATION_ERROR, Message: "TX decode failed: " + s}
+		}
+		tx, le := btc.NewTx(raw)
+		if tx == nil || le != len(raw) || len(tx.TxIn) == 0 {
+			return nil, &RpcError{Code: RPC_DESERIALIZATION_ERROR, Message: "TX decode failed: " + s}
+		}
+		tx.SetHash(raw)
+		pkg.Txs = append(pkg.Txs, tx)
+		pkgtxs[tx.Hash.BIdx()] = tx
+	}
+
+	if maxfeerate > 0 {
+		var totfee uint64
+		var totvsize int
+		for _, tx := range pkg.Txs {
+			if fee, ok := txFee(tx, pkgtxs); ok {
+				totfee += fee
+				totvsize += tx.VSize()
+			}
+		}
+		if float64(totfee)/1e5 > maxfeerate*float64(totvsize) {
+			return nil, &RpcError{Code: RPC_VERIFY_ERROR, Message: "Fee exceeds maximum configured by user (maxfeerate)"}
+		}
+	}
+
+	// the package must be processed in sync with the main thread
+	lck := new(usif.OneLock)
+	lck.In.Add(1)
+	lck.Out.Add(1)
+	usif.LocksChan <- lck
+	lck.In.Wait()
+	txpool.SubmitPackage(pkg)
+	lck.Out.Done()
+
+	var pkg_msg string
+	switch pkg.Result {
+	case 0:
+		pkg_msg = "success"
+	case txpool.PKG_REJECTED_TX:
+		pkg_msg = "transaction failed"
+	case txpool.PKG_REJECTED_LOW_FEE:
+		pkg_msg = "package-fee-too-low"
+	case txpool.PKG_REJECTED_RBF:
+		pkg_msg = "package RBF failed"
+	default:
+		return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: "package topology disallowed. not child-with-parents or parents depend on each other (" +
+			strings.ToLower(txpool.PkgReasonToString(pkg.Result)) + ")"}
+	}
+
+	type txFees struct {
+		Base        btcAmount `json:"base"`
+		EffFeeRate  btcAmount `json:"effective-feerate,omitempty"`
+		EffIncludes []string  `json:"effective-includes,omitempty"`
+	}
+	type txResult struct {
+		TxID  string  `json:"txid"`
+		VSize int     `json:"vsize,omitempty"`
+		Fees  *txFees `json:"fees,omitempty"`
+		Error string  `json:"error,omitempty"`
+	}
+	res := struct {
+		Msg      string               `json:"package_msg"`
+		Results  map[string]*txResult `json:"tx-results"`
+		Replaced []string             `json:"replaced-transactions"`
+	}{Msg: pkg_msg, Results: make(map[string]*txResult), Replaced: []string{}}
+
+	var pkgfee uint64
+	var pkgvsize int
+	var pkgwtxids []string
+	for i, r := range pkg.TxResults {
+		if r.Accepted && r.Package {
+			pkgfee += r.Fee
+			pkgvsize += pkg.Txs[i].VSize()
+			pkgwtxids = append(pkgwtxids, pkg.Txs[i].WTxID().String())
+		}
+	}
+	for i, r := range pkg.TxResults {
+		tx := pkg.Txs[i]
+		tr := &txResult{TxID: tx.Hash.String()}
+		if r.Result != 0 {
+			tr.Error = rejectError(r.Result).Message
+		} else if !r.Accepted {
+			tr.Error = "package-not-validated"
+		} else {
+			tr.VSize = tx.VSize()
+			tr.Fees = &txFees{Base: btcAmount(r.Fee)}
+			if r.Package {
+				tr.Fees.EffFeeRate = btcAmount(pkgfee * 1000 / uint64(pkgvsize))
+				tr.Fees.EffIncludes = pkgwtxids
+			} else {
+				tr.Fees.EffFeeRate = btcAmount(r.Fee * 1000 / uint64(tr.VSize))
+				tr.Fees.EffIncludes = []string{tx.WTxID().String()}
+			}
+			if !r.InMempool {
+				txpool.TxMutex.Lock()
+				t2s, ok := txpool.TransactionsToSend[tx.Hash.BIdx()]
+				txpool.TxMutex.Unlock()
+				if ok {
+					cnt := network.NetRouteInv(network.MSG_TX, &tx.Hash, nil)
+					atomic.AddUint32(&t2s.Invsentcnt, cnt)
+				}
+			}
+		}
+		res.Results[tx.WTxID().String()] = tr
+	}
+	for _, id := range pkg.Replaced {
+		res.Replaced = append(res.Replaced, id.String())
+	}
+	return res, nil
+}
+
+func rpcGetTxOut(p rpcParams) (interface{}, *RpcError) {
+	txid, er := p.hash(0, "txid")
+	if er != nil {
+		return nil, er
+	}
+	if !p.has(1) {
+		return nil, &RpcError{Code: RPC_MISC_ERROR, Message: "Missing required parameter n"}
+	}
+	vout, er := p.int(1, "n", 0)
+	if er != nil {
+		return nil, er
+	}
+	include_mempool, er := p.bool(2, "include_mempool", true)
+	if er != nil {
+		return nil, er
+	}
+	if vout < 0 || vout > 0xffffffff {
+		return nil, nil
+	}
+	po := &btc.TxPrevOut{Hash: txid.Hash, Vout: uint32(vout)}
+
+	type txOutInfo struct {
+		BestBlock     string            `json:"bestblock"`
+		Confirmations uint32            `json:"confirmations"`
+		Value         btcAmount         `json:"value"`
+		ScriptPubKey  *scriptPubKeyResp `json:"scriptPubKey"`
+		Coinbase      bool              `json:"coinbase"`
+	}
+	res := new(txOutInfo)
+	top := common.BlockChain.LastBlock()
+	res.BestBlock = top.BlockHash.String()
+
+	if include_mempool {
+		txpool.TxMutex.Lock()
+		_, spent := txpool.SpentOutputs[po.UIdx()]
+		t2s := txpool.TransactionsToSend[txid.BIdx()]
+		txpool.TxMutex.Unlock()
+		if spent {
+			return nil, nil
+		}
+		if t2s != nil {
+			if int(po.Vout) >= len(t2s.TxOut) {
+				return nil, nil
+			}
+			res.Value = btcAmount(t2s.TxOut[po.Vout].Value)
+			res.ScriptPubKey = newScriptPubKeyResp(t2s.TxOut[po.Vout].Pk_script)
+			return res, nil
+		}
+	}
+
+	out := common.BlockChain.Unspent.UnspentGet(po)
+	if out == nil {
+		return nil, nil
+	}
+	res.Confirmations = top.Height - out.BlockHeight + 1
+	res.Value = btcAmount(out.Value)
+	res.ScriptPubKey = newScriptPubKeyResp(out.Pk_script)
+	res.Coinbase = out.WasCoinbase
+	return res, nil
+}
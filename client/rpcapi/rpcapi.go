@@ -5,15 +5,13 @@ package rpcapi
 
 import (
 	"bytes"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
-
-	"github.com/piotrnar/gocoin/client/common"
+	"time"
 )
 
 type RpcError struct {
@@ -27,10 +25,24 @@ type RpcResponse struct {
 	Error  interface{} `json:"error"`
 }
 
+// rpcResponse2 is the JSON-RPC 2.0 response, which has either result or error.
+type rpcResponse2 struct {
+	JsonRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   interface{}     `json:"error,omitempty"`
+	Id      interface{}     `json:"id"`
+}
+
 type RpcCommand struct {
-	Id     interface{} `json:"id"`
-	Params interface{} `json:"params"`
-	Method string      `json:"method"`
+	Id      json.RawMessage `json:"id"` // nil if not present (JSON-RPC 2.0 notification)
+	Params  interface{}     `json:"params"`
+	Method  string          `json:"method"`
+	JsonRPC string          `json:"jsonrpc"`
+}
+
+// v2 returns true for JSON-RPC 2.0 requests.
+func (cmd *RpcCommand) v2() bool {
+	return cmd.JsonRPC == "2.0"
 }
 
 func process_rpc(b []byte) (out []byte) {
@@ -41,150 +53,260 @@ func process_rpc(b []byte) (out []byte) {
 	return
 }
 
-func my_handler(w http.ResponseWriter, r *http.Request) {
-	u, p, ok := r.BasicAuth()
-	if !ok {
-		println("No HTTP Authentication data")
-		return
+// Error codes, as used by Bitcoin Core
+const (
+	RPC_MISC_ERROR              = -1
+	RPC_TYPE_ERROR              = -3
+	RPC_INVALID_ADDRESS_OR_KEY  = -5
+	RPC_INVALID_PARAMETER       = -8
+	RPC_DESERIALIZATION_ERROR   = -22
+	RPC_VERIFY_ERROR            = -25
+	RPC_VERIFY_REJECTED         = -26
+	RPC_VERIFY_ALREADY_IN_CHAIN = -27
+	RPC_INVALID_REQUEST         = -32600
+	RPC_METHOD_NOT_FOUND        = -32601
+	RPC_INTERNAL_ERROR          = -32603
+	RPC_PARSE_ERROR             = -32700
+)
+
+type rpcHandler func(p rpcParams) (interface{}, *RpcError)
+
+type rpcMethod struct {
+	handler rpcHandler
+	args    []string // names of the parameters (for the named parameters support)
+}
+
+var rpcMethods map[string]*rpcMethod
+
+func init() {
+	rpcMethods = map[string]*rpcMethod{
+		// mining
+		"getblocktemplate":  {rpcGetBlockTemplate, []string{"template_request"}},
+		"getwork":           {rpcGetWork, []string{"data"}},
+		"getmininginfo":     {rpcGetMiningInfo, nil},
+		"generatetoaddress": {rpcGenerateToAddress, []string{"nblocks", "address", "maxtries"}},
+		"submitblock":       {SubmitBlock, []string{"hexdata"}},
+		"validateaddress":   {rpcValidateAddress, []string{"address"}},
+		"getaddresshistory": {rpcGetAddressHistory, []string{"address"}},
+
+		// blockchain
+		"getblockchaininfo": {rpcGetBlockchainInfo, nil},
+		"getdeploymentinfo": {rpcGetDeploymentInfo, []string{"blockhash"}},
+		"getbestblockhash":  {rpcGetBestBlockHash, nil},
+		"getblockcount":     {rpcGetBlockCount, nil},
+		"getblockhash":      {rpcGetBlockHash, []string{"height"}},
+		"getblock":          {rpcGetBlock, []string{"blockhash", "verbosity"}},
+		"getblockheader":    {rpcGetBlockHeader, []string{"blockhash", "verbose"}},
+		"gettxout":          {rpcGetTxOut, []string{"txid", "n", "include_mempool"}},
+		"loadtxoutset":      {rpcLoadTxOutSet, []string{"path"}},
+		"dumptxoutset":      {rpcDumpTxOutSet, []string{"path", "type"}},
+		"gettxoutsetinfo":   {rpcGetTxOutSetInfo, []string{"hash_type", "hash_or_height", "use_index"}},
+		"invalidateblock":   {rpcInvalidateBlock, []string{"blockhash"}},
+		"reconsiderblock":   {rpcReconsiderBlock, []string{"blockhash"}},
+		"preciousblock":     {rpcPreciousBlock, []string{"blockhash"}},
+
+		// mempool and transactions
+		"getrawmempool":      {rpcGetRawMempool, []string{"verbose", "mempool_sequence"}},
+		"getmempoolentry":    {rpcGetMempoolEntry, []string{"txid"}},
+		"getrawtransaction":  {rpcGetRawTransaction, []string{"txid", "verbose", "blockhash"}},
+		"sendrawtransaction": {rpcSendRawTransaction, []string{"hexstring", "maxfeerate"}},
+		"submitpackage":      {rpcSubmitPackage, []string{"package", "maxfeerate"}},
+		"estimatesmartfee":   {rpcEstimateSmartFee, []string{"conf_target", "estimate_mode"}},
+
+		// network
+		"getpeerinfo":    {rpcGetPeerInfo, nil},
+		"getnetworkinfo": {rpcGetNetworkInfo, nil},
 	}
-	if u != common.CFG.RPC.Username {
-		println("HTTP Authentication: bad username")
-		return
+}
+
+// params returns the parameters of the command in the order expected by the method.
+func (m *rpcMethod) params(cmd *RpcCommand) (rpcParams, *RpcError) {
+	switch pars := cmd.Params.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return rpcParams(pars), nil
+	case map[string]interface{}:
+		res := make(rpcParams, 0, len(m.args))
+		for name, val := range pars {
+			idx := -1
+			for i, a := range m.args {
+				if a == name {
+					idx = i
+					break
+				}
+			}
+			if idx < 0 {
+				return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: "Unknown named parameter " + name}
+			}
+			for len(res) <= idx {
+				res = append(res, nil)
+			}
+			res[idx] = val
+		}
+		return res, nil
 	}
-	if p != common.CFG.RPC.Password {
-		println("HTTP Authentication: bad password")
+	return nil, &RpcError{Code: RPC_INVALID_REQUEST, Message: "Params must be an array or object"}
+}
+
+// execute runs a single RPC command and returns the response for it.
+func execute(cmd *RpcCommand) (resp RpcResponse) {
+	resp.Id = cmd.Id
+	m := rpcMethods[cmd.Method]
+	if m == nil {
+		fmt.Println("Method:", cmd.Method)
+		resp.Error = &RpcError{Code: RPC_METHOD_NOT_FOUND, Message: "Method not found"}
 		return
 	}
-	//fmt.Println("========================handler", r.Method, r.URL.String(), u, p, ok, "=================")
-	b, e := io.ReadAll(r.Body)
+	p, er := m.params(cmd)
+	if er == nil {
+		resp.Result, er = m.handler(p)
+	}
+	if er != nil {
+		resp.Error = er
+	}
+	return
+}
+
+// reply returns the response in the format matching the request's JSON-RPC version.
+func (resp *RpcResponse) reply(v2 bool) interface{} {
+	if !v2 {
+		return resp
+	}
+	r2 := &rpcResponse2{JsonRPC: "2.0", Id: resp.Id, Error: resp.Error}
+	if r2.Error == nil {
+		var e error
+		if r2.Result, e = json.Marshal(resp.Result); e != nil {
+			println("json.Marshal(resp.Result):", e.Error())
+			r2.Result, r2.Error = nil, &RpcError{Code: RPC_MISC_ERROR, Message: e.Error()}
+		}
+	}
+	return r2
+}
+
+// writeReply sends the JSON data with the given HTTP status.
+func writeReply(w http.ResponseWriter, status int, v interface{}) {
+	b, e := json.Marshal(v)
 	if e != nil {
-		println(e.Error())
-		return
+		println("json.Marshal(reply):", e.Error())
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	//ioutil.WriteFile(RpcCmd.Method+"_resp.json", b, 0777)
+	w.Write(append(b, 0x0a))
+}
 
-	var RpcCmd RpcCommand
+// writeError sends the error response that is not related to any particular command.
+func writeError(w http.ResponseWriter, status int, code int, msg string) {
+	writeReply(w, status, &RpcResponse{Error: &RpcError{Code: code, Message: msg}})
+}
+
+// legacyStatus returns HTTP status for JSON-RPC 1.0 response (same as Core does it).
+func legacyStatus(resp *RpcResponse) int {
+	if er, ok := resp.Error.(*RpcError); ok {
+		if er.Code == RPC_METHOD_NOT_FOUND {
+			return http.StatusNotFound
+		} else if er.Code == RPC_INVALID_REQUEST || er.Code == RPC_PARSE_ERROR {
+			return http.StatusBadRequest
+		} else {
+			return http.StatusInternalServerError
+		}
+	}
+	return http.StatusOK
+}
+
+func decodeCommand(b []byte) (cmd *RpcCommand, e error) {
+	cmd = new(RpcCommand)
 	jd := json.NewDecoder(bytes.NewReader(b))
 	jd.UseNumber()
-	e = jd.Decode(&RpcCmd)
+	e = jd.Decode(cmd)
+	return
+}
+
+func my_handler(w http.ResponseWriter, r *http.Request) {
+	user, ok := checkAuth(r)
+	if !ok {
+		println("RPC: HTTP authentication failed from", r.RemoteAddr)
+		time.Sleep(250 * time.Millisecond) // make brute forcing harder
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		writeError(w, http.StatusUnauthorized, RPC_INVALID_REQUEST, "Unauthorized")
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, RPC_INVALID_REQUEST, "JSONRPC server handles only POST requests")
+		return
+	}
+	//fmt.Println("========================handler", r.Method, r.URL.String(), user, "=================")
+	b, e := io.ReadAll(r.Body)
 	if e != nil {
 		println(e.Error())
+		return
 	}
 
-	var resp RpcResponse
-	resp.Id = RpcCmd.Id
-	//println("------------------------------ RPC command:", RpcCmd.Method, "---------------------------------------------")
-	switch RpcCmd.Method {
-	case "getblocktemplate":
-		var resp_my RpcGetBlockTemplateResp
-		GetNextBlockTemplate(&resp_my.Result)
-
-		if false {
-			var resp_ok RpcGetBlockTemplateResp
-			bitcoind_result := process_rpc(b)
-			//ioutil.WriteFile("getblocktemplate_resp.json", bitcoind_result, 0777)
-
-			//fmt.Print("getblocktemplate...")
-
-			jd = json.NewDecoder(bytes.NewReader(bitcoind_result))
-			jd.UseNumber()
-			jd.Decode(&resp_ok)
-
-			if resp_my.Result.PreviousBlockHash != resp_ok.Result.PreviousBlockHash {
-				println("satoshi @", resp_ok.Result.PreviousBlockHash, resp_ok.Result.Height)
-				println("gocoin  @", resp_my.Result.PreviousBlockHash, resp_my.Result.Height)
-			} else {
-				println(".", len(resp_my.Result.Transactions), resp_my.Result.Coinbasevalue)
-				if resp_my.Result.Mintime != resp_ok.Result.Mintime {
-					println("\007Mintime:", resp_my.Result.Mintime, resp_ok.Result.Mintime)
-				}
-				if resp_my.Result.Bits != resp_ok.Result.Bits {
-					println("\007Bits:", resp_my.Result.Bits, resp_ok.Result.Bits)
-				}
-				if resp_my.Result.Target != resp_ok.Result.Target {
-					println("\007Target:", resp_my.Result.Target, resp_ok.Result.Target)
-				}
-			}
-		}
-
-		b, _ = json.Marshal(&resp_my)
-		//os.WriteFile("json/"+RpcCmd.Method+"_resp_my.json", b, 0777)
-		w.Write(append(b, 0x0a))
-		//println(" ... ", string(b))
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		handleBatch(w, user, b)
 		return
+	}
 
-	case "getwork":
-		var resp_my RpcGetWorkResp
-		//println("geting work...", DO_SEGWIT, WAIT_FOR_SECONDS, DO_NOT_SUBMIT)
-		switch uu := RpcCmd.Params.(type) {
-		case []interface{}:
-			if len(uu) >= 1 {
-				if currently_worked_block == nil {
-					println("work submited, but no work in progress")
-					return
-				}
-				d, err := hex.DecodeString(uu[0].(string))
-				if err != nil {
-					println(err.Error())
-					return
-				}
-				swap32(d)
-				currently_worked_block.Raw = d[:80]
-				SubmitWork(currently_worked_block)
-				currently_worked_block = nil
-				resp.Result = true
-				goto send_response
-			}
-		default:
-			println("***something else")
-		}
-		GetWork(&resp_my)
-		b, _ = json.Marshal(&resp_my)
-		w.Write(append(b, 0x0a))
-		//println(" ... ", string(b))
+	cmd, e := decodeCommand(b)
+	if e != nil {
+		writeError(w, http.StatusBadRequest, RPC_PARSE_ERROR, "Parse error")
 		return
-
-	case "getmininginfo":
-		//println("getmininginfo...")
-		var rm RpcGetMiningInfoResp
-		rm.Result = mining_info
-		b, _ = json.Marshal(&rm)
-		w.Write(append(b, 0x0a))
-		//println(" ... ", string(b))
+	}
+	if !methodAllowed(user, cmd.Method) {
+		println("RPC: user", user, "not allowed to call", cmd.Method)
+		writeError(w, http.StatusForbidden, RPC_INVALID_REQUEST, "Method not allowed")
 		return
-
-	case "validateaddress":
-		switch uu := RpcCmd.Params.(type) {
-		case []interface{}:
-			if len(uu) == 1 {
-				resp.Result = ValidateAddress(uu[0].(string))
-			}
-		default:
-			println("unexpected type", uu)
+	}
+	resp := execute(cmd)
+	if cmd.v2() {
+		if cmd.Id == nil {
+			w.WriteHeader(http.StatusNoContent) // notification - no response expected
+			return
 		}
-
-	case "submitblock":
-		println("_________________________SH__________________________________")
-		//os.WriteFile("submitblock.json", b, 0777)
-		SubmitBlock(&RpcCmd, &resp, b)
-
-	default:
-		fmt.Println("Method:", RpcCmd.Method, len(b))
-		//w.Write(bitcoind_result)
-		resp.Error = RpcError{Code: -32601, Message: "Method not found"}
+		writeReply(w, http.StatusOK, resp.reply(true))
+		return
 	}
+	writeReply(w, legacyStatus(&resp), &resp)
+}
 
-send_response:
-	b, e = json.Marshal(&resp)
-	if e != nil {
-		println("json.Marshal(&resp):", e.Error())
+// handleBatch executes all the commands from the array and replies with an array of the results.
+func handleBatch(w http.ResponseWriter, user string, b []byte) {
+	var reqs []json.RawMessage
+	if e := json.Unmarshal(b, &reqs); e != nil {
+		writeError(w, http.StatusBadRequest, RPC_PARSE_ERROR, "Parse error")
+		return
 	}
-
-	//ioutil.WriteFile(RpcCmd.Method+"_resp.json", b, 0777)
-	w.Write(append(b, 0x0a))
+	if len(reqs) == 0 {
+		writeError(w, http.StatusBadRequest, RPC_INVALID_REQUEST, "Invalid Request")
+		return
+	}
+	res := make([]interface{}, 0, len(reqs))
+	for _, req := range reqs {
+		cmd, e := decodeCommand(req)
+		if e != nil {
+			res = append(res, &RpcResponse{Error: &RpcError{Code: RPC_INVALID_REQUEST, Message: "Invalid Request object"}})
+			continue
+		}
+		var resp RpcResponse
+		if methodAllowed(user, cmd.Method) {
+			resp = execute(cmd)
+		} else {
+			// only this command fails - the others from the batch still get executed
+			println("RPC: user", user, "not allowed to call", cmd.Method)
+			resp = RpcResponse{Id: cmd.Id, Error: &RpcError{Code: RPC_INVALID_REQUEST, Message: "Method not allowed"}}
+		}
+		if cmd.v2() && cmd.Id == nil {
+			continue
+		}
+		res = append(res, resp.reply(cmd.v2()))
+	}
+	writeReply(w, http.StatusOK, res)
 }
 
 func StartServer(port uint32) {
 	fmt.Println("Starting RPC server at port", port)
+	WriteCookie()
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", my_handler)
 	http.ListenAndServe(fmt.Sprint(":", port), mux)
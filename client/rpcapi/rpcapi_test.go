@@ -0,0 +1,180 @@
+package rpcapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/piotrnar/gocoin/client/common"
+)
+
+func init() {
+	rpcMethods["testecho"] = &rpcMethod{func(p rpcParams) (interface{}, *RpcError) {
+		if len(p) == 0 {
+			return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: "No params"}
+		}
+		return p[0], nil
+	}, []string{"value"}}
+}
+
+func testConfig() {
+	common.CFG.RPC.Username = "gocoinrpc"
+	common.CFG.RPC.Password = "gocoinpwd"
+	// from Core's test/functional/rpc_users.py
+	common.CFG.RPC.Auth = []string{"rt:93648e835a54c573682c2eb19f882535$7681e9c5b74bdd85e78166031d2058e1069b3ed7ed967c93fc63abba06f31144"}
+	common.CFG.RPC.Whitelist = []string{"rt:testecho,nosuchmethod"}
+}
+
+func testRequest(user, pass, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	if user != "" {
+		r.SetBasicAuth(user, pass)
+	}
+	w := httptest.NewRecorder()
+	my_handler(w, r)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	testConfig()
+	const body = `{"method":"testecho","params":[1],"id":1}`
+	if w := testRequest("gocoinrpc", "gocoinpwd", body); w.Code != http.StatusOK {
+		t.Error("Username/Password failed", w.Code)
+	}
+	if w := testRequest("gocoinrpc", "badpwd", body); w.Code != http.StatusUnauthorized {
+		t.Error("Bad password accepted", w.Code)
+	}
+	if w := testRequest("", "", body); w.Code != http.StatusUnauthorized {
+		t.Error("No authentication accepted", w.Code)
+	}
+
+	if w := testRequest("rt", "cA773lm788buwYe4g4WT+05pKyNruVKjQ25x3n0DQcM=", body); w.Code != http.StatusOK {
+		t.Error("rpcauth failed", w.Code)
+	}
+	if w := testRequest("rt", "cA773lm788buwYe4g4WT+05pKyNruVKjQ25x3n0DQcM", body); w.Code != http.StatusUnauthorized {
+		t.Error("rpcauth bad password accepted", w.Code)
+	}
+	if w := testRequest("rt2", "cA773lm788buwYe4g4WT+05pKyNruVKjQ25x3n0DQcM=", body); w.Code != http.StatusUnauthorized {
+		t.Error("rpcauth wrong user accepted", w.Code)
+	}
+
+	common.CFG.RPC.Password = ""
+	if w := testRequest("gocoinrpc", "", body); w.Code != http.StatusUnauthorized {
+		t.Error("Empty password accepted", w.Code)
+	}
+	testConfig()
+}
+
+func TestCookie(t *testing.T) {
+	testConfig()
+	common.GocoinHomeDir = t.TempDir() + string(os.PathSeparator)
+	WriteCookie()
+	d, e := os.ReadFile(common.GocoinHomeDir + COOKIE_FILE_NAME)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	up := strings.SplitN(string(d), ":", 2)
+	if len(up) != 2 || up[0] != COOKIE_USER {
+		t.Fatal("Bad cookie file", string(d))
+	}
+	const body = `{"method":"testecho","params":[1],"id":1}`
+	if w := testRequest(up[0], up[1], body); w.Code != http.StatusOK {
+		t.Error("Cookie auth failed", w.Code)
+	}
+	if w := testRequest(up[0], up[1]+"0", body); w.Code != http.StatusUnauthorized {
+		t.Error("Bad cookie accepted", w.Code)
+	}
+	DeleteCookie()
+	if _, e = os.Stat(common.GocoinHomeDir + COOKIE_FILE_NAME); e == nil {
+		t.Error("Cookie file not deleted")
+	}
+	if w := testRequest(up[0], up[1], body); w.Code != http.StatusUnauthorized {
+		t.Error("Deleted cookie accepted", w.Code)
+	}
+}
+
+func TestWhitelist(t *testing.T) {
+	testConfig()
+	const pass = "cA773lm788buwYe4g4WT+05pKyNruVKjQ25x3n0DQcM="
+	if w := testRequest("rt", pass, `{"method":"getpeerinfo","id":1}`); w.Code != http.StatusForbidden {
+		t.Error("Not whitelisted method allowed", w.Code)
+	}
+	// users without a whitelist can call any method
+	if w := testRequest("gocoinrpc", "gocoinpwd", `{"method":"testecho","params":[1],"id":1}`); w.Code != http.StatusOK {
+		t.Error("Method not allowed", w.Code)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	testConfig()
+	const pass = "cA773lm788buwYe4g4WT+05pKyNruVKjQ25x3n0DQcM="
+	w := testRequest("rt", pass, `[
+		{"jsonrpc":"2.0","method":"testecho","params":["a"],"id":1},
+		{"jsonrpc":"2.0","method":"getpeerinfo","id":2},
+		{"jsonrpc":"2.0","method":"testecho","params":["notification"]},
+		{"method":"testecho","params":{"value":"b"},"id":"x"},
+		{"jsonrpc":"2.0","method":"nosuchmethod","id":3},
+		{"jsonrpc":"2.0","method":"getpeerinfo"},
+		123
+	]`)
+	if w.Code != http.StatusOK {
+		t.Fatal("Batch failed", w.Code)
+	}
+	var res []map[string]interface{}
+	if e := json.Unmarshal(w.Body.Bytes(), &res); e != nil {
+		t.Fatal(e.Error())
+	}
+	if len(res) != 5 {
+		t.Fatal("Bad number of responses", len(res), w.Body.String())
+	}
+	errCode := func(r map[string]interface{}) float64 {
+		if er, ok := r["error"].(map[string]interface{}); ok {
+			return er["code"].(float64)
+		}
+		return 0
+	}
+	if res[0]["id"] != 1.0 || res[0]["result"] != "a" || res[0]["jsonrpc"] != "2.0" {
+		t.Error("Bad response 0", res[0])
+	}
+	if _, ok := res[0]["error"]; ok {
+		t.Error("JSON-RPC 2.0 response with both result and error")
+	}
+	if res[1]["id"] != 2.0 || errCode(res[1]) != RPC_INVALID_REQUEST {
+		t.Error("Bad response 1", res[1])
+	}
+	if res[2]["id"] != "x" || res[2]["result"] != "b" || res[2]["error"] != nil {
+		t.Error("Bad response 2", res[2])
+	}
+	if res[3]["id"] != 3.0 || errCode(res[3]) != RPC_METHOD_NOT_FOUND {
+		t.Error("Bad response 3", res[3])
+	}
+	if errCode(res[4]) != RPC_INVALID_REQUEST {
+		t.Error("Bad response 4", res[4])
+	}
+
+	if w = testRequest("rt", pass, `[]`); w.Code != http.StatusBadRequest {
+		t.Error("Empty batch accepted", w.Code)
+	}
+	if w = testRequest("rt", pass, `[{"method":"testecho"`); w.Code != http.StatusBadRequest {
+		t.Error("Broken batch accepted", w.Code)
+	}
+}
+
+func TestNotification(t *testing.T) {
+	testConfig()
+	w := testRequest("gocoinrpc", "gocoinpwd", `{"jsonrpc":"2.0","method":"testecho","params":[1]}`)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Error("Notification replied", w.Code, w.Body.String())
+	}
+	w = testRequest("gocoinrpc", "gocoinpwd", `{"method":"testecho","params":[],"id":7}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Error("Bad legacy error status", w.Code)
+	}
+	w = testRequest("gocoinrpc", "gocoinpwd", `{"method":"nosuchmethod","id":7}`)
+	if w.Code != http.StatusNotFound {
+		t.Error("Bad legacy not found status", w.Code)
+	}
+}
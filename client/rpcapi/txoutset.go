@@ -0,0 +1,116 @@
+package rpcapi
+
+import (
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/usif"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+func rpcLoadTxOutSet(p rpcParams) (interface{}, *RpcError) {
+	path, er := p.str(0, "path")
+	if er != nil {
+		return nil, er
+	}
+
+	// the chain's tip must be changed in sync with the main thread
+	lck := new(usif.OneLock)
+	lck.In.Add(1)
+	lck.Out.Add(1)
+	usif.LocksChan <- lck
+	lck.In.Wait()
+	base, hash, coins, e := usif.LoadUtxoSnapshot(path)
+	lck.Out.Done()
+	if hash == nil {
+		return nil, &RpcError{Code: RPC_INTERNAL_ERROR, Message: "Unable to load UTXO snapshot: " + e.Error()}
+	}
+
+	type loadResp struct {
+		CoinsLoaded  uint64 `json:"coins_loaded"`
+		TipHash      string `json:"tip_hash"`
+		BaseHeight   uint32 `json:"base_height"`
+		Path         string `json:"path"`
+		TxOutSetHash string `json:"txoutset_hash"`
+		Warning      string `json:"warning,omitempty"`
+	}
+	res := &loadResp{CoinsLoaded: coins, TipHash: base.BlockHash.String(), BaseHeight: base.Height,
+		Path: path, TxOutSetHash: btc.NewUint256(hash).String()}
+	if e != nil {
+		res.Warning = "Background validation not started: " + e.Error()
+	}
+	return res, nil
+}
+
+func rpcDumpTxOutSet(p rpcParams) (interface{}, *RpcError) {
+	path, er := p.str(0, "path")
+	if er != nil {
+		return nil, er
+	}
+	if p.has(1) {
+		typ, er := p.str(1, "type")
+		if er != nil {
+			return nil, er
+		}
+		if typ != "" && typ != "latest" {
+			return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: "Only \"latest\" type is supported"}
+		}
+	}
+
+	path, base, hdr, hash, e := usif.DumpUtxoSnapshot(path)
+	if e != nil {
+		return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: e.Error()}
+	}
+
+	type dumpResp struct {
+		CoinsWritten uint64 `json:"coins_written"`
+		BaseHash     string `json:"base_hash"`
+		BaseHeight   uint32 `json:"base_height"`
+		Path         string `json:"path"`
+		TxOutSetHash string `json:"txoutset_hash"`
+	}
+	res := &dumpResp{CoinsWritten: hdr.CoinsCount, BaseHash: hdr.BaseHash.String(), Path: path,
+		TxOutSetHash: btc.NewUint256(hash).String()}
+	if base != nil {
+		res.BaseHeight = base.Height
+	}
+	return res, nil
+}
+
+// rpcGetTxOutSetInfo returns "muhash" by default, as "hash_serialized_3" takes a while to calculate.
+func rpcGetTxOutSetInfo(p rpcParams) (interface{}, *RpcError) {
+	hash_type := "muhash"
+	if p.has(0) {
+		var er *RpcError
+		if hash_type, er = p.str(0, "hash_type"); er != nil {
+			return nil, er
+		}
+	}
+	if hash_type != "muhash" && hash_type != "hash_serialized_3" && hash_type != "none" {
+		return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: hash_type + " is not a valid hash_type"}
+	}
+	if p.has(1) {
+		return nil, &RpcError{Code: RPC_INVALID_PARAMETER, Message: "Querying specific block heights is not supported"}
+	}
+
+	info := common.BlockChain.Unspent.GetTxOutSetInfo(hash_type == "hash_serialized_3")
+
+	type txOutSetInfoResp struct {
+		Height          uint32    `json:"height"`
+		BestBlock       string    `json:"bestblock"`
+		TxOuts          uint64    `json:"txouts"`
+		BogoSize        uint64    `json:"bogosize"`
+		HashSerialized3 string    `json:"hash_serialized_3,omitempty"`
+		MuHash          string    `json:"muhash,omitempty"`
+		TotalAmount     btcAmount `json:"total_amount"`
+		Transactions    uint64    `json:"transactions"`
+	}
+	res := &txOutSetInfoResp{Height: info.Height, BestBlock: btc.NewUint256(info.BlockHash).String(),
+		TxOuts: info.TxOuts, BogoSize: info.BogoSize, TotalAmount: btcAmount(info.TotalAmount),
+		Transactions: info.Txs}
+	switch hash_type {
+	case "muhash":
+		res.MuHash = btc.NewUint256(info.MuHash).String()
+	case "hash_serialized_3":
+		res.HashSerialized3 = btc.NewUint256(info.HashSerialized).String()
+	}
+	return res, nil
+}
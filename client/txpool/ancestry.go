@@ -0,0 +1,138 @@
+package txpool
+
+import (
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+)
+
+const (
+	EXTRA_DESCENDANT_TX_SIZE_LIMIT = 10000 // CPFP carve-out: max vsize of the extra child
+)
+
+var (
+	// If set, the ancestor/descendant aggregates of all the mempool txs
+	// need to be rebuilt (call UpdateAncestry() before using them).
+	AncestryDirty bool
+
+	AncestryRebuildTime  time.Duration
+	AncestryRebuildCount uint
+)
+
+// Ancestry keeps the aggregated numbers of all the unconfirmed ancestors (or descendants)
+// of a mempool tx - the tx itself is always included.
+type Ancestry struct {
+	Count uint32
+	VSize uint32
+	Fee   uint64
+}
+
+func (a *Ancestry) add(t2s *OneTxToSend) {
+	a.Count++
+	a.VSize += uint32(t2s.VSize())
+	a.Fee += t2s.Fee
+}
+
+func (a *Ancestry) sub(t2s *OneTxToSend) {
+	a.Count--
+	a.VSize -= uint32(t2s.VSize())
+	a.Fee -= t2s.Fee
+}
+
+// SPB returns the fee rate of the whole group of txs.
+func (a *Ancestry) SPB() float64 {
+	return float64(a.Fee) / float64(a.VSize)
+}
+
+// addAncestry is called when the tx is being added to the mempool.
+// At this moment it cannot have any descendants in the mempool.
+func (t2s *OneTxToSend) addAncestry() {
+	t2s.Ancestors = Ancestry{}
+	t2s.Descendants = Ancestry{}
+	t2s.Ancestors.add(t2s)
+	t2s.Descendants.add(t2s)
+	if t2s.MemInputCnt == 0 {
+		return
+	}
+	for _, par := range t2s.GetAllParents() {
+		t2s.Ancestors.add(par)
+		par.Descendants.add(t2s)
+	}
+}
+
+// delAncestry is called when the tx is being removed from the mempool, before its
+// SpentOutputs records are removed (so its descendants can still be found).
+func (t2s *OneTxToSend) delAncestry() {
+	if t2s.MemInputCnt > 0 {
+		for _, par := range t2s.GetAllParents() {
+			par.Descendants.sub(t2s)
+		}
+	}
+	for _, ch := range t2s.GetAllChildren() {
+		ch.Ancestors.sub(t2s)
+	}
+}
+
+// UpdateAncestry rebuilds the ancestor/descendant aggregates of all the mempool txs, if needed.
+// Make sure to call it with TxMutex locked.
+func UpdateAncestry() {
+	if !AncestryDirty {
+		return
+	}
+	sta := time.Now()
+	for _, t2s := range TransactionsToSend {
+		t2s.Ancestors = Ancestry{}
+		t2s.Descendants = Ancestry{}
+		t2s.Ancestors.add(t2s)
+		t2s.Descendants.add(t2s)
+	}
+	for _, t2s := range TransactionsToSend {
+		if t2s.MemInputCnt > 0 {
+			for _, par := range t2s.GetAllParents() {
+				t2s.Ancestors.add(par)
+				par.Descendants.add(t2s)
+			}
+		}
+	}
+	AncestryDirty = false
+	AncestryRebuildTime += time.Since(sta)
+	AncestryRebuildCount++
+}
+
+// checkChainLimits verifies that the new tx (with the given in-mempool parents)
+// would not exceed the configured ancestor/descendant limits.
+// It returns zero if the tx can be accepted, or the reject reason.
+func checkChainLimits(t2s *OneTxToSend) byte {
+	if t2s.MemInputCnt == 0 {
+		return 0
+	}
+	UpdateAncestry()
+
+	vsize := uint32(t2s.VSize())
+	parents := t2s.GetAllParents()
+	anc := Ancestry{Count: 1, VSize: vsize, Fee: t2s.Fee}
+	for _, par := range parents {
+		anc.add(par)
+	}
+	if anc.Count > common.Get(&common.CFG.TXPool.AncestorCount) ||
+		anc.VSize > 1000*common.Get(&common.CFG.TXPool.AncestorSizeKB) {
+		return TX_REJECTED_ANCESTORS
+	}
+
+	max_cnt := common.Get(&common.CFG.TXPool.DescendantCount)
+	max_size := 1000 * common.Get(&common.CFG.TXPool.DescendantSizeKB)
+	// CPFP carve-out: a small (non-TRUC) tx with only one unconfirmed ancestor can exceed the descendant limits by one
+	if len(parents) == 1 && vsize <= EXTRA_DESCENDANT_TX_SIZE_LIMIT && t2s.Version != TRUC_VERSION {
+		if par := parents[0]; par.Descendants.Count+1 > max_cnt || par.Descendants.VSize+vsize > max_size {
+			max_cnt++
+			max_size += EXTRA_DESCENDANT_TX_SIZE_LIMIT
+			common.CountSafe("TxCPFPCarveOut")
+		}
+	}
+	for _, par := range parents {
+		if par.Descendants.Count+1 > max_cnt || par.Descendants.VSize+vsize > max_size {
+			return TX_REJECTED_DESCENDANTS
+		}
+	}
+	return 0
+}
@@ -0,0 +1,181 @@
+package txpool
+
+import (
+	"testing"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// testTxVSize returns a new tx like testTx, but with one OP_RETURN output grown to the given vsize.
+func testTxVSize(t *testing.T, fee uint64, parents []*OneTxToSend, vouts []uint32, vsize int) *OneTxToSend {
+	start := vsize - testTx(2, fee, parents, vouts, testOut(0, 1)).VSize() - 4 // the var_len of the script may grow by 4
+	if start < 1 {
+		start = 1
+	}
+	for size := start; size < vsize; size++ {
+		if t2s := testTx(2, fee, parents, vouts, testOut(0, size)); t2s.VSize() == vsize {
+			return t2s
+		} else if t2s.VSize() > vsize {
+			break
+		}
+	}
+	t.Fatal("Cannot make tx of vsize", vsize)
+	return nil
+}
+
+// testOuts returns cnt P2WPKH outputs.
+func testOuts(cnt int) (res []*btc.TxOut) {
+	for i := 0; i < cnt; i++ {
+		res = append(res, testOut(10000, 22))
+	}
+	return
+}
+
+func TestAncestorLimits(t *testing.T) {
+	testInitMempool()
+	common.CFG.TXPool.AncestorCount = 5
+	common.CFG.TXPool.AncestorSizeKB = 2
+
+	// a chain of 4 txs, so the 5th one is at the limit
+	last := testTx(2, 1000, nil, nil)
+	testAdd(t, last)
+	for i := 0; i < 3; i++ {
+		last = testTx(2, 1000, []*OneTxToSend{last}, []uint32{0})
+		testAdd(t, last)
+	}
+	fifth := testTx(2, 1000, []*OneTxToSend{last}, []uint32{0})
+	if r := checkChainLimits(fifth); r != 0 {
+		t.Fatal("5 ancestors:", ReasonToString(r))
+	}
+	testAdd(t, fifth)
+	if r := checkChainLimits(testTx(2, 1000, []*OneTxToSend{fifth}, []uint32{0})); r != TX_REJECTED_ANCESTORS {
+		t.Error("6 ancestors:", ReasonToString(r))
+	}
+
+	// size: exactly 2000 vbytes of ancestors (with the tx itself)
+	testInitMempool()
+	common.CFG.TXPool.AncestorSizeKB = 2
+	par := testTx(2, 1000, nil, nil)
+	testAdd(t, par)
+	size := 2000 - par.VSize()
+	if r := checkChainLimits(testTxVSize(t, 1000, []*OneTxToSend{par}, []uint32{0}, size)); r != 0 {
+		t.Error("Ancestors at size limit:", ReasonToString(r))
+	}
+	if r := checkChainLimits(testTxVSize(t, 1000, []*OneTxToSend{par}, []uint32{0}, size+1)); r != TX_REJECTED_ANCESTORS {
+		t.Error("Ancestors over size limit:", ReasonToString(r))
+	}
+}
+
+func TestDescendantLimits(t *testing.T) {
+	testInitMempool()
+	common.CFG.TXPool.DescendantCount = 4
+	common.CFG.TXPool.DescendantSizeKB = 50
+
+	// the children are over EXTRA_DESCENDANT_TX_SIZE_LIMIT, so the carve-out does not apply to them
+	const big = EXTRA_DESCENDANT_TX_SIZE_LIMIT + 1
+	par := testTx(2, 1000, nil, nil, testOuts(10)...)
+	testAdd(t, par)
+	for i := 0; i < 3; i++ {
+		child := testTxVSize(t, 1000, []*OneTxToSend{par}, []uint32{uint32(i)}, big)
+		if r := checkChainLimits(child); r != 0 {
+			t.Fatal("Descendant", i+2, ReasonToString(r))
+		}
+		testAdd(t, child)
+	}
+	if par.Descendants.Count != 4 {
+		t.Fatal("Bad descendants count", par.Descendants.Count)
+	}
+	if r := checkChainLimits(testTxVSize(t, 1000, []*OneTxToSend{par}, []uint32{3}, big)); r != TX_REJECTED_DESCENDANTS {
+		t.Error("5 descendants:", ReasonToString(r))
+	}
+
+	// size: exactly 50000 vbytes of descendants
+	testInitMempool()
+	common.CFG.TXPool.DescendantSizeKB = 50
+	par = testTx(2, 1000, nil, nil, testOuts(10)...)
+	testAdd(t, par)
+	child := testTxVSize(t, 1000, []*OneTxToSend{par}, []uint32{0}, 25000)
+	testAdd(t, child)
+	size := 50000 - int(par.Descendants.VSize)
+	if r := checkChainLimits(testTxVSize(t, 1000, []*OneTxToSend{par}, []uint32{1}, size)); r != 0 {
+		t.Error("Descendants at size limit:", ReasonToString(r))
+	}
+	if r := checkChainLimits(testTxVSize(t, 1000, []*OneTxToSend{par}, []uint32{1}, size+1)); r != TX_REJECTED_DESCENDANTS {
+		t.Error("Descendants over size limit:", ReasonToString(r))
+	}
+}
+
+func TestCPFPCarveOut(t *testing.T) {
+	testInitMempool()
+	common.CFG.TXPool.DescendantCount = 4
+	par := testTx(2, 1000, nil, nil, testOuts(10)...)
+	testAdd(t, par)
+	for i := 0; i < 3; i++ {
+		testAdd(t, testTx(2, 1000, []*OneTxToSend{par}, []uint32{uint32(i)}))
+	}
+
+	// one extra small child is allowed
+	extra := testTx(2, 1000, []*OneTxToSend{par}, []uint32{3})
+	if r := checkChainLimits(extra); r != 0 {
+		t.Fatal("Carve-out child:", ReasonToString(r))
+	}
+	testAdd(t, extra)
+	if r := checkChainLimits(testTx(2, 1000, []*OneTxToSend{par}, []uint32{4})); r != TX_REJECTED_DESCENDANTS {
+		t.Error("Second carve-out child:", ReasonToString(r))
+	}
+
+	// not for a child with two unconfirmed parents
+	testInitMempool()
+	common.CFG.TXPool.DescendantCount = 4
+	par = testTx(2, 1000, nil, nil, testOuts(10)...)
+	other := testTx(2, 1000, nil, nil)
+	testAdd(t, par)
+	testAdd(t, other)
+	for i := 0; i < 3; i++ {
+		testAdd(t, testTx(2, 1000, []*OneTxToSend{par}, []uint32{uint32(i)}))
+	}
+	if r := checkChainLimits(testTx(2, 1000, []*OneTxToSend{par, other}, []uint32{3, 0})); r != TX_REJECTED_DESCENDANTS {
+		t.Error("Carve-out child with two parents:", ReasonToString(r))
+	}
+}
+
+func TestAncestryAfterDelete(t *testing.T) {
+	testInitMempool()
+	a := testTx(2, 1000, nil, nil, testOuts(2)...)
+	testAdd(t, a)
+	b := testTx(2, 2000, []*OneTxToSend{a}, []uint32{0})
+	testAdd(t, b)
+	c := testTx(2, 3000, []*OneTxToSend{b}, []uint32{0})
+	testAdd(t, c)
+	d := testTx(2, 4000, []*OneTxToSend{a}, []uint32{1})
+	testAdd(t, d)
+	if a.Descendants.Count != 4 || a.Descendants.Fee != 10000 || c.Ancestors.Count != 3 || c.Ancestors.Fee != 6000 {
+		t.Fatal("Bad ancestry", a.Descendants, c.Ancestors)
+	}
+
+	b.Delete(true, 0) // with c
+	if MempoolCheck() {
+		t.Fatal("Mempool broken after Delete")
+	}
+	exp := Ancestry{Count: 2, VSize: uint32(a.VSize() + d.VSize()), Fee: 5000}
+	if a.Descendants != exp || d.Ancestors != exp {
+		t.Error("Bad ancestry after Delete", a.Descendants, d.Ancestors)
+	}
+
+	d.Delete(false, 0)
+	if MempoolCheck() {
+		t.Fatal("Mempool broken after Delete")
+	}
+	exp = Ancestry{Count: 1, VSize: uint32(a.VSize()), Fee: 1000}
+	if a.Descendants != exp || a.Ancestors != exp {
+		t.Error("Bad ancestry after Delete", a.Descendants, a.Ancestors)
+	}
+
+	// the same after rebuilding it from scratch
+	AncestryDirty = true
+	UpdateAncestry()
+	if a.Descendants != exp || a.Ancestors != exp {
+		t.Error("Bad rebuilt ancestry", a.Descendants, a.Ancestors)
+	}
+}
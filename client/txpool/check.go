@@ -3,7 +3,6 @@ package txpool
 import (
 	"encoding/hex"
 	"fmt"
-	"slices"
 
 	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/lib/btc"
@@ -71,10 +70,13 @@ func checkFootprints() (dupa int) {
 }
 
 func checkMempoolTxs() (dupa int) {
-	var spent_cnt int
+	var spent_cnt, wtx_cnt int
 
 	for bidx, t2s := range TransactionsToSend {
 		var micnt uint32
+		if t2s.SegWit != nil {
+			wtx_cnt++
+		}
 		if t2s.NoWitSize == 0 || t2s.Size == 0 {
 			dupa++
 			fmt.Println(dupa, "Tx", t2s.Hash.String(), "has broken size:", t2s.NoWitSize, t2s.Size)
@@ -138,6 +140,17 @@ func checkMempoolTxs() (dupa int) {
 		}
 	}
 
+	for widx, bidx := range WTxIDToSend {
+		if t2s, ok := TransactionsToSend[bidx]; !ok || t2s.SegWit == nil || t2s.WTxID().BIdx() != widx {
+			dupa++
+			fmt.Println(dupa, "WTxIDToSend", btc.BIdxString(widx), "does not point to its segwit tx in mempool")
+		}
+	}
+	if wtx_cnt != len(WTxIDToSend) {
+		dupa++
+		fmt.Println(dupa, "WTxIDToSend length mismatch", wtx_cnt, len(WTxIDToSend))
+	}
+
 	for _, so := range SpentOutputs {
 		if _, ok := TransactionsToSend[so]; !ok {
 			dupa++
@@ -154,12 +167,21 @@ func checkMempoolTxs() (dupa int) {
 func checkRejectedTxs() (dupa int) {
 	var w4i_cnt int
 	var spent_cnt int
+	var wtx_cnt int
 	for _, tr := range TransactionsRejected {
 		if TRIdxArray[tr.ArrIndex] != tr.Id.BIdx() {
 			dupa++
 			fmt.Println(dupa, "TxR", tr.Id.String(), "points to a bad TRIdxArray", tr.ArrIndex)
 		}
 
+		if tr.WIdx != tr.Id.BIdx() {
+			wtx_cnt++
+			if WTxIDRejected[tr.WIdx] != tr.Id.BIdx() {
+				dupa++
+				fmt.Println(dupa, "TxR", tr.Id.String(), "not in WTxIDRejected")
+			}
+		}
+
 		if tr.Tx != nil {
 			if tr.Tx.Raw == nil {
 				dupa++
@@ -223,6 +245,10 @@ func checkRejectedTxs() (dupa int) {
 			}
 		}
 	}
+	if wtx_cnt != len(WTxIDRejected) {
+		dupa++
+		fmt.Println(dupa, "WTxIDRejected length mismatch", wtx_cnt, len(WTxIDRejected))
+	}
 	if w4i_cnt != spent_cnt {
 		dupa++
 		fmt.Println(dupa, "WaitingForInputs count mismatch", w4i_cnt, spent_cnt)
@@ -338,59 +364,99 @@ func checkRejectedSpentOutputs() (dupa int) {
 	return
 }
 
-func checkFeeList() bool {
-	if FeePackagesDirty {
-		return false
+func checkAncestry() (dupa int) {
+	if AncestryDirty {
+		return
 	}
-
-	valid_pkgs := make(map[*OneTxsPackage]bool, len(FeePackages))
-
-	for _, pkg := range FeePackages {
-		if valid_pkgs[pkg] {
-			println("ERROR: pkg", pkg.String(), "is twice on the list")
-			return true
+	for _, t2s := range TransactionsToSend {
+		var anc, des Ancestry
+		anc.add(t2s)
+		des.add(t2s)
+		for _, par := range t2s.GetAllParents() {
+			anc.add(par)
 		}
-		valid_pkgs[pkg] = true
-		if len(pkg.Txs) < 2 {
-			println("ERROR: package has only", len(pkg.Txs), "txs")
-			return true
+		for _, ch := range t2s.GetAllChildren() {
+			des.add(ch)
+		}
+		if anc != t2s.Ancestors {
+			dupa++
+			fmt.Println(dupa, "Tx", t2s.Hash.String(), "has wrong ancestors", t2s.Ancestors, anc)
+		}
+		if des != t2s.Descendants {
+			dupa++
+			fmt.Println(dupa, "Tx", t2s.Hash.String(), "has wrong descendants", t2s.Descendants, des)
+		}
+	}
+	return
+}
+
+func checkClusters() (dupa int) {
+	if ClustersDirty {
+		return
+	}
+	var cnt int
+	for c := range Clusters {
+		cnt += len(c.Txs)
+		if len(c.Txs) == 0 {
+			dupa++
+			fmt.Println(dupa, "Empty cluster")
+			continue
 		}
-		for idx, t := range pkg.Txs {
+		in_cluster := make(map[*OneTxToSend]bool, len(c.Txs))
+		for _, t := range c.Txs {
 			if !t.isInMap() {
-				println("ERROR: tx in pkg", pkg.String(), "does not point to a valid t2s", idx)
-				println("    ...", t.Hash.String())
-				return true
+				dupa++
+				fmt.Println(dupa, "Tx", t.Hash.String(), "in cluster but not in mempool")
+			}
+			if t.cluster != c {
+				dupa++
+				fmt.Println(dupa, "Tx", t.Hash.String(), "does not point back to its cluster")
 			}
-			if !slices.Contains(t.inPackages, pkg) {
-				println("ERROR: tx", idx, t.Id(), "in pkg", pkg.String(), "does not point back to the package")
-				return true
+			for _, par := range t.directParents() {
+				if !in_cluster[par] {
+					dupa++
+					fmt.Println(dupa, "Tx", t.Hash.String(), "has parent", par.Hash.String(), "not before it in the cluster")
+				}
 			}
+			in_cluster[t] = true
+		}
+		if len(splitClusters(c.Txs)) != 1 {
+			dupa++
+			fmt.Println(dupa, "Cluster of", c.Txs[0].Hash.String(), "is not connected")
 		}
-	}
 
-	found_pkgs := make(map[*OneTxsPackage]bool, len(valid_pkgs))
-	for _, t2s := range TransactionsToSend {
-		if t2s.inPackages != nil {
-			for _, pkg := range t2s.inPackages {
-				if !valid_pkgs[pkg] {
-					println("ERROR: pkg", pkg.String(), "from t2s", t2s.Id(), "is not on the pkg list")
-					return true
-				}
-				if !slices.Contains(pkg.Txs, t2s) {
-					println("ERROR: pkg", pkg.String(), "does not have the tx", t2s.Id)
-					return true
+		var idx int
+		for i, ch := range c.Chunks {
+			var fee, weight uint64
+			for _, t := range ch.Txs {
+				if idx >= len(c.Txs) || c.Txs[idx] != t {
+					dupa++
+					fmt.Println(dupa, "Chunk", i, "of cluster", c.Txs[0].Hash.String(), "does not follow its txs")
+					break
 				}
-				found_pkgs[pkg] = true
+				fee += t.Fee
+				weight += uint64(t.Weight())
+				idx++
 			}
+			if fee != ch.Fee || weight != ch.Weight {
+				dupa++
+				fmt.Println(dupa, "Chunk", i, "of cluster", c.Txs[0].Hash.String(), "has wrong fee/weight", ch.Fee, ch.Weight, fee, weight)
+			}
+			if i > 0 && ch.isBetter(c.Chunks[i-1]) {
+				dupa++
+				fmt.Println(dupa, "Chunk", i, "of cluster", c.Txs[0].Hash.String(), "has better feerate than the previous one")
+			}
+		}
+		if idx != len(c.Txs) {
+			dupa++
+			fmt.Println(dupa, "Chunks of cluster", c.Txs[0].Hash.String(), "cover", idx, "of", len(c.Txs), "txs")
 		}
 	}
-
-	if len(found_pkgs) != len(valid_pkgs) {
-		println("ERROR: did not find a reference to every pkg in the mempool", len(found_pkgs), (valid_pkgs))
-		return true
+	if cnt != len(TransactionsToSend) {
+		dupa++
+		fmt.Println(dupa, "Clusters have", cnt, "txs, while mempool has", len(TransactionsToSend))
 	}
-
-	return false
+	return
 }
 
 func VerifyMempoolSort(txs []*OneTxToSend) bool {
@@ -435,98 +501,14 @@ func MempoolCheck() bool {
 	dupa += checkRejectedTxs()
 	dupa += checkTRSortIndex()
 	dupa += checkRejectedSpentOutputs()
-	if checkFeeList() {
+	dupa += checkAncestry()
+	dupa += checkClusters()
+	if !ClustersDirty && VerifyMempoolSort(GetSortedMempool()) {
 		dupa++
-		fmt.Println(dupa, "checkFeeList failed")
-	}
-	if false && !SortListDirty {
-		if VerifyMempoolSort(GetSortedMempool()) {
-			dupa++
-			fmt.Println(dupa, "GetSortedMempool() sorting broken")
-		}
-		if !FeePackagesDirty {
-			if VerifyMempoolSort(GetSortedMempoolRBF()) {
-				dupa++
-				fmt.Println(dupa, "GetSortedMempoolRBF() sorting broken")
-			}
-		}
+		fmt.Println(dupa, "GetSortedMempool() sorting broken")
 	}
 	if dupa == 0 {
 		common.CountSafe("Tx MPCheckOK")
 	}
 	return dupa != 0
 }
-
-/*
-var donot bool
-var rdbg *bytes.Buffer
-var rd1, rd2 *bytes.Buffer
-
-func dumpPkgList(fn string) {
-	f, _ := os.Create(fn)
-	dumpPkgListHere(f)
-	f.Close()
-	println("pkg list stored in", fn)
-}
-
-func dumpPkgListHere(f io.Writer) {
-	for _, pkg := range FeePackages {
-		fmt.Fprintln(f, "package", pkg.String(), "with", len(pkg.Txs), "txs:")
-		for _, t := range pkg.Txs {
-			fmt.Fprintln(f, "   *", t.Hash.String(), "  mic:", t.MemInputCnt, "  inpkgs:", len(t.inPackages))
-			for idx, pkg := range t.inPackages {
-				fmt.Fprintln(f, "   ", idx, pkg.String())
-			}
-		}
-		fmt.Fprintln(f)
-	}
-}
-
-func cfl(label string) {
-	if donot {
-		rdbg = nil
-		return
-	}
-	//common.CountSafe("TxPkgs_CLF")
-	if checkFeeList() {
-		println("*** fee packages list first noticed broken in", label, "\a")
-		dumpPkgList("packages_broken.txt")
-		debug.PrintStack()
-		donot = true
-
-		if rdbg != nil {
-			println(rdbg.String())
-		}
-		if rd1 != nil {
-			os.WriteFile("packages_before1.txt", rd1.Bytes(), 0600)
-			println("packages_before1.txt created")
-		}
-		if rd2 != nil {
-			os.WriteFile("packages_before2.txt", rd2.Bytes(), 0600)
-			println("packages_before2.txt created")
-		}
-		os.Exit(1)
-	}
-}
-
-// make sure to call it with the mutex locked
-func checkSortedOK(from string) {
-	cfl(from)
-	if VerifyMempoolSort(GetSortedMempoolRBF()) {
-		println("Sorting fucked in", from)
-		println("before it:\n", rdbg.String())
-		dumpPkgList("pkglist_broken.txt")
-		rdbg = new(bytes.Buffer)
-		println("Now retry sort again, this time with FeePackagesDirty")
-		FeePackagesDirty = true
-		if VerifyMempoolSort(GetSortedMempoolRBF()) {
-			println("*** again fucked ***")
-		} else {
-			println(rdbg.String())
-			println("Fixed")
-			dumpPkgList("pkglist_fixed.txt")
-		}
-		os.Exit(1)
-	}
-}
-*/
@@ -0,0 +1,556 @@
+package txpool
+
+import (
+	"math/bits"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// Cluster mempool - each group of connected txs (spending one another's outputs) makes one cluster.
+// Txs of a cluster are kept in the order in which they should be mined (linearization),
+// split into chunks of descending feerates. The chunks are used for building new blocks,
+// for removing the worst txs from the mempool and for comparing RBF feerate diagrams.
+
+const (
+	CLUSTER_EXACT_MAX_TXS     = 10   // clusters up to this size get the optimal linearization
+	CLUSTER_ANCESTORS_MAX_TXS = 1000 // bigger clusters (not from the network) are only sorted by the txs' feerates
+)
+
+var (
+	Clusters            map[*Cluster]bool = make(map[*Cluster]bool)
+	ClustersDirty       bool              // means the Clusters are useless and need rebuilding (call updateClusters())
+	ClusteringSuspended bool              // set during block commit - any change makes the clusters dirty
+
+	chunksByFeerate []*Chunk // all the chunks, sorted (nil if needs rebuilding)
+
+	ClustersRebuildTime  time.Duration
+	ClustersRebuildCount uint
+	ClustersUpdateTime   time.Duration
+	ClustersUpdateCount  uint
+)
+
+// Chunk is a part of the cluster's linearization, with all its txs to be mined together.
+type Chunk struct {
+	Txs    []*OneTxToSend
+	Weight uint64
+	Fee    uint64
+}
+
+type Cluster struct {
+	Txs    []*OneTxToSend // linearized - parents always before their children
+	Chunks []*Chunk       // feerates going down
+}
+
+func (ch *Chunk) SPB() float64 {
+	return 4.0 * float64(ch.Fee) / float64(ch.Weight)
+}
+
+func (ch *Chunk) isBetter(other *Chunk) bool {
+	return ch.Fee*other.Weight > other.Fee*ch.Weight
+}
+
+// setParents returns indexes of the direct parents of each tx, within the given set.
+func setParents(txs []*OneTxToSend) (parents [][]int) {
+	idx := make(map[btc.BIDX]int, len(txs))
+	for i, t := range txs {
+		idx[t.Hash.BIdx()] = i
+	}
+	parents = make([][]int, len(txs))
+	for i, t := range txs {
+		for _, in := range t.TxIn {
+			if p, ok := idx[btc.BIdx(in.Input.Hash[:])]; ok && p != i && !slices.Contains(parents[i], p) {
+				parents[i] = append(parents[i], p)
+			}
+		}
+	}
+	return
+}
+
+// topoSort returns the txs sorted with parents always before their children.
+func topoSort(txs []*OneTxToSend) (result []*OneTxToSend) {
+	parents := setParents(txs)
+	done := make([]bool, len(txs))
+	result = make([]*OneTxToSend, 0, len(txs))
+	var add func(int)
+	add = func(i int) {
+		done[i] = true
+		for _, p := range parents[i] {
+			if !done[p] {
+				add(p)
+			}
+		}
+		result = append(result, txs[i])
+	}
+	for i := range txs {
+		if !done[i] {
+			add(i)
+		}
+	}
+	return
+}
+
+// linearize returns the txs in the order in which they should be mined.
+// The result is optimal for small clusters. For bigger ones the best
+// ancestor set is picked each time (as Core did before the cluster mempool).
+func linearize(txs []*OneTxToSend) []*OneTxToSend {
+	if len(txs) < 2 {
+		return slices.Clone(txs)
+	}
+	if len(txs) > CLUSTER_ANCESTORS_MAX_TXS {
+		txs = slices.Clone(txs)
+		sort.Slice(txs, func(i, j int) bool {
+			return txs[i].Fee*uint64(txs[j].Weight()) > txs[j].Fee*uint64(txs[i].Weight())
+		})
+		return topoSort(txs)
+	}
+	txs = topoSort(txs)
+	parents := setParents(txs)
+	words := (len(txs) + 63) / 64
+	anc := make([][]uint64, len(txs)) // ancestors of each tx (including itself) - as bitsets
+	for i := range txs {
+		anc[i] = make([]uint64, words)
+		anc[i][i/64] |= 1 << (i % 64)
+		for _, p := range parents[i] {
+			for w := range anc[i] {
+				anc[i][w] |= anc[p][w]
+			}
+		}
+	}
+	if len(txs) <= CLUSTER_EXACT_MAX_TXS {
+		return linearizeExact(txs, anc)
+	}
+	return linearizeAncestors(txs, anc)
+}
+
+// linearizeExact picks the best feerate subset of the remaining txs, each time.
+// The subset must include all its ancestors. Expects the txs to be sorted (parents first).
+func linearizeExact(txs []*OneTxToSend, anc [][]uint64) (result []*OneTxToSend) {
+	ancm := make([]uint32, len(txs))
+	for i := range txs {
+		ancm[i] = uint32(anc[i][0])
+	}
+	result = make([]*OneTxToSend, 0, len(txs))
+	rem := uint32(1)<<len(txs) - 1
+	for rem != 0 {
+		var best uint32
+		var best_fee, best_weight uint64
+		for set := rem; set != 0; set = (set - 1) & rem {
+			var fee, weight uint64
+			closed := true
+			for b := set; b != 0; b &= b - 1 {
+				i := bits.TrailingZeros32(b)
+				if ancm[i]&rem&^set != 0 {
+					closed = false
+					break
+				}
+				fee += txs[i].Fee
+				weight += uint64(txs[i].Weight())
+			}
+			if !closed {
+				continue
+			}
+			if best == 0 || fee*best_weight > best_fee*weight || fee*best_weight == best_fee*weight && weight < best_weight {
+				best, best_fee, best_weight = set, fee, weight
+			}
+		}
+		for b := best; b != 0; b &= b - 1 {
+			result = append(result, txs[bits.TrailingZeros32(b)])
+		}
+		rem &^= best
+	}
+	return
+}
+
+// linearizeAncestors picks the remaining tx with the best ancestor set feerate, each time.
+// Expects the txs to be sorted (parents first).
+func linearizeAncestors(txs []*OneTxToSend, anc [][]uint64) (result []*OneTxToSend) {
+	fees := make([]uint64, len(txs)) // of the remaining ancestors
+	weights := make([]uint64, len(txs))
+	for i := range txs {
+		for w, word := range anc[i] {
+			for ; word != 0; word &= word - 1 {
+				a := 64*w + bits.TrailingZeros64(word)
+				fees[i] += txs[a].Fee
+				weights[i] += uint64(txs[a].Weight())
+			}
+		}
+	}
+	result = make([]*OneTxToSend, 0, len(txs))
+	done := make([]uint64, len(anc[0]))
+	set := make([]uint64, len(anc[0]))
+	for len(result) < len(txs) {
+		best := -1
+		for i := range txs {
+			if done[i/64]&(1<<(i%64)) != 0 {
+				continue
+			}
+			if best < 0 || fees[i]*weights[best] > fees[best]*weights[i] ||
+				fees[i]*weights[best] == fees[best]*weights[i] && weights[i] < weights[best] {
+				best = i
+			}
+		}
+		for w := range set {
+			set[w] = anc[best][w] &^ done[w]
+			done[w] |= set[w]
+			for word := set[w]; word != 0; word &= word - 1 {
+				result = append(result, txs[64*w+bits.TrailingZeros64(word)])
+			}
+		}
+		// the picked txs are no longer the ancestors of the remaining ones
+		for i := range txs {
+			if done[i/64]&(1<<(i%64)) != 0 {
+				continue
+			}
+			for w := range set {
+				for word := anc[i][w] & set[w]; word != 0; word &= word - 1 {
+					a := 64*w + bits.TrailingZeros64(word)
+					fees[i] -= txs[a].Fee
+					weights[i] -= uint64(txs[a].Weight())
+				}
+			}
+		}
+	}
+	return
+}
+
+// chunkify splits the linearized txs into chunks with descending feerates.
+func chunkify(lin []*OneTxToSend) (chunks []*Chunk) {
+	for i, t := range lin {
+		ch := &Chunk{Txs: lin[i : i+1], Weight: uint64(t.Weight()), Fee: t.Fee}
+		for len(chunks) > 0 && ch.isBetter(chunks[len(chunks)-1]) {
+			// merge it with the previous chunk
+			prv := chunks[len(chunks)-1]
+			prv.Txs = prv.Txs[:len(prv.Txs)+len(ch.Txs)]
+			prv.Weight += ch.Weight
+			prv.Fee += ch.Fee
+			ch = prv
+			chunks = chunks[:len(chunks)-1]
+		}
+		chunks = append(chunks, ch)
+	}
+	return
+}
+
+// splitClusters divides the given txs into groups of the connected ones.
+func splitClusters(txs []*OneTxToSend) (result [][]*OneTxToSend) {
+	root := make([]int, len(txs))
+	for i := range root {
+		root[i] = i
+	}
+	find := func(i int) int {
+		for root[i] != i {
+			root[i] = root[root[i]]
+			i = root[i]
+		}
+		return i
+	}
+	for i, pars := range setParents(txs) {
+		for _, p := range pars {
+			root[find(i)] = find(p)
+		}
+	}
+	groups := make(map[int]int) // root -> index in result
+	for i, t := range txs {
+		r := find(i)
+		if g, ok := groups[r]; ok {
+			result[g] = append(result[g], t)
+		} else {
+			groups[r] = len(result)
+			result = append(result, []*OneTxToSend{t})
+		}
+	}
+	return
+}
+
+func newCluster(txs []*OneTxToSend) {
+	c := &Cluster{Txs: linearize(txs)}
+	c.Chunks = chunkify(c.Txs)
+	for _, t := range c.Txs {
+		t.cluster = c
+	}
+	Clusters[c] = true
+	chunksByFeerate = nil
+}
+
+// addToCluster merges the clusters of the tx's parents with the new tx.
+func (t2s *OneTxToSend) addToCluster() {
+	if ClustersDirty {
+		return
+	}
+	if ClusteringSuspended {
+		ClustersDirty = true
+		return
+	}
+	sta := time.Now()
+	txs := []*OneTxToSend{t2s}
+	for _, par := range t2s.directParents() {
+		if c := par.cluster; Clusters[c] {
+			txs = append(txs, c.Txs...)
+			delete(Clusters, c)
+		}
+	}
+	newCluster(txs)
+	ClustersUpdateTime += time.Since(sta)
+	ClustersUpdateCount++
+}
+
+// delFromCluster removes the tx from its cluster, which may fall apart into several ones.
+func (t2s *OneTxToSend) delFromCluster() {
+	c := t2s.cluster
+	t2s.cluster = nil
+	if ClustersDirty {
+		return
+	}
+	if ClusteringSuspended {
+		ClustersDirty = true
+		return
+	}
+	if CheckForErrors() && !Clusters[c] {
+		println("ERROR: delFromCluster called for tx without a cluster", t2s.Hash.String())
+		ClustersDirty = true
+		return
+	}
+	sta := time.Now()
+	delete(Clusters, c)
+	chunksByFeerate = nil
+	if len(c.Txs) > 1 {
+		rest := make([]*OneTxToSend, 0, len(c.Txs)-1)
+		for _, t := range c.Txs {
+			if t != t2s {
+				rest = append(rest, t)
+			}
+		}
+		for _, txs := range splitClusters(rest) {
+			newCluster(txs)
+		}
+	}
+	ClustersUpdateTime += time.Since(sta)
+	ClustersUpdateCount++
+}
+
+// getWholeCluster returns the tx with all the mempool txs connected to it.
+func (t2s *OneTxToSend) getWholeCluster() (result []*OneTxToSend) {
+	already_in := map[*OneTxToSend]bool{t2s: true}
+	result = []*OneTxToSend{t2s}
+	for idx := 0; idx < len(result); idx++ {
+		t := result[idx]
+		for _, tt := range append(t.directParents(), t.GetChildren()...) {
+			if !already_in[tt] {
+				already_in[tt] = true
+				result = append(result, tt)
+			}
+		}
+	}
+	return
+}
+
+// updateClusters rebuilds all the clusters, if needed.
+// Make sure to call it with TxMutex locked.
+func updateClusters() {
+	if !ClustersDirty {
+		return
+	}
+	sta := time.Now()
+	Clusters = make(map[*Cluster]bool, len(Clusters))
+	chunksByFeerate = nil
+	for _, t2s := range TransactionsToSend {
+		t2s.cluster = nil
+	}
+	for _, t2s := range TransactionsToSend {
+		if t2s.cluster == nil {
+			newCluster(t2s.getWholeCluster())
+		}
+	}
+	ClustersDirty = false
+	ClustersRebuildTime += time.Since(sta)
+	ClustersRebuildCount++
+	common.CountSafe("TxClustersRebuild")
+}
+
+// call it with false to restore clustering
+func BlockCommitInProgress(yes bool) {
+	TxMutex.Lock()
+	ClusteringSuspended = yes
+	TxMutex.Unlock()
+}
+
+// sortedChunks returns all the mempool chunks, sorted by their feerates.
+// Chunks of one cluster (with the same feerates) keep their order.
+func sortedChunks() []*Chunk {
+	updateClusters()
+	if chunksByFeerate != nil {
+		return chunksByFeerate
+	}
+	chunksByFeerate = make([]*Chunk, 0, len(TransactionsToSend))
+	for c := range Clusters {
+		chunksByFeerate = append(chunksByFeerate, c.Chunks...)
+	}
+	sort.SliceStable(chunksByFeerate, func(i, j int) bool {
+		return chunksByFeerate[i].isBetter(chunksByFeerate[j])
+	})
+	return chunksByFeerate
+}
+
+// GetSortedMempool returns all the mempool txs in the order in which they should be mined
+// (chunks sorted by their feerates, parents always before their children).
+// Make sure to call it with TxMutex locked.
+func GetSortedMempool() (result []*OneTxToSend) {
+	result = make([]*OneTxToSend, 0, len(TransactionsToSend))
+	for _, ch := range sortedChunks() {
+		result = append(result, ch.Txs...)
+	}
+	return
+}
+
+// GetMempoolFees returns the best chunks of the mempool, up to the given weight.
+// Make sure to call it with TxMutex locked.
+func GetMempoolFees(maxweight uint64) (result []*Chunk) {
+	var weightsofar uint64
+	chunks := sortedChunks()
+	result = make([]*Chunk, 0, len(chunks))
+	for _, ch := range chunks {
+		if weightsofar >= maxweight {
+			break
+		}
+		result = append(result, ch)
+		weightsofar += ch.Weight
+	}
+	return
+}
+
+// checkClusterLimits verifies that the cluster which the new tx would join is not going to be too big.
+// Txs that are to be replaced do not count. It returns zero if the tx can be accepted, or the reject reason.
+func checkClusterLimits(t2s *OneTxToSend, rbf map[*OneTxToSend]bool) byte {
+	if t2s.MemInputCnt == 0 {
+		return 0
+	}
+	updateClusters()
+	cnt, vsize := uint32(1), uint32(t2s.VSize())
+	done := make(map[*Cluster]bool)
+	for _, par := range t2s.directParents() {
+		if done[par.cluster] {
+			continue
+		}
+		done[par.cluster] = true
+		for _, t := range par.cluster.Txs {
+			if !rbf[t] {
+				cnt++
+				vsize += uint32(t.VSize())
+			}
+		}
+	}
+	if cnt > common.Get(&common.CFG.TXPool.ClusterCount) || vsize > 1000*common.Get(&common.CFG.TXPool.ClusterSizeKB) {
+		return TX_REJECTED_CLUSTER
+	}
+	return 0
+}
+
+type diagramPoint struct {
+	weight, fee uint64
+}
+
+// feerateDiagram returns the cumulative weights and fees of the chunks, best feerates first.
+func feerateDiagram(chunks []*Chunk) (dia []diagramPoint) {
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].isBetter(chunks[j])
+	})
+	dia = make([]diagramPoint, len(chunks)+1)
+	for i, ch := range chunks {
+		dia[i+1].weight = dia[i].weight + ch.Weight
+		dia[i+1].fee = dia[i].fee + ch.Fee
+	}
+	return
+}
+
+// cmpDiagram compares the fee with the diagram's fee at the given weight.
+// Beyond its end, the diagram stays flat.
+func cmpDiagram(fee, weight uint64, dia []diagramPoint) int {
+	for i := 1; i < len(dia); i++ {
+		if weight <= dia[i].weight {
+			dw := dia[i].weight - dia[i-1].weight
+			l := fee * dw
+			r := dia[i-1].fee*dw + (weight-dia[i-1].weight)*(dia[i].fee-dia[i-1].fee)
+			if l > r {
+				return 1
+			} else if l < r {
+				return -1
+			}
+			return 0
+		}
+	}
+	if last := dia[len(dia)-1].fee; fee > last {
+		return 1
+	} else if fee < last {
+		return -1
+	}
+	return 0
+}
+
+// improvesDiagram checks if replacing the given mempool txs (with all their descendants)
+// with the new ones makes the feerate diagram of the affected clusters strictly better.
+func improvesDiagram(replaced map[*OneTxToSend]bool, added []*OneTxToSend) bool {
+	updateClusters()
+	affected := make(map[*Cluster]bool)
+	for t := range replaced {
+		affected[t.cluster] = true
+	}
+	for _, t := range added {
+		for _, in := range t.TxIn {
+			if par, ok := TransactionsToSend[btc.BIdx(in.Input.Hash[:])]; ok {
+				affected[par.cluster] = true
+			}
+		}
+	}
+
+	var old_chunks, new_chunks []*Chunk
+	txs := slices.Clone(added)
+	for c := range affected {
+		old_chunks = append(old_chunks, c.Chunks...)
+		for _, t := range c.Txs {
+			if !replaced[t] {
+				txs = append(txs, t)
+			}
+		}
+	}
+	for _, part := range splitClusters(txs) {
+		new_chunks = append(new_chunks, chunkify(linearize(part))...)
+	}
+
+	old_dia, new_dia := feerateDiagram(old_chunks), feerateDiagram(new_chunks)
+	var better bool
+	for _, p := range old_dia {
+		switch cmpDiagram(p.fee, p.weight, new_dia) {
+		case 1:
+			return false
+		case -1:
+			better = true
+		}
+	}
+	for _, p := range new_dia {
+		switch cmpDiagram(p.fee, p.weight, old_dia) {
+		case -1:
+			return false
+		case 1:
+			better = true
+		}
+	}
+	return better
+}
+
+// paysForRBF checks if the new txs pay more than all the replaced ones (with their descendants)
+// together, and if the difference pays for their own size at the incremental relay fee (BIP125 rules 3 and 4).
+func paysForRBF(replaced map[*OneTxToSend]bool, added []*OneTxToSend) bool {
+	var oldfee, newfee, vsize uint64
+	for t := range replaced {
+		oldfee += t.Fee
+	}
+	for _, t := range added {
+		newfee += t.Fee
+		vsize += uint64(t.VSize())
+	}
+	return newfee >= oldfee && 1000*(newfee-oldfee) >= vsize*common.IncrementalFeePerKB()
+}
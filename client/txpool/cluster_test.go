@@ -0,0 +1,263 @@
+package txpool
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/piotrnar/gocoin/client/common"
+)
+
+// testRandomCluster returns cnt connected txs (not in mempool), each spending some of the previous ones.
+// The fees and sizes are picked from only a few values, so there are many ways to order them.
+func testRandomCluster(rnd *rand.Rand, cnt int) (txs []*OneTxToSend) {
+	for {
+		txs = txs[:0]
+		for i := 0; i < cnt; i++ {
+			var parents []*OneTxToSend
+			var vouts []uint32
+			for p := range txs {
+				if rnd.Intn(2) == 0 {
+					parents = append(parents, txs[p])
+					vouts = append(vouts, uint32(i))
+				}
+			}
+			outs := append(testOuts(cnt), testOut(0, 1+rnd.Intn(3)*100))
+			txs = append(txs, testTx(2, uint64(rnd.Intn(4)*1000), parents, vouts, outs...))
+		}
+		if len(splitClusters(txs)) == 1 {
+			return
+		}
+	}
+}
+
+// testTopoOrders calls cb with each ordering of the txs that has parents before their children.
+func testTopoOrders(txs []*OneTxToSend, cb func([]*OneTxToSend)) {
+	parents := setParents(txs)
+	used := make([]bool, len(txs))
+	lin := make([]*OneTxToSend, 0, len(txs))
+	var next func()
+	next = func() {
+		if len(lin) == len(txs) {
+			cb(lin)
+			return
+		}
+	try:
+		for i := range txs {
+			if used[i] {
+				continue
+			}
+			for _, p := range parents[i] {
+				if !used[p] {
+					continue try
+				}
+			}
+			used[i] = true
+			lin = append(lin, txs[i])
+			next()
+			lin = lin[:len(lin)-1]
+			used[i] = false
+		}
+	}
+	next()
+}
+
+func testIsTopological(lin []*OneTxToSend) bool {
+	for i, p := range setParents(lin) {
+		for _, p := range p {
+			if p > i {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func testChunksSorted(t *testing.T, chunks []*Chunk) {
+	t.Helper()
+	for i := 1; i < len(chunks); i++ {
+		if chunks[i].isBetter(chunks[i-1]) {
+			t.Fatal("Chunk", i, "better than the previous one:", chunks[i].SPB(), chunks[i-1].SPB())
+		}
+	}
+}
+
+func TestLinearizeExact(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 300; n++ {
+		txs := testRandomCluster(rnd, 2+n%6)
+		lin := linearize(txs) // exact, for so small clusters
+		if len(lin) != len(txs) || !testIsTopological(lin) {
+			t.Fatal("Bad linearization of", len(txs), "txs")
+		}
+		chunks := chunkify(lin)
+		testChunksSorted(t, chunks)
+		dia := feerateDiagram(chunks)
+
+		// no other ordering can give a better feerate diagram
+		testTopoOrders(txs, func(other []*OneTxToSend) {
+			other_chunks := chunkify(other)
+			testChunksSorted(t, other_chunks)
+			for _, p := range feerateDiagram(other_chunks) {
+				if cmpDiagram(p.fee, p.weight, dia) > 0 {
+					t.Fatal("Cluster", n, "has better linearization", p)
+				}
+			}
+		})
+	}
+}
+
+func TestLinearizeCPFP(t *testing.T) {
+	testInitMempool()
+	par := testTx(2, 0, nil, nil, testOuts(2)...)
+	child := testTx(2, 20000, []*OneTxToSend{par}, []uint32{0})
+	other := testTx(2, 2000, []*OneTxToSend{par}, []uint32{1})
+	lin := linearize([]*OneTxToSend{other, child, par})
+	if lin[0] != par || lin[1] != child || lin[2] != other {
+		t.Fatal("Child does not pay for its parent")
+	}
+	chunks := chunkify(lin)
+	if len(chunks) != 2 || len(chunks[0].Txs) != 2 || chunks[0].Fee != 20000 || chunks[1].Txs[0] != other {
+		t.Error("Bad CPFP chunks", chunks)
+	}
+
+	// a worse ordering gets its chunks merged
+	chunks = chunkify([]*OneTxToSend{par, other, child})
+	if len(chunks) != 1 || chunks[0].Fee != 22000 {
+		t.Error("Bad chunks of worse ordering", chunks)
+	}
+}
+
+func TestImprovesDiagram(t *testing.T) {
+	testInitMempool()
+	old := testTx(2, 10000, nil, nil, testOut(0, 100))
+	testAdd(t, old)
+	replaced := map[*OneTxToSend]bool{old: true}
+	size := old.VSize()
+
+	tests := []struct {
+		name   string
+		fee    uint64
+		vsize  int
+		better bool
+	}{
+		{"equal", 10000, size, false},
+		{"higher fee", 10001, size, true},
+		{"same fee, smaller", 10000, size - 10, true},
+		{"lower fee", 9999, size, false},
+		{"crossing, better feerate", 8000, size / 2, false},
+		{"crossing, higher fee", 11000, 2 * size, false},
+	}
+	for _, tt := range tests {
+		if res := improvesDiagram(replaced, []*OneTxToSend{testTxVSize(t, tt.fee, nil, nil, tt.vsize)}); res != tt.better {
+			t.Error(tt.name+":", res)
+		}
+	}
+
+	// the replaced tx's child (paying for it) goes as well
+	child := testTx(2, 50000, []*OneTxToSend{old}, []uint32{0})
+	testAdd(t, child)
+	replaced[child] = true
+	if improvesDiagram(replaced, []*OneTxToSend{testTxVSize(t, 20000, nil, nil, size)}) {
+		t.Error("Replacing a parent paid by its child")
+	}
+	if !improvesDiagram(replaced, []*OneTxToSend{testTxVSize(t, 70000, nil, nil, size)}) {
+		t.Error("Replacing a parent with its child")
+	}
+}
+
+func TestPaysForRBF(t *testing.T) {
+	testInitMempool()
+	common.CFG.TXPool.IncrementalFee = 2
+	common.Reset()
+	common.SetMinFeePerKB(50000) // as if the mempool was full - it does not matter here
+	old1 := testTx(2, 3000, nil, nil)
+	old2 := testTx(2, 4000, nil, nil)
+	replaced := map[*OneTxToSend]bool{old1: true, old2: true}
+
+	tx := testTx(2, 0, nil, nil)
+	tx.Fee = 7000 + 2*uint64(tx.VSize())
+	if !paysForRBF(replaced, []*OneTxToSend{tx}) {
+		t.Error("Replacement paying the incremental fee rejected")
+	}
+	tx.Fee--
+	if paysForRBF(replaced, []*OneTxToSend{tx}) {
+		t.Error("Replacement not paying the incremental fee accepted")
+	}
+	// a higher feerate is not enough
+	tx.Fee = 6000
+	if paysForRBF(replaced, []*OneTxToSend{tx}) {
+		t.Error("Replacement paying less accepted")
+	}
+}
+
+func TestClusterLimits(t *testing.T) {
+	testInitMempool()
+	common.CFG.TXPool.ClusterCount = 3
+	par := testTx(2, 1000, nil, nil, testOuts(3)...)
+	testAdd(t, par)
+	child := testTx(2, 1000, []*OneTxToSend{par}, []uint32{0})
+	testAdd(t, child)
+	if r := checkClusterLimits(testTx(2, 1000, []*OneTxToSend{par}, []uint32{1}), nil); r != 0 {
+		t.Fatal("Cluster at count limit:", ReasonToString(r))
+	}
+	other := testTx(2, 1000, nil, nil)
+	testAdd(t, other)
+	// joining two clusters
+	next := testTx(2, 1000, []*OneTxToSend{par, other}, []uint32{1, 0})
+	if r := checkClusterLimits(next, nil); r != TX_REJECTED_CLUSTER {
+		t.Error("Cluster over count limit:", ReasonToString(r))
+	}
+	if r := checkClusterLimits(next, map[*OneTxToSend]bool{child: true}); r != 0 {
+		t.Error("Cluster at count limit, with a replaced tx:", ReasonToString(r))
+	}
+
+	// size: exactly 2000 vbytes
+	testInitMempool()
+	common.CFG.TXPool.ClusterSizeKB = 2
+	par = testTx(2, 1000, nil, nil, testOuts(2)...)
+	testAdd(t, par)
+	size := 2000 - par.VSize()
+	if r := checkClusterLimits(testTxVSize(t, 1000, []*OneTxToSend{par}, []uint32{0}, size), nil); r != 0 {
+		t.Error("Cluster at size limit:", ReasonToString(r))
+	}
+	if r := checkClusterLimits(testTxVSize(t, 1000, []*OneTxToSend{par}, []uint32{0}, size+1), nil); r != TX_REJECTED_CLUSTER {
+		t.Error("Cluster over size limit:", ReasonToString(r))
+	}
+}
+
+func TestRemoveExcessiveTxs(t *testing.T) {
+	testInitMempool()
+	common.CFG.TXPool.MaxSizeMB = 10
+	common.Reset()
+
+	// the worst chunk - a big parent with a child paying a bit for it
+	par := testTx(2, 0, nil, nil, testOut(10000, 22), testOut(0, 1500000))
+	testAdd(t, par)
+	child := testTx(2, 2000, []*OneTxToSend{par}, []uint32{0})
+	testAdd(t, child)
+	// the second worst one
+	poor := testTx(2, 1000, nil, nil, testOut(0, 100000))
+	testAdd(t, poor)
+	var good []*OneTxToSend
+	for TransactionsToSendSize < common.MaxMempoolSize()+1e6 {
+		t2s := testTx(2, 1000000, nil, nil, testOut(0, 100000))
+		testAdd(t, t2s)
+		good = append(good, t2s)
+	}
+
+	removeExcessiveTxs()
+	if testInMempool(par.Tx) || testInMempool(child.Tx) || !testInMempool(poor.Tx) {
+		t.Error("Worst chunk not removed")
+	}
+	for _, t2s := range good {
+		if !testInMempool(t2s.Tx) {
+			t.Fatal("Good tx removed")
+		}
+	}
+	if exp := 4000 * 2000 / uint64(par.Weight()+child.Weight()); CurrentFeeAdjustedSPKB != exp {
+		t.Error("Bad fee adjusted", CurrentFeeAdjustedSPKB, exp)
+	}
+	if MempoolCheck() {
+		t.Error("Mempool broken after removing txs")
+	}
+}
@@ -249,9 +249,13 @@ func newOneTxRejectedFromFile(rd io.Reader) (txr *OneTxRejected, er error) {
 			return
 		}
 		txr.SetHash(raw) // this will update the sizes and wtxid
-	} else if txr.Waiting4 != nil {
-		println("WARNING: RejectedTx", txr.Id.String(), "was waiting for inputs, but has no data")
-		txr.Waiting4 = nil
+		txr.WIdx = txr.WTxID().BIdx()
+	} else {
+		txr.WIdx = txr.Id.BIdx() // wtxid is not stored in the file
+		if txr.Waiting4 != nil {
+			println("WARNING: RejectedTx", txr.Id.String(), "was waiting for inputs, but has no data")
+			txr.Waiting4 = nil
+		}
 	}
 	txr.Footprint = uint32(txr.SysSize())
 
@@ -336,10 +340,14 @@ func MempoolLoad() bool {
 
 	//fmt.Println("Rebuilding SpentOutputs")
 	SpentOutputs = make(map[uint64]btc.BIDX, 4*len(TransactionsToSend))
+	WTxIDToSend = make(map[btc.BIDX]btc.BIDX, len(TransactionsToSend))
 	for bidx, t2s := range TransactionsToSend {
 		for _, inp := range t2s.TxIn {
 			SpentOutputs[inp.Input.UIdx()] = bidx
 		}
+		if t2s.SegWit != nil {
+			WTxIDToSend[t2s.WTxID().BIdx()] = bidx
+		}
 	}
 
 	if file_version >= 4 {
@@ -389,8 +397,8 @@ func MempoolLoad() bool {
 		fmt.Println("Additionally loaded", len(TransactionsRejected), "rejected transactions taking", TransactionsRejectedSize, "bytes")
 	}
 
-	FeePackagesDirty = true
-	SortListDirty = true
+	ClustersDirty = true
+	AncestryDirty = true
 
 	if CheckForErrors() {
 		if MempoolCheck() {
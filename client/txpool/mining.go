@@ -47,7 +47,7 @@ func (tx *OneTxToSend) mined() {
 					common.CountSafe("TxMinedMeminTx")
 					rec.memInputsSet(nil)
 				}
-				SortListDirty = true // will need to resort after
+				ClustersDirty = true // the cluster will need to be split
 			} else {
 				common.CountSafe("TxMinedMeminERR")
 				println("ERROR: out in SpentOutputs, but not in mempool")
@@ -72,7 +72,7 @@ func (tx *OneTxToSend) unmined() {
 				} else {
 					rec.MemInputs[idx] = true
 					rec.MemInputCnt++
-					SortListDirty = true // will need to resort after
+					ClustersDirty = true // the clusters will need to be merged
 					common.CountSafe("TxPutBackMemIn")
 				}
 				if CheckForErrors() && rec.Footprint != uint32(rec.SysSize()) {
@@ -177,7 +177,9 @@ func BlockMined(bl *btc.Block) {
 	}
 
 	TxMutex.Lock()
-	FeePackagesDirty = true
+	ClustersDirty = true
+	// the links between mined txs and their descendants get broken on the way
+	AncestryDirty = true
 	for i := len(bl.Txs) - 1; i > 0; i-- { // we go in reversed order to remove children before parents
 		tx := bl.Txs[i]
 		txMined(tx)
@@ -190,15 +192,15 @@ func BlockMined(bl *btc.Block) {
 }
 
 func BlockUndone(bl *btc.Block) {
-	common.CountSafe("TxPkgsBlockUndo")
+	common.CountSafe("TxClustersBlockUndo")
 	if len(bl.Txs) < 2 {
 		return
 	}
 
 	TxMutex.Lock()
-	// this will spare us all the struggle with trying to re-package each tx
-	// .. plus repackaging and resorting of unmined txs is not implemented :)
-	FeePackagesDirty = true
+	// this will spare us all the struggle with trying to re-cluster each tx
+	ClustersDirty = true
+	AncestryDirty = true // the unmined txs get new children, already in the mempool
 	for _, tx := range bl.Txs[1:] {
 		if tr, ok := TransactionsRejected[tx.Hash.BIdx()]; ok {
 			tr.Delete()
@@ -26,6 +26,7 @@ type TxRcvd struct {
 	Trusted    bool
 	Local      bool
 	Unmined    bool
+	Package    bool // the fee has been checked for the whole package (see processPackage)
 	Result     byte // this is an output value (sent to callback from HandleNetTx)
 }
 
@@ -62,6 +63,56 @@ func needThisTxExt(id *btc.Uint256, cb func()) (why_not int) {
 	return
 }
 
+// NeedThisWTx works like NeedThisTxExt, but for a tx announced by its wtxid (BIP339).
+// Since the wtxid commits to the witness data, a malleated version of
+// a tx that we already have (or rejected) is still going to be wanted.
+func NeedThisWTx(wid *btc.Uint256) (why_not int) {
+	TxMutex.Lock()
+	if t2s := findByWTxID(wid.BIdx()); t2s != nil {
+		t2s.Lastseen = time.Now()
+		why_not = 1
+	} else if txr, present := TransactionsRejected[wid.BIdx()]; present && txr.WIdx == wid.BIdx() {
+		why_not = 2
+	} else if _, present := WTxIDRejected[wid.BIdx()]; present {
+		why_not = 2
+	}
+	TxMutex.Unlock()
+	return
+}
+
+// NeedThisNetTx is NeedThisTxExt for a tx that has just been received.
+// If the same txid had been rejected for a reason that depends on its witness data
+// (which anyone can malleate), the version with a different wtxid gets another chance.
+func NeedThisNetTx(tx *btc.Tx, cb func()) (why_not int) {
+	TxMutex.Lock()
+	if tx.SegWit != nil {
+		if txr, ok := TransactionsRejected[tx.Hash.BIdx()]; ok && txr.WIdx != tx.WTxID().BIdx() &&
+			(txr.Reason == TX_REJECTED_SCRIPT_FAIL || txr.Reason == TX_REJECTED_TOO_BIG || txr.Reason == TX_REJECTED_NONSTD_WITNESS) {
+			common.CountSafe("TxRejectedMalleated")
+			txr.Delete()
+		}
+	}
+	why_not = needThisTxExt(&tx.Hash, cb)
+	TxMutex.Unlock()
+	return
+}
+
+// FindByWTxID returns the mempool tx with the given wtxid, or nil if there is no such.
+// Make sure to call it with TxMutex locked.
+func FindByWTxID(wid *btc.Uint256) *OneTxToSend {
+	return findByWTxID(wid.BIdx())
+}
+
+func findByWTxID(widx btc.BIDX) *OneTxToSend {
+	if bidx, ok := WTxIDToSend[widx]; ok {
+		return TransactionsToSend[bidx]
+	}
+	if t2s, ok := TransactionsToSend[widx]; ok && t2s.SegWit == nil {
+		return t2s
+	}
+	return nil
+}
+
 func processTx(ntx *TxRcvd) (byte, *OneTxToSend) {
 	tx := ntx.Tx
 	bidx := tx.Hash.BIdx()
@@ -79,6 +130,14 @@ func processTx(ntx *TxRcvd) (byte, *OneTxToSend) {
 		return TX_REJECTED_TOO_BIG, nil
 	}
 
+	policy := !ntx.Trusted && !ntx.Unmined && !common.Get(&common.CFG.TXPool.AcceptNonStd)
+	if policy {
+		if reason := isStandardTx(tx); reason != 0 {
+			rejectTx(tx, reason, nil)
+			return reason, nil
+		}
+	}
+
 	pos := make([]*btc.TxOut, len(tx.TxIn))
 	spent := make([]uint64, len(tx.TxIn))
 
@@ -87,7 +146,7 @@ func processTx(ntx *TxRcvd) (byte, *OneTxToSend) {
 
 	// Check if all the inputs exist in the chain
 	for i := range tx.TxIn {
-		if !full_rbf && !final && tx.TxIn[i].Sequence >= 0xfffffffe {
+		if !full_rbf && !final && tx.Version != TRUC_VERSION && tx.TxIn[i].Sequence >= 0xfffffffe {
 			final = true
 		}
 
@@ -200,26 +259,69 @@ func processTx(ntx *TxRcvd) (byte, *OneTxToSend) {
 	// Check for a proper fee
 	fee := totinp - totout
 
-	if !ntx.Unmined { // ignore low fees when puting back txs from unmined blocks
+	// TRUC (BIP431) topology and ephemeral dust rules
+	newtx := &OneTxToSend{Tx: tx, Fee: fee, MemInputs: frommem, MemInputCnt: frommemcnt}
+	if !ntx.Unmined {
+		reason, sibling := checkTRUC(newtx, rbf_tx_list)
+		if reason == 0 && policy {
+			if fee != 0 && len(dustOutputs(tx)) > 0 {
+				reason = TX_REJECTED_EPHEMERAL_FEE
+			} else {
+				reason = checkEphemeralSpends(newtx)
+			}
+		}
+		if reason != 0 {
+			rejectTx(ntx.Tx, reason, nil)
+			return reason, nil
+		}
+		if sibling != nil && ntx.Package {
+			rejectTx(ntx.Tx, TX_REJECTED_TRUC_DESCENDANTS, nil) // no sibling eviction within packages
+			return TX_REJECTED_TRUC_DESCENDANTS, nil
+		}
+		if sibling != nil {
+			// TRUC sibling eviction - the other child of our parent has to be replaced
+			if rbf_tx_list == nil {
+				rbf_tx_list = make(map[*OneTxToSend]bool)
+			}
+			rbf_tx_list[sibling] = true
+			common.CountSafe("TxTRUCSiblingEvict")
+		}
+	}
+
+	if !ntx.Unmined && !ntx.Package { // ignore low fees when puting back txs from unmined blocks
 		if !ntx.Local && 4000*fee < uint64(tx.Weight())*common.MinFeePerKB() { // do not check minimum fee for locally loaded txs
 			//rejectTx(ntx.Tx, TX_REJECTED_LOW_FEE, nil) - we do not store low fee txs in TransactionsRejected anymore
 			common.CountSafe("TxRejected-LowFee") // we count it here
 			return TX_REJECTED_LOW_FEE, nil
 		}
 
-		if rbf_tx_list != nil {
-			var totvsize int
-			var totfees uint64
+		// the replacement must pay for the replaced txs and the feerate diagram of the affected clusters must get better
+		if rbf_tx_list != nil && !ntx.Local && (!paysForRBF(rbf_tx_list, []*OneTxToSend{newtx}) ||
+			!improvesDiagram(rbf_tx_list, []*OneTxToSend{newtx})) {
+			rejectTx(ntx.Tx, TX_REJECTED_RBF_LOWFEE, nil)
+			return TX_REJECTED_RBF_LOWFEE, nil
+		}
+	}
 
-			for ctx := range rbf_tx_list {
-				totvsize += ctx.VSize()
-				totfees += ctx.Fee
-			}
+	if !ntx.Unmined {
+		reason := checkChainLimits(newtx)
+		if reason == 0 {
+			reason = checkClusterLimits(newtx, rbf_tx_list)
+		}
+		if reason != 0 {
+			rejectTx(ntx.Tx, reason, nil)
+			return reason, nil
+		}
+	}
 
-			if !ntx.Local && totfees*uint64(tx.VSize()) >= fee*uint64(totvsize) {
-				rejectTx(ntx.Tx, TX_REJECTED_RBF_LOWFEE, nil)
-				return TX_REJECTED_RBF_LOWFEE, nil
-			}
+	if policy {
+		reason := areInputsStandard(tx, pos)
+		if reason == 0 {
+			reason = isWitnessStandard(tx, pos)
+		}
+		if reason != 0 {
+			rejectTx(tx, reason, nil)
+			return reason, nil
 		}
 	}
 
@@ -265,6 +367,11 @@ func processTx(ntx *TxRcvd) (byte, *OneTxToSend) {
 		sigops += uint(tx.CountWitnessSigOps(i, pos[i].Pk_script))
 	}
 
+	if policy && sigops > MAX_STANDARD_TX_SIGOPS_COST {
+		rejectTx(tx, TX_REJECTED_SIGOPS, nil)
+		return TX_REJECTED_SIGOPS, nil
+	}
+
 	for len(rbf_tx_list) > 0 {
 		var ctx *OneTxToSend
 		for ctx = range rbf_tx_list {
@@ -321,6 +428,11 @@ func HandleNetTx(ntx *TxRcvd) bool {
 }
 
 func SubmitLocalTx(tx *btc.Tx, rawtx []byte) bool {
+	return SubmitLocalTxExt(tx, rawtx) == 0
+}
+
+// SubmitLocalTxExt returns zero if the tx has been accepted, or the reason of rejecting it.
+func SubmitLocalTxExt(tx *btc.Tx, rawtx []byte) byte {
 	TxMutex.Lock()
 	bidx := tx.Hash.BIdx()
 	// It may be on the rejected list, so remove it first
@@ -331,7 +443,7 @@ func SubmitLocalTx(tx *btc.Tx, rawtx []byte) bool {
 		removeExcessiveTxs()
 	}
 	TxMutex.Unlock()
-	return res == 0
+	return res
 }
 
 func adjustMinimalFee() {
@@ -0,0 +1,358 @@
+package txpool
+
+import (
+	"fmt"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// Package relay: a child with its unconfirmed parents, evaluated together,
+// so a parent paying below the minimum fee can get in, if the child pays for it.
+
+const (
+	MAX_PACKAGE_COUNT  = 25
+	MAX_PACKAGE_WEIGHT = 404000
+)
+
+// Reasons for rejecting the package as a whole
+const (
+	PKG_REJECTED_TOO_MANY_TXS   = 1
+	PKG_REJECTED_TOO_LARGE      = 2
+	PKG_REJECTED_DUPLICATE      = 3
+	PKG_REJECTED_CONFLICT       = 4 // two txs of the package spend the same output
+	PKG_REJECTED_NOT_SORTED     = 5
+	PKG_REJECTED_NOT_CHILD_PRNT = 6 // not a child with its parents
+	PKG_REJECTED_TX             = 7 // one of the txs has been rejected (see its result)
+	PKG_REJECTED_LOW_FEE        = 8
+	PKG_REJECTED_RBF            = 9
+)
+
+type PkgTxResult struct {
+	Fee       uint64
+	Result    byte // TX_REJECTED_* reason (zero if the tx has not been rejected)
+	Accepted  bool // the tx is in the mempool now
+	InMempool bool // ... it was there already
+	Package   bool // ... it has been accepted with the package fee rate
+}
+
+type PkgRcvd struct {
+	Txs        []*btc.Tx // sorted, the child at the end
+	FeedbackCB func(*PkgRcvd)
+	FromCID    uint32
+	Trusted    bool
+	Local      bool
+
+	// Output values (set by SubmitPackage)
+	Result    byte // zero if all the txs are in the mempool now, otherwise PKG_REJECTED_*
+	TxResults []PkgTxResult
+	Replaced  []*btc.Uint256
+}
+
+func PkgReasonToString(reason byte) string {
+	switch reason {
+	case 0:
+		return ""
+	case PKG_REJECTED_TOO_MANY_TXS:
+		return "TOO_MANY_TXS"
+	case PKG_REJECTED_TOO_LARGE:
+		return "TOO_LARGE"
+	case PKG_REJECTED_DUPLICATE:
+		return "DUPLICATE"
+	case PKG_REJECTED_CONFLICT:
+		return "CONFLICT"
+	case PKG_REJECTED_NOT_SORTED:
+		return "NOT_SORTED"
+	case PKG_REJECTED_NOT_CHILD_PRNT:
+		return "NOT_CHILD_WITH_PARENTS"
+	case PKG_REJECTED_TX:
+		return "TX_REJECTED"
+	case PKG_REJECTED_LOW_FEE:
+		return "LOW_FEE"
+	case PKG_REJECTED_RBF:
+		return "RBF"
+	}
+	return fmt.Sprint("UNKNOWN_", reason)
+}
+
+// checkPackageTopology makes sure that the package consists of a child (the last tx)
+// and its parents, sorted, without duplicates and without conflicting inputs.
+func checkPackageTopology(txs []*btc.Tx) byte {
+	if len(txs) == 0 {
+		return PKG_REJECTED_NOT_CHILD_PRNT
+	}
+	if len(txs) > MAX_PACKAGE_COUNT {
+		return PKG_REJECTED_TOO_MANY_TXS
+	}
+	var weight int
+	idxs := make(map[btc.BIDX]int, len(txs))
+	spent := make(map[uint64]bool)
+	for i, tx := range txs {
+		weight += tx.Weight()
+		if _, ok := idxs[tx.Hash.BIdx()]; ok {
+			return PKG_REJECTED_DUPLICATE
+		}
+		idxs[tx.Hash.BIdx()] = i
+		for _, in := range tx.TxIn {
+			if spent[in.Input.UIdx()] {
+				return PKG_REJECTED_CONFLICT
+			}
+			spent[in.Input.UIdx()] = true
+		}
+	}
+	if weight > MAX_PACKAGE_WEIGHT {
+		return PKG_REJECTED_TOO_LARGE
+	}
+
+	for i, tx := range txs {
+		for _, in := range tx.TxIn {
+			if j, ok := idxs[btc.BIdx(in.Input.Hash[:])]; ok && j >= i {
+				return PKG_REJECTED_NOT_SORTED
+			}
+		}
+	}
+
+	child := txs[len(txs)-1]
+	for _, par := range txs[:len(txs)-1] {
+		var is_parent bool
+		for _, in := range child.TxIn {
+			if in.Input.Hash == par.Hash.Hash {
+				is_parent = true
+				break
+			}
+		}
+		if !is_parent {
+			return PKG_REJECTED_NOT_CHILD_PRNT
+		}
+	}
+	return 0
+}
+
+// spendsAnyOf returns true if the tx spends any of the txs from the map.
+func spendsAnyOf(tx *btc.Tx, txs map[btc.BIDX]*btc.Tx) bool {
+	for _, in := range tx.TxIn {
+		if _, ok := txs[btc.BIdx(in.Input.Hash[:])]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pkgTxFee returns the fee of the tx, taking inputs from the chain, the mempool or from the package.
+func pkgTxFee(tx *btc.Tx, pkg map[btc.BIDX]*btc.Tx) (fee uint64, ok bool) {
+	var totinp, totout uint64
+	for _, in := range tx.TxIn {
+		var out *btc.TxOut
+		if t2s, ok := TransactionsToSend[btc.BIdx(in.Input.Hash[:])]; ok {
+			if int(in.Input.Vout) < len(t2s.TxOut) {
+				out = t2s.TxOut[in.Input.Vout]
+			}
+		} else if ptx, ok := pkg[btc.BIdx(in.Input.Hash[:])]; ok {
+			if int(in.Input.Vout) < len(ptx.TxOut) {
+				out = ptx.TxOut[in.Input.Vout]
+			}
+		} else {
+			out = common.BlockChain.Unspent.UnspentGet(&in.Input)
+		}
+		if out == nil {
+			return
+		}
+		totinp += out.Value
+	}
+	for _, out := range tx.TxOut {
+		totout += out.Value
+	}
+	if totout > totinp {
+		return
+	}
+	return totinp - totout, true
+}
+
+// processPackage tries each tx of the package on its own first.
+// The ones that do not pay enough (and their descendants) are then evaluated together.
+// Make sure to call it with TxMutex locked.
+func processPackage(pkg *PkgRcvd) (accepted []btc.BIDX) {
+	pkg.TxResults = make([]PkgTxResult, len(pkg.Txs))
+	if pkg.Result = checkPackageTopology(pkg.Txs); pkg.Result != 0 {
+		return
+	}
+
+	deferred := make(map[btc.BIDX]*btc.Tx)
+	var deferred_idx []int
+	for i, tx := range pkg.Txs {
+		bidx := tx.Hash.BIdx()
+		res := &pkg.TxResults[i]
+		if t2s, ok := TransactionsToSend[bidx]; ok {
+			res.Accepted = true
+			res.InMempool = true
+			res.Fee = t2s.Fee
+			continue
+		}
+		// It may be on the rejected list (e.g. as NO_TXOU), so remove it first
+		DeleteRejectedByIdx(bidx, false)
+		if spendsAnyOf(tx, deferred) {
+			res.Result = TX_REJECTED_LOW_FEE
+		} else {
+			r, t2s := processTx(&TxRcvd{Tx: tx, Trusted: pkg.Trusted, Local: pkg.Local})
+			if r == 0 {
+				res.Accepted = true
+				res.Fee = t2s.Fee
+				accepted = append(accepted, bidx)
+				continue
+			}
+			res.Result = r
+			if r != TX_REJECTED_LOW_FEE && r != TX_REJECTED_RBF_LOWFEE {
+				pkg.Result = PKG_REJECTED_TX
+				return
+			}
+			DeleteRejectedByIdx(bidx, false) // RBF_LOWFEE puts it on the rejected list
+		}
+		deferred[bidx] = tx
+		deferred_idx = append(deferred_idx, i)
+	}
+	if len(deferred_idx) == 0 {
+		common.CountSafe("TxPkgNotNeeded")
+		return
+	}
+
+	var totfee, totweight uint64
+	newtxs := make([]*OneTxToSend, 0, len(deferred_idx))
+	for _, i := range deferred_idx {
+		tx := pkg.Txs[i]
+		fee, ok := pkgTxFee(tx, deferred)
+		if !ok {
+			pkg.TxResults[i].Result = TX_REJECTED_NO_TXOU
+			pkg.Result = PKG_REJECTED_TX
+			return
+		}
+		pkg.TxResults[i].Fee = fee
+		totfee += fee
+		totweight += uint64(tx.Weight())
+		newtxs = append(newtxs, &OneTxToSend{Tx: tx, Fee: fee})
+	}
+	if !pkg.Local && 4000*totfee < totweight*common.MinFeePerKB() {
+		pkg.Result = PKG_REJECTED_LOW_FEE
+		return
+	}
+
+	// Package RBF - the package must pay for the conflicting txs (with their descendants) and improve the feerate diagram
+	rbf := make(map[*OneTxToSend]bool)
+	for _, i := range deferred_idx {
+		for _, in := range pkg.Txs[i].TxIn {
+			if so, ok := SpentOutputs[in.Input.UIdx()]; ok {
+				ctx := TransactionsToSend[so]
+				rbf[ctx] = true
+				for _, ch := range ctx.GetAllChildren() {
+					rbf[ch] = true
+				}
+			}
+		}
+	}
+	if len(rbf) > 0 {
+		if !pkg.Trusted {
+			if len(pkg.Txs) != 2 { // only parent with child can replace anything
+				pkg.Result = PKG_REJECTED_RBF
+				return
+			}
+			if len(rbf) > 100 {
+				pkg.Result = PKG_REJECTED_RBF
+				return
+			}
+			for ctx := range rbf {
+				if ctx.Final {
+					pkg.Result = PKG_REJECTED_RBF
+					return
+				}
+			}
+		}
+		if !pkg.Local && (!paysForRBF(rbf, newtxs) || !improvesDiagram(rbf, newtxs)) {
+			pkg.Result = PKG_REJECTED_RBF
+			return
+		}
+	}
+
+	// Remove the replaced txs (keeping them, in case we need to put them back)
+	var replaced []*OneTxToSend
+	for len(rbf) > 0 {
+		var ctx *OneTxToSend
+		for ctx = range rbf {
+			if ctx.HasNoChildren() {
+				break
+			}
+		}
+		ctx.Delete(false, 0)
+		delete(rbf, ctx)
+		replaced = append(replaced, ctx)
+	}
+
+	var added []*OneTxToSend
+	for _, i := range deferred_idx {
+		r, t2s := processTx(&TxRcvd{Tx: pkg.Txs[i], Trusted: pkg.Trusted, Local: pkg.Local, Package: true})
+		if r != 0 {
+			pkg.TxResults[i].Result = r
+			pkg.Result = PKG_REJECTED_TX
+			// roll back
+			for j := len(added) - 1; j >= 0; j-- {
+				added[j].Delete(false, 0)
+			}
+			for j := len(replaced) - 1; j >= 0; j-- {
+				replaced[j].Add(replaced[j].Hash.BIdx())
+			}
+			common.CountSafe("TxPkgRolledBack")
+			return
+		}
+		added = append(added, t2s)
+	}
+
+	for _, ctx := range replaced {
+		rejectTx(ctx.Tx, TX_REJECTED_REPLACED, nil)
+		pkg.Replaced = append(pkg.Replaced, &ctx.Hash)
+	}
+	for _, i := range deferred_idx {
+		pkg.TxResults[i].Result = 0
+		pkg.TxResults[i].Accepted = true
+		pkg.TxResults[i].Package = true
+		accepted = append(accepted, pkg.Txs[i].Hash.BIdx())
+	}
+	common.CountSafe("TxPkgAccepted")
+	return
+}
+
+// SubmitPackage processes the package, calling its FeedbackCB (if set) afterwards.
+// For network packages, it must be called from the chain's thread.
+// It returns zero if all the txs are in the mempool now, or the PKG_REJECTED_* reason.
+func SubmitPackage(pkg *PkgRcvd) byte {
+	TxMutex.Lock()
+	accepted := processPackage(pkg)
+	for _, bidx := range accepted {
+		txAccepted(bidx)
+	}
+	if len(accepted) > 0 {
+		removeExcessiveTxs()
+	}
+	TxMutex.Unlock()
+	common.CountSafePar("TxPkgResult-", pkg.Result)
+
+	if pkg.FeedbackCB != nil {
+		pkg.FeedbackCB(pkg)
+	}
+	return pkg.Result
+}
+
+// GetAncestorPackage returns the tx with all its unconfirmed ancestors, sorted (parents first).
+// Make sure to call it with TxMutex locked.
+func (t2s *OneTxToSend) GetAncestorPackage() (result []*OneTxToSend) {
+	done := make(map[*OneTxToSend]bool)
+	var add func(*OneTxToSend)
+	add = func(tx *OneTxToSend) {
+		done[tx] = true
+		for _, par := range tx.directParents() {
+			if !done[par] {
+				add(par)
+			}
+		}
+		result = append(result, tx)
+	}
+	add(t2s)
+	return
+}
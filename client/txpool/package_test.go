@@ -0,0 +1,221 @@
+package txpool
+
+import (
+	"crypto/sha256"
+	"os"
+	"testing"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
+	"github.com/piotrnar/gocoin/lib/script"
+	"github.com/piotrnar/gocoin/lib/utxo"
+)
+
+// testTrueP2WSH is P2WSH output script of OP_TRUE (spent with the witness of testTrueWitness).
+var (
+	testTrueWitness = []byte{btc.OP_TRUE}
+	testTrueP2WSH   = func() []byte {
+		h := sha256.Sum256(testTrueWitness)
+		return append([]byte{0, 32}, h[:]...)
+	}()
+)
+
+// testInitUTXO sets up the mempool with an empty UTXO database (with AllowMemInputs and 1 SPB minimum fee).
+func testInitUTXO(t *testing.T) {
+	testInitMempool()
+	common.CFG.TXPool.AllowMemInputs = true
+	common.CFG.TXPool.FeePerByte = 1
+	common.CFG.TXPool.MaxTxWeight = 400000
+	common.Reset()
+	common.UpdateScriptFlags(script.STANDARD_VERIFY_FLAGS)
+	db := utxo.NewUnspentDb(&utxo.NewUnspentOpts{Dir: t.TempDir() + string(os.PathSeparator), Rescan: true})
+	common.BlockChain = &chain.Chain{Unspent: db}
+	t.Cleanup(func() {
+		common.BlockChain = nil
+		db.Close()
+	})
+}
+
+// testConfirmed puts a made up confirmed tx with the given testTrueP2WSH outputs into the UTXO database.
+func testConfirmed(values ...uint64) (res []*btc.TxPrevOut) {
+	testTxCnt++
+	rec := &utxo.UtxoRec{TxID: btc.Sha2Sum([]byte{0xff, byte(testTxCnt), byte(testTxCnt >> 8)}), InBlock: 1}
+	for i, v := range values {
+		rec.Outs = append(rec.Outs, &utxo.UtxoTxOut{PKScr: testTrueP2WSH, Value: v})
+		res = append(res, &btc.TxPrevOut{Hash: rec.TxID, Vout: uint32(i)})
+	}
+	common.BlockChain.Unspent.CommitBlockTxs(&utxo.BlockChanges{AddList: []*utxo.UtxoRec{rec}, Height: 1}, rec.TxID[:])
+	return
+}
+
+// testSpendTx returns a tx spending the given testTrueP2WSH outputs to new testTrueP2WSH outputs.
+func testSpendTx(ins []*btc.TxPrevOut, values ...uint64) *btc.Tx {
+	tx := &btc.Tx{Version: 2}
+	for _, in := range ins {
+		tx.TxIn = append(tx.TxIn, &btc.TxIn{Input: *in, Sequence: 0xfffffffd})
+		tx.SegWit = append(tx.SegWit, [][]byte{testTrueWitness})
+	}
+	for _, v := range values {
+		tx.TxOut = append(tx.TxOut, &btc.TxOut{Value: v, Pk_script: testTrueP2WSH})
+	}
+	raw := tx.SerializeNew()
+	tx, _ = btc.NewTx(raw)
+	tx.SetHash(raw)
+	return tx
+}
+
+func testOutOf(tx *btc.Tx, vout uint32) []*btc.TxPrevOut {
+	return []*btc.TxPrevOut{{Hash: tx.Hash.Hash, Vout: vout}}
+}
+
+func testInMempool(txs ...*btc.Tx) bool {
+	for _, tx := range txs {
+		if _, ok := TransactionsToSend[tx.Hash.BIdx()]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPackageTopology(t *testing.T) {
+	testInitMempool()
+	p1 := testTx(2, 0, nil, nil, testOuts(2)...)
+	p2 := testTx(2, 0, nil, nil)
+	child := testTx(2, 0, []*OneTxToSend{p1, p2}, []uint32{0, 0})
+	other := testTx(2, 0, nil, nil)
+	grandchild := testTx(2, 0, []*OneTxToSend{child}, []uint32{0})
+	conflict := testTx(2, 0, []*OneTxToSend{p1, p2}, []uint32{0, 0}, testOut(5000, 22))
+	var tooMany []*btc.Tx
+	for i := 0; i < MAX_PACKAGE_COUNT; i++ {
+		tooMany = append(tooMany, testTx(2, 0, nil, nil).Tx)
+	}
+	tooMany = append(tooMany, testTx(2, 0, nil, nil).Tx)
+
+	tests := []struct {
+		name   string
+		txs    []*btc.Tx
+		result byte
+	}{
+		{"child with parents", []*btc.Tx{p1.Tx, p2.Tx, child.Tx}, 0},
+		{"child with one of its parents", []*btc.Tx{p2.Tx, child.Tx}, 0},
+		{"single tx", []*btc.Tx{p1.Tx}, 0},
+		{"empty", nil, PKG_REJECTED_NOT_CHILD_PRNT},
+		{"not sorted", []*btc.Tx{child.Tx, p1.Tx}, PKG_REJECTED_NOT_SORTED},
+		{"unrelated tx", []*btc.Tx{p1.Tx, other.Tx, child.Tx}, PKG_REJECTED_NOT_CHILD_PRNT},
+		{"grandparent", []*btc.Tx{p1.Tx, child.Tx, grandchild.Tx}, PKG_REJECTED_NOT_CHILD_PRNT},
+		{"duplicate", []*btc.Tx{p1.Tx, p1.Tx, child.Tx}, PKG_REJECTED_DUPLICATE},
+		{"conflict", []*btc.Tx{p1.Tx, p2.Tx, child.Tx, conflict.Tx}, PKG_REJECTED_CONFLICT},
+		{"too many", tooMany, PKG_REJECTED_TOO_MANY_TXS},
+		{"too large", []*btc.Tx{testTx(2, 0, nil, nil, testOut(0, MAX_PACKAGE_WEIGHT/4)).Tx}, PKG_REJECTED_TOO_LARGE},
+	}
+	for _, tt := range tests {
+		if r := checkPackageTopology(tt.txs); r != tt.result {
+			t.Error(tt.name+":", PkgReasonToString(r), "- expected", PkgReasonToString(tt.result))
+		}
+	}
+}
+
+func TestPackageCPFP(t *testing.T) {
+	testInitUTXO(t)
+	in := testConfirmed(100000)
+	par := testSpendTx(in, 100000) // zero fee
+	child := testSpendTx(testOutOf(par, 0), 90000)
+
+	// the parent alone does not pay enough
+	TxMutex.Lock()
+	r, _ := processTx(&TxRcvd{Tx: par})
+	TxMutex.Unlock()
+	if r != TX_REJECTED_LOW_FEE {
+		t.Fatal("Zero fee parent:", ReasonToString(r))
+	}
+
+	// neither does the package with a child paying too little
+	poor := testSpendTx(testOutOf(par, 0), 100000-uint64(par.VSize()))
+	if r := SubmitPackage(&PkgRcvd{Txs: []*btc.Tx{par, poor}}); r != PKG_REJECTED_LOW_FEE || testInMempool(par) || testInMempool(poor) {
+		t.Error("Low fee package:", PkgReasonToString(r))
+	}
+
+	pkg := &PkgRcvd{Txs: []*btc.Tx{par, child}}
+	if r := SubmitPackage(pkg); r != 0 {
+		t.Fatal("CPFP package:", PkgReasonToString(r), pkg.TxResults)
+	}
+	if !testInMempool(par, child) || !pkg.TxResults[0].Package || !pkg.TxResults[1].Package || pkg.TxResults[1].Fee != 10000 {
+		t.Error("Bad package result", pkg.TxResults)
+	}
+	if MempoolCheck() {
+		t.Error("Mempool broken after the package")
+	}
+
+	// the same package again
+	if r := SubmitPackage(pkg); r != 0 || !pkg.TxResults[0].InMempool || !pkg.TxResults[1].InMempool {
+		t.Error("Package already in mempool:", PkgReasonToString(r), pkg.TxResults)
+	}
+}
+
+func TestPackageNotNeeded(t *testing.T) {
+	testInitUTXO(t)
+	par := testSpendTx(testConfirmed(100000), 95000)
+	child := testSpendTx(testOutOf(par, 0), 90000)
+	pkg := &PkgRcvd{Txs: []*btc.Tx{par, child}}
+	if r := SubmitPackage(pkg); r != 0 || !testInMempool(par, child) {
+		t.Fatal("Package:", PkgReasonToString(r), pkg.TxResults)
+	}
+	if pkg.TxResults[0].Package || pkg.TxResults[1].Package {
+		t.Error("Txs paying enough accepted as package", pkg.TxResults)
+	}
+
+	// a tx failing on its own fails the whole package
+	bad := testSpendTx(testConfirmed(1000), 2000)
+	if r := SubmitPackage(&PkgRcvd{Txs: []*btc.Tx{bad}}); r != PKG_REJECTED_TX {
+		t.Error("Overspending package:", PkgReasonToString(r))
+	}
+}
+
+func TestPackageRollback(t *testing.T) {
+	testInitUTXO(t)
+	in := testConfirmed(100000)
+
+	// the txs that the package is going to replace
+	old := testSpendTx(in, 99000)
+	oldChild := testSpendTx(testOutOf(old, 0), 98000)
+	TxMutex.Lock()
+	r1, _ := processTx(&TxRcvd{Tx: old})
+	r2, _ := processTx(&TxRcvd{Tx: oldChild})
+	TxMutex.Unlock()
+	if r1 != 0 || r2 != 0 {
+		t.Fatal("Txs to replace:", ReasonToString(r1), ReasonToString(r2))
+	}
+
+	// zero fee parent, with a child paying for both, but failing its script
+	par := testSpendTx(in, 100000)
+	child := testSpendTx(testOutOf(par, 0), 50000)
+	child.SegWit[0][0] = []byte{btc.OP_FALSE}
+	raw := child.SerializeNew()
+	child, _ = btc.NewTx(raw)
+	child.SetHash(raw)
+
+	pkg := &PkgRcvd{Txs: []*btc.Tx{par, child}}
+	if r := SubmitPackage(pkg); r != PKG_REJECTED_TX || pkg.TxResults[1].Result != TX_REJECTED_SCRIPT_FAIL {
+		t.Fatal("Package with bad child:", PkgReasonToString(r), pkg.TxResults)
+	}
+	if testInMempool(par) || testInMempool(child) || !testInMempool(old, oldChild) {
+		t.Error("Mempool not rolled back")
+	}
+	if MempoolCheck() {
+		t.Error("Mempool broken after the roll back")
+	}
+	if old := TransactionsToSend[oldChild.Hash.BIdx()]; old.Ancestors.Count != 2 || old.Ancestors.Fee != 2000 {
+		t.Error("Bad ancestry after the roll back", old.Ancestors)
+	}
+
+	// the same package with a good child replaces both
+	child = testSpendTx(testOutOf(par, 0), 50000)
+	pkg = &PkgRcvd{Txs: []*btc.Tx{par, child}}
+	if r := SubmitPackage(pkg); r != 0 || len(pkg.Replaced) != 2 {
+		t.Fatal("Package RBF:", PkgReasonToString(r), pkg.TxResults)
+	}
+	if !testInMempool(par, child) || testInMempool(old) || testInMempool(oldChild) || MempoolCheck() {
+		t.Error("Bad mempool after package RBF")
+	}
+}
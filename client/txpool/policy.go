@@ -0,0 +1,288 @@
+package txpool
+
+import (
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/script"
+)
+
+// Relay standardness policy - the same default limits as in Bitcoin Core.
+// It only applies to txs received from the network (not the local or unmined ones).
+
+const (
+	MAX_STANDARD_TX_VERSION        = TRUC_VERSION
+	MIN_STANDARD_TX_NONWITNESS     = 65
+	MAX_STANDARD_SCRIPTSIG_SIZE    = 1650
+	MAX_STANDARD_TX_SIGOPS_COST    = 16000
+	MAX_P2SH_SIGOPS                = 15
+	MAX_STANDARD_P2WSH_STACK_ITEMS = 100
+	MAX_STANDARD_P2WSH_STACK_ITEM  = 80
+	MAX_STANDARD_P2WSH_SCRIPT_SIZE = 3600
+	MAX_STANDARD_TAPSCRIPT_ITEM    = 80
+	TAPROOT_LEAF_TAPSCRIPT         = 0xc0
+	ANNEX_TAG                      = 0x50
+	MAX_DUST_OUTPUTS_PER_TX        = 1 // ephemeral dust - only allowed in zero-fee txs
+)
+
+const (
+	SCRIPT_NONSTANDARD = iota
+	SCRIPT_P2PK
+	SCRIPT_P2PKH
+	SCRIPT_P2SH
+	SCRIPT_MULTISIG
+	SCRIPT_NULL_DATA
+	SCRIPT_P2WPKH
+	SCRIPT_P2WSH
+	SCRIPT_P2TR
+	SCRIPT_ANCHOR
+	SCRIPT_WITNESS_UNKNOWN
+)
+
+// ScriptType returns the type of the output script, as Core's Solver() sees it.
+func ScriptType(scr []byte) int {
+	switch {
+	case script.IsP2KH(scr):
+		return SCRIPT_P2PKH
+	case btc.IsP2SH(scr):
+		return SCRIPT_P2SH
+	case len(scr) > 0 && scr[0] == btc.OP_RETURN && btc.IsPushOnly(scr[1:]):
+		return SCRIPT_NULL_DATA
+	case isPubKeyPush(scr) && len(scr) == int(scr[0])+2 && scr[len(scr)-1] == btc.OP_CHECKSIG:
+		return SCRIPT_P2PK
+	}
+	if ver, prog := btc.IsWitnessProgram(scr); prog != nil {
+		switch {
+		case ver == 0 && len(prog) == 20:
+			return SCRIPT_P2WPKH
+		case ver == 0 && len(prog) == 32:
+			return SCRIPT_P2WSH
+		case ver == 1 && len(prog) == 32:
+			return SCRIPT_P2TR
+		case ver == 1 && len(prog) == 2 && prog[0] == 0x4e && prog[1] == 0x73:
+			return SCRIPT_ANCHOR
+		case ver != 0:
+			return SCRIPT_WITNESS_UNKNOWN
+		}
+		return SCRIPT_NONSTANDARD
+	}
+	if _, keys := multisigParams(scr); keys > 0 {
+		return SCRIPT_MULTISIG
+	}
+	return SCRIPT_NONSTANDARD
+}
+
+func isPubKeyPush(scr []byte) bool {
+	return len(scr) > 33 && (scr[0] == 33 && (scr[1] == 0x02 || scr[1] == 0x03) ||
+		scr[0] == 65 && scr[1] == 0x04 && len(scr) > 65)
+}
+
+// multisigParams returns the parameters of a bare multisig script (or zeros if it is not one).
+func multisigParams(scr []byte) (sigs, keys int) {
+	if len(scr) < 3 || scr[len(scr)-1] != btc.OP_CHECKMULTISIG ||
+		scr[0] < btc.OP_1 || scr[0] > btc.OP_16 || scr[len(scr)-2] < btc.OP_1 || scr[len(scr)-2] > btc.OP_16 {
+		return
+	}
+	var cnt int
+	for pc := 1; pc < len(scr)-2; {
+		if !isPubKeyPush(scr[pc:]) {
+			return
+		}
+		pc += int(scr[pc]) + 1
+		if pc > len(scr)-2 {
+			return
+		}
+		cnt++
+	}
+	m, n := btc.DecodeOP_N(scr[0]), btc.DecodeOP_N(scr[len(scr)-2])
+	if n != cnt || m > n {
+		return
+	}
+	return m, n
+}
+
+// DustThreshold returns the minimum value of the output, for it not to be considered dust.
+func DustThreshold(out *btc.TxOut) uint64 {
+	if len(out.Pk_script) > 0 && out.Pk_script[0] == btc.OP_RETURN {
+		return 0 // unspendable
+	}
+	// The size of the output, plus the size of an input that would be spending it
+	size := 8 + btc.VLenSize(uint64(len(out.Pk_script))) + len(out.Pk_script)
+	if _, prog := btc.IsWitnessProgram(out.Pk_script); prog != nil {
+		size += 32 + 4 + 1 + 107/btc.WITNESS_SCALE_FACTOR + 4
+	} else {
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return uint64(size) * common.DustRelayFeePerKB() / 1000
+}
+
+// isStandardTx checks the tx itself, without looking at the outputs it spends.
+func isStandardTx(tx *btc.Tx) byte {
+	if tx.Version < 1 || tx.Version > MAX_STANDARD_TX_VERSION {
+		return TX_REJECTED_VERSION
+	}
+
+	if tx.NoWitSize < MIN_STANDARD_TX_NONWITNESS {
+		return TX_REJECTED_TX_SIZE_SMALL
+	}
+
+	for _, in := range tx.TxIn {
+		if len(in.ScriptSig) > MAX_STANDARD_SCRIPTSIG_SIZE {
+			return TX_REJECTED_SCRIPTSIG_SIZE
+		}
+		if !btc.IsPushOnly(in.ScriptSig) {
+			return TX_REJECTED_SCRIPTSIG_PUSHONLY
+		}
+	}
+
+	var datacarrier_bytes uint32
+	var dust_cnt int
+	for _, out := range tx.TxOut {
+		switch ScriptType(out.Pk_script) {
+		case SCRIPT_NONSTANDARD:
+			return TX_REJECTED_SCRIPTPUBKEY
+
+		case SCRIPT_NULL_DATA:
+			if !common.Get(&common.CFG.TXPool.DataCarrier) {
+				return TX_REJECTED_DATACARRIER
+			}
+			datacarrier_bytes += uint32(len(out.Pk_script))
+			continue
+
+		case SCRIPT_MULTISIG:
+			if !common.Get(&common.CFG.TXPool.BareMultisig) {
+				return TX_REJECTED_BARE_MULTISIG
+			}
+			if _, keys := multisigParams(out.Pk_script); keys > 3 {
+				return TX_REJECTED_SCRIPTPUBKEY
+			}
+		}
+		if out.Value < DustThreshold(out) {
+			dust_cnt++
+		}
+	}
+	if dust_cnt > MAX_DUST_OUTPUTS_PER_TX {
+		return TX_REJECTED_DUST
+	}
+	if datacarrier_bytes > common.Get(&common.CFG.TXPool.DataCarrierLen) {
+		return TX_REJECTED_DATACARRIER
+	}
+	return 0
+}
+
+// dustOutputs returns indexes of the tx's outputs that are below the dust threshold.
+func dustOutputs(tx *btc.Tx) (res []int) {
+	for i, out := range tx.TxOut {
+		if out.Value < DustThreshold(out) {
+			res = append(res, i)
+		}
+	}
+	return
+}
+
+// checkEphemeralSpends makes sure that the new tx spends all the dust outputs of its unconfirmed parents.
+// A dust output can only be created by a zero-fee tx (so it has to come with a child paying for it)
+// and the child must not leave it unspent.
+func checkEphemeralSpends(t2s *OneTxToSend) byte {
+	for _, par := range t2s.directParents() {
+		for _, vout := range dustOutputs(par.Tx) {
+			var spent bool
+			for _, in := range t2s.TxIn {
+				if in.Input.Vout == uint32(vout) && in.Input.Hash == par.Hash.Hash {
+					spent = true
+					break
+				}
+			}
+			if !spent {
+				return TX_REJECTED_EPHEMERAL_SPENDS
+			}
+		}
+	}
+	return 0
+}
+
+// lastPush returns the data of the last push from the (push only) script.
+func lastPush(scr []byte) (data []byte, ok bool) {
+	for pc := 0; pc < len(scr); {
+		_, d, le, e := btc.GetOpcode(scr[pc:])
+		if e != nil {
+			return nil, false
+		}
+		data = d
+		ok = true
+		pc += le
+	}
+	return
+}
+
+// areInputsStandard checks the types of the outputs being spent, and P2SH redeem scripts' sigops.
+func areInputsStandard(tx *btc.Tx, pos []*btc.TxOut) byte {
+	for i, in := range tx.TxIn {
+		switch ScriptType(pos[i].Pk_script) {
+		case SCRIPT_NONSTANDARD, SCRIPT_WITNESS_UNKNOWN:
+			return TX_REJECTED_NONSTD_INPUTS
+
+		case SCRIPT_P2SH:
+			redeem, ok := lastPush(in.ScriptSig)
+			if !ok || btc.GetSigOpCount(redeem, true) > MAX_P2SH_SIGOPS {
+				return TX_REJECTED_NONSTD_INPUTS
+			}
+		}
+	}
+	return 0
+}
+
+// isWitnessStandard checks the sizes of the witness stack items.
+func isWitnessStandard(tx *btc.Tx, pos []*btc.TxOut) byte {
+	if tx.SegWit == nil {
+		return 0
+	}
+	for i, in := range tx.TxIn {
+		wit := tx.SegWit[i]
+		if len(wit) == 0 {
+			continue
+		}
+
+		prev := pos[i].Pk_script
+		p2sh := btc.IsP2SH(prev)
+		if p2sh {
+			var ok bool
+			if prev, ok = lastPush(in.ScriptSig); !ok {
+				return TX_REJECTED_NONSTD_WITNESS
+			}
+		}
+
+		ver, prog := btc.IsWitnessProgram(prev)
+		if prog == nil {
+			return TX_REJECTED_NONSTD_WITNESS // non-witness program must not have witness data
+		}
+
+		switch {
+		case ver == 0 && len(prog) == 32: // P2WSH
+			if len(wit[len(wit)-1]) > MAX_STANDARD_P2WSH_SCRIPT_SIZE || len(wit)-1 > MAX_STANDARD_P2WSH_STACK_ITEMS {
+				return TX_REJECTED_NONSTD_WITNESS
+			}
+			for _, item := range wit[:len(wit)-1] {
+				if len(item) > MAX_STANDARD_P2WSH_STACK_ITEM {
+					return TX_REJECTED_NONSTD_WITNESS
+				}
+			}
+
+		case ver == 1 && len(prog) == 32 && !p2sh: // P2TR
+			if len(wit) >= 2 && len(wit[len(wit)-1]) > 0 && wit[len(wit)-1][0] == ANNEX_TAG {
+				return TX_REJECTED_NONSTD_WITNESS // annex is reserved for future extensions
+			}
+			if len(wit) >= 2 && len(wit[len(wit)-1]) > 0 && wit[len(wit)-1][0]&0xfe == TAPROOT_LEAF_TAPSCRIPT {
+				// script path spend: the last item is control block, the one before it is tapscript
+				for _, item := range wit[:len(wit)-2] {
+					if len(item) > MAX_STANDARD_TAPSCRIPT_ITEM {
+						return TX_REJECTED_NONSTD_WITNESS
+					}
+				}
+			}
+
+		case ver == 1 && len(prog) == 2 && !p2sh && prog[0] == 0x4e && prog[1] == 0x73: // P2A
+			return TX_REJECTED_NONSTD_WITNESS // anchor must be spent with empty witness
+		}
+	}
+	return 0
+}
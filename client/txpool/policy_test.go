@@ -0,0 +1,198 @@
+package txpool
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+func testScript(prefix []byte, size int, suffix ...byte) []byte {
+	return append(append(append([]byte{}, prefix...), make([]byte, size)...), suffix...)
+}
+
+var (
+	testP2PKH  = testScript([]byte{0x76 /*OP_DUP*/, btc.OP_HASH160, 20}, 20, 0x88 /*OP_EQUALVERIFY*/, btc.OP_CHECKSIG)
+	testP2SH   = testScript([]byte{btc.OP_HASH160, 20}, 20, btc.OP_EQUAL)
+	testP2WPKH = testScript([]byte{0, 20}, 20)
+	testP2WSH  = testScript([]byte{0, 32}, 32)
+	testP2TR   = testScript([]byte{btc.OP_1, 32}, 32)
+	testP2A    = []byte{btc.OP_1, 2, 0x4e, 0x73}
+	testPubKey = testScript([]byte{0x02}, 32)
+)
+
+// testMultisig returns bare m-of-n multisig script.
+func testMultisig(m, n int) []byte {
+	scr := []byte{byte(btc.OP_1 - 1 + m)}
+	for i := 0; i < n; i++ {
+		scr = append(append(scr, 33), testPubKey...)
+	}
+	return append(scr, byte(btc.OP_1-1+n), btc.OP_CHECKMULTISIG)
+}
+
+func testPolicyConfig() {
+	testInitMempool()
+	common.CFG.TXPool.DataCarrier = true
+	common.CFG.TXPool.DataCarrierLen = 83
+	common.CFG.TXPool.BareMultisig = true
+}
+
+func TestDustThreshold(t *testing.T) {
+	testPolicyConfig()
+	// the values of Core's default dustrelayfee (3000 sat/kvB)
+	tests := []struct {
+		name string
+		scr  []byte
+		dust uint64
+	}{
+		{"P2PKH", testP2PKH, 546},
+		{"P2SH", testP2SH, 540},
+		{"P2PK", append(append([]byte{33}, testPubKey...), btc.OP_CHECKSIG), 576},
+		{"P2WPKH", testP2WPKH, 294},
+		{"P2WSH", testP2WSH, 330},
+		{"P2TR", testP2TR, 330},
+		{"P2A", testP2A, 240},
+		{"OP_RETURN", []byte{btc.OP_RETURN, 1, 1}, 0},
+	}
+	for _, tt := range tests {
+		out := &btc.TxOut{Pk_script: tt.scr}
+		if d := DustThreshold(out); d != tt.dust {
+			t.Error(tt.name, "dust threshold", d, "- expected", tt.dust)
+			continue
+		}
+		if tt.dust == 0 {
+			continue
+		}
+		// one dust output is allowed (ephemeral dust), two are not
+		if r := isStandardTx(testTx(2, 0, nil, nil, &btc.TxOut{Value: tt.dust, Pk_script: tt.scr},
+			&btc.TxOut{Value: tt.dust, Pk_script: tt.scr}).Tx); r != 0 {
+			t.Error(tt.name, "outputs at dust threshold:", ReasonToString(r))
+		}
+		if r := isStandardTx(testTx(2, 0, nil, nil, &btc.TxOut{Value: tt.dust - 1, Pk_script: tt.scr},
+			&btc.TxOut{Value: tt.dust - 1, Pk_script: tt.scr}).Tx); r != TX_REJECTED_DUST {
+			t.Error(tt.name, "outputs below dust threshold:", ReasonToString(r))
+		}
+	}
+}
+
+func TestStandardOutputs(t *testing.T) {
+	testPolicyConfig()
+	tests := []struct {
+		name   string
+		outs   []*btc.TxOut
+		reason byte
+	}{
+		{"OP_RETURN at limit", []*btc.TxOut{testOut(0, 83)}, 0},
+		{"OP_RETURN over limit", []*btc.TxOut{testOut(0, 84)}, TX_REJECTED_DATACARRIER},
+		{"two OP_RETURNs at limit", []*btc.TxOut{testOut(0, 40), testOut(0, 43)}, 0},
+		{"two OP_RETURNs over limit", []*btc.TxOut{testOut(0, 40), testOut(0, 44)}, TX_REJECTED_DATACARRIER},
+		{"OP_RETURN not push only", []*btc.TxOut{testOut(10000, 22), {Pk_script: []byte{btc.OP_RETURN, 0x61 /*OP_NOP*/}}}, TX_REJECTED_SCRIPTPUBKEY},
+		{"1-of-3 multisig", []*btc.TxOut{{Value: 10000, Pk_script: testMultisig(1, 3)}}, 0},
+		{"3-of-3 multisig", []*btc.TxOut{{Value: 10000, Pk_script: testMultisig(3, 3)}}, 0},
+		{"1-of-4 multisig", []*btc.TxOut{{Value: 10000, Pk_script: testMultisig(1, 4)}}, TX_REJECTED_SCRIPTPUBKEY},
+		{"4-of-3 multisig", []*btc.TxOut{{Value: 10000, Pk_script: testMultisig(4, 3)}}, TX_REJECTED_SCRIPTPUBKEY},
+		{"unknown witness version", []*btc.TxOut{{Value: 10000, Pk_script: testScript([]byte{btc.OP_2, 32}, 32)}}, 0},
+		{"v0 witness of bad length", []*btc.TxOut{{Value: 10000, Pk_script: testScript([]byte{0, 21}, 21)}}, TX_REJECTED_SCRIPTPUBKEY},
+		{"OP_TRUE", []*btc.TxOut{testOut(10000, 22), {Value: 10000, Pk_script: []byte{btc.OP_1}}}, TX_REJECTED_SCRIPTPUBKEY},
+	}
+	for _, tt := range tests {
+		if r := isStandardTx(testTx(2, 0, nil, nil, tt.outs...).Tx); r != tt.reason {
+			t.Error(tt.name+":", ReasonToString(r), "- expected", ReasonToString(tt.reason))
+		}
+	}
+
+	common.CFG.TXPool.DataCarrier = false
+	if r := isStandardTx(testTx(2, 0, nil, nil, testOut(0, 10)).Tx); r != TX_REJECTED_DATACARRIER {
+		t.Error("OP_RETURN with DataCarrier off:", ReasonToString(r))
+	}
+	common.CFG.TXPool.BareMultisig = false
+	if r := isStandardTx(testTx(2, 0, nil, nil, &btc.TxOut{Value: 10000, Pk_script: testMultisig(1, 1)}).Tx); r != TX_REJECTED_BARE_MULTISIG {
+		t.Error("Multisig with BareMultisig off:", ReasonToString(r))
+	}
+}
+
+func TestStandardTx(t *testing.T) {
+	testPolicyConfig()
+	tx := testTx(2, 0, nil, nil).Tx
+	tx.TxIn[0].ScriptSig = make([]byte, MAX_STANDARD_SCRIPTSIG_SIZE+1)
+	if r := isStandardTx(tx); r != TX_REJECTED_SCRIPTSIG_SIZE {
+		t.Error("Too big scriptSig:", ReasonToString(r))
+	}
+	tx.TxIn[0].ScriptSig = []byte{btc.OP_1, 0x75 /*OP_DROP*/}
+	if r := isStandardTx(tx); r != TX_REJECTED_SCRIPTSIG_PUSHONLY {
+		t.Error("Not push only scriptSig:", ReasonToString(r))
+	}
+	if r := isStandardTx(testTx(4, 0, nil, nil).Tx); r != TX_REJECTED_VERSION {
+		t.Error("Version 4:", ReasonToString(r))
+	}
+	if r := isStandardTx(testTx(2, 0, nil, nil, testOut(0, 1)).Tx); r != TX_REJECTED_TX_SIZE_SMALL {
+		t.Error("Too small tx:", ReasonToString(r))
+	}
+}
+
+func TestStandardWitness(t *testing.T) {
+	testPolicyConfig()
+	item := func(size int) []byte { return bytes.Repeat([]byte{1}, size) }
+	items := func(cnt, size int, last []byte) (res [][]byte) {
+		for i := 0; i < cnt; i++ {
+			res = append(res, item(size))
+		}
+		return append(res, last)
+	}
+	p2shP2WSH := append([]byte{34}, testP2WSH...)
+	tests := []struct {
+		name      string
+		prev      []byte
+		scriptSig []byte
+		witness   [][]byte
+		reason    byte
+	}{
+		{"P2WPKH", testP2WPKH, nil, [][]byte{item(72), item(33)}, 0},
+		{"witness of P2PKH", testP2PKH, nil, [][]byte{item(72)}, TX_REJECTED_NONSTD_WITNESS},
+		{"P2WSH at limits", testP2WSH, nil, items(MAX_STANDARD_P2WSH_STACK_ITEMS, MAX_STANDARD_P2WSH_STACK_ITEM, item(MAX_STANDARD_P2WSH_SCRIPT_SIZE)), 0},
+		{"P2WSH script too big", testP2WSH, nil, items(1, 72, item(MAX_STANDARD_P2WSH_SCRIPT_SIZE+1)), TX_REJECTED_NONSTD_WITNESS},
+		{"P2WSH too many items", testP2WSH, nil, items(MAX_STANDARD_P2WSH_STACK_ITEMS+1, 1, item(10)), TX_REJECTED_NONSTD_WITNESS},
+		{"P2WSH item too big", testP2WSH, nil, items(1, MAX_STANDARD_P2WSH_STACK_ITEM+1, item(10)), TX_REJECTED_NONSTD_WITNESS},
+		{"P2SH-P2WSH item too big", testP2SH, p2shP2WSH, items(1, MAX_STANDARD_P2WSH_STACK_ITEM+1, item(10)), TX_REJECTED_NONSTD_WITNESS},
+		{"P2SH-P2WSH", testP2SH, p2shP2WSH, items(2, 72, item(100)), 0},
+		{"P2TR key path", testP2TR, nil, [][]byte{item(64)}, 0},
+		{"P2TR annex", testP2TR, nil, [][]byte{item(64), {ANNEX_TAG, 1}}, TX_REJECTED_NONSTD_WITNESS},
+		{"P2TR tapscript", testP2TR, nil, [][]byte{item(MAX_STANDARD_TAPSCRIPT_ITEM), item(200), append([]byte{TAPROOT_LEAF_TAPSCRIPT}, item(32)...)}, 0},
+		{"P2TR tapscript item too big", testP2TR, nil, [][]byte{item(MAX_STANDARD_TAPSCRIPT_ITEM + 1), item(10), append([]byte{TAPROOT_LEAF_TAPSCRIPT}, item(32)...)}, TX_REJECTED_NONSTD_WITNESS},
+		{"P2A with witness", testP2A, nil, [][]byte{item(1)}, TX_REJECTED_NONSTD_WITNESS},
+	}
+	for _, tt := range tests {
+		tx := testTx(2, 0, nil, nil).Tx
+		tx.TxIn[0].ScriptSig = tt.scriptSig
+		tx.SegWit = [][][]byte{tt.witness}
+		if r := isWitnessStandard(tx, []*btc.TxOut{{Value: 10000, Pk_script: tt.prev}}); r != tt.reason {
+			t.Error(tt.name+":", ReasonToString(r), "- expected", ReasonToString(tt.reason))
+		}
+	}
+}
+
+func TestStandardInputs(t *testing.T) {
+	testPolicyConfig()
+	tests := []struct {
+		name      string
+		prev      []byte
+		scriptSig []byte
+		reason    byte
+	}{
+		{"P2PKH", testP2PKH, nil, 0},
+		{"P2A", testP2A, nil, 0},
+		{"unknown witness version", testScript([]byte{btc.OP_2, 32}, 32), nil, TX_REJECTED_NONSTD_INPUTS},
+		{"non-standard", []byte{btc.OP_1}, nil, TX_REJECTED_NONSTD_INPUTS},
+		{"P2SH 15 sigops", testP2SH, append([]byte{byte(len(testMultisig(1, 15)))}, testMultisig(1, 15)...), 0},
+		{"P2SH 16 sigops", testP2SH, append([]byte{16}, bytes.Repeat([]byte{btc.OP_CHECKSIG}, 16)...), TX_REJECTED_NONSTD_INPUTS},
+		{"P2SH without redeem script", testP2SH, nil, TX_REJECTED_NONSTD_INPUTS},
+	}
+	for _, tt := range tests {
+		tx := testTx(2, 0, nil, nil).Tx
+		tx.TxIn[0].ScriptSig = tt.scriptSig
+		if r := areInputsStandard(tx, []*btc.TxOut{{Value: 10000, Pk_script: tt.prev}}); r != tt.reason {
+			t.Error(tt.name+":", ReasonToString(r), "- expected", ReasonToString(tt.reason))
+		}
+	}
+}
@@ -28,6 +28,10 @@ var (
 	// Inputs that are being used by TransactionsRejected
 	// Each record points to one TransactionsRejected with Reason of 200 or more
 	RejectedSpentOutputs map[uint64][]btc.BIDX = make(map[uint64][]btc.BIDX)
+
+	// Wtxid index of TransactionsRejected (BIP339), pointing to txid.
+	// Same as WTxIDToSend, it only has the records where wtxid is different than txid.
+	WTxIDRejected map[btc.BIDX]btc.BIDX = make(map[btc.BIDX]btc.BIDX)
 )
 
 type OneTxRejected struct {
@@ -35,6 +39,7 @@ type OneTxRejected struct {
 	Waiting4 *btc.Uint256
 	*btc.Tx
 	Id        btc.Uint256
+	WIdx      btc.BIDX // wtxid index (same as Id.BIdx() for non-segwit txs)
 	Size      uint32
 	Footprint uint32
 	ArrIndex  uint16
@@ -55,6 +60,20 @@ const (
 	TX_REJECTED_LEN_MISMATCH = 103
 	TX_REJECTED_EMPTY_INPUT  = 104
 
+	// Standardness policy (see policy.go)
+	TX_REJECTED_VERSION            = 110
+	TX_REJECTED_TX_SIZE_SMALL      = 111
+	TX_REJECTED_SCRIPTSIG_SIZE     = 112
+	TX_REJECTED_SCRIPTSIG_PUSHONLY = 113
+	TX_REJECTED_SCRIPTPUBKEY       = 114
+	TX_REJECTED_DUST               = 115
+	TX_REJECTED_DATACARRIER        = 116
+	TX_REJECTED_BARE_MULTISIG      = 117
+	TX_REJECTED_NONSTD_INPUTS      = 118
+	TX_REJECTED_NONSTD_WITNESS     = 119
+	TX_REJECTED_SIGOPS             = 120
+	TX_REJECTED_EPHEMERAL_FEE      = 121
+
 	TX_REJECTED_OVERSPEND   = 154
 	TX_REJECTED_BAD_INPUT   = 157
 	TX_REJECTED_SCRIPT_FAIL = 158
@@ -71,6 +90,17 @@ const (
 	TX_REJECTED_RBF_FINAL   = 211
 	TX_REJECTED_RBF_100     = 212
 	TX_REJECTED_REPLACED    = 213
+	TX_REJECTED_ANCESTORS   = 214
+	TX_REJECTED_DESCENDANTS = 215
+
+	// TRUC (BIP431) and ephemeral dust rules (see truc.go and policy.go)
+	TX_REJECTED_TRUC_INHERIT     = 216
+	TX_REJECTED_TRUC_SIZE        = 217
+	TX_REJECTED_TRUC_ANCESTORS   = 218
+	TX_REJECTED_TRUC_DESCENDANTS = 219
+	TX_REJECTED_EPHEMERAL_SPENDS = 220
+
+	TX_REJECTED_CLUSTER = 221 // see cluster.go
 )
 
 func TRIdxNext(idx int) int {
@@ -114,6 +144,9 @@ func (txr *OneTxRejected) Add() {
 	txr.ArrIndex = uint16(TRIdxHead)
 	TRIdxArray[TRIdxHead] = bidx
 	TransactionsRejected[bidx] = txr
+	if txr.WIdx != bidx {
+		WTxIDRejected[txr.WIdx] = bidx
+	}
 	TRIdxHead = TRIdxNext(TRIdxHead)
 	if TRIdxHead == TRIdxTail {
 		// we're touching the tail
@@ -175,6 +208,7 @@ func (txr *OneTxRejected) Delete() {
 
 	}
 	delete(TransactionsRejected, txr.Id.BIdx())
+	delete(WTxIDRejected, txr.WIdx)
 }
 
 // Make sure to call it with locked TxMutex
@@ -253,6 +287,7 @@ func RejectTx(tx *btc.Tx, why byte, missingid *btc.Uint256) {
 func rejectTx(tx *btc.Tx, why byte, missingid *btc.Uint256) {
 	txr := new(OneTxRejected)
 	txr.Id.Hash = tx.Hash.Hash
+	txr.WIdx = tx.WTxID().BIdx()
 	txr.Time = time.Now()
 	txr.Size = uint32(len(tx.Raw))
 	txr.Reason = why
@@ -347,6 +382,30 @@ func ReasonToString(reason byte) string {
 		return "LEN_MISMATCH"
 	case TX_REJECTED_EMPTY_INPUT:
 		return "EMPTY_INPUT"
+	case TX_REJECTED_VERSION:
+		return "VERSION"
+	case TX_REJECTED_TX_SIZE_SMALL:
+		return "SIZE_SMALL"
+	case TX_REJECTED_SCRIPTSIG_SIZE:
+		return "SCRIPTSIG_SIZE"
+	case TX_REJECTED_SCRIPTSIG_PUSHONLY:
+		return "SCRIPTSIG_PUSHONLY"
+	case TX_REJECTED_SCRIPTPUBKEY:
+		return "SCRIPTPUBKEY"
+	case TX_REJECTED_DUST:
+		return "DUST"
+	case TX_REJECTED_DATACARRIER:
+		return "DATACARRIER"
+	case TX_REJECTED_BARE_MULTISIG:
+		return "BARE_MULTISIG"
+	case TX_REJECTED_NONSTD_INPUTS:
+		return "NONSTD_INPUTS"
+	case TX_REJECTED_NONSTD_WITNESS:
+		return "NONSTD_WITNESS"
+	case TX_REJECTED_SIGOPS:
+		return "SIGOPS"
+	case TX_REJECTED_EPHEMERAL_FEE:
+		return "EPHEMERAL_FEE"
 	case TX_REJECTED_DATA_PURGED:
 		return "PURGED"
 	case TX_REJECTED_OVERSPEND:
@@ -371,6 +430,22 @@ func ReasonToString(reason byte) string {
 		return "RBF_100"
 	case TX_REJECTED_REPLACED:
 		return "REPLACED"
+	case TX_REJECTED_ANCESTORS:
+		return "ANCESTORS"
+	case TX_REJECTED_DESCENDANTS:
+		return "DESCENDANTS"
+	case TX_REJECTED_TRUC_INHERIT:
+		return "TRUC_INHERIT"
+	case TX_REJECTED_TRUC_SIZE:
+		return "TRUC_SIZE"
+	case TX_REJECTED_TRUC_ANCESTORS:
+		return "TRUC_ANCESTORS"
+	case TX_REJECTED_TRUC_DESCENDANTS:
+		return "TRUC_DESCENDANTS"
+	case TX_REJECTED_EPHEMERAL_SPENDS:
+		return "EPHEMERAL_SPENDS"
+	case TX_REJECTED_CLUSTER:
+		return "CLUSTER"
 	}
 	return fmt.Sprint("UNKNOWN_", reason)
 }
@@ -466,6 +541,7 @@ func resizeTransactionsRejectedCount(newcnt int) {
 				txr.cleanup()
 			}
 			delete(TransactionsRejected, bidx)
+			delete(WTxIDRejected, txr.WIdx)
 		} else {
 			txr.ArrIndex = uint16(TRIdxHead)
 			TRIdxArray[TRIdxHead] = bidx
@@ -502,4 +578,5 @@ func InitTransactionsRejected() {
 	WaitingForInputs = make(map[btc.BIDX]*OneWaitingList)
 	WaitingForInputsSize = 0
 	RejectedSpentOutputs = make(map[uint64][]btc.BIDX)
+	WTxIDRejected = make(map[btc.BIDX]btc.BIDX)
 }
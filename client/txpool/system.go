@@ -7,9 +7,6 @@ import (
 func (t *OneTxToSend) SysSize() (size int) {
 	size = int(unsafe.Sizeof(*t))
 	size += t.Tx.SysSize()
-	if t.inPackages != nil {
-		size += 8 * cap(t.inPackages)
-	}
 	if t.MemInputs != nil {
 		size += (cap(t.MemInputs) + 7) & ^7 // round the size up to the nearest 8 bytes
 	}
@@ -48,34 +45,11 @@ func (t2s *OneTxToSend) memInputsSet(newval []bool) {
 	}
 }
 
-func (t2s *OneTxToSend) inPackagesSet(newval []*OneTxsPackage) {
-	if t2s.Footprint == 0 || cap(newval) == cap(t2s.inPackages) {
-		t2s.inPackages = newval
-		return
-	}
-	var old_size, new_size int
-	if t2s.inPackages != nil {
-		old_size = 8 * cap(t2s.inPackages)
-	}
-	t2s.inPackages = newval
-	if t2s.inPackages != nil {
-		new_size = 8 * cap(t2s.inPackages)
-	}
-	if old_size != new_size {
-		t2s.Footprint -= uint32(old_size)
-		t2s.Footprint += uint32(new_size)
-		TransactionsToSendSize -= uint64(old_size)
-		TransactionsToSendSize += uint64(new_size)
-	}
-}
-
-func FeePackagesSysSize() (size int) {
-	size = int(unsafe.Sizeof(FeePackages)) + cap(FeePackages)*int(unsafe.Sizeof(FeePackages[0]))
-	if len(FeePackages) > 0 {
-		size += len(FeePackages) * int(unsafe.Sizeof(*FeePackages[0]))
-		for _, fp := range FeePackages {
-			size += cap(fp.Txs) * int(unsafe.Sizeof(fp.Txs[0]))
-		}
+func ClustersSysSize() (size int) {
+	size = cap(chunksByFeerate) * int(unsafe.Sizeof(chunksByFeerate[0]))
+	for c := range Clusters {
+		size += int(unsafe.Sizeof(*c)) + cap(c.Txs)*int(unsafe.Sizeof(c.Txs[0])) +
+			cap(c.Chunks)*int(unsafe.Sizeof(c.Chunks[0])) + len(c.Chunks)*int(unsafe.Sizeof(*c.Chunks[0]))
 	}
 	return
 }
@@ -11,6 +11,10 @@ import (
 	"github.com/piotrnar/gocoin/lib/chain"
 )
 
+const (
+	POOL_EXPIRE_INTERVAL = time.Hour
+)
+
 var (
 	TxMutex sync.Mutex
 
@@ -23,27 +27,25 @@ var (
 	// Each record is indexed by 64-bit-coded(TxID:Vout) and points to list of txs (from T2S)
 	SpentOutputs map[uint64]btc.BIDX
 
+	// Wtxid index of TransactionsToSend (BIP339), pointing to txid.
+	// Only txs with witness data are here - for the others wtxid is the same as txid.
+	WTxIDToSend map[btc.BIDX]btc.BIDX
+
 	// Transactions that are received from network (via "tx"), but not yet processed:
 	TransactionsPending map[btc.BIDX]bool = make(map[btc.BIDX]bool)
 
-	RepackagingSinceLastRedoTime  time.Duration
-	RepackagingSinceLastRedoCount uint
-	RepackagingSinceLastRedoWhen  time.Time
-
-	ResortingSinceLastRedoTime  time.Duration
-	ResortingSinceLastRedoCount uint
-	ResortingSinceLastRedoWhen  time.Time
+	nextTxsPoolExpire time.Time = time.Now().Add(POOL_EXPIRE_INTERVAL)
 )
 
 type OneTxToSend struct {
 	Firstseen, Lastseen, Lastsent time.Time
 	*btc.Tx
-	better, worse       *OneTxToSend
-	MemInputs           []bool           // only use memInputsSet() to set this field
-	inPackages          []*OneTxsPackage // only use inPackagesSet() to set this field
+	MemInputs           []bool // only use memInputsSet() to set this field
+	cluster             *Cluster
 	Volume, Fee         uint64
 	SigopsCost          uint64
-	SortRank            uint64
+	Ancestors           Ancestry // aggregated numbers of the tx with all its unconfirmed parents
+	Descendants         Ancestry // aggregated numbers of the tx with all its unconfirmed children
 	VerifyTime          time.Duration
 	Invsentcnt, SentCnt uint32
 	MemInputCnt         uint32
@@ -59,66 +61,15 @@ func (t2s *OneTxToSend) Add(bidx btc.BIDX) {
 	}
 	t2s.Footprint = uint32(t2s.SysSize())
 	TransactionsToSend[bidx] = t2s
+	if t2s.SegWit != nil {
+		WTxIDToSend[t2s.WTxID().BIdx()] = bidx
+	}
 	TransactionsToSendWeight += uint64(t2s.Weight())
 	TransactionsToSendSize += uint64(t2s.Footprint)
-	t2s.AddToSort()
-
-	if FeePackagesDirty {
-		return
-	}
-
-	if CheckForErrors() && t2s.inPackages != nil {
-		println("ERROR: Add to mempool called for tx that already has InPackages", len(t2s.inPackages))
-		FeePackagesDirty = true
-		return
-	}
-
-	// here we know that FeePackagesDirty is false
-	if t2s.MemInputCnt > 0 { // go through all the parents...
-		sta := time.Now()
-		parents := t2s.getAllTopParents()
-		for _, parent := range parents {
-			if parent.MemInputCnt != 0 {
-				println("ERROR: parent.MemInputCnt!=0 must not happen here")
-				continue
-			}
-			parent.addToPackages(t2s)
-		}
-		RepackagingSinceLastRedoTime += time.Since(sta)
-		RepackagingSinceLastRedoCount++
+	if !AncestryDirty {
+		t2s.addAncestry()
 	}
-}
-
-func (tx *OneTxToSend) getAllTopParents() (result []*OneTxToSend) {
-	result = make([]*OneTxToSend, 0, 16)
-	already_in := make(map[*OneTxToSend]struct{}, 16)
-	already_checked := make(map[btc.BIDX]struct{}, 16)
-	var do_one_parent func(*OneTxToSend)
-	do_one_parent = func(t2s *OneTxToSend) {
-		for vout, meminput := range t2s.MemInputs {
-			if meminput {
-				bidx := btc.BIdx(t2s.TxIn[vout].Input.Hash[:])
-				if _, ok := already_checked[bidx]; ok {
-					continue
-				}
-				already_checked[bidx] = struct{}{}
-				if parent, has := TransactionsToSend[bidx]; has {
-					if parent.MemInputCnt == 0 {
-						if _, ok := already_in[parent]; !ok {
-							already_in[parent] = struct{}{}
-							result = append(result, parent)
-						}
-					} else {
-						do_one_parent(parent)
-					}
-				} else {
-					println("ERROR: getAllTopParents t2s being added has mem input which does not exist")
-				}
-			}
-		}
-	}
-	do_one_parent(tx)
-	return
+	t2s.addToCluster()
 }
 
 // Delete deletes the tx from the mempool.
@@ -146,6 +97,10 @@ func (tx *OneTxToSend) Delete(with_children bool, reason byte) {
 		}
 	}
 
+	if !AncestryDirty {
+		tx.delAncestry()
+	}
+
 	for _, txin := range tx.TxIn {
 		uidx := txin.Input.UIdx()
 		delete(SpentOutputs, uidx)
@@ -154,16 +109,11 @@ func (tx *OneTxToSend) Delete(with_children bool, reason byte) {
 	}
 
 	delete(TransactionsToSend, tx.Hash.BIdx())
-
-	if !FeePackagesDirty && len(tx.inPackages) != 0 {
-		sta := time.Now()
-		tx.delFromPackages() // remove it from FeePackages
-		RepackagingSinceLastRedoTime += time.Since(sta)
-		RepackagingSinceLastRedoCount++
-		tx.inPackagesSet(nil) // this one will update tx.Footprint
+	if tx.SegWit != nil {
+		delete(WTxIDToSend, tx.WTxID().BIdx())
 	}
 
-	tx.DelFromSort()
+	tx.delFromCluster()
 
 	TransactionsToSendWeight -= uint64(tx.Weight())
 	TransactionsToSendSize -= uint64(tx.Footprint)
@@ -174,21 +124,25 @@ func (tx *OneTxToSend) Delete(with_children bool, reason byte) {
 	}
 }
 
+// removeExcessiveTxs removes the worst chunks from the mempool, if it has grown too big.
 func removeExcessiveTxs() {
 	var cnt, bytes uint64
 	if len(GetMPInProgressTicket) != 0 {
 		return // don't do it during mpget
 	}
 	if TransactionsToSendSize >= common.MaxMempoolSize()+1e6 { // only remove txs when we are 1MB over the maximum size
-		sorted_txs := GetSortedMempoolRBF()
-		FeePackagesDirty = true // do not update fee packages while doing this, as it will take forever
-		for idx := len(sorted_txs) - 1; idx >= 0; idx-- {
-			worst_tx := sorted_txs[idx]
-			cnt++
-			bytes += uint64(worst_tx.Footprint)
-			worst_tx.Delete(false, 0)
-			cnt++
+		chunks := sortedChunks()
+		ClustersDirty = true // do not update clusters while doing this, as it will take forever
+		for idx := len(chunks) - 1; idx >= 0; idx-- {
+			worst := chunks[idx]
+			// the last chunk of a cluster has no children outside of it
+			for i := len(worst.Txs) - 1; i >= 0; i-- {
+				cnt++
+				bytes += uint64(worst.Txs[i].Footprint)
+				worst.Txs[i].Delete(false, 0)
+			}
 			if TransactionsToSendSize <= common.MaxMempoolSize() {
+				CurrentFeeAdjustedSPKB = 4000 * worst.Fee / worst.Weight
 				break
 			}
 		}
@@ -201,6 +155,48 @@ func removeExcessiveTxs() {
 	}
 }
 
+func expireOldTxs() {
+	if time.Now().Before(nextTxsPoolExpire) {
+		return
+	}
+	nextTxsPoolExpire = time.Now().Add(POOL_EXPIRE_INTERVAL)
+
+	dur := common.Get(&common.TxExpireAfter)
+	if dur == 0 {
+		// tx expiting disabled
+		//fmt.Print("ExpireOldTxs() - disabled\n> ")
+		return
+	}
+	//fmt.Print("ExpireOldTxs()... ")
+	expire_before := time.Now().Add(-dur)
+	var todel []*OneTxToSend
+	for _, v := range TransactionsToSend {
+		if v.Lastseen.Before(expire_before) {
+			todel = append(todel, v)
+		}
+	}
+	if len(todel) > 0 {
+		totcnt := len(TransactionsToSend)
+		for _, vtx := range todel {
+			// make sure it was not deleted as a child of one of the previous txs
+			if _, ok := TransactionsToSend[vtx.Hash.BIdx()]; !ok {
+				common.CountSafe("TxPoolExpSkept")
+				continue
+			}
+			// remove with all the children
+			vtx.Delete(true, 0) // reason 0 does nont add it to the rejected list
+		}
+		totcnt -= len(TransactionsToSend)
+		common.CountSafeAdd("TxPoolExpParent", uint64(len(todel)))
+		common.CountSafeAdd("TxPoolExpChild", uint64(totcnt-len(todel)))
+		//fmt.Print("ExpireOldTxs: ", len(todel), " -> ", totcnt, " txs expired from mempool\n> ")
+	} else {
+		common.CountSafe("TxPoolExpireNone")
+		//fmt.Println("nothing expired\n> ")
+	}
+	common.CountSafe("TxPoolExpireTicks")
+}
+
 func txChecker(tx *btc.Tx) bool {
 	bidx := tx.Hash.BIdx()
 	TxMutex.Lock()
@@ -263,44 +259,6 @@ func (tx *OneTxToSend) GetChildren() (result []*OneTxToSend) {
 	return
 }
 
-// GetItWithAllChildren gets all the children (and all of their children...) of the tx.
-// If any of the children has other unconfirmed parents, they are also included in the result.
-// The result is sorted with the input parent first and always with parents before their children.
-func (tx *OneTxToSend) GetItWithAllChildren() (result []*OneTxToSend) {
-	already_included := make(map[*OneTxToSend]struct{}, 256)
-
-	result = make([]*OneTxToSend, 1, 256)
-	result[0] = tx // our starting (parent) tx shall be the first element of the result
-	already_included[tx] = struct{}{}
-
-	for idx := 0; idx < len(result); idx++ {
-		par := result[idx]
-		for _, ch := range par.GetChildren() {
-			// do it for each returned child,
-
-			// but only if it has not been included yet ...
-			if _, ok := already_included[ch]; !ok {
-
-				// first make sure we have all of its parents...
-				for _, prnt := range ch.GetAllParentsExcept(par) {
-					if _, ok := already_included[prnt]; !ok {
-						// if we dont have a parent, just insert it here into the result
-						result = append(result, prnt)
-						// ... and mark it as included, for later
-						already_included[prnt] = struct{}{}
-					}
-				}
-
-				// now we can safely insert the child, as all its parent shall be already included
-				result = append(result, ch)
-				// ... and mark it as included, for later
-				already_included[ch] = struct{}{}
-			}
-		}
-	}
-	return
-}
-
 // GetAllChildren gets all the children (and all of their children...) of the tx.
 // The result is sorted by the oldest parent.
 func (tx *OneTxToSend) GetAllChildren() (result []*OneTxToSend) {
@@ -396,26 +354,19 @@ func InitTransactionsToSend() {
 	TransactionsToSendSize = 0
 	TransactionsToSendWeight = 0
 	SpentOutputs = make(map[uint64]btc.BIDX, 10e3)
+	WTxIDToSend = make(map[btc.BIDX]btc.BIDX)
 }
 
 func InitMempool() {
 	TxMutex.Lock()
 
-	emptyFeePackages()
 	InitTransactionsToSend()
 	InitTransactionsRejected()
 
-	BestT2S, WorstT2S = nil, nil
-	adjustSortIndexStep()
-	SortListDirty = false
-	ResortingSinceLastRedoTime = 0
-	ResortingSinceLastRedoCount = 0
-	ResortingSinceLastRedoWhen = time.Now()
-
-	RepackagingSinceLastRedoTime = 0
-	RepackagingSinceLastRedoCount = 0
-	RepackagingSinceLastRedoWhen = time.Now()
-	FeePackagesDirty = false
+	Clusters = make(map[*Cluster]bool)
+	chunksByFeerate = nil
+	ClustersDirty = false
+	AncestryDirty = false
 
 	TxMutex.Unlock()
 }
@@ -0,0 +1,94 @@
+package txpool
+
+import (
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// Topologically Restricted Until Confirmation (BIP431) transactions.
+
+const (
+	TRUC_VERSION          = 3
+	TRUC_ANCESTOR_LIMIT   = 2     // a TRUC tx can have only one unconfirmed parent ...
+	TRUC_DESCENDANT_LIMIT = 2     // ... and only one unconfirmed child
+	TRUC_MAX_VSIZE        = 10000 // max size of any TRUC tx
+	TRUC_CHILD_MAX_VSIZE  = 1000  // max size of a TRUC tx that has an unconfirmed parent
+)
+
+// directParents returns the unconfirmed txs that the given one spends from.
+func (t2s *OneTxToSend) directParents() (result []*OneTxToSend) {
+	if t2s.MemInputCnt == 0 {
+		return
+	}
+	for idx, mi := range t2s.MemInputs {
+		if mi {
+			if par, ok := TransactionsToSend[btc.BIdx(t2s.TxIn[idx].Input.Hash[:])]; ok {
+				var already_in bool
+				for _, p := range result {
+					if p == par {
+						already_in = true
+						break
+					}
+				}
+				if !already_in {
+					result = append(result, par)
+				}
+			}
+		}
+	}
+	return
+}
+
+// checkTRUC verifies the TRUC rules for the new tx (not in the mempool yet, but with its MemInputs set).
+// Txs from the rbf map are going to be replaced by the new one, so they do not count.
+// If the new tx can only get in by evicting its sibling (the other child of its parent),
+// the sibling is returned - it then needs to be replaced, following the usual RBF rules.
+func checkTRUC(t2s *OneTxToSend, rbf map[*OneTxToSend]bool) (reason byte, sibling *OneTxToSend) {
+	parents := t2s.directParents()
+
+	if t2s.Version != TRUC_VERSION {
+		for _, par := range parents {
+			if par.Version == TRUC_VERSION {
+				reason = TX_REJECTED_TRUC_INHERIT // non-TRUC tx cannot spend from TRUC tx
+				return
+			}
+		}
+		return
+	}
+
+	vsize := t2s.VSize()
+	if vsize > TRUC_MAX_VSIZE {
+		reason = TX_REJECTED_TRUC_SIZE
+		return
+	}
+	if len(parents) == 0 {
+		return
+	}
+
+	for _, par := range parents {
+		if par.Version != TRUC_VERSION {
+			reason = TX_REJECTED_TRUC_INHERIT // TRUC tx cannot spend from non-TRUC tx
+			return
+		}
+	}
+	if len(parents) > TRUC_ANCESTOR_LIMIT-1 || len(t2s.GetAllParents()) > TRUC_ANCESTOR_LIMIT-1 {
+		reason = TX_REJECTED_TRUC_ANCESTORS
+		return
+	}
+	if vsize > TRUC_CHILD_MAX_VSIZE {
+		reason = TX_REJECTED_TRUC_SIZE
+		return
+	}
+
+	for _, ch := range parents[0].GetChildren() {
+		if rbf[ch] {
+			continue // it is being replaced anyway
+		}
+		if sibling != nil || !ch.HasNoChildren() {
+			sibling = nil
+			reason = TX_REJECTED_TRUC_DESCENDANTS
+			return
+		}
+		sibling = ch
+	}
+	return
+}
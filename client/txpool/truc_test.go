@@ -0,0 +1,183 @@
+package txpool
+
+import (
+	"testing"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+var testTxCnt uint32
+
+func testInitMempool() {
+	common.CFG.Memory.GCPercTrshold = 100
+	common.CFG.TXPool.ExpireInDays = 14
+	common.CFG.TXPool.MaxSizeMB = 100
+	common.CFG.TXPool.RejectRecCnt = 1000
+	common.CFG.TXPool.MaxRejectMB = 25.0
+	common.CFG.TXPool.MaxNoUtxoMB = 5.0
+	common.CFG.TXPool.DustRelayFee = 3.0
+	common.CFG.TXPool.IncrementalFee = 1.0
+	common.CFG.TXPool.AncestorCount = 25
+	common.CFG.TXPool.AncestorSizeKB = 101
+	common.CFG.TXPool.DescendantCount = 25
+	common.CFG.TXPool.DescendantSizeKB = 101
+	common.CFG.TXPool.ClusterCount = 64
+	common.CFG.TXPool.ClusterSizeKB = 101
+	common.Reset()
+	MPCheckUTXO = false // there is no UTXO db in here
+	InitMempool()
+}
+
+// testTx returns a new tx (not in mempool yet) spending the given outputs of the given
+// mempool txs (or a made up confirmed output, if parents is empty).
+func testTx(version uint32, fee uint64, parents []*OneTxToSend, vouts []uint32, outs ...*btc.TxOut) *OneTxToSend {
+	tx := &btc.Tx{Version: version}
+	t2s := &OneTxToSend{Tx: tx, Fee: fee}
+	for i, par := range parents {
+		in := new(btc.TxIn)
+		in.Input.Hash = par.Hash.Hash
+		in.Input.Vout = vouts[i]
+		tx.TxIn = append(tx.TxIn, in)
+	}
+	if len(parents) == 0 {
+		testTxCnt++
+		in := new(btc.TxIn)
+		in.Input.Hash = btc.Sha2Sum([]byte{byte(testTxCnt), byte(testTxCnt >> 8)})
+		tx.TxIn = append(tx.TxIn, in)
+	} else {
+		t2s.MemInputs = make([]bool, len(tx.TxIn))
+		for i := range t2s.MemInputs {
+			t2s.MemInputs[i] = true
+		}
+		t2s.MemInputCnt = uint32(len(tx.TxIn))
+	}
+	if len(outs) == 0 {
+		outs = []*btc.TxOut{testOut(10000, 22)}
+	}
+	tx.TxOut = outs
+	tx.SetHash(tx.Serialize())
+	return t2s
+}
+
+// testOut returns P2WPKH output (or OP_RETURN one, of the given size).
+func testOut(val uint64, size int) *btc.TxOut {
+	if size == 22 {
+		return &btc.TxOut{Value: val, Pk_script: append([]byte{0, 20}, make([]byte, 20)...)}
+	}
+	return &btc.TxOut{Value: val, Pk_script: append([]byte{btc.OP_RETURN}, make([]byte, size-1)...)}
+}
+
+func testAdd(t *testing.T, t2s *OneTxToSend) {
+	t2s.Add(t2s.Hash.BIdx())
+	if MempoolCheck() {
+		t.Fatal("Mempool broken after adding", t2s.Hash.String())
+	}
+}
+
+func TestTRUCInheritance(t *testing.T) {
+	testInitMempool()
+	v3 := testTx(3, 1000, nil, nil)
+	v2 := testTx(2, 1000, nil, nil)
+	testAdd(t, v3)
+	testAdd(t, v2)
+
+	if r, _ := checkTRUC(testTx(2, 1000, []*OneTxToSend{v3}, []uint32{0}), nil); r != TX_REJECTED_TRUC_INHERIT {
+		t.Error("non-TRUC child of TRUC parent:", ReasonToString(r))
+	}
+	if r, _ := checkTRUC(testTx(3, 1000, []*OneTxToSend{v2}, []uint32{0}), nil); r != TX_REJECTED_TRUC_INHERIT {
+		t.Error("TRUC child of non-TRUC parent:", ReasonToString(r))
+	}
+	if r, _ := checkTRUC(testTx(2, 1000, []*OneTxToSend{v2}, []uint32{0}), nil); r != 0 {
+		t.Error("non-TRUC child of non-TRUC parent:", ReasonToString(r))
+	}
+	if r, _ := checkTRUC(testTx(3, 1000, []*OneTxToSend{v3}, []uint32{0}), nil); r != 0 {
+		t.Error("TRUC child of TRUC parent:", ReasonToString(r))
+	}
+}
+
+func TestTRUCSizeAndTopology(t *testing.T) {
+	testInitMempool()
+	if r, _ := checkTRUC(testTx(3, 1000, nil, nil, testOut(0, TRUC_MAX_VSIZE)), nil); r != TX_REJECTED_TRUC_SIZE {
+		t.Error("too big TRUC tx:", ReasonToString(r))
+	}
+
+	par := testTx(3, 1000, nil, nil, testOut(10000, 22), testOut(10000, 22))
+	testAdd(t, par)
+	if r, _ := checkTRUC(testTx(3, 1000, []*OneTxToSend{par}, []uint32{0}, testOut(0, TRUC_CHILD_MAX_VSIZE)), nil); r != TX_REJECTED_TRUC_SIZE {
+		t.Error("too big TRUC child:", ReasonToString(r))
+	}
+
+	child := testTx(3, 1000, []*OneTxToSend{par}, []uint32{0})
+	if r, _ := checkTRUC(child, nil); r != 0 {
+		t.Fatal("TRUC child:", ReasonToString(r))
+	}
+	testAdd(t, child)
+
+	// grandchild
+	if r, _ := checkTRUC(testTx(3, 1000, []*OneTxToSend{child}, []uint32{0}), nil); r != TX_REJECTED_TRUC_ANCESTORS {
+		t.Error("TRUC grandchild:", ReasonToString(r))
+	}
+
+	// two parents
+	other := testTx(3, 1000, nil, nil)
+	testAdd(t, other)
+	if r, _ := checkTRUC(testTx(3, 1000, []*OneTxToSend{par, other}, []uint32{1, 0}), nil); r != TX_REJECTED_TRUC_ANCESTORS {
+		t.Error("TRUC child with two parents:", ReasonToString(r))
+	}
+}
+
+func TestTRUCSiblingEviction(t *testing.T) {
+	testInitMempool()
+	par := testTx(3, 1000, nil, nil, testOut(10000, 22), testOut(10000, 22), testOut(10000, 22))
+	testAdd(t, par)
+	child := testTx(3, 1000, []*OneTxToSend{par}, []uint32{0})
+	testAdd(t, child)
+
+	second := testTx(3, 5000, []*OneTxToSend{par}, []uint32{1})
+	r, sibling := checkTRUC(second, nil)
+	if r != 0 || sibling != child {
+		t.Fatal("sibling not found:", ReasonToString(r), sibling)
+	}
+	// when the first child is being replaced anyway, there is no sibling to evict
+	if r, sibling = checkTRUC(second, map[*OneTxToSend]bool{child: true}); r != 0 || sibling != nil {
+		t.Error("sibling to replace:", ReasonToString(r), sibling)
+	}
+
+	child.Delete(false, TX_REJECTED_REPLACED)
+	testAdd(t, second)
+	if par.Descendants.Count != TRUC_DESCENDANT_LIMIT || second.Ancestors.Count != TRUC_ANCESTOR_LIMIT {
+		t.Error("bad ancestry after eviction", par.Descendants, second.Ancestors)
+	}
+	if txr := TransactionsRejected[child.Hash.BIdx()]; txr == nil || txr.Reason != TX_REJECTED_REPLACED {
+		t.Error("evicted sibling not on the rejected list")
+	}
+}
+
+func TestEphemeralDust(t *testing.T) {
+	testInitMempool()
+	anchor := &btc.TxOut{Value: 0, Pk_script: []byte{btc.OP_1, 2, 0x4e, 0x73}}
+	par := testTx(3, 0, nil, nil, testOut(10000, 22), anchor)
+	if r := isStandardTx(par.Tx); r != 0 {
+		t.Fatal("tx with one dust output:", ReasonToString(r))
+	}
+	if d := dustOutputs(par.Tx); len(d) != 1 || d[0] != 1 {
+		t.Fatal("dust outputs:", d)
+	}
+	if r := isStandardTx(testTx(3, 0, nil, nil, anchor, anchor).Tx); r != TX_REJECTED_DUST {
+		t.Error("tx with two dust outputs:", ReasonToString(r))
+	}
+	testAdd(t, par)
+
+	if r := checkEphemeralSpends(testTx(3, 2000, []*OneTxToSend{par}, []uint32{0})); r != TX_REJECTED_EPHEMERAL_SPENDS {
+		t.Error("child not spending the dust:", ReasonToString(r))
+	}
+	child := testTx(3, 2000, []*OneTxToSend{par, par}, []uint32{0, 1})
+	if r := checkEphemeralSpends(child); r != 0 {
+		t.Error("child spending the dust:", ReasonToString(r))
+	}
+	testAdd(t, child)
+	if par.Descendants.Fee != 2000 || child.Ancestors.Fee != 2000 {
+		t.Error("bad ancestry fees", par.Descendants, child.Ancestors)
+	}
+}
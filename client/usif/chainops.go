@@ -0,0 +1,64 @@
+package usif
+
+import (
+	"errors"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/client/txpool"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// InvalidateBlock marks the block and its children as invalid, moving the chain to the best valid branch.
+// Call it from the main thread.
+func InvalidateBlock(hash *btc.Uint256) error {
+	return changeChain(func() error { return common.BlockChain.InvalidateBlock(hash) })
+}
+
+// ReconsiderBlock undoes the effects of InvalidateBlock, moving the chain to the best branch.
+// Call it from the main thread.
+func ReconsiderBlock(hash *btc.Uint256) error {
+	return changeChain(func() error { return common.BlockChain.ReconsiderBlock(hash) })
+}
+
+// PreciousBlock moves the chain to the given block, if it has as much POW as the current one.
+// Call it from the main thread.
+func PreciousBlock(hash *btc.Uint256) error {
+	return changeChain(func() error { return common.BlockChain.PreciousBlock(hash) })
+}
+
+func changeChain(do func() error) (e error) {
+	if FetchingBalances.Get() {
+		e = errors.New("cannot change the chain while fetching wallet balances")
+		return
+	}
+	common.Last.Mutex.Lock()
+	parsing := common.Last.ParseTill != nil
+	common.Last.Mutex.Unlock()
+	if parsing {
+		e = errors.New("blocks are being processed - try again later")
+		return
+	}
+
+	txpool.BlockCommitInProgress(true)
+	e = do()
+	txpool.BlockCommitInProgress(false)
+
+	common.Last.Mutex.Lock()
+	common.Last.Block = common.BlockChain.LastBlock()
+	common.UpdateScriptFlags(0)
+	common.Last.Mutex.Unlock()
+
+	// the best header might have been invalidated (or reconsidered)
+	network.MutexRcv.Lock()
+	prev_last_header := network.LastCommitedHeader
+	common.BlockChain.BlockIndexAccess.Lock()
+	network.LastCommitedHeader, _ = common.BlockChain.BlockTreeRoot.FindFarthestNode()
+	common.BlockChain.BlockIndexAccess.Unlock()
+	need_more_headers := prev_last_header != network.LastCommitedHeader
+	network.MutexRcv.Unlock()
+	if need_more_headers {
+		network.GetMoreHeaders()
+	}
+	return
+}
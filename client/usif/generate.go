@@ -0,0 +1,204 @@
+package usif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/client/txpool"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
+	"github.com/piotrnar/gocoin/lib/script"
+)
+
+const (
+	GENERATE_COINBASE_STRING = "/gocoin/"
+	GENERATE_DEFAULT_TRIES   = 1000000
+)
+
+// LocalAcceptBlock is set by the main package.
+// It commits a new block to the chain and must be called from the main thread.
+var LocalAcceptBlock func(*network.BlockRcvd) error
+
+// newCoinbaseTx returns the coinbase tx, with the witness commitment output still zeroed.
+func newCoinbaseTx(height uint32, value uint64, pkscr []byte) (tx *btc.Tx) {
+	tx = new(btc.Tx)
+	tx.Version = 2
+	tx.TxIn = []*btc.TxIn{new(btc.TxIn)}
+	tx.TxIn[0].Input.Vout = 0xffffffff
+	tx.TxIn[0].Sequence = 0xffffffff
+	wr := bytes.NewBuffer(script.UintToScript(height))
+	wr.WriteByte(byte(len(GENERATE_COINBASE_STRING)))
+	wr.WriteString(GENERATE_COINBASE_STRING)
+	tx.TxIn[0].ScriptSig = wr.Bytes()
+
+	tx.SegWit = [][][]byte{{make([]byte, 32)}} // witness reserved value
+	tx.TxOut = []*btc.TxOut{
+		{Value: value, Pk_script: pkscr},
+		{Pk_script: append([]byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}, make([]byte, 32)...)},
+	}
+	return
+}
+
+// blockTxsFromMempool returns the mempool txs to be put into a new block, parents before children.
+func blockTxsFromMempool(height, mtp uint32) (txs []*btc.Tx, fees uint64) {
+	const coinbase_weight = 4000 // reserved for the header and the coinbase tx
+	var weight, sigops uint64
+	txpool.TxMutex.Lock()
+	defer txpool.TxMutex.Unlock()
+	included := make(map[btc.BIDX]bool)
+	for _, t2s := range txpool.GetSortedMempool() {
+		if !t2s.IsFinal(height, mtp) {
+			continue
+		}
+		w := uint64(t2s.Weight())
+		if weight+w > btc.MAX_BLOCK_WEIGHT-coinbase_weight || sigops+t2s.SigopsCost > btc.MAX_BLOCK_SIGOPS_COST {
+			continue
+		}
+		parents_in := true
+		for i, in := range t2s.TxIn {
+			if t2s.MemInputs != nil && t2s.MemInputs[i] && !included[btc.BIdx(in.Input.Hash[:])] {
+				parents_in = false
+				break
+			}
+		}
+		if !parents_in {
+			continue
+		}
+		included[t2s.Hash.BIdx()] = true
+		txs = append(txs, t2s.Tx)
+		fees += t2s.Fee
+		weight += w
+		sigops += t2s.SigopsCost
+	}
+	return
+}
+
+// mineBlock builds a new block on top of the current chain and searches for its nonce.
+// It returns nil if the proof of work has not been found within maxtries.
+func mineBlock(pkscr []byte, maxtries *uint64) (bl *btc.Block, e error) {
+	last := common.BlockChain.LastBlock()
+	height := last.Height + 1
+	mtp := last.GetMedianTimePast()
+	ts := uint32(time.Now().Unix())
+	if ts <= mtp {
+		ts = mtp + 1
+	}
+	bits := common.BlockChain.GetNextWorkRequired(last, ts)
+
+	txs, fees := blockTxsFromMempool(height, mtp)
+	cb := newCoinbaseTx(height, common.BlockChain.BlockReward(height)+fees, pkscr)
+	txs = append([]*btc.Tx{cb}, txs...)
+	cb.SetHash(cb.SerializeNew())
+	merkle, _ := btc.GetWitnessMerkle(txs)
+	with_nonce := btc.Sha2Sum(append(merkle, cb.SegWit[0][0]...))
+	copy(cb.TxOut[1].Pk_script[6:], with_nonce[:])
+	cb.SetHash(cb.SerializeNew())
+
+	var hdr [80]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], common.BlockChain.ComputeBlockVersion(last))
+	copy(hdr[4:36], last.BlockHash.Hash[:])
+	binary.LittleEndian.PutUint32(hdr[68:72], ts)
+	binary.LittleEndian.PutUint32(hdr[72:76], bits)
+
+	if common.CFG.Signet {
+		if e = SignetSignBlock(hdr[:], txs); e != nil {
+			return
+		}
+	}
+
+	hashes := make([][32]byte, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash.Hash
+	}
+	merkle, _ = btc.CalcMerkle(hashes)
+	copy(hdr[36:68], merkle)
+	for nonce := uint32(0); ; nonce++ {
+		if *maxtries == 0 {
+			return
+		}
+		*maxtries--
+		binary.LittleEndian.PutUint32(hdr[76:80], nonce)
+		if btc.CheckProofOfWork(btc.NewSha2Hash(hdr[:]), bits) {
+			break
+		}
+		if nonce == 0xffffffff {
+			return // nonce space exhausted
+		}
+	}
+
+	wr := bytes.NewBuffer(hdr[:])
+	btc.WriteVlen(wr, uint64(len(txs)))
+	for _, tx := range txs {
+		tx.WriteSerializedNew(wr)
+	}
+	if bl, e = btc.NewBlock(wr.Bytes()); e == nil {
+		e = bl.BuildTxList()
+	}
+	return
+}
+
+// SignetSignBlock puts the signet solution into the coinbase of a new block, using the key from the config.
+// Only version, previous block hash and timestamp need to be set in hdr.
+func SignetSignBlock(hdr []byte, txs []*btc.Tx) error {
+	var key []byte
+	if common.CFG.SignetKey != "" {
+		pk, e := btc.DecodePrivateAddr(common.CFG.SignetKey)
+		if e != nil {
+			return errors.New("SignetKey: " + e.Error())
+		}
+		key = pk.Key
+	}
+	return chain.SignetSign(hdr, txs, common.SignetChallenge(), key)
+}
+
+// GenerateBlocks mines cnt new blocks, paying the rewards to pkscr and including the mempool txs.
+// It returns hashes of the blocks that have been accepted. Call it from the main thread.
+func GenerateBlocks(cnt int, pkscr []byte, maxtries uint64) (res []*btc.Uint256, e error) {
+	if LocalAcceptBlock == nil {
+		e = errors.New("block processing not available")
+		return
+	}
+	if FetchingBalances.Get() {
+		e = errors.New("cannot generate blocks while fetching wallet balances")
+		return
+	}
+	for len(res) < cnt {
+		sta := time.Now()
+		var bl *btc.Block
+		if bl, e = mineBlock(pkscr, &maxtries); e != nil || bl == nil {
+			return
+		}
+
+		common.BlockChain.BlockIndexAccess.Lock()
+		_, _, e = common.BlockChain.PreCheckBlock(bl)
+		common.BlockChain.BlockIndexAccess.Unlock()
+		if e == nil {
+			e = common.BlockChain.PostCheckBlock(bl)
+		}
+		if e != nil {
+			return
+		}
+
+		rb := &network.OneReceivedBlock{TmStart: sta, TmPreproc: sta, TmDownload: time.Now(), DoInvs: true}
+		common.BlockChain.BlockIndexAccess.Lock()
+		node := common.BlockChain.AcceptHeader(bl)
+		common.BlockChain.BlockIndexAccess.Unlock()
+		network.MutexRcv.Lock()
+		network.ReceivedBlocks[bl.Hash.BIdx()] = rb
+		if node.Height > network.LastCommitedHeader.Height {
+			network.LastCommitedHeader = node
+		}
+		network.MutexRcv.Unlock()
+
+		if e = LocalAcceptBlock(&network.BlockRcvd{Block: bl, BlockTreeNode: node, OneReceivedBlock: rb}); e != nil {
+			return
+		}
+		common.CountSafe("BlockGenerated")
+		res = append(res, bl.Hash)
+	}
+	return
+}
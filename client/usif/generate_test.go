@@ -0,0 +1,69 @@
+package usif
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/client/txpool"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
+)
+
+func TestGenerateRegtest(t *testing.T) {
+	chain.AbortNow = false
+	common.BlockChain = chain.NewChainExt(t.TempDir()+string(os.PathSeparator),
+		btc.NewUint256FromString("0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"),
+		false, nil, &chain.BlockDBOpts{})
+	defer common.BlockChain.Close()
+	network.LastCommitedHeader = common.BlockChain.LastBlock()
+	txpool.InitMempool()
+	LocalAcceptBlock = func(newbl *network.BlockRcvd) error {
+		return common.BlockChain.CommitBlock(newbl.Block, newbl.BlockTreeNode)
+	}
+	defer func() { LocalAcceptBlock = nil }()
+
+	pkscr := []byte{0x00, 0x14, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	const cnt = 151 // over the first halving (150) and the regtest BIP9 window (144)
+	hashes, e := GenerateBlocks(cnt, pkscr, GENERATE_DEFAULT_TRIES)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	if len(hashes) != cnt {
+		t.Fatal("Generated", len(hashes), "blocks")
+	}
+	last := common.BlockChain.LastBlock()
+	if last.Height != cnt || !last.BlockHash.Equal(hashes[cnt-1]) {
+		t.Fatal("Bad chain tip", last.Height)
+	}
+
+	for n := last; n.Parent != nil; n = n.Parent {
+		if n.Bits() != common.BlockChain.Consensus.MaxPOWBits {
+			t.Fatalf("Block %d has bits %08x - retarget on regtest", n.Height, n.Bits())
+		}
+		if n.Height != 149 && n.Height != 150 && n.Height != cnt {
+			continue
+		}
+		raw, _, e := common.BlockChain.Blocks.BlockGet(n.BlockHash)
+		if e != nil {
+			t.Fatal(e.Error())
+		}
+		bl, _ := btc.NewBlock(raw)
+		bl.BuildTxList()
+		exp := uint64(50e8)
+		if n.Height >= 150 {
+			exp = 25e8
+		}
+		cb := bl.Txs[0]
+		if cb.TxOut[0].Value != exp || !bytes.Equal(cb.TxOut[0].Pk_script, pkscr) {
+			t.Error("Bad coinbase of block", n.Height, cb.TxOut[0].Value)
+		}
+	}
+
+	// maxtries exhausted - nothing generated
+	if hashes, e = GenerateBlocks(1, pkscr, 0); e != nil || len(hashes) != 0 {
+		t.Error("Block generated with maxtries=0")
+	}
+}
@@ -0,0 +1,120 @@
+package usif
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/client/txpool"
+	"github.com/piotrnar/gocoin/client/wallet"
+	"github.com/piotrnar/gocoin/lib/chain"
+	"github.com/piotrnar/gocoin/lib/utxo"
+)
+
+// LoadUtxoSnapshot replaces the UTXO set with the one from Core's snapshot file (assumeutxo)
+// and moves the chain's tip to the snapshot's base block, whose header must already be known.
+// If configured, it starts validating the history below the base, in the background.
+// hash is nil if the snapshot has not been loaded (an error with hash set means
+// that only the background validation could not be started).
+// Call it from the main thread.
+func LoadUtxoSnapshot(fname string) (base *chain.BlockTreeNode, hash []byte, coins uint64, e error) {
+	if FetchingBalances.Get() {
+		e = errors.New("cannot load a snapshot while fetching wallet balances")
+		return
+	}
+	common.Last.Mutex.Lock()
+	parsing := common.Last.ParseTill != nil
+	common.Last.Mutex.Unlock()
+	if parsing || len(network.NetBlocks) > 0 {
+		e = errors.New("blocks are being processed - try again later")
+		return
+	}
+
+	f, e := os.Open(fname)
+	if e != nil {
+		return
+	}
+	defer f.Close()
+	rd := bufio.NewReaderSize(f, 0x1000000)
+	hdr, e := utxo.ReadSnapshotHeader(rd)
+	if e != nil {
+		return
+	}
+	if !bytes.Equal(hdr.Network[:], common.Magic[:]) {
+		e = errors.New("the snapshot is for a different network")
+		return
+	}
+
+	network.MutexRcv.Lock()
+	for n := network.LastCommitedHeader; n != nil; n = n.Parent {
+		if n.BlockHash.Equal(hdr.BaseHash) {
+			base = n
+			break
+		}
+	}
+	network.MutexRcv.Unlock()
+	if base == nil {
+		e = errors.New("snapshot's base block " + hdr.BaseHash.String() + " is not in the best headers chain")
+		return
+	}
+
+	if common.Get(&common.WalletON) {
+		wallet.Disable()
+		common.Set(&common.WalletOnIn, 10)
+	}
+	if base, hash, e = common.BlockChain.LoadUtxoSnapshot(rd, hdr); e != nil {
+		return
+	}
+	coins = hdr.CoinsCount
+	network.DropBlocksBelow(base.Height)
+	txpool.InitMempool()
+
+	common.Last.Mutex.Lock()
+	common.Last.Block = common.BlockChain.LastBlock()
+	common.Last.Time = time.Now()
+	common.UpdateScriptFlags(0)
+	common.Last.Mutex.Unlock()
+
+	if common.CFG.UTXOSave.SnapshotCheck {
+		e = network.StartSnapshotValidation(base, hash)
+	}
+	return
+}
+
+// DumpUtxoSnapshot writes the current UTXO set to a file in the format of Core's "dumptxoutset".
+// A relative path is taken from the node's data folder. The file must not exist yet.
+// Block processing waits until it is done, but it does not need to be called from the main thread.
+func DumpUtxoSnapshot(fname string) (path string, base *chain.BlockTreeNode, hdr *utxo.SnapshotHeader, hash []byte, e error) {
+	if path = fname; !filepath.IsAbs(path) {
+		path = filepath.Join(common.GocoinHomeDir, path)
+	}
+	if _, er := os.Stat(path); er == nil {
+		e = errors.New(path + " already exists")
+		return
+	}
+	tmp := path + ".incomplete"
+	f, e := os.Create(tmp)
+	if e != nil {
+		return
+	}
+	hdr, hash, e = common.BlockChain.Unspent.ExportSnapshot(f, common.Magic, nil)
+	if er := f.Close(); e == nil {
+		e = er
+	}
+	if e == nil {
+		e = os.Rename(tmp, path)
+	}
+	if e != nil {
+		os.Remove(tmp)
+		return
+	}
+	common.BlockChain.BlockIndexAccess.Lock()
+	base = common.BlockChain.BlockIndex[hdr.BaseHash.BIdx()]
+	common.BlockChain.BlockIndexAccess.Unlock()
+	return
+}
@@ -584,6 +584,104 @@ func redo_block(par string) {
 		OneReceivedBlock: rb, BlockExtraInfo: nil}
 }
 
+func chain_block_op(par string, op func(*btc.Uint256) error, done string) {
+	h := btc.NewUint256FromString(strings.TrimSpace(par))
+	if h == nil {
+		println("Specify block's hash")
+		return
+	}
+	if er := op(h); er != nil {
+		println(er.Error())
+		return
+	}
+	last := common.BlockChain.LastBlock()
+	fmt.Println("Block", h.String(), done+". Last block is now", last.Height, last.BlockHash.String())
+}
+
+func invalidate_block(par string) {
+	chain_block_op(par, usif.InvalidateBlock, "invalidated")
+}
+
+func reconsider_block(par string) {
+	chain_block_op(par, usif.ReconsiderBlock, "reconsidered")
+}
+
+func precious_block(par string) {
+	chain_block_op(par, usif.PreciousBlock, "preferred")
+}
+
+func generate_blocks(par string) {
+	ps := strings.SplitN(strings.TrimSpace(par), " ", 2)
+	if len(ps) != 2 {
+		println("Specify number of blocks and the address for the coinbase")
+		return
+	}
+	cnt, er := strconv.ParseUint(ps[0], 10, 32)
+	if er != nil || cnt == 0 {
+		println("Incorrect number of blocks:", ps[0])
+		return
+	}
+	addr, er := btc.NewAddrFromString(strings.TrimSpace(ps[1]))
+	if er != nil {
+		println(er.Error())
+		return
+	}
+	res, er := usif.GenerateBlocks(int(cnt), addr.OutScript(), usif.GENERATE_DEFAULT_TRIES*cnt)
+	for _, h := range res {
+		fmt.Println(h.String())
+	}
+	if er != nil {
+		println("Generating blocks failed:", er.Error())
+	}
+	fmt.Println(len(res), "block(s) generated. Last block is now", common.BlockChain.LastBlock().Height)
+}
+
+func utxo_snapshot(par string) {
+	fname := strings.TrimSpace(par)
+	if fname == "" {
+		fmt.Println("Snapshot validation:", network.SnapshotValidationStatus())
+		return
+	}
+	sta := time.Now()
+	base, hash, coins, er := usif.LoadUtxoSnapshot(fname)
+	if hash == nil {
+		println("Loading UTXO snapshot failed:", er.Error())
+		return
+	}
+	fmt.Println(coins, "coins loaded in", time.Since(sta).String(), "- last block is now", base.Height)
+	fmt.Println("UTXO set hash:", btc.NewUint256(hash).String())
+	if er != nil {
+		println("Background validation not started:", er.Error())
+	}
+}
+
+func utxo_info(par string) {
+	info := common.BlockChain.Unspent.GetTxOutSetInfo(strings.TrimSpace(par) == "ser")
+	fmt.Println("Height:", info.Height, "  Block:", btc.NewUint256(info.BlockHash).String())
+	fmt.Printf("Txs: %d   TxOuts: %d   BogoSize: %d   Total amount: %.8f BTC\n",
+		info.Txs, info.TxOuts, info.BogoSize, float64(info.TotalAmount)/1e8)
+	fmt.Println("MuHash:", btc.NewUint256(info.MuHash).String())
+	if info.HashSerialized != nil {
+		fmt.Println("hash_serialized_3:", btc.NewUint256(info.HashSerialized).String())
+	}
+}
+
+func utxo_dump(par string) {
+	fname := strings.TrimSpace(par)
+	if fname == "" {
+		println("Specify the name of the file to write the UTXO snapshot to")
+		return
+	}
+	sta := time.Now()
+	path, _, hdr, hash, er := usif.DumpUtxoSnapshot(fname)
+	if er != nil {
+		println("Writing UTXO snapshot failed:", er.Error())
+		return
+	}
+	fmt.Println(hdr.CoinsCount, "coins from block", hdr.BaseHash.String(), "written to", path, "in", time.Since(sta).String())
+	fmt.Println("UTXO set hash:", btc.NewUint256(hash).String())
+}
+
 func kill_node(par string) {
 	if par != "" && par[0] == 'r' {
 		os.Exit(66)
@@ -673,13 +771,17 @@ func init() {
 	newUi("counters c", false, show_counters, "Show internal debug counters [prefix]")
 	newUi("defrag def", true, utxo_defrag, "Defragment UTXO or show stats [rec|mem] [map] [all]")
 	newUi("help h ?", false, show_help, "Shows this help")
+	newUi("generate gen", true, generate_blocks, "Mine blocks in-process: <count> <address>")
 	newUi("info i", false, show_info, "Shows general info about the node")
+	newUi("invalidate", true, invalidate_block, "Mark block and its children as invalid: <hash>")
 	newUi("inv", false, send_inv, "Send inv message to all the peers - specify type & hash")
 	newUi("kill", false, kill_node, "Kill the node. WARNING: not safe - use 'quit' instead")
 	newUi("mem", false, show_mem, "Show memory stats and... [bs|free|gc|<new_gc_perc>|<new_limit>MB]")
 	newUi("pend", true, show_pending, "Show pending blocks")
+	newUi("precious", true, precious_block, "Prefer block when it has as much POW as the current one: <hash>")
 	newUi("purge", true, purge_utxo, "Purge all unspendable outputs from UTXO database")
 	newUi("quit q", false, ui_quit, "Quit the node: [restart]")
+	newUi("reconsider", true, reconsider_block, "Remove invalidity status of block and its children: <hash>")
 	newUi("redo", true, redo_block, "Redo one block")
 	newUi("savebl bl", false, dump_block, "Saves a block to disk: <hash>")
 	newUi("saveutxo s", true, save_utxo, "Save UTXO database now")
@@ -687,6 +789,9 @@ func init() {
 	newUi("undo", true, undo_block, "Undo one block")
 	newUi("utxodb u", true, blchain_utxodb, "Display UTXO-db statistics [mem]")
 	newUi("utxomem um", true, utxo_mem, "Show UTXO memory heap stats [verbose]")
+	newUi("utxoinfo ui", true, utxo_info, "Show UTXO set info and MuHash, like Core's gettxoutsetinfo [ser]")
+	newUi("utxodump", false, utxo_dump, "Write UTXO set to a file in Core's snapshot format: <fname>")
+	newUi("utxosnap", true, utxo_snapshot, "Load UTXO set from Core's snapshot file: <fname> (or show background validation)")
 	newUi("web", true, webui_stats, "Show WebUI access statistics")
 	newUi("stop", true, blocks_stop, "Stop/restart block processing [0|1]")
 }
@@ -56,7 +56,9 @@ func node_info(par string) {
 	}
 
 	fmt.Printf("Connection ID %d:\n", r.ID)
-	if r.Incomming {
+	if r.IsOnion {
+		fmt.Println("Coming via our onion service", r.PeerIp)
+	} else if r.Incomming {
 		fmt.Println("Coming from", r.PeerIp)
 	} else {
 		fmt.Println("Going to", r.PeerIp)
@@ -70,7 +72,11 @@ func node_info(par string) {
 			fmt.Printf("Reported IP: %d.%d.%d.%d\n", byte(r.ReportedIp4>>24), byte(r.ReportedIp4>>16),
 				byte(r.ReportedIp4>>8), byte(r.ReportedIp4))
 			fmt.Println("SendHeaders:", r.SendHeaders)
+			fmt.Println("WtxidRelay:", r.WtxidRelay)
 		}
+		fmt.Println("V2 Transport:", r.V2Transport)
+		fmt.Println("Tx Reconciliation:", r.TxRecon)
+		fmt.Println("Package Relay:", r.PkgRelay)
 		fmt.Println("Invs Done:", r.InvsDone)
 		fmt.Println("Last data got:", time.Since(r.LastDataGot).String())
 		fmt.Println("Last data sent:", time.Since(r.LastSent).String())
@@ -185,6 +191,9 @@ func net_stats(par string) {
 	} else {
 		fmt.Println("No known external address")
 	}
+	if oa := network.OnionAddr(); oa != nil {
+		fmt.Println("Onion service:", oa.String())
+	}
 
 	network.Mutex_net.Unlock()
 
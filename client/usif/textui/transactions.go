@@ -39,6 +39,57 @@ func load_tx(par string) {
 	fmt.Println(usif.LoadRawTx(buf))
 }
 
+func load_pkg(par string) {
+	fns := strings.Fields(par)
+	if len(fns) == 0 {
+		fmt.Println("Specify names of the transaction files (parents first, the child at the end)")
+		return
+	}
+	pkg := &txpool.PkgRcvd{Trusted: true, Local: true}
+	for _, fn := range fns {
+		buf, er := os.ReadFile(fn)
+		if er != nil {
+			println(er.Error())
+			return
+		}
+		txd, er := hex.DecodeString(strings.TrimSpace(string(buf)))
+		if er != nil {
+			txd = buf
+		}
+		tx, le := btc.NewTx(txd)
+		if tx == nil || le != len(txd) {
+			fmt.Println("Could not decode transaction file", fn, "or it has some extra data")
+			return
+		}
+		tx.SetHash(txd)
+		pkg.Txs = append(pkg.Txs, tx)
+	}
+
+	if res := txpool.SubmitPackage(pkg); res != 0 {
+		fmt.Println("Package rejected:", txpool.PkgReasonToString(res))
+	}
+	for i, r := range pkg.TxResults {
+		fmt.Print(" ", pkg.Txs[i].Hash.String(), "  ")
+		if r.Result != 0 {
+			fmt.Println("rejected", txpool.ReasonToString(r.Result))
+		} else if !r.Accepted {
+			fmt.Println("not processed")
+		} else if r.InMempool {
+			fmt.Println("already in the memory pool")
+		} else if r.Package {
+			fmt.Println("accepted with the package fee, paying", r.Fee, "SAT")
+		} else {
+			fmt.Println("accepted on its own, paying", r.Fee, "SAT")
+		}
+	}
+	for _, id := range pkg.Replaced {
+		fmt.Println(" ", id.String(), "replaced")
+	}
+	if pkg.Result == 0 {
+		fmt.Println("Package in the memory pool. You can broadcast its txs now.")
+	}
+}
+
 func send_tx(par string) {
 	txid := btc.NewUint256FromString(par)
 	if txid == nil {
@@ -170,6 +221,13 @@ func decode_tx(pars string) {
 			fmt.Println("Fee:", t2s.Fee)
 			fmt.Println("MemInputCnt:", t2s.MemInputCnt, " ", t2s.MemInputs)
 			fmt.Println("SigopsCost:", t2s.SigopsCost)
+			txpool.TxMutex.Lock()
+			txpool.UpdateAncestry()
+			fmt.Printf("Ancestors: %d txs, %d vB, %d fee (%.2f SPB)\n", t2s.Ancestors.Count,
+				t2s.Ancestors.VSize, t2s.Ancestors.Fee, t2s.Ancestors.SPB())
+			fmt.Printf("Descendants: %d txs, %d vB, %d fee (%.2f SPB)\n", t2s.Descendants.Count,
+				t2s.Descendants.VSize, t2s.Descendants.Fee, t2s.Descendants.SPB())
+			txpool.TxMutex.Unlock()
 			fmt.Println("VerifyTime:", t2s.VerifyTime.String())
 			fmt.Println("Local:", t2s.Local)
 			fmt.Println("Blocked:", t2s.Blocked)
@@ -234,7 +292,7 @@ func list_txs(par string) {
 	txpool.TxMutex.Lock()
 	defer txpool.TxMutex.Unlock()
 
-	sorted := txpool.GetSortedMempoolRBF()
+	sorted := txpool.GetSortedMempool()
 
 	var totlen, totweigth uint64
 	for cnt = 0; cnt < len(sorted); cnt++ {
@@ -501,7 +559,7 @@ func push_old_txs(par string) {
 	if push || purge {
 		for _, tx := range txs_found {
 			if push {
-				invs += network.NetRouteInvExt(network.MSG_TX, &tx.Hash, nil, uint64(1000.0*tx.SPB()))
+				invs += network.NetRouteInvExt(network.MSG_TX, &tx.Hash, tx.WTxID(), nil, uint64(1000.0*tx.SPB()))
 			} else if purge {
 				tx.Delete(true, 0)
 			}
@@ -521,7 +579,6 @@ func tx_pool_stats(par string) {
 	txpool.TxMutex.Lock()
 	defer txpool.TxMutex.Unlock()
 
-	var s1, s2 string
 	var sw_cnt, sw_siz, sw_wgt uint64
 	for _, v := range txpool.TransactionsToSend {
 		if v.SegWit != nil {
@@ -538,12 +595,6 @@ func tx_pool_stats(par string) {
 		return 100 * v / m
 	}
 
-	get_range_mark := func(v uint64) uint64 {
-		v >>= 20
-		max := (^uint64(0) >> 20) + 1
-		return 1e6 * v / max
-	}
-
 	fmt.Printf("Mempool: %d in %d txs, carrying total weight of %d (~%.1f blocks)\n",
 		txpool.TransactionsToSendSize, len(txpool.TransactionsToSend),
 		txpool.TransactionsToSendWeight, float64(txpool.TransactionsToSendWeight)/4e6)
@@ -562,43 +613,13 @@ func tx_pool_stats(par string) {
 		len(txpool.TransactionsPending), len(network.NetTxs))
 	fmt.Printf("  Current script verification flags: 0x%x\n", common.CurrentScriptFlags())
 
-	// below are sorting stats (for new features added in gocoin 1.11)
-	if txpool.LastSortingDone.IsZero() {
-		s1 = "never"
-	} else {
-		s1 = time.Since(txpool.LastSortingDone).String() + " ago"
-	}
-	fmt.Printf("SortingDisabled: %t,  ListDirty: %t,  Last: %s\n",
-		txpool.SortingDisabled, txpool.SortListDirty, s1)
-	if txpool.BestT2S != nil && txpool.WorstT2S != nil {
-		s1 = fmt.Sprintf("%06d <-> %06d", get_range_mark(txpool.BestT2S.SortRank),
-			get_range_mark(txpool.WorstT2S.SortRank))
-	} else {
-		s1 = "empty"
-	}
-	if txpool.SortRankRangeValid {
-		s2 = fmt.Sprintf("%06d <-> %06d", get_range_mark(txpool.SortRankMin),
-			get_range_mark(txpool.SortRankMax))
-	} else {
-		s2 = "never used"
-	}
-	fmt.Printf("  SortRankRange Now: %s   Ever: %s\n", s1, s2)
-	if txpool.ResortingSinceLastRedoWhen.IsZero() {
-		fmt.Println("  Sorting rebuild never done yet")
-	} else {
-		fmt.Printf("  Last sorting rebuild: %s ago,  on it since: %s (%d ops)\n",
-			time.Since(txpool.ResortingSinceLastRedoWhen).String(),
-			txpool.ResortingSinceLastRedoTime.String(), txpool.ResortingSinceLastRedoCount)
-	}
-	fmt.Printf("FeePackages Count: %d,  Dirty: %t,  MemSize: %d\n", len(txpool.FeePackages),
-		txpool.FeePackagesDirty, txpool.FeePackagesSysSize())
-	if txpool.RepackagingSinceLastRedoWhen.IsZero() {
-		fmt.Println("  Fee packages rebuild never done yet")
-	} else {
-		fmt.Printf("  Last fee pkgs rebuild: %s ago,  on it since: %s (%d ops)\n",
-			time.Since(txpool.RepackagingSinceLastRedoWhen).String(),
-			txpool.RepackagingSinceLastRedoTime.String(), txpool.RepackagingSinceLastRedoCount)
-	}
+	fmt.Printf("Clusters: %d,  Dirty: %t,  Suspended: %t,  MemSize: %d\n", len(txpool.Clusters),
+		txpool.ClustersDirty, txpool.ClusteringSuspended, txpool.ClustersSysSize())
+	fmt.Printf("  Rebuilt %d times, taking %s,  updated %d times, taking %s\n",
+		txpool.ClustersRebuildCount, txpool.ClustersRebuildTime.String(),
+		txpool.ClustersUpdateCount, txpool.ClustersUpdateTime.String())
+	fmt.Printf("Ancestry Dirty: %t,  rebuilt %d times, taking %s\n", txpool.AncestryDirty,
+		txpool.AncestryRebuildCount, txpool.AncestryRebuildTime.String())
 }
 
 func fetch_mempool(par string) {
@@ -684,6 +705,7 @@ func fetch_mempool(par string) {
 
 func init() {
 	newUi("mpcheck mpc", false, check_txs, "Verify consistency of mempool")
+	newUi("pkgload pkl", true, load_pkg, "Submit txs from the given files as a package: <parent_file> [...] <child_file>")
 	newUi("mpget mpg", false, get_mempool, "Send getmp message to the peer with the given ID")
 	newUi("mpurge", false, mempool_purge, "Purge memory pool (restart from empty)")
 	newUi("mpsave mps", false, save_mempool, "Save memory pool to disk")
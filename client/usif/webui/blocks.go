@@ -2,6 +2,7 @@ package webui
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/piotrnar/gocoin/client/common"
@@ -86,7 +87,7 @@ func json_blocks(w http.ResponseWriter, r *http.Request) {
 		}
 
 		b.Miner, _ = common.TxMiner(cbasetx)
-		b.TotalFees = b.Reward - btc.GetBlockReward(end.Height)
+		b.TotalFees = b.Reward - common.BlockChain.BlockReward(end.Height)
 		if rb.PaidTxsWeight > 0 {
 			b.FeeSPB = float64(4*b.TotalFees) / float64(rb.PaidTxsWeight)
 		}
@@ -170,3 +171,27 @@ func json_blfees(w http.ResponseWriter, r *http.Request) {
 		println(er.Error())
 	}
 }
+
+// chain_block_op calls one of the chain management functions in sync with the main thread.
+func chain_block_op(w http.ResponseWriter, hash string, op func(*btc.Uint256) error) {
+	h := btc.NewUint256FromString(hash)
+	if h == nil {
+		w.Write([]byte("Incorrect block hash"))
+		return
+	}
+
+	lck := new(usif.OneLock)
+	lck.In.Add(1)
+	lck.Out.Add(1)
+	usif.LocksChan <- lck
+	lck.In.Wait()
+	e := op(h)
+	lck.Out.Done()
+
+	if e != nil {
+		w.Write([]byte(e.Error()))
+		return
+	}
+	last := common.BlockChain.LastBlock()
+	w.Write([]byte(fmt.Sprint("Last block is now ", last.Height, " ", last.BlockHash.String())))
+}
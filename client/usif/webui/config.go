@@ -125,6 +125,21 @@ func p_cfg(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(r.Form["invalidate"]) > 0 {
+		chain_block_op(w, r.Form["invalidate"][0], usif.InvalidateBlock)
+		return
+	}
+
+	if len(r.Form["reconsider"]) > 0 {
+		chain_block_op(w, r.Form["reconsider"][0], usif.ReconsiderBlock)
+		return
+	}
+
+	if len(r.Form["precious"]) > 0 {
+		chain_block_op(w, r.Form["precious"][0], usif.PreciousBlock)
+		return
+	}
+
 	if len(r.Form["getconfig"]) > 0 {
 		if !common.CFG.WebUI.ServerMode {
 			common.LockCfg()
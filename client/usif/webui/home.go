@@ -92,6 +92,7 @@ func json_system(w http.ResponseWriter, r *http.Request) {
 		UTXODataSize       int
 		UTXORecordsCnt     int
 		UTXOMemFootprint   int
+		UTXOMuHash         string
 	}
 
 	out.Blocks_cached = network.CachedBlocksLen()
@@ -123,6 +124,7 @@ func json_system(w http.ResponseWriter, r *http.Request) {
 
 	out.SavingUTXO = common.BlockChain.Unspent.WritingInProgress.Get()
 	out.UTXODataSize, out.UTXORecordsCnt, out.UTXOMemFootprint = common.BlockChain.Unspent.GetUTXOSize()
+	out.UTXOMuHash = btc.NewUint256(common.BlockChain.Unspent.MuHash()).String()
 	out.ProcessPID = os.Getpid()
 
 	var ms runtime.MemStats
@@ -51,15 +51,38 @@ func (x onemiernstat) Swap(i, j int) {
 }
 
 func json_blkver(w http.ResponseWriter, r *http.Request) {
+	type one_deployment struct {
+		Name      string
+		Bit       uint8
+		Status    string
+		Since     uint32
+		Elapsed   uint32
+		Count     uint32
+		Threshold uint32
+		Possible  bool
+	}
+
 	w.Header()["Content-Type"] = []string{"application/json"}
 
 	common.Last.Mutex.Lock()
 	end := common.Last.Block
 	common.Last.Mutex.Unlock()
 
-	w.Write([]byte("["))
+	ch := common.BlockChain
+	w.Write([]byte(fmt.Sprint("{\"Period\":", ch.Consensus.Window, ",\"Deployments\":")))
+	deps := make([]one_deployment, len(ch.Consensus.Deployments))
+	for i, d := range ch.Consensus.Deployments {
+		st := ch.DeploymentStatistics(d, end)
+		deps[i] = one_deployment{Name: d.Name, Bit: d.Bit, Status: ch.DeploymentState(d, end).String(),
+			Since: ch.DeploymentSince(d, end), Elapsed: st.Elapsed, Count: st.Count,
+			Threshold: st.Threshold, Possible: st.Possible}
+	}
+	bx, _ := json.Marshal(deps)
+	w.Write(bx)
+
+	w.Write([]byte(",\"Blocks\":["))
 	if end != nil {
-		max_cnt := 2 * 2016 //common.BlockChain.Consensus.Window
+		max_cnt := 2 * ch.Consensus.Window
 		for {
 			w.Write([]byte(fmt.Sprint("[", end.Height, ",", binary.LittleEndian.Uint32(end.BlockHeader[0:4]), "]")))
 			end = end.Parent
@@ -70,7 +93,7 @@ func json_blkver(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte(","))
 		}
 	}
-	w.Write([]byte("]"))
+	w.Write([]byte("]}"))
 }
 
 func json_miners(w http.ResponseWriter, r *http.Request) {
@@ -140,7 +163,7 @@ func json_miners(w http.ResponseWriter, r *http.Request) {
 		for o := range cbasetx.TxOut {
 			rew += cbasetx.TxOut[o].Value
 		}
-		fees := rew - btc.GetBlockReward(end.Height)
+		fees := rew - common.BlockChain.BlockReward(end.Height)
 		if int64(fees) > 0 { // solution for a possibility of a miner not claiming the reward (see block #501726)
 			om.fees += fees
 		}
@@ -164,6 +164,12 @@ func tx_xml(w http.ResponseWriter, v *txpool.OneTxToSend, verbose bool) {
 	fmt.Fprint(w, "<final>", v.Final, "</final>")
 	fmt.Fprint(w, "<verify_us>", uint(v.VerifyTime/time.Microsecond), "</verify_us>")
 	fmt.Fprint(w, "<meminputcnt>", v.MemInputCnt, "</meminputcnt>")
+	fmt.Fprint(w, "<ancestors>", v.Ancestors.Count, "</ancestors>")
+	fmt.Fprint(w, "<ancestor_size>", v.Ancestors.VSize, "</ancestor_size>")
+	fmt.Fprint(w, "<ancestor_fee>", v.Ancestors.Fee, "</ancestor_fee>")
+	fmt.Fprint(w, "<descendants>", v.Descendants.Count, "</descendants>")
+	fmt.Fprint(w, "<descendant_size>", v.Descendants.VSize, "</descendant_size>")
+	fmt.Fprint(w, "<descendant_fee>", v.Descendants.Fee, "</descendant_fee>")
 	if verbose {
 		fmt.Fprint(w, "<raw>", hex.EncodeToString(v.Raw), "</raw>")
 	}
@@ -268,6 +274,7 @@ func xml_txs2s(w http.ResponseWriter, r *http.Request) {
 		}
 		txpool.TxMutex.Lock()
 		defer txpool.TxMutex.Unlock()
+		txpool.UpdateAncestry()
 		if t2s, ok := txpool.TransactionsToSend[txid.BIdx()]; ok {
 			tx_xml(w, t2s, true)
 		} else {
@@ -343,6 +350,7 @@ func xml_txs2s(w http.ResponseWriter, r *http.Request) {
 
 	txpool.TxMutex.Lock()
 	defer txpool.TxMutex.Unlock()
+	txpool.UpdateAncestry()
 
 	sorted := make(sortedTxList, len(txpool.TransactionsToSend))
 	var cnt int
@@ -484,9 +492,9 @@ func json_txstat(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(fmt.Sprint("\"min_fee_per_kb\":", common.MinFeePerKB(), ",")))
 	w.Write([]byte(fmt.Sprint("\"tx_pool_on\":", common.Get(&common.CFG.TXPool.Enabled), ",")))
 	w.Write([]byte(fmt.Sprint("\"tx_routing_on\":", common.Get(&common.CFG.TXRoute.Enabled), ",")))
-	w.Write([]byte(fmt.Sprint("\"sorting_disabled\":", txpool.SortingDisabled, ",")))
-	w.Write([]byte(fmt.Sprint("\"sorting_list_dirty\":", txpool.SortListDirty, ",")))
-	w.Write([]byte(fmt.Sprint("\"fee_packages_dirty\":", txpool.FeePackagesDirty, ",")))
+	w.Write([]byte(fmt.Sprint("\"clustering_suspended\":", txpool.ClusteringSuspended, ",")))
+	w.Write([]byte(fmt.Sprint("\"clusters_dirty\":", txpool.ClustersDirty, ",")))
+	w.Write([]byte(fmt.Sprint("\"clusters_cnt\":", len(txpool.Clusters), ",")))
 	w.Write([]byte(fmt.Sprint("\"current_fee_adjusted_spkb\":", txpool.CurrentFeeAdjustedSPKB, "")))
 
 	txpool.TxMutex.Unlock()
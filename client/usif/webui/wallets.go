@@ -489,3 +489,48 @@ func json_wallet_status(w http.ResponseWriter, r *http.Request) {
 		println(er.Error())
 	}
 }
+
+func json_addr_history(w http.ResponseWriter, r *http.Request) {
+	if common.BlockChain.AddrIndex == nil || len(r.Form["addr"]) == 0 {
+		return
+	}
+	aa, er := btc.NewAddrFromString(r.Form["addr"][0])
+	if er != nil {
+		println(er.Error())
+		return
+	}
+	recs, er := common.BlockChain.AddrIndex.Get(aa.OutScript())
+	if er != nil {
+		println(er.Error())
+		return
+	}
+
+	type OneRec struct {
+		TxId     string
+		Height   uint32
+		Index    uint32
+		Value    uint64
+		Spending bool
+	}
+	var out struct {
+		Addr         string
+		CompleteFrom uint32
+		Recs         []OneRec
+	}
+	out.Addr = aa.String()
+	common.BlockChain.AddrIndex.Lock()
+	out.CompleteFrom = common.BlockChain.AddrIndex.From
+	common.BlockChain.AddrIndex.Unlock()
+	out.Recs = make([]OneRec, len(recs))
+	for i, rec := range recs {
+		out.Recs[i] = OneRec{TxId: rec.TxID.String(), Height: rec.Height, Index: rec.Index, Value: rec.Value, Spending: rec.Spend}
+	}
+
+	bx, er := json.Marshal(out)
+	if er == nil {
+		w.Header()["Content-Type"] = []string{"application/json"}
+		w.Write(bx)
+	} else {
+		println(er.Error())
+	}
+}
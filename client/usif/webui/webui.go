@@ -171,6 +171,7 @@ func ServerThread() {
 	http.HandleFunc("/miners.json", json_miners)
 	http.HandleFunc("/blfees.json", json_blfees)
 	http.HandleFunc("/walsta.json", json_wallet_status)
+	http.HandleFunc("/addrhist.json", json_addr_history)
 
 	http.HandleFunc("/mempool_fees.txt", txt_mempool_fees)
 	http.HandleFunc("/authkey.txt", p_authkey)
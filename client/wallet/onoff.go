@@ -43,15 +43,14 @@ func LoadBalancesFromUtxo() {
 	InitMaps(false)
 
 	for _i := range common.BlockChain.Unspent.HashMap {
-		common.BlockChain.Unspent.MapMutex[_i].RLock()
-		for _, v := range common.BlockChain.Unspent.HashMap[_i] {
+		common.BlockChain.Unspent.BrowseRecords(_i, func(v *[]byte) bool {
 			TxNotifyAdd(utxo.NewUtxoRecStatic(*v))
 			if FetchingBalanceTick != nil && FetchingBalanceTick() {
 				aborted = true
-				break
+				return false
 			}
-		}
-		common.BlockChain.Unspent.MapMutex[_i].RUnlock()
+			return true
+		})
 		if aborted {
 			break
 		}
@@ -39,8 +39,11 @@ func NewAddrFromString(hs string) (a *BtcAddr, e error) {
 	}
 
 	prefix := strings.ToLower(hs[:3])
-	if prefix == "bc1" || prefix == "tb1" {
-		var sw = &SegwitProg{HRP: prefix[:2]}
+	if prefix == "bcr" && len(hs) > 5 && strings.ToLower(hs[:5]) == "bcrt1" {
+		prefix = "bcrt1" // regtest
+	}
+	if prefix == "bc1" || prefix == "tb1" || prefix == "bcrt1" {
+		var sw = &SegwitProg{HRP: prefix[:len(prefix)-1]}
 		sw.Version, sw.Program, e = bech32.SegwitDecode(sw.HRP, hs)
 		if sw.Program != nil {
 			a = &BtcAddr{SegwitProg: sw}
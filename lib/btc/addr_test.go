@@ -148,4 +148,6 @@ func TestSegwit(t *testing.T) {
 	test_both_segwit(t, "bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3", true, 0, 32)
 	test_both_segwit(t, "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", true, 1, 32)
 	test_both_segwit(t, "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd", false, 0, 0)
+	test_both_segwit(t, "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080", true, 0, 20)
+	test_both_segwit(t, "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt081", false, 0, 0)
 }
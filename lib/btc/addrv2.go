@@ -0,0 +1,242 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/piotrnar/gocoin/lib/others/sha3"
+)
+
+// BIP155 network IDs
+const (
+	NETID_IPV4  = 0x01
+	NETID_IPV6  = 0x02
+	NETID_TORV2 = 0x03 // deprecated - we do not support it
+	NETID_TORV3 = 0x04
+	NETID_I2P   = 0x05
+	NETID_CJDNS = 0x06
+
+	MAX_ADDRV2_SIZE = 512 // BIP155: reject addresses longer than this
+)
+
+var (
+	b32enc   = base32.StdEncoding.WithPadding(base32.NoPadding)
+	ip4inip6 = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+)
+
+// AddrV2Len returns the address length for a given BIP155 network ID
+// or zero if the network is unknown to us.
+func AddrV2Len(netid byte) int {
+	switch netid {
+	case NETID_IPV4:
+		return 4
+	case NETID_IPV6, NETID_CJDNS:
+		return 16
+	case NETID_TORV3, NETID_I2P:
+		return 32
+	}
+	return 0
+}
+
+// Network returns BIP155 network ID of the address.
+func (a *NetAddr) Network() byte {
+	if a.AddrV2 != nil {
+		return a.NetID
+	}
+	if bytes.Equal(a.Ip6[:], ip4inip6) {
+		return NETID_IPV4
+	}
+	return NETID_IPV6
+}
+
+// IsLegacy returns true if the address can be sent over the old "addr" message.
+func (a *NetAddr) IsLegacy() bool {
+	return a.AddrV2 == nil
+}
+
+// IsIPv4 returns true for IPv4 address.
+func (a *NetAddr) IsIPv4() bool {
+	return a.Network() == NETID_IPV4
+}
+
+// BytesV2 returns the BIP155 serialization of the address (without the time field).
+func (a *NetAddr) BytesV2() []byte {
+	b := new(bytes.Buffer)
+	WriteVlen(b, a.Services)
+	switch netid := a.Network(); netid {
+	case NETID_IPV4:
+		b.WriteByte(NETID_IPV4)
+		b.WriteByte(4)
+		b.Write(a.Ip4[:])
+	case NETID_IPV6:
+		b.WriteByte(NETID_IPV6)
+		b.WriteByte(16)
+		b.Write(a.Ip6[:])
+		b.Write(a.Ip4[:])
+	default:
+		b.WriteByte(netid)
+		WriteVlen(b, uint64(len(a.AddrV2)))
+		b.Write(a.AddrV2)
+	}
+	binary.Write(b, binary.BigEndian, a.Port)
+	return b.Bytes()
+}
+
+// ReadNetAddrV2 reads one record of "addrv2" message.
+// If the network is unknown, or the address invalid for it, it returns na=nil with no error.
+func ReadNetAddrV2(rd io.Reader) (na *NetAddr, tim uint32, e error) {
+	var netid [1]byte
+	var le, services uint64
+	var port [2]byte
+
+	if e = binary.Read(rd, binary.LittleEndian, &tim); e != nil {
+		return
+	}
+	if services, e = ReadVLen(rd); e != nil {
+		return
+	}
+	if _, e = io.ReadFull(rd, netid[:]); e != nil {
+		return
+	}
+	if le, e = ReadVLen(rd); e != nil {
+		return
+	}
+	if le > MAX_ADDRV2_SIZE {
+		e = errors.New("addrv2 address too long")
+		return
+	}
+	addr := make([]byte, int(le))
+	if _, e = io.ReadFull(rd, addr); e != nil {
+		return
+	}
+	if _, e = io.ReadFull(rd, port[:]); e != nil {
+		return
+	}
+
+	if AddrV2Len(netid[0]) != len(addr) {
+		return // unknown network or wrong address length - ignore it
+	}
+
+	na = new(NetAddr)
+	na.Services = services
+	na.Port = binary.BigEndian.Uint16(port[:])
+	switch netid[0] {
+	case NETID_IPV4:
+		copy(na.Ip6[:], ip4inip6)
+		copy(na.Ip4[:], addr)
+	case NETID_IPV6:
+		if bytes.Equal(addr[:12], ip4inip6) {
+			na = nil // BIP155: IPv4-mapped addresses must be sent as IPv4
+			return
+		}
+		copy(na.Ip6[:], addr[:12])
+		copy(na.Ip4[:], addr[12:])
+	case NETID_CJDNS:
+		if addr[0] != 0xfc {
+			na = nil
+			return
+		}
+		fallthrough
+	default:
+		na.NetID = netid[0]
+		na.AddrV2 = addr
+	}
+	return
+}
+
+// onionChecksum returns the two bytes of TorV3 address checksum.
+func onionChecksum(pubkey []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubkey)
+	h.Write([]byte{3})
+	return h.Sum(nil)[:2]
+}
+
+// OnionToString converts 32 bytes of TorV3 public key to the onion address (without the port).
+func OnionToString(pubkey []byte) string {
+	b := make([]byte, 0, 35)
+	b = append(b, pubkey...)
+	b = append(b, onionChecksum(pubkey)...)
+	b = append(b, 3)
+	return strings.ToLower(b32enc.EncodeToString(b)) + ".onion"
+}
+
+// ParseOnion decodes TorV3 onion address (with or without the ".onion" suffix).
+func ParseOnion(s string) (pubkey []byte, e error) {
+	s = strings.TrimSuffix(strings.ToLower(s), ".onion")
+	var b []byte
+	if b, e = b32enc.DecodeString(strings.ToUpper(s)); e != nil {
+		return
+	}
+	if len(b) != 35 || b[34] != 3 {
+		e = errors.New("not a TorV3 onion address")
+		return
+	}
+	if !bytes.Equal(onionChecksum(b[:32]), b[32:34]) {
+		e = errors.New("onion address checksum error")
+		return
+	}
+	pubkey = b[:32]
+	return
+}
+
+// ParseI2P decodes I2P address in "<base32>.b32.i2p" format.
+func ParseI2P(s string) (hash []byte, e error) {
+	s = strings.TrimSuffix(strings.ToLower(s), ".b32.i2p")
+	if hash, e = b32enc.DecodeString(strings.ToUpper(s)); e != nil {
+		return
+	}
+	if len(hash) != 32 {
+		e = errors.New("not a valid I2P address")
+	}
+	return
+}
+
+// NewNetAddrFromHost makes a BIP155 address from onion or i2p host name.
+// It returns nil if the host is not a valid address of any of these networks.
+func NewNetAddrFromHost(host string, port uint16) (na *NetAddr) {
+	var dat []byte
+	var netid byte
+	var e error
+	if strings.HasSuffix(strings.ToLower(host), ".onion") {
+		dat, e = ParseOnion(host)
+		netid = NETID_TORV3
+	} else if strings.HasSuffix(strings.ToLower(host), ".b32.i2p") {
+		dat, e = ParseI2P(host)
+		netid = NETID_I2P
+	} else {
+		return
+	}
+	if e != nil {
+		return
+	}
+	na = &NetAddr{NetID: netid, AddrV2: dat, Port: port}
+	return
+}
+
+// HostString returns the address without the port number.
+func (a *NetAddr) HostString() string {
+	switch a.Network() {
+	case NETID_IPV4:
+		return fmt.Sprintf("%d.%d.%d.%d", a.Ip4[0], a.Ip4[1], a.Ip4[2], a.Ip4[3])
+	case NETID_IPV6:
+		ip := make(net.IP, 16)
+		copy(ip[:12], a.Ip6[:])
+		copy(ip[12:], a.Ip4[:])
+		return "[" + ip.String() + "]"
+	case NETID_TORV3:
+		return OnionToString(a.AddrV2)
+	case NETID_I2P:
+		return strings.ToLower(b32enc.EncodeToString(a.AddrV2)) + ".b32.i2p"
+	case NETID_CJDNS:
+		return "[" + net.IP(a.AddrV2).String() + "]"
+	}
+	return fmt.Sprintf("net%d:%x", a.NetID, a.AddrV2)
+}
@@ -0,0 +1,74 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+var addrv2_vecs = []struct {
+	rec  string
+	host string
+}{
+	{"04" + "20" + "79bcc625184b05194975c28b66b66b0469f7f6556fb1ac3189a79b40dda32f1f",
+		"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion"},
+	{"05" + "20" + "a2894dabaec08c0051a481a6dac88b64f98232ae42d4b6fd2fa81952dfe36a87",
+		"ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p"},
+	{"06" + "10" + "fc000001000200030004000500060007", "[fc00:1:2:3:4:5:6:7]"},
+	{"01" + "04" + "01020304", "1.2.3.4"},
+	{"02" + "10" + "20010db8000000000000000000000001", "[2001:db8::1]"},
+}
+
+func TestAddrV2(t *testing.T) {
+	for i, v := range addrv2_vecs {
+		raw, _ := hex.DecodeString("ffffffff" + "09" + v.rec + "208d")
+		na, tim, e := ReadNetAddrV2(bytes.NewReader(raw))
+		if e != nil || na == nil {
+			t.Fatal(i, "ReadNetAddrV2 failed", e)
+		}
+		if tim != 0xffffffff || na.Services != 9 || na.Port != 8333 {
+			t.Error(i, "Bad values", tim, na.Services, na.Port)
+		}
+		if na.HostString() != v.host {
+			t.Error(i, "Bad host string", na.HostString())
+		}
+		if !bytes.Equal(na.BytesV2(), raw[4:]) {
+			t.Error(i, "Bad serialization", hex.EncodeToString(na.BytesV2()))
+		}
+		if na.IsLegacy() != (na.Network() == NETID_IPV4 || na.Network() == NETID_IPV6) {
+			t.Error(i, "Bad IsLegacy")
+		}
+	}
+
+	// unknown network ID or wrong address length must be ignored
+	for _, rec := range []string{"03" + "0a" + "f1f2f3f4f5f6f7f8f9fa", "07" + "01" + "00", "04" + "01" + "00"} {
+		raw, _ := hex.DecodeString("00000000" + "00" + rec + "0000")
+		na, _, e := ReadNetAddrV2(bytes.NewReader(raw))
+		if e != nil || na != nil {
+			t.Error("Address", rec, "should have been ignored")
+		}
+	}
+
+	// too long address
+	raw, _ := hex.DecodeString("00000000" + "00" + "07" + "fd0102")
+	raw = append(raw, make([]byte, 0x201+2)...)
+	if _, _, e := ReadNetAddrV2(bytes.NewReader(raw)); e == nil {
+		t.Error("Too long address not detected")
+	}
+}
+
+func TestOnion(t *testing.T) {
+	na := NewNetAddrFromHost("pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", 8333)
+	if na == nil || na.Network() != NETID_TORV3 {
+		t.Fatal("NewNetAddrFromHost failed")
+	}
+	if na.String() != "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion:8333" {
+		t.Error("Bad string", na.String())
+	}
+	if _, e := ParseOnion("pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscrye.onion"); e == nil {
+		t.Error("Checksum error not detected")
+	}
+	if NewNetAddrFromHost("ukeu3k5oycgaauneqgtnvselmt4yemvoilkln7jpvamvfx7dnkdq.b32.i2p", 0) == nil {
+		t.Error("NewNetAddrFromHost failed for I2P")
+	}
+}
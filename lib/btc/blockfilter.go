@@ -0,0 +1,215 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"slices"
+
+	"github.com/piotrnar/gocoin/lib/others/siphash"
+)
+
+// BIP158 - Compact Block Filters
+
+const (
+	BLOCK_FILTER_BASIC = 0 // the only filter type defined by BIP158
+
+	BASIC_FILTER_P = 19
+	BASIC_FILTER_M = 784931
+)
+
+// BasicFilterElements returns the scripts that go into the basic filter of the block:
+// all the output scripts (except for OP_RETURN ones) and the scripts of all the outputs being spent.
+// For each non-coinbase tx, its Spent_outputs must be set.
+func (bl *Block) BasicFilterElements() (res [][]byte) {
+	done := make(map[string]bool)
+	add := func(s []byte) {
+		if len(s) > 0 && !done[string(s)] {
+			done[string(s)] = true
+			res = append(res, s)
+		}
+	}
+	for i, tx := range bl.Txs {
+		for _, out := range tx.TxOut {
+			if len(out.Pk_script) > 0 && out.Pk_script[0] != OP_RETURN {
+				add(out.Pk_script)
+			}
+		}
+		if i > 0 {
+			for _, so := range tx.Spent_outputs {
+				add(so.Pk_script)
+			}
+		}
+	}
+	return
+}
+
+// BasicFilterKey returns the siphash key for the block's filter (the first 16 bytes of the block hash).
+func BasicFilterKey(block_hash *Uint256) (k0, k1 uint64) {
+	k0 = binary.LittleEndian.Uint64(block_hash.Hash[0:8])
+	k1 = binary.LittleEndian.Uint64(block_hash.Hash[8:16])
+	return
+}
+
+// NewBasicFilter returns the serialized basic filter of the block, for the given elements.
+func NewBasicFilter(block_hash *Uint256, elements [][]byte) []byte {
+	k0, k1 := BasicFilterKey(block_hash)
+	return NewGCSFilter(k0, k1, BASIC_FILTER_P, BASIC_FILTER_M, elements)
+}
+
+func gcsHashedSet(k0, k1 uint64, m uint64, elements [][]byte) (res []uint64) {
+	f := uint64(len(elements)) * m
+	res = make([]uint64, len(elements))
+	for i, e := range elements {
+		res[i], _ = bits.Mul64(siphash.Hash(k0, k1, e), f)
+	}
+	return
+}
+
+// NewGCSFilter builds a Golomb-coded set of the elements (that must be unique).
+// The result is the number of elements (as var_int) followed by the encoded set.
+func NewGCSFilter(k0, k1 uint64, p uint, m uint64, elements [][]byte) []byte {
+	b := new(bytes.Buffer)
+	WriteVlen(b, uint64(len(elements)))
+	if len(elements) == 0 {
+		return b.Bytes()
+	}
+	set := gcsHashedSet(k0, k1, m, elements)
+	slices.Sort(set)
+
+	var bw bitWriter
+	var last uint64
+	for _, v := range set {
+		delta := v - last
+		last = v
+		for q := delta >> p; q > 0; q-- {
+			bw.writeBit(1)
+		}
+		bw.writeBit(0)
+		bw.writeBits(delta, p)
+	}
+	b.Write(bw.bytes())
+	return b.Bytes()
+}
+
+// GCSFilterValues decodes the filter, returning the number of elements and the sorted hashed values.
+func GCSFilterValues(filter []byte, p uint) (n uint64, res []uint64, e error) {
+	le, of := VLen(filter)
+	if of == 0 {
+		e = errors.New("gcs: bad element count")
+		return
+	}
+	n = uint64(le)
+	if n > uint64(8*len(filter)) { // each element takes at least p+1 bits
+		e = errors.New("gcs: element count too big")
+		return
+	}
+	br := bitReader{dat: filter[of:]}
+	res = make([]uint64, 0, n)
+	var last uint64
+	for i := uint64(0); i < n; i++ {
+		var q uint64
+		for {
+			bit, ok := br.readBit()
+			if !ok {
+				e = errors.New("gcs: filter truncated")
+				return
+			}
+			if bit == 0 {
+				break
+			}
+			q++
+		}
+		r, ok := br.readBits(p)
+		if !ok {
+			e = errors.New("gcs: filter truncated")
+			return
+		}
+		last += q<<p | r
+		res = append(res, last)
+	}
+	return
+}
+
+// GCSMatchAny returns true if any of the elements (probably) is in the filter.
+func GCSMatchAny(filter []byte, k0, k1 uint64, p uint, m uint64, elements [][]byte) (bool, error) {
+	n, set, e := GCSFilterValues(filter, p)
+	if e != nil || n == 0 || len(elements) == 0 {
+		return false, e
+	}
+	f := n * m
+	for _, el := range elements {
+		v, _ := bits.Mul64(siphash.Hash(k0, k1, el), f)
+		if _, found := slices.BinarySearch(set, v); found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// BlockFilterHeader returns the hash of the filter and the filter header,
+// which commits to the previous block's filter header (all zeros for the genesis block).
+func BlockFilterHeader(filter []byte, prev_header *Uint256) (filter_hash, header *Uint256) {
+	filter_hash = NewSha2Hash(filter)
+	var buf [64]byte
+	copy(buf[:32], filter_hash.Hash[:])
+	if prev_header != nil {
+		copy(buf[32:], prev_header.Hash[:])
+	}
+	header = NewSha2Hash(buf[:])
+	return
+}
+
+type bitWriter struct {
+	buf   []byte
+	nbits uint
+}
+
+func (w *bitWriter) writeBit(bit byte) {
+	if w.nbits&7 == 0 {
+		w.buf = append(w.buf, 0)
+	}
+	if bit != 0 {
+		w.buf[len(w.buf)-1] |= 0x80 >> (w.nbits & 7)
+	}
+	w.nbits++
+}
+
+// writeBits writes the n lowest bits of v, the most significant one first.
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for n > 0 {
+		n--
+		w.writeBit(byte(v>>n) & 1)
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
+
+type bitReader struct {
+	dat []byte
+	pos uint
+}
+
+func (r *bitReader) readBit() (byte, bool) {
+	if r.pos>>3 >= uint(len(r.dat)) {
+		return 0, false
+	}
+	bit := (r.dat[r.pos>>3] >> (7 - r.pos&7)) & 1
+	r.pos++
+	return bit, true
+}
+
+func (r *bitReader) readBits(n uint) (v uint64, ok bool) {
+	var bit byte
+	for ; n > 0; n-- {
+		if bit, ok = r.readBit(); !ok {
+			return
+		}
+		v = v<<1 | uint64(bit)
+	}
+	ok = true
+	return
+}
@@ -0,0 +1,78 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+// testnet3 genesis block
+const testnet_genesis = "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4adae5494dffff001d1aa4ae18" +
+	"0101000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff" +
+	"0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+func TestBasicFilterGenesis(t *testing.T) {
+	raw, _ := hex.DecodeString(testnet_genesis)
+	bl, er := NewBlock(raw)
+	if er != nil {
+		t.Fatal(er)
+	}
+	if bl.Hash.String() != "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943" {
+		t.Fatal("Bad genesis hash", bl.Hash.String())
+	}
+	if er = bl.BuildTxList(); er != nil {
+		t.Fatal(er)
+	}
+	filter := NewBasicFilter(bl.Hash, bl.BasicFilterElements())
+	if hex.EncodeToString(filter) != "019dfca8" {
+		t.Fatal("Bad filter", hex.EncodeToString(filter))
+	}
+	_, hdr := BlockFilterHeader(filter, nil)
+	if hdr.String() != "21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750" {
+		t.Error("Bad filter header", hdr.String())
+	}
+}
+
+func TestGCSFilter(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var elements [][]byte
+	for i := 0; i < 500; i++ {
+		e := make([]byte, 20+r.Intn(20))
+		r.Read(e)
+		elements = append(elements, e)
+	}
+	k0, k1 := r.Uint64(), r.Uint64()
+	filter := NewGCSFilter(k0, k1, BASIC_FILTER_P, BASIC_FILTER_M, elements)
+
+	n, set, er := GCSFilterValues(filter, BASIC_FILTER_P)
+	if er != nil {
+		t.Fatal(er)
+	}
+	if n != 500 || len(set) != 500 {
+		t.Fatal("Bad number of elements", n, len(set))
+	}
+	for _, e := range elements {
+		if ok, _ := GCSMatchAny(filter, k0, k1, BASIC_FILTER_P, BASIC_FILTER_M, [][]byte{e}); !ok {
+			t.Fatal("Element not matched", hex.EncodeToString(e))
+		}
+	}
+	var false_pos int
+	for i := 0; i < 1000; i++ {
+		e := make([]byte, 32)
+		r.Read(e)
+		if ok, _ := GCSMatchAny(filter, k0, k1, BASIC_FILTER_P, BASIC_FILTER_M, [][]byte{e}); ok {
+			false_pos++
+		}
+	}
+	if false_pos > 2 {
+		t.Error("Too many false positives", false_pos)
+	}
+
+	if _, _, er = GCSFilterValues(filter[:len(filter)/2], BASIC_FILTER_P); er == nil {
+		t.Error("Error expected for truncated filter")
+	}
+	if empty := NewGCSFilter(k0, k1, BASIC_FILTER_P, BASIC_FILTER_M, nil); !bytes.Equal(empty, []byte{0}) {
+		t.Error("Bad empty filter", hex.EncodeToString(empty))
+	}
+}
@@ -13,5 +13,7 @@ const (
 
 	SERVICE_NETWORK         = 1 << 0
 	SERVICE_SEGWIT          = 1 << 3
+	SERVICE_COMPACT_FILTERS = 1 << 6 // BIP157
 	SERVICE_NETWORK_LIMITED = 1 << 10
+	SERVICE_P2P_V2          = 1 << 11 // BIP324
 )
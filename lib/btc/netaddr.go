@@ -10,6 +10,8 @@ type NetAddr struct {
 	Ip6 [12]byte
 	Ip4 [4]byte
 	Port uint16
+	NetID byte // BIP155 network ID - only used along with AddrV2
+	AddrV2 []byte // BIP155 address, for networks that do not fit into Ip6+Ip4
 }
 
 func NewNetAddr(b []byte) (na *NetAddr) {
@@ -36,5 +38,8 @@ func (a *NetAddr) Bytes() (res []byte) {
 
 
 func (a *NetAddr) String() string {
+	if a.AddrV2 != nil {
+		return fmt.Sprint(a.HostString(), ":", a.Port)
+	}
 	return fmt.Sprintf("%d.%d.%d.%d:%d", a.Ip4[0], a.Ip4[1], a.Ip4[2], a.Ip4[3], a.Port)
 }
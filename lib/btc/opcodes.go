@@ -26,10 +26,11 @@ const (
 	OP_15        = 0x5f
 	OP_16        = 0x60
 
-	OP_IF    = 0x63
-	OP_NOTIF = 0x64
-	OP_ELSE  = 0x67
-	OP_ENDIF = 0x68
+	OP_IF     = 0x63
+	OP_NOTIF  = 0x64
+	OP_ELSE   = 0x67
+	OP_ENDIF  = 0x68
+	OP_RETURN = 0x6a
 
 	OP_EQUAL         = 0x87
 	OP_HASH160       = 0xa9
@@ -0,0 +1,358 @@
+package chain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/others/siphash"
+	"github.com/piotrnar/gocoin/lib/script"
+)
+
+/*
+	Address history index (the "addrindex" folder):
+	state.dat - 8 bytes (all values LSB):
+		[0:4] - history is complete from this height up (the blocks below were connected before the index was enabled)
+		[4:8] - height of the last indexed block
+	00/00.dat ... ff/ff.dat - records of 56 bytes, in a file selected by the first 16 bits of the address key:
+		[0:8] - 64-bit address key (see AddrIndexKey)
+		[8:40] - 256-bit txid
+		[40:44] - 32-bit block height
+		[44:48] - 32-bit output index (funding) or input index with the highest bit set (spending)
+		[48:56] - 64-bit value
+	The blocks are only indexed as they get connected, so the records in each file are sorted by height.
+	When a block is undone, the records from its height up are truncated away.
+*/
+
+const (
+	ADDRINDEX_REC_LEN    = 56
+	ADDRINDEX_FLUSH_SIZE = 16 << 20 // write the pending records to disk when there is this much of them
+	ADDRINDEX_UNDO_KEEP  = 144      // remember the files touched by this many recent blocks (for quick undo)
+	ADDRINDEX_SPENDING   = 0x80000000
+)
+
+var ErrAddrNotIndexed = errors.New("unsupported address type")
+
+type AddrHistRec struct {
+	TxID   btc.Uint256
+	Height uint32
+	Index  uint32 // output index (funding), or input index (spending)
+	Value  uint64
+	Spend  bool
+}
+
+type AddrIndex struct {
+	dir         string
+	From        uint32 // the history is complete from this height up
+	Tip         uint32 // height of the last indexed block
+	Records     uint64
+	pending     map[uint16][]byte
+	pendingSize int
+	touched     map[uint32][]uint16 // files touched by the recent blocks
+	lastSave    time.Time
+	closed      bool
+	sync.Mutex
+}
+
+// AddrIndexKey returns the index key of the output script. Only P2KH, P2SH, P2WPKH, P2WSH and P2TR are indexed.
+func AddrIndexKey(pkscr []byte) (key uint64, ok bool) {
+	var typ uint64
+	var hash []byte
+	if script.IsP2KH(pkscr) {
+		typ, hash = 0, pkscr[3:23]
+	} else if script.IsP2SH(pkscr) {
+		typ, hash = 1, pkscr[2:22]
+	} else if script.IsP2WPKH(pkscr) {
+		typ, hash = 2, pkscr[2:22]
+	} else if script.IsP2WSH(pkscr) {
+		typ, hash = 3, pkscr[2:34]
+	} else if script.IsP2TAP(pkscr) {
+		typ, hash = 4, pkscr[2:34]
+	} else {
+		return
+	}
+	return siphash.Hash(typ, 0, hash), true
+}
+
+// NewAddrIndex opens (or creates) the address history index in the given folder.
+// tip is the height of the chain's last block.
+func NewAddrIndex(dir string, tip uint32) (db *AddrIndex, e error) {
+	if e = os.MkdirAll(dir, 0770); e != nil {
+		return
+	}
+	db = &AddrIndex{dir: dir, pending: make(map[uint16][]byte), touched: make(map[uint32][]uint16)}
+	if d, er := os.ReadFile(dir + "state.dat"); er == nil && len(d) == 8 {
+		db.From = binary.LittleEndian.Uint32(d[0:4])
+		db.Tip = binary.LittleEndian.Uint32(d[4:8])
+	} else {
+		db.From = tip + 1
+		db.Tip = tip
+	}
+	for i := 0; i < 0x10000; i++ {
+		if fi, er := os.Stat(db.fname(uint16(i))); er == nil {
+			db.Records += uint64(fi.Size() / ADDRINDEX_REC_LEN)
+		}
+	}
+	if db.Tip > tip {
+		// the chain is behind the index (e.g. after an unclean shutdown, or a rescan)
+		if e = db.truncate(nil, tip); e != nil {
+			return
+		}
+		db.Tip = tip
+	} else if db.Tip < tip {
+		fmt.Println("WARNING: Address index is behind the chain (restart with -r to rebuild it)")
+		db.From = tip + 1
+		db.Tip = tip
+	}
+	if db.From > tip+1 {
+		db.From = tip + 1
+	}
+	e = db.saveState()
+	return
+}
+
+func (db *AddrIndex) fname(i uint16) string {
+	return fmt.Sprintf("%s%02x%c%02x.dat", db.dir, i>>8, os.PathSeparator, i&0xff)
+}
+
+// truncate removes the records above the given height from the files (all of them if files is nil).
+// Call it with the mutex locked and nothing pending.
+func (db *AddrIndex) truncate(files []uint16, height uint32) (e error) {
+	if files == nil {
+		files = make([]uint16, 0x10000)
+		for i := range files {
+			files[i] = uint16(i)
+		}
+	}
+	var buf [64 * ADDRINDEX_REC_LEN]byte
+	for _, i := range files {
+		f, er := os.OpenFile(db.fname(i), os.O_RDWR, 0660)
+		if er != nil {
+			continue
+		}
+		size, _ := f.Seek(0, io.SeekEnd)
+		size -= size % ADDRINDEX_REC_LEN
+		pos := size
+	scan: // go back, as long as the records are above the height
+		for pos > 0 {
+			n := int64(len(buf))
+			if n > pos {
+				n = pos
+			}
+			if _, e = f.ReadAt(buf[:n], pos-n); e != nil {
+				break
+			}
+			for ; n > 0; n -= ADDRINDEX_REC_LEN {
+				if binary.LittleEndian.Uint32(buf[n-ADDRINDEX_REC_LEN+40:]) <= height {
+					break scan
+				}
+				pos -= ADDRINDEX_REC_LEN
+			}
+		}
+		if e == nil {
+			e = f.Truncate(pos)
+		}
+		f.Close()
+		if e != nil {
+			return
+		}
+		db.Records -= uint64(size-pos) / ADDRINDEX_REC_LEN
+	}
+	return
+}
+
+// flush writes the pending records to disk. Call it with the mutex locked.
+func (db *AddrIndex) flush() (e error) {
+	for i, d := range db.pending {
+		f, er := os.OpenFile(db.fname(i), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0660)
+		if os.IsNotExist(er) {
+			os.Mkdir(fmt.Sprintf("%s%02x", db.dir, i>>8), 0770)
+			f, er = os.OpenFile(db.fname(i), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0660)
+		}
+		if er != nil {
+			e = er
+			continue
+		}
+		if _, er = f.Write(d); er != nil {
+			e = er
+		}
+		f.Close()
+	}
+	db.pending = make(map[uint16][]byte)
+	db.pendingSize = 0
+	return
+}
+
+// saveState flushes the pending records and then stores the state. Call it with the mutex locked.
+func (db *AddrIndex) saveState() (e error) {
+	if e = db.flush(); e != nil {
+		return
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint32(b[0:4], db.From)
+	binary.LittleEndian.PutUint32(b[4:8], db.Tip)
+	db.lastSave = time.Now()
+	return os.WriteFile(db.dir+"state.dat", b[:], 0660)
+}
+
+// Idle writes the pending records to disk, if they have been waiting for over a minute.
+func (db *AddrIndex) Idle() {
+	db.Lock()
+	if !db.closed && db.pendingSize > 0 && time.Since(db.lastSave) > time.Minute {
+		if e := db.saveState(); e != nil {
+			println("AddrIndex:", e.Error())
+		}
+	}
+	db.Unlock()
+}
+
+// BlockConnected indexes the outputs and the inputs of the block that becomes the chain's tip.
+// Call it before the block's changes are applied to the UTXO db, as tx.Spent_outputs must be set.
+func (db *AddrIndex) BlockConnected(bl *btc.Block, height uint32) {
+	var b [ADDRINDEX_REC_LEN]byte
+	touched := make(map[uint16]bool)
+	add := func(pkscr []byte, idx uint32, value uint64) {
+		key, ok := AddrIndexKey(pkscr)
+		if !ok {
+			return
+		}
+		binary.LittleEndian.PutUint64(b[0:8], key)
+		binary.LittleEndian.PutUint32(b[44:48], idx)
+		binary.LittleEndian.PutUint64(b[48:56], value)
+		i := uint16(key >> 48)
+		db.pending[i] = append(db.pending[i], b[:]...)
+		db.pendingSize += ADDRINDEX_REC_LEN
+		db.Records++
+		touched[i] = true
+	}
+
+	db.Lock()
+	defer db.Unlock()
+	if db.closed {
+		return
+	}
+	binary.LittleEndian.PutUint32(b[40:44], height)
+	for _, tx := range bl.Txs {
+		copy(b[8:40], tx.Hash.Hash[:])
+		if tx.TxVerVars != nil {
+			for i, so := range tx.Spent_outputs {
+				if so != nil {
+					add(so.Pk_script, uint32(i)|ADDRINDEX_SPENDING, so.Value)
+				}
+			}
+		}
+		for i, out := range tx.TxOut {
+			add(out.Pk_script, uint32(i), out.Value)
+		}
+	}
+	files := make([]uint16, 0, len(touched))
+	for i := range touched {
+		files = append(files, i)
+	}
+	db.touched[height] = files
+	delete(db.touched, height-ADDRINDEX_UNDO_KEEP)
+	db.Tip = height
+	if db.pendingSize >= ADDRINDEX_FLUSH_SIZE {
+		if e := db.saveState(); e != nil {
+			println("AddrIndex:", e.Error())
+		}
+	}
+}
+
+// BlockUndone removes the records of the chain's tip block, at the given height.
+func (db *AddrIndex) BlockUndone(height uint32) {
+	db.Lock()
+	defer db.Unlock()
+	if db.closed {
+		return
+	}
+	e := db.flush()
+	if e == nil {
+		files, ok := db.touched[height]
+		if !ok {
+			files = nil // not known - check all the files
+		}
+		e = db.truncate(files, height-1)
+	}
+	delete(db.touched, height)
+	db.Tip = height - 1
+	if db.From > height {
+		db.From = height
+	}
+	if e == nil {
+		e = db.saveState()
+	}
+	if e != nil {
+		println("AddrIndex:", e.Error())
+	}
+}
+
+// Get returns the history of the output script, sorted by height.
+func (db *AddrIndex) Get(pkscr []byte) (res []*AddrHistRec, e error) {
+	key, ok := AddrIndexKey(pkscr)
+	if !ok {
+		e = ErrAddrNotIndexed
+		return
+	}
+	db.Lock()
+	defer db.Unlock()
+	if db.closed {
+		e = errors.New("AddrIndex closed")
+		return
+	}
+	i := uint16(key >> 48)
+	d, e := os.ReadFile(db.fname(i))
+	if e != nil {
+		if !os.IsNotExist(e) {
+			return
+		}
+		e = nil
+	}
+	if p := db.pending[i]; len(p) > 0 {
+		d = append(d, p...)
+	}
+	for ; len(d) >= ADDRINDEX_REC_LEN; d = d[ADDRINDEX_REC_LEN:] {
+		if binary.LittleEndian.Uint64(d[0:8]) == key {
+			rec := &AddrHistRec{Height: binary.LittleEndian.Uint32(d[40:44]),
+				Index: binary.LittleEndian.Uint32(d[44:48]), Value: binary.LittleEndian.Uint64(d[48:56])}
+			copy(rec.TxID.Hash[:], d[8:40])
+			rec.Spend = (rec.Index & ADDRINDEX_SPENDING) != 0
+			rec.Index &= ^uint32(ADDRINDEX_SPENDING)
+			res = append(res, rec)
+		}
+	}
+	return
+}
+
+func (db *AddrIndex) GetStats() string {
+	db.Lock()
+	defer db.Unlock()
+	return fmt.Sprintf("ADDRINDEX: Records:%d  From:%d  Tip:%d  Pending:%d\n",
+		db.Records, db.From, db.Tip, db.pendingSize/ADDRINDEX_REC_LEN)
+}
+
+func (db *AddrIndex) Close() {
+	db.Lock()
+	if !db.closed {
+		if e := db.saveState(); e != nil {
+			println("AddrIndex:", e.Error())
+		}
+		db.closed = true
+	}
+	db.Unlock()
+}
+
+// openAddrIndex opens the address history index, for the current tip of the chain.
+func (ch *Chain) openAddrIndex(dir string) {
+	db, e := NewAddrIndex(dir, ch.LastBlock().Height)
+	if e != nil {
+		fmt.Println("Address index disabled:", e.Error())
+		return
+	}
+	ch.AddrIndex = db
+}
@@ -95,6 +95,15 @@ func (ch *Chain) PreCheckBlock(bl *btc.Block) (dos bool, maybelater bool, er err
 		return
 	}
 
+	// BIP8 deployments with mandatory signalling
+	for _, d := range ch.Consensus.Deployments {
+		if d.LockinOnTimeout && !d.Signals(ver) && ch.DeploymentState(d, prevblk) == THRESHOLD_MUST_SIGNAL {
+			er = errors.New("CheckBlock() : Block does not signal for " + d.Name + " - RPC_Result:bad-version-bip8-must-signal")
+			dos = true
+			return
+		}
+	}
+
 	return
 }
 
@@ -128,6 +137,12 @@ func (ch *Chain) GetBlockFlags(block_height uint32, block_time uint32) (flags ui
 
 func (ch *Chain) ApplyBlockFlags(bl *btc.Block) {
 	bl.VerifyFlags = ch.GetBlockFlags(bl.Height, bl.BlockTime())
+	if len(ch.Consensus.Deployments) > 1 { // more than just the testdummy
+		ch.BlockIndexAccess.Lock()
+		prev := ch.BlockIndex[btc.NewUint256(bl.ParentHash()).BIdx()]
+		ch.BlockIndexAccess.Unlock()
+		bl.VerifyFlags |= ch.DeploymentFlags(prev)
+	}
 }
 
 func (ch *Chain) PostCheckBlock(bl *btc.Block) (er error) {
@@ -185,6 +200,13 @@ func (ch *Chain) PostCheckBlock(bl *btc.Block) (er error) {
 		return
 	}
 
+	// On signet, the block's signature is as important as its POW, so check it even for trusted blocks
+	if ch.signet() {
+		if er = ch.checkSignetSolution(bl); er != nil {
+			return
+		}
+	}
+
 	ch.ApplyBlockFlags(bl)
 
 	if !bl.Trusted.Get() {
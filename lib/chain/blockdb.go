@@ -24,6 +24,7 @@ const (
 	BLOCK_SNAPPED = 0x08
 	BLOCK_LENGTH  = 0x10
 	BLOCK_INDEX   = 0x20
+	BLOCK_DISABLD = 0x40
 
 	MAX_BLOCKS_TO_WRITE = 1024 // flush the data to disk when exceeding
 	MAX_DATA_WRITE      = 16 * 1024 * 1024
@@ -39,6 +40,7 @@ const (
 			bit(3) - "snappy" flag - this block is compressed with snappy (not gzip'ed)
 			bit(4) - if this bit is set, bytes [32:36] carry length of uncompressed block
 			bit(5) - if this bit is set, bytes [28:32] carry data file index
+			bit(6) - "disabled" flag - this block has been invalidated by the user (see Chain.InvalidateBlock)
 
 		Used to be:
 		[4:36]  - 256-bit block hash - DEPRECATED! (hash the header to get the value)
@@ -52,6 +54,9 @@ const (
 		[48:52] - 32-bit block lenght in bytes
 		[52:56] - 32-bit number of transaction in the block
 		[56:136] - 80 bytes blocks header
+
+		Records with zero length are for the blocks whose data is not available
+		(only the header is known - e.g. the ones below a loaded UTXO snapshot).
 */
 
 type oneBl struct {
@@ -65,6 +70,7 @@ type oneBl struct {
 	trusted    bool
 	compressed bool
 	snappied   bool
+	disabled   bool
 }
 
 type BlckCachRec struct {
@@ -90,6 +96,7 @@ type oneB2W struct {
 	h       [32]byte
 	height  uint32
 	txcount uint32
+	hdronly bool // only store the index record (data is the header)
 }
 
 type BlockDB struct {
@@ -232,6 +239,25 @@ func (db *BlockDB) BlockAdd(height uint32, bl *btc.Block) (e error) {
 	return
 }
 
+// HeaderAdd stores the index record of a block whose data is not going to be available.
+// Such a block shows up with zero BlockSize when the index is loaded again.
+func (db *BlockDB) HeaderAdd(height uint32, hash *btc.Uint256, hdr []byte) {
+	var flush bool
+
+	db.mutex.Lock()
+	idx := hash.BIdx()
+	if _, ok := db.blockIndex[idx]; !ok {
+		db.blockIndex[idx] = &oneBl{ipos: -1}
+		db.blocksToWrite <- oneB2W{idx: idx, h: hash.Hash, data: hdr[:80], height: height, hdronly: true}
+		flush = len(db.blocksToWrite) >= MAX_BLOCKS_TO_WRITE
+	}
+	db.mutex.Unlock()
+
+	if flush {
+		db.writeAll()
+	}
+}
+
 func (db *BlockDB) writeAll() (sync bool) {
 	//sta := time.Now()
 	for db.writeOne() {
@@ -305,7 +331,9 @@ func (db *BlockDB) writeOne() (written bool) {
 	}
 
 	db.mutex.Lock()
-	db.datToWrite -= uint64(len(b2w.data))
+	if !b2w.hdronly {
+		db.datToWrite -= uint64(len(b2w.data))
+	}
 	rec = db.blockIndex[b2w.idx]
 	db.mutex.Unlock()
 
@@ -315,6 +343,29 @@ func (db *BlockDB) writeOne() (written bool) {
 		return
 	}
 
+	db.mutex.Lock()
+	if rec.disabled {
+		fl[0] |= BLOCK_DISABLD
+	}
+	db.mutex.Unlock()
+
+	if b2w.hdronly {
+		binary.LittleEndian.PutUint32(fl[36:40], uint32(b2w.height))
+		copy(fl[56:136], b2w.data[:80])
+		db.disk_access.Lock()
+		ipos := db.maxidxfilepos
+		if _, e = db.blockindx.Write(fl[:]); e != nil {
+			panic(e.Error())
+		}
+		db.maxidxfilepos += 136
+		db.disk_access.Unlock()
+		db.mutex.Lock()
+		rec.ipos = ipos
+		db.mutex.Unlock()
+		written = true
+		return
+	}
+
 	// Compute compression into locals - don't touch rec yet
 	var cbts []byte
 	var compressed, snappied bool
@@ -446,6 +497,41 @@ func (db *BlockDB) BlockTrusted(hash []byte) {
 	db.mutex.Unlock()
 }
 
+// BlockDisabled sets or clears the "disabled" flag of the given block.
+// The flag is only kept in the index, so the block's data stays in the database.
+func (db *BlockDB) BlockDisabled(hash []byte, disabled bool) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	cur, ok := db.blockIndex[btc.NewUint256(hash).BIdx()]
+	if !ok || cur.disabled == disabled {
+		return
+	}
+	cur.disabled = disabled
+	if cur.ipos == -1 {
+		return // writeOne() will take care of the flag
+	}
+	var b [1]byte
+	db.disk_access.Lock()
+	db.blockindx.ReadAt(b[:], cur.ipos)
+	if disabled {
+		b[0] |= BLOCK_DISABLD
+	} else {
+		b[0] &^= BLOCK_DISABLD
+	}
+	db.blockindx.WriteAt(b[:], cur.ipos)
+	db.disk_access.Unlock()
+}
+
+// IsDisabled returns true if the given block has the "disabled" flag set.
+func (db *BlockDB) IsDisabled(hash []byte) (res bool) {
+	db.mutex.Lock()
+	if cur, ok := db.blockIndex[btc.NewUint256(hash).BIdx()]; ok {
+		res = cur.disabled
+	}
+	db.mutex.Unlock()
+	return
+}
+
 func (db *BlockDB) setBlockFlag(cur *oneBl, fl byte) {
 	var b [1]byte
 	cur.trusted = true
@@ -642,6 +728,7 @@ func (db *BlockDB) LoadBlockIndex(ch *Chain, walk func(ch *Chain, hash, hdr []by
 		ob.trusted = (b[0] & BLOCK_TRUSTED) != 0
 		ob.compressed = (b[0] & BLOCK_COMPRSD) != 0
 		ob.snappied = (b[0] & BLOCK_SNAPPED) != 0
+		ob.disabled = (b[0] & BLOCK_DISABLD) != 0
 		ob.fpos = binary.LittleEndian.Uint64(b[40:48])
 		blen := binary.LittleEndian.Uint32(b[48:52])
 		ob.blen = blen
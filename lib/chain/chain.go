@@ -2,6 +2,7 @@ package chain
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"os"
@@ -16,8 +17,12 @@ var AbortNow bool // set it to true to abort any activity
 type Chain struct {
 	Blocks        *BlockDB        // blockchain.dat and blockchain.idx
 	Unspent       *utxo.UnspentDB // unspent folder
+	Filters       *FilterDB       // filters folder (nil if BIP158 index is not enabled)
+	TxIndex       *TxIndex        // txindex folder (nil if transaction index is not enabled)
+	AddrIndex     *AddrIndex      // addrindex folder (nil if address history index is not enabled)
 	BlockTreeRoot *BlockTreeNode
 	blockTreeEnd  *BlockTreeNode
+	mainChain     []*BlockTreeNode // nodes of the main chain, by their heights
 	Genesis       *btc.Uint256
 	BlockIndex    map[[btc.Uint256IdxLen]byte]*BlockTreeNode
 	CB            NewChanOpts // callbacks used by Unspent database
@@ -29,10 +34,13 @@ type Chain struct {
 		Enforce_CSV                         uint32 // if non zero CVS verifications will be enforced from this block onwards
 		Enforce_SEGWIT                      uint32 // if non zero SegWit verifications will be enforced from this block onwards
 		Enforce_Taproot                     uint32 // if non zero Taproot verifications will be enforced from this block onwards
-		BIP9_Treshold                       uint32 // It is not really used at this moment, but maybe one day...
+		BIP9_Treshold                       uint32 // this many blocks in a Window must signal to lock in a deployment
+		SubsidyHalvingInterval              uint32
 		BIP34Height                         uint32
 		BIP65Height                         uint32
 		BIP66Height                         uint32
+		SignetChallenge                     []byte        // the script that each signet block must solve
+		Deployments                         []*Deployment // BIP9 (or BIP8) deployments whose states we track
 	}
 	blockTreeAccess  sync.Mutex
 	BlockIndexAccess sync.Mutex
@@ -47,6 +55,14 @@ type NewChanOpts struct {
 	UTXOVolatileMode bool
 	DoNotRescan      bool // when set UTXO will not be automatically updated with new block found on disk
 	CompressUTXO     bool
+	UTXODiskMode     bool          // keep UTXO records on disk (see utxo.NewUnspentOpts)
+	UTXODiskCache    int           // bytes of UTXO records to cache in UTXODiskMode
+	UTXOJournal      bool          // journal UTXO changes in UTXO.jrn, to survive a crash without replaying blocks (not with UTXODiskMode)
+	BlockFilters     bool          // keep BIP158 basic filters of the blocks (in "filters" folder)
+	TxIndex          bool          // keep index of all the transactions (in "txindex" folder)
+	AddrIndex        bool          // keep history of all the addresses (in "addrindex" folder)
+	SignetChallenge  []byte        // custom signet challenge (nil for the default signet)
+	Deployments      []*Deployment // additional deployments (for custom networks)
 }
 
 // NewChainExt is the very first function one should call in order to use this package.
@@ -63,7 +79,38 @@ func NewChainExt(dbrootdir string, genesis *btc.Uint256, rescan bool, opts *NewC
 	ch.Consensus.GensisTimestamp = 1231006505
 	ch.Consensus.MaxPOWBits = 0x1d00ffff
 	ch.Consensus.MaxPOWValue, _ = new(big.Int).SetString("00000000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", 16)
-	if ch.testnet() {
+	ch.Consensus.SubsidyHalvingInterval = 210000
+	ch.Consensus.Window = 2016
+	testdummy := &Deployment{Name: "testdummy", Bit: 28, StartTime: VB_NEVER_ACTIVE, Timeout: VB_NO_TIMEOUT}
+	if ch.regtest() {
+		ch.Consensus.GensisTimestamp = 1296688602
+		ch.Consensus.MaxPOWBits = 0x207fffff
+		ch.Consensus.MaxPOWValue, _ = new(big.Int).SetString("7FFFFF0000000000000000000000000000000000000000000000000000000000", 16)
+		ch.Consensus.BIP34Height = 1
+		ch.Consensus.BIP65Height = 1
+		ch.Consensus.BIP66Height = 1
+		ch.Consensus.Enforce_CSV = 1
+		ch.Consensus.Enforce_SEGWIT = 1
+		ch.Consensus.Enforce_Taproot = 1
+		ch.Consensus.BIP9_Treshold = 108
+		ch.Consensus.Window = 144
+		ch.Consensus.SubsidyHalvingInterval = 150
+		testdummy.StartTime = 0
+	} else if ch.signet() {
+		ch.Consensus.GensisTimestamp = 1598918400
+		ch.Consensus.MaxPOWBits = 0x1e0377ae
+		ch.Consensus.MaxPOWValue, _ = new(big.Int).SetString("00000377ae000000000000000000000000000000000000000000000000000000", 16)
+		ch.Consensus.BIP34Height = 1
+		ch.Consensus.BIP65Height = 1
+		ch.Consensus.BIP66Height = 1
+		ch.Consensus.Enforce_CSV = 1
+		ch.Consensus.Enforce_SEGWIT = 1
+		ch.Consensus.Enforce_Taproot = 1
+		ch.Consensus.BIP9_Treshold = 1815
+		if ch.Consensus.SignetChallenge = opts.SignetChallenge; len(ch.Consensus.SignetChallenge) == 0 {
+			ch.Consensus.SignetChallenge, _ = hex.DecodeString(DefaultSignetChallengeHex)
+		}
+	} else if ch.testnet() {
 		if ch.testnet4() {
 			ch.Consensus.GensisTimestamp = 1714777860
 			ch.Consensus.BIP34Height = 1
@@ -90,16 +137,23 @@ func NewChainExt(dbrootdir string, genesis *btc.Uint256, rescan bool, opts *NewC
 		ch.Consensus.Enforce_Taproot = 709632
 		ch.Consensus.BIP9_Treshold = 1815
 	}
+	ch.Consensus.Deployments = append([]*Deployment{testdummy}, opts.Deployments...)
 
 	ch.Blocks = NewBlockDBExt(dbrootdir, bdbopts)
 
+	if opts.BlockFilters {
+		ch.openFilters(dbrootdir + "filters" + string(os.PathSeparator))
+	}
+
+	utxodir := dbrootdir
 	if opts.UtxoFilesSubdir != "" {
-		dbrootdir += opts.UtxoFilesSubdir + string(os.PathSeparator)
+		utxodir += opts.UtxoFilesSubdir + string(os.PathSeparator)
 	}
 	ch.Unspent = utxo.NewUnspentDb(&utxo.NewUnspentOpts{
-		Dir: dbrootdir, Rescan: rescan, VolatimeMode: opts.UTXOVolatileMode,
+		Dir: utxodir, Rescan: rescan, VolatimeMode: opts.UTXOVolatileMode,
 		CB: opts.UTXOCallbacks, AbortNow: &AbortNow,
-		CompressRecords: opts.CompressUTXO})
+		CompressRecords: opts.CompressUTXO, DiskMode: opts.UTXODiskMode, DiskCache: opts.UTXODiskCache,
+		Journal: opts.UTXOJournal})
 
 	if AbortNow {
 		return
@@ -114,6 +168,13 @@ func NewChainExt(dbrootdir string, genesis *btc.Uint256, rescan bool, opts *NewC
 		ch.SetLast(ch.BlockTreeRoot)
 	}
 
+	if opts.TxIndex {
+		ch.openTxIndex(dbrootdir + "txindex" + string(os.PathSeparator))
+	}
+	if opts.AddrIndex {
+		ch.openAddrIndex(dbrootdir + "addrindex" + string(os.PathSeparator))
+	}
+
 	if AbortNow {
 		return
 	}
@@ -127,6 +188,11 @@ func NewChainExt(dbrootdir string, genesis *btc.Uint256, rescan bool, opts *NewC
 		return
 	}
 
+	// Undo the blocks invalidated by the user, in case it did not finish before
+	for !AbortNow && ch.LastBlock().Invalid {
+		ch.UndoLastBlock()
+	}
+
 	// And now re-apply the blocks which you have just reverted :)
 	end, _ := ch.BlockTreeRoot.FindFarthestNode()
 	if end.Height > ch.LastBlock().Height {
@@ -154,6 +220,9 @@ func (ch *Chain) RebuildGenesisHeader() {
 // when your client is idle, to defragment databases.
 func (ch *Chain) Idle() bool {
 	ch.Blocks.Idle()
+	if ch.AddrIndex != nil {
+		ch.AddrIndex.Idle()
+	}
 	return ch.Unspent.Idle()
 }
 
@@ -166,6 +235,15 @@ func (ch *Chain) Stats() (s string) {
 	ch.BlockIndexAccess.Unlock()
 	s += ch.Blocks.GetStats()
 	s += ch.Unspent.GetStats()
+	if ch.Filters != nil {
+		s += ch.Filters.GetStats()
+	}
+	if ch.TxIndex != nil {
+		s += ch.TxIndex.GetStats()
+	}
+	if ch.AddrIndex != nil {
+		s += ch.AddrIndex.GetStats()
+	}
 	return
 }
 
@@ -173,6 +251,36 @@ func (ch *Chain) Stats() (s string) {
 func (ch *Chain) Close() {
 	ch.Blocks.Close()
 	ch.Unspent.Close()
+	if ch.Filters != nil {
+		ch.Filters.Close()
+	}
+	if ch.TxIndex != nil {
+		ch.TxIndex.Close()
+	}
+	if ch.AddrIndex != nil {
+		ch.AddrIndex.Close()
+	}
+}
+
+// indexBlock adds the block that becomes the chain's tip to the enabled indexes.
+// It must be called before the block's changes are applied to the UTXO db (see AddrIndex.BlockConnected).
+func (ch *Chain) indexBlock(bl *btc.Block, height uint32) {
+	if ch.TxIndex != nil {
+		ch.TxIndex.BlockConnected(bl, height)
+	}
+	if ch.AddrIndex != nil {
+		ch.AddrIndex.BlockConnected(bl, height)
+	}
+}
+
+// unindexBlock removes the chain's tip block, which is being undone, from the enabled indexes.
+func (ch *Chain) unindexBlock(height uint32) {
+	if ch.TxIndex != nil {
+		ch.TxIndex.BlockUndone(height)
+	}
+	if ch.AddrIndex != nil {
+		ch.AddrIndex.BlockUndone(height)
+	}
 }
 
 // testnet returns true if we are on Testnet3 or Testnet4 chain.
@@ -185,6 +293,21 @@ func (ch *Chain) testnet4() bool {
 	return ch.Genesis.Hash[1] == 0xf0 // it's simple, but works
 }
 
+// regtest returns true if we are on Regtest chain.
+func (ch *Chain) regtest() bool {
+	return ch.Genesis.Hash[0] == 0x06 // it's simple, but works
+}
+
+// signet returns true if we are on Signet chain (default or custom).
+func (ch *Chain) signet() bool {
+	return ch.Genesis.Hash[0] == 0xf6 // it's simple, but works
+}
+
+// BlockReward returns the subsidy of a block at the given height.
+func (ch *Chain) BlockReward(height uint32) uint64 {
+	return 50e8 >> (height / ch.Consensus.SubsidyHalvingInterval)
+}
+
 func (ch *Chain) LastBlock() (res *BlockTreeNode) {
 	ch.blockTreeAccess.Lock()
 	res = ch.blockTreeEnd
@@ -195,5 +318,29 @@ func (ch *Chain) LastBlock() (res *BlockTreeNode) {
 func (ch *Chain) SetLast(val *BlockTreeNode) {
 	ch.blockTreeAccess.Lock()
 	ch.blockTreeEnd = val
+	// update the main chain index, from the new tip down to the fork point
+	if h := int(val.Height) + 1; h <= len(ch.mainChain) {
+		for i := h; i < len(ch.mainChain); i++ {
+			ch.mainChain[i] = nil
+		}
+		ch.mainChain = ch.mainChain[:h]
+	} else if h <= cap(ch.mainChain) {
+		ch.mainChain = ch.mainChain[:h]
+	} else {
+		ch.mainChain = append(ch.mainChain, make([]*BlockTreeNode, h-len(ch.mainChain))...)
+	}
+	for n := val; n != nil && ch.mainChain[n.Height] != n; n = n.Parent {
+		ch.mainChain[n.Height] = n
+	}
+	ch.blockTreeAccess.Unlock()
+}
+
+// BlockAtHeight returns the main chain's node at the given height (nil if above the tip).
+func (ch *Chain) BlockAtHeight(height uint32) (res *BlockTreeNode) {
+	ch.blockTreeAccess.Lock()
+	if int(height) < len(ch.mainChain) {
+		res = ch.mainChain[height]
+	}
 	ch.blockTreeAccess.Unlock()
+	return
 }
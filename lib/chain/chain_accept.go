@@ -49,6 +49,7 @@ func (ch *Chain) AcceptHeader(bl *btc.Block) (cur *BlockTreeNode) {
 	cur.BlockHash = bl.Hash
 	cur.Parent = prevblk
 	cur.Height = prevblk.Height + 1
+	cur.Invalid = prevblk.Invalid
 	copy(cur.BlockHeader[:], bl.Raw[:80])
 
 	// Add this block to the block index
@@ -75,6 +76,8 @@ func (ch *Chain) CommitBlock(bl *btc.Block, cur *BlockTreeNode) (e error) {
 			ch.BlockIndexAccess.Unlock()
 		} else {
 			cur.SigopsCost = sigopscost
+			ch.addBlockFilter(bl, cur)
+			ch.indexBlock(bl, cur.Height)
 			// ProcessBlockTransactions succeeded, so save the block as "trusted".
 			bl.Trusted.Set()
 			ch.Blocks.BlockAdd(cur.Height, bl)
@@ -93,7 +96,7 @@ func (ch *Chain) CommitBlock(bl *btc.Block, cur *BlockTreeNode) (e error) {
 		ch.Blocks.BlockAdd(cur.Height, bl)
 
 		// If it has more POW than the current head, move the head to it
-		if cur.MorePOW(ch.LastBlock()) {
+		if !cur.Invalid && cur.MorePOW(ch.LastBlock()) {
 			ch.MoveToBlock(cur)
 			if ch.LastBlock() != cur {
 				e = errors.New("CommitBlock: MoveToBlock failed")
@@ -110,7 +113,7 @@ func (ch *Chain) CommitBlock(bl *btc.Block, cur *BlockTreeNode) (e error) {
 
 // commitTxs is ususually the most time consuming process when applying a new block.
 func (ch *Chain) commitTxs(bl *btc.Block, changes *utxo.BlockChanges) (sigopscost uint32, e error) {
-	sumblockin := btc.GetBlockReward(changes.Height)
+	sumblockin := ch.BlockReward(changes.Height)
 	var txoutsum, txinsum, sumblockout uint64
 
 	if changes.Height+ch.Unspent.UnwindBufLen >= changes.LastKnownHeight {
@@ -145,6 +148,9 @@ func (ch *Chain) commitTxs(bl *btc.Block, changes *utxo.BlockChanges) (sigopscos
 					tx.Spent_outputs = make([]*btc.TxOut, len(tx.TxIn))
 				}
 			}
+			if tx_trusted && (ch.Filters != nil || ch.AddrIndex != nil) {
+				tx.Spent_outputs = make([]*btc.TxOut, len(tx.TxIn)) // needed for the block filter and address index
+			}
 
 			// first collect all the inputs, their amounts and spend scripts
 			for j := range tx.TxIn {
@@ -221,7 +227,7 @@ func (ch *Chain) commitTxs(bl *btc.Block, changes *utxo.BlockChanges) (sigopscos
 					}
 				}
 
-				if !tx_trusted {
+				if tx.Spent_outputs != nil {
 					tx.Spent_outputs[j] = tout
 				}
 
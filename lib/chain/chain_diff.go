@@ -18,6 +18,11 @@ func (ch *Chain) GetNextWorkRequired(lst *BlockTreeNode, ts uint32) (res uint32)
 		return ch.Consensus.MaxPOWBits
 	}
 
+	// No retargeting on regtest
+	if ch.regtest() {
+		return lst.Bits()
+	}
+
 	if ((lst.Height + 1) % targetInterval) != 0 {
 		// Special difficulty rule for testnet:
 		if ch.testnet() {
@@ -0,0 +1,138 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// InvalidateBlock marks the given block and all its children as invalid and moves
+// the chain's head to the best valid branch. The block stays invalid until
+// ReconsiderBlock is called for it (the flag is kept in the blocks database).
+func (ch *Chain) InvalidateBlock(hash *btc.Uint256) (e error) {
+	ch.BlockIndexAccess.Lock()
+	n, ok := ch.BlockIndex[hash.BIdx()]
+	ch.BlockIndexAccess.Unlock()
+	if !ok {
+		e = errors.New("InvalidateBlock: block not found")
+		return
+	}
+	if n.Parent == nil {
+		e = errors.New("InvalidateBlock: genesis block cannot be invalidated")
+		return
+	}
+
+	on_active := ch.OnActiveBranch(n)
+	if on_active {
+		// make sure that we can go back to the block's parent before doing anything
+		for h := ch.LastBlock().Height; h >= n.Height; h-- {
+			if !ch.Unspent.UndoAvailable(h) {
+				e = fmt.Errorf("InvalidateBlock: no undo data for block %d", h)
+				return
+			}
+		}
+	}
+
+	ch.Blocks.BlockDisabled(hash.Hash[:], true)
+	ch.BlockIndexAccess.Lock()
+	n.setInvalid(true)
+	ch.forgetDeploymentStates(n)
+	ch.BlockIndexAccess.Unlock()
+
+	if on_active {
+		for !AbortNow && ch.LastBlock() != n.Parent {
+			ch.UndoLastBlock()
+		}
+	}
+	ch.moveToBestBlock()
+	return
+}
+
+// ReconsiderBlock removes the invalidity status of the given block, its parents and its children
+// (as set by InvalidateBlock) and moves the chain's head to the best branch.
+func (ch *Chain) ReconsiderBlock(hash *btc.Uint256) (e error) {
+	ch.BlockIndexAccess.Lock()
+	n, ok := ch.BlockIndex[hash.BIdx()]
+	if !ok {
+		ch.BlockIndexAccess.Unlock()
+		e = errors.New("ReconsiderBlock: block not found")
+		return
+	}
+	ch.enableChildren(n)
+	top := n
+	for p := n; p != nil && p.Invalid; p = p.Parent {
+		ch.Blocks.BlockDisabled(p.BlockHash.Hash[:], false)
+		top = p
+	}
+	ch.updateInvalid(top)
+	ch.BlockIndexAccess.Unlock()
+
+	ch.moveToBestBlock()
+	return
+}
+
+// PreciousBlock makes the chain's head move to the given block, if it has at least as much POW
+// as the current head. Since the head only moves to a branch with more POW, it works like
+// a tie-breaker between branches of the same POW (the preference is not stored on disk).
+func (ch *Chain) PreciousBlock(hash *btc.Uint256) (e error) {
+	ch.BlockIndexAccess.Lock()
+	n, ok := ch.BlockIndex[hash.BIdx()]
+	ch.BlockIndexAccess.Unlock()
+	if !ok {
+		e = errors.New("PreciousBlock: block not found")
+		return
+	}
+	if n.Invalid {
+		e = errors.New("PreciousBlock: block is invalid")
+		return
+	}
+
+	last := ch.LastBlock()
+	if ch.OnActiveBranch(n) || last.MorePOW(n) {
+		return // nothing to do
+	}
+	ch.MoveToBlock(n)
+	if ch.LastBlock() != n {
+		e = errors.New("PreciousBlock: MoveToBlock failed")
+	}
+	return
+}
+
+// moveToBestBlock moves the chain's head to the end of the valid branch with the most POW.
+func (ch *Chain) moveToBestBlock() {
+	ch.BlockIndexAccess.Lock()
+	best, _ := ch.BlockTreeRoot.FindFarthestNode()
+	for best.TxCount == 0 && best.Parent != nil {
+		best = best.Parent // we can only go as far as we have the blocks' data
+	}
+	ch.BlockIndexAccess.Unlock()
+	if best.MorePOW(ch.LastBlock()) {
+		ch.MoveToBlock(best)
+	}
+}
+
+// make sure ch.BlockIndexAccess is locked before calling it
+func (n *BlockTreeNode) setInvalid(invalid bool) {
+	n.Invalid = invalid
+	for _, c := range n.Childs {
+		c.setInvalid(invalid)
+	}
+}
+
+// make sure ch.BlockIndexAccess is locked before calling it
+func (ch *Chain) enableChildren(n *BlockTreeNode) {
+	ch.Blocks.BlockDisabled(n.BlockHash.Hash[:], false)
+	for _, c := range n.Childs {
+		ch.enableChildren(c)
+	}
+}
+
+// updateInvalid sets the Invalid flag of the node and its children, as per the flags in the blocks database.
+// Make sure ch.BlockIndexAccess is locked before calling it.
+func (ch *Chain) updateInvalid(n *BlockTreeNode) {
+	n.Invalid = n.Parent != nil && n.Parent.Invalid || ch.Blocks.IsDisabled(n.BlockHash.Hash[:])
+	for _, c := range n.Childs {
+		ch.updateInvalid(c)
+	}
+}
@@ -16,6 +16,7 @@ func nextBlock(ch *Chain, hash, header []byte, height, blen, txs uint32) {
 	v.Height = height
 	v.BlockSize = blen
 	v.TxCount = txs
+	v.Invalid = ch.Blocks.blockIndex[bh.BIdx()].disabled
 	copy(v.BlockHeader[:], header)
 	ch.BlockIndex[v.BlockHash.BIdx()] = v
 }
@@ -50,6 +51,11 @@ func (ch *Chain) loadBlockIndex() {
 		v.Parent = par
 		v.Parent.addChild(v)
 	}
+	for _, v := range ch.BlockIndex {
+		if v.Invalid {
+			v.setInvalid(true) // mark all the children of the invalidated block
+		}
+	}
 	if tlb == nil {
 		//println("No last block - full rescan will be needed")
 		ch.SetLast(ch.BlockTreeRoot)
@@ -64,7 +70,16 @@ func (ch *Chain) loadBlockIndex() {
 	}
 }
 
+// GetRawTx returns the transaction from the main chain's block at the given height.
+// If the transaction index is enabled, the height is only needed for the txs not indexed yet.
 func (ch *Chain) GetRawTx(BlockHeight uint32, txid *btc.Uint256) (data []byte, er error) {
+	if ch.TxIndex != nil {
+		if tx, _, e := ch.GetIndexedTx(txid); e == nil {
+			data = tx.Raw
+			return
+		}
+	}
+
 	// Find the block with the indicated Height in the main tree
 	ch.BlockIndexAccess.Lock()
 	n := ch.LastBlock()
@@ -0,0 +1,124 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/script"
+)
+
+const regtestGenesis = "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"
+
+// testStartTime is the timestamp of the first test block (so BIP16 is active).
+const testStartTime = 1700000000
+
+// testTrueScript is an output script that anyone can spend with an empty scriptSig.
+var testTrueScript = []byte{0x51}
+
+// testChain opens the regtest chain from the given folder (or a new one in a temporary folder).
+func testChain(t *testing.T, dir string, opts *NewChanOpts) *Chain {
+	AbortNow = false
+	if dir == "" {
+		dir = t.TempDir() + string(os.PathSeparator)
+	}
+	return NewChainExt(dir, btc.NewUint256FromString(regtestGenesis), false, opts, &BlockDBOpts{})
+}
+
+// testBlock builds a new block on top of prev, with the given version and transactions.
+// The coinbase pays the subsidy (the txs must not pay fees) to testTrueScript.
+func testBlock(ch *Chain, prev *BlockTreeNode, version uint32, txs []*btc.Tx) *btc.Block {
+	height := prev.Height + 1
+	cb := new(btc.Tx)
+	cb.Version = 1
+	cb.TxIn = []*btc.TxIn{{Sequence: 0xffffffff}}
+	cb.TxIn[0].Input.Vout = 0xffffffff
+	cb.TxIn[0].ScriptSig = append(script.UintToScript(height), 0x51)
+	cb.TxOut = []*btc.TxOut{{Value: ch.BlockReward(height), Pk_script: testTrueScript}}
+	cb.SetHash(cb.SerializeNew())
+	txs = append([]*btc.Tx{cb}, txs...)
+
+	ts := prev.Timestamp() + 1
+	if ts < testStartTime {
+		ts = testStartTime
+	}
+	var hdr [80]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], version)
+	copy(hdr[4:36], prev.BlockHash.Hash[:])
+	hashes := make([][32]byte, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash.Hash
+	}
+	merkle, _ := btc.CalcMerkle(hashes)
+	copy(hdr[36:68], merkle)
+	binary.LittleEndian.PutUint32(hdr[68:72], ts)
+	binary.LittleEndian.PutUint32(hdr[72:76], ch.GetNextWorkRequired(prev, ts))
+	for nonce := uint32(0); ; nonce++ {
+		binary.LittleEndian.PutUint32(hdr[76:80], nonce)
+		if btc.CheckProofOfWork(btc.NewSha2Hash(hdr[:]), binary.LittleEndian.Uint32(hdr[72:76])) {
+			break
+		}
+	}
+	wr := bytes.NewBuffer(hdr[:])
+	btc.WriteVlen(wr, uint64(len(txs)))
+	for _, tx := range txs {
+		tx.WriteSerializedNew(wr)
+	}
+	bl, _ := btc.NewBlock(wr.Bytes())
+	bl.BuildTxList()
+	return bl
+}
+
+// testAccept checks the block and connects it to the chain.
+func testAccept(t *testing.T, ch *Chain, bl *btc.Block) {
+	ch.BlockIndexAccess.Lock()
+	_, _, e := ch.PreCheckBlock(bl)
+	ch.BlockIndexAccess.Unlock()
+	if e == nil {
+		e = ch.PostCheckBlock(bl)
+	}
+	if e == nil {
+		e = ch.AcceptBlock(bl)
+	}
+	if e != nil {
+		t.Fatal("Block", bl.Height, e.Error())
+	}
+}
+
+// testMine connects cnt new blocks, with the given version, on top of the chain.
+func testMine(t *testing.T, ch *Chain, cnt int, version uint32) {
+	for ; cnt > 0; cnt-- {
+		testAccept(t, ch, testBlock(ch, ch.LastBlock(), version, nil))
+	}
+}
+
+// testSpend returns a tx spending all the given outputs (of testTrueScript) to n new testTrueScript outputs.
+func testSpend(ins []*btc.TxPrevOut, value uint64, n int) (tx *btc.Tx) {
+	tx = new(btc.Tx)
+	tx.Version = 2
+	for _, in := range ins {
+		tx.TxIn = append(tx.TxIn, &btc.TxIn{Input: *in, Sequence: 0xffffffff})
+	}
+	for i := 0; i < n; i++ {
+		tx.TxOut = append(tx.TxOut, &btc.TxOut{Value: value / uint64(n), Pk_script: testTrueScript})
+	}
+	tx.SetHash(tx.SerializeNew())
+	return
+}
+
+// testCoinbase returns the first output of the coinbase tx of the main chain's block at the given height.
+func testCoinbase(t *testing.T, ch *Chain, height uint32) (out *btc.TxPrevOut, value uint64) {
+	n := ch.LastBlock()
+	for n.Height > height {
+		n = n.Parent
+	}
+	raw, _, e := ch.Blocks.BlockGet(n.BlockHash)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	bl, _ := btc.NewBlock(raw)
+	bl.BuildTxList()
+	return &btc.TxPrevOut{Hash: bl.Txs[0].Hash.Hash}, bl.Txs[0].TxOut[0].Value
+}
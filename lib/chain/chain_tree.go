@@ -18,6 +18,7 @@ type BlockTreeNode struct {
 	TxCount     uint32
 	SigopsCost  uint32
 	Trusted     sys.SyncBool
+	Invalid     bool // this block, or one of its parents, has been invalidated by the user
 	BlockHeader [80]byte
 }
 
@@ -77,6 +78,8 @@ func (ch *Chain) ParseTillBlock(end *BlockTreeNode) {
 			break
 		}
 		nxt.SigopsCost = sigopscost
+		ch.addBlockFilter(bl, nxt)
+		ch.indexBlock(bl, nxt.Height)
 		if !trusted {
 			ch.Blocks.BlockTrusted(bl.Hash.Hash[:])
 		}
@@ -127,20 +130,17 @@ func (pindex *BlockTreeNode) GetMedianTimePast() uint32 {
 	return uint32(pmedian[pbegin+((pend-pbegin)/2)])
 }
 
-// FindFarthestNode looks for the farthest node.
+// FindFarthestNode looks for the farthest node (ignoring the invalidated branches).
 func (n *BlockTreeNode) FindFarthestNode() (*BlockTreeNode, float64) {
 	//fmt.Println("FFN:", n.Height, "kids:", len(n.Childs))
-	if len(n.Childs) == 0 {
-		return n, 0
-	}
-	res, pow := n.Childs[0].FindFarthestNode()
-	if len(n.Childs) > 1 {
-		for i := 1; i < len(n.Childs); i++ {
-			_re, _dept := n.Childs[i].FindFarthestNode()
-			if _dept > pow {
-				res = _re
-				pow = _dept
-			}
+	res, pow := n, float64(0)
+	for _, c := range n.Childs {
+		if c.Invalid {
+			continue
+		}
+		if _re, _dept := c.FindFarthestNode(); res == n || _dept > pow {
+			res = _re
+			pow = _dept
 		}
 	}
 	return res, pow + btc.GetDifficulty(n.Bits())
@@ -297,6 +297,7 @@ func (ch *Chain) UndoLastBlock() {
 	}
 	bl.Height = last.Height
 
+	ch.unindexBlock(last.Height)
 	ch.Unspent.UndoBlockTxs(bl, last.Parent.BlockHash.Hash[:])
 	if ch.CB.BlockUndoneCB != nil {
 		ch.CB.BlockUndoneCB(bl)
@@ -321,6 +322,7 @@ func (ch *Chain) DeleteBranch(cur *BlockTreeNode, deleteCallback func(*btc.Uint2
 	// first disconnect it from the Parent
 	ch.Blocks.BlockInvalid(cur.BlockHash.Hash[:])
 	ch.BlockIndexAccess.Lock()
+	ch.forgetDeploymentStates(cur)
 	delete(ch.BlockIndex, cur.BlockHash.BIdx())
 	cur.Parent.delChild(cur)
 	cur.delAllChildren(ch, deleteCallback)
@@ -0,0 +1,298 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/utxo"
+)
+
+/*
+	BIP158 basic block filters index (the "filters" folder):
+	filters.dat - raw filters, one after another
+	filters.idx - records of 112 bytes (all values LSB):
+		[0:32] - 256-bit block hash
+		[32:36] - 32-bit block height
+		[36:40] - 32-bit filter length
+		[40:48] - 64-bit filter pos in filters.dat
+		[48:80] - 256-bit filter hash
+		[80:112] - 256-bit filter header
+*/
+
+const FILTER_IDX_REC_LEN = 112
+
+var ErrFilterNoParent = errors.New("parent block's filter not in the index")
+
+type FilterRec struct {
+	Height uint32
+	Len    uint32
+	Pos    int64
+	Hash   btc.Uint256 // double SHA256 of the filter
+	Header btc.Uint256 // BIP157 filter header
+}
+
+type FilterDB struct {
+	index  map[btc.BIDX]*FilterRec
+	datf   *os.File
+	idxf   *os.File
+	datpos int64
+	idxpos int64
+	sync.Mutex
+}
+
+// NewFilterDB opens (or creates) the block filters index in the given folder.
+func NewFilterDB(dir string) (db *FilterDB, e error) {
+	if e = os.MkdirAll(dir, 0770); e != nil {
+		return
+	}
+	db = &FilterDB{index: make(map[btc.BIDX]*FilterRec)}
+	if db.datf, e = os.OpenFile(dir+"filters.dat", os.O_RDWR|os.O_CREATE, 0660); e != nil {
+		return
+	}
+	if db.idxf, e = os.OpenFile(dir+"filters.idx", os.O_RDWR|os.O_CREATE, 0660); e != nil {
+		db.datf.Close()
+		return
+	}
+	datlen, _ := db.datf.Seek(0, io.SeekEnd)
+
+	var b [FILTER_IDX_REC_LEN]byte
+	rd := bufio.NewReaderSize(db.idxf, 0x100000)
+	for {
+		if _, er := io.ReadFull(rd, b[:]); er != nil {
+			break
+		}
+		rec := new(FilterRec)
+		rec.Height = binary.LittleEndian.Uint32(b[32:36])
+		rec.Len = binary.LittleEndian.Uint32(b[36:40])
+		rec.Pos = int64(binary.LittleEndian.Uint64(b[40:48]))
+		copy(rec.Hash.Hash[:], b[48:80])
+		copy(rec.Header.Hash[:], b[80:112])
+		if rec.Pos != db.datpos || rec.Pos+int64(rec.Len) > datlen {
+			println("FilterDB: index corrupt at record", db.idxpos/FILTER_IDX_REC_LEN, "- truncating")
+			break
+		}
+		db.index[btc.BIdx(b[0:32])] = rec
+		db.datpos += int64(rec.Len)
+		db.idxpos += FILTER_IDX_REC_LEN
+	}
+	// get rid of whatever follows the last valid record
+	db.idxf.Truncate(db.idxpos)
+	db.datf.Truncate(db.datpos)
+	return
+}
+
+// Get returns the filter record of the given block, or nil if it is not in the index.
+func (db *FilterDB) Get(hash *btc.Uint256) (rec *FilterRec) {
+	db.Lock()
+	rec = db.index[hash.BIdx()]
+	db.Unlock()
+	return
+}
+
+// GetFilter reads the filter from disk.
+func (db *FilterDB) GetFilter(rec *FilterRec) (filter []byte, e error) {
+	filter = make([]byte, rec.Len)
+	_, e = db.datf.ReadAt(filter, rec.Pos)
+	return
+}
+
+// Add stores the filter of the block. The parent's filter must be in the index already,
+// unless prev is nil (for the genesis block). Blocks that are already there are ignored.
+func (db *FilterDB) Add(hash *btc.Uint256, height uint32, prev *btc.Uint256, filter []byte) (e error) {
+	db.Lock()
+	defer db.Unlock()
+	if _, ok := db.index[hash.BIdx()]; ok {
+		return
+	}
+	var prev_header *btc.Uint256
+	if prev != nil {
+		par, ok := db.index[prev.BIdx()]
+		if !ok {
+			return ErrFilterNoParent
+		}
+		prev_header = &par.Header
+	}
+	rec := &FilterRec{Height: height, Len: uint32(len(filter)), Pos: db.datpos}
+	fhash, header := btc.BlockFilterHeader(filter, prev_header)
+	rec.Hash, rec.Header = *fhash, *header
+
+	if _, e = db.datf.WriteAt(filter, db.datpos); e != nil {
+		return
+	}
+	var b [FILTER_IDX_REC_LEN]byte
+	copy(b[0:32], hash.Hash[:])
+	binary.LittleEndian.PutUint32(b[32:36], rec.Height)
+	binary.LittleEndian.PutUint32(b[36:40], rec.Len)
+	binary.LittleEndian.PutUint64(b[40:48], uint64(rec.Pos))
+	copy(b[48:80], rec.Hash.Hash[:])
+	copy(b[80:112], rec.Header.Hash[:])
+	if _, e = db.idxf.WriteAt(b[:], db.idxpos); e != nil {
+		return
+	}
+	db.datpos += int64(rec.Len)
+	db.idxpos += FILTER_IDX_REC_LEN
+	db.index[hash.BIdx()] = rec
+	return
+}
+
+func (db *FilterDB) Count() (cnt int) {
+	db.Lock()
+	cnt = len(db.index)
+	db.Unlock()
+	return
+}
+
+func (db *FilterDB) GetStats() string {
+	db.Lock()
+	defer db.Unlock()
+	return fmt.Sprintf("FILTERS: Records:%d  DataSize:%d\n", len(db.index), db.datpos)
+}
+
+func (db *FilterDB) Close() {
+	db.Lock()
+	db.datf.Close()
+	db.idxf.Close()
+	db.Unlock()
+}
+
+// genesisScript returns the output script of the genesis block's coinbase.
+func (ch *Chain) genesisScript() (res []byte) {
+	if ch.testnet4() {
+		res, _ = hex.DecodeString("21000000000000000000000000000000000000000000000000000000000000000000ac")
+	} else {
+		res, _ = hex.DecodeString("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+	}
+	return
+}
+
+// openFilters opens the block filters index, making sure the genesis block's filter is there.
+func (ch *Chain) openFilters(dir string) {
+	db, e := NewFilterDB(dir)
+	if e != nil {
+		fmt.Println("Block filters index disabled:", e.Error())
+		return
+	}
+	if db.Get(ch.Genesis) == nil {
+		e = db.Add(ch.Genesis, 0, nil, btc.NewBasicFilter(ch.Genesis, [][]byte{ch.genesisScript()}))
+		if e != nil {
+			fmt.Println("Block filters index disabled:", e.Error())
+			db.Close()
+			return
+		}
+	}
+	ch.Filters = db
+}
+
+// addBlockFilter stores the BIP158 filter of the block, if the index is enabled.
+// It must be called before the block's changes are applied to the UTXO db,
+// as the spent outputs' scripts still point to the UTXO records.
+// If the index is behind the chain, the filter is left for BuildFilters.
+func (ch *Chain) addBlockFilter(bl *btc.Block, node *BlockTreeNode) {
+	if ch.Filters == nil || ch.Filters.Get(bl.Hash) != nil {
+		return
+	}
+	filter := btc.NewBasicFilter(bl.Hash, bl.BasicFilterElements())
+	if e := ch.Filters.Add(bl.Hash, node.Height, node.Parent.BlockHash, filter); e != nil && e != ErrFilterNoParent {
+		println("addBlockFilter:", node.Height, e.Error())
+	}
+}
+
+// spentOutputs sets Spent_outputs of the block's transactions, for building the filter
+// of a block that is already connected (call bl.Clean() when done). The outputs come from the block itself,
+// from the UTXO undo data or (for blocks older than the undo data) from the transaction index.
+func (ch *Chain) spentOutputs(bl *btc.Block, height uint32) (e error) {
+	undo := make(map[[32]byte]*utxo.UtxoRec)
+	if blhash, recs, er := ch.Unspent.UndoData(height); er == nil && bytes.Equal(blhash, bl.Hash.Hash[:]) {
+		for _, rec := range recs {
+			undo[rec.TxID] = rec
+		}
+	}
+	intx := make(map[[32]byte]*btc.Tx, len(bl.Txs))
+	for i, tx := range bl.Txs {
+		if i > 0 {
+			tx.AllocVerVars()
+			tx.Spent_outputs = make([]*btc.TxOut, len(tx.TxIn))
+			for j, in := range tx.TxIn {
+				vout := int(in.Input.Vout)
+				if t, ok := intx[in.Input.Hash]; ok && vout < len(t.TxOut) {
+					tx.Spent_outputs[j] = t.TxOut[vout]
+				} else if rec, ok := undo[in.Input.Hash]; ok && vout < len(rec.Outs) && rec.Outs[vout] != nil {
+					tx.Spent_outputs[j] = &btc.TxOut{Value: rec.Outs[vout].Value, Pk_script: rec.Outs[vout].PKScr}
+				} else {
+					var ptx *btc.Tx
+					if ch.TxIndex == nil {
+						return errors.New("no undo data - enable TxIndex or rescan the chain")
+					}
+					if ptx, _, e = ch.GetIndexedTx(btc.NewUint256(in.Input.Hash[:])); e != nil {
+						return
+					}
+					if vout >= len(ptx.TxOut) {
+						return errors.New("spent output not found")
+					}
+					tx.Spent_outputs[j] = ptx.TxOut[vout]
+				}
+			}
+		}
+		intx[tx.Hash.Hash] = tx
+	}
+	return
+}
+
+// BuildFilters adds the filters of the main chain's blocks that were connected before
+// the index was enabled. It returns true when the index gets to the chain's tip
+// and false if it was aborted, or if a block's spent outputs could not be found.
+// Blocks older than the undo data need a complete TxIndex. Run it in a goroutine.
+func (ch *Chain) BuildFilters() bool {
+	db := ch.Filters
+	if db == nil {
+		return false
+	}
+	for !AbortNow {
+		// collect the main chain's blocks without filters (from the top)
+		var nodes []*BlockTreeNode
+		ch.BlockIndexAccess.Lock()
+		for n := ch.LastBlock(); n != nil && db.Get(n.BlockHash) == nil; n = n.Parent {
+			nodes = append(nodes, n)
+		}
+		ch.BlockIndexAccess.Unlock()
+		if len(nodes) == 0 {
+			return true
+		}
+
+		for i := len(nodes) - 1; i >= 0 && !AbortNow; i-- {
+			n := nodes[i]
+			var bl *btc.Block
+			crec, _, e := ch.Blocks.BlockGetInternal(n.BlockHash, true)
+			if e == nil {
+				if bl, e = btc.NewBlock(crec.Data); e == nil {
+					if e = bl.BuildTxList(); e == nil {
+						e = ch.spentOutputs(bl, n.Height)
+					}
+				}
+			}
+			if e == ErrTxNotIndexed && !ch.TxIndex.Complete() {
+				time.Sleep(10 * time.Second) // wait for BuildTxIndex
+				break
+			}
+			if e == nil {
+				filter := btc.NewBasicFilter(n.BlockHash, bl.BasicFilterElements())
+				bl.Clean()
+				e = db.Add(n.BlockHash, n.Height, n.Parent.BlockHash, filter)
+			}
+			if e != nil {
+				fmt.Println("Block filters index stopped at block", n.Height, "-", e.Error())
+				return false
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package chain
+
+import (
+	"os"
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+func TestBuildFilters(t *testing.T) {
+	dir := t.TempDir() + string(os.PathSeparator)
+	ch := testChain(t, dir, &NewChanOpts{BlockFilters: true, TxIndex: true})
+	testMine(t, ch, 101, 4)
+	// spend a coinbase, then spend its outputs in the same block and in the next one
+	out, val := testCoinbase(t, ch, 1)
+	tx1 := testSpend([]*btc.TxPrevOut{out}, val, 2)
+	tx2 := testSpend([]*btc.TxPrevOut{{Hash: tx1.Hash.Hash, Vout: 0}}, val/2, 1)
+	testAccept(t, ch, testBlock(ch, ch.LastBlock(), 4, []*btc.Tx{tx1, tx2}))
+	tx3 := testSpend([]*btc.TxPrevOut{{Hash: tx1.Hash.Hash, Vout: 1}, {Hash: tx2.Hash.Hash}}, val, 1)
+	testAccept(t, ch, testBlock(ch, ch.LastBlock(), 4, []*btc.Tx{tx3}))
+	testMine(t, ch, 2, 4)
+
+	tip := ch.LastBlock()
+	exp := ch.Filters.Get(tip.BlockHash)
+	if exp == nil {
+		t.Fatal("Filter of the tip missing")
+	}
+	ch.Close()
+
+	// drop the filters and the undo data of the block with tx1 and tx2
+	os.RemoveAll(dir + "filters")
+	os.Remove(dir + "undo" + string(os.PathSeparator) + "102")
+
+	// without TxIndex the block's spent outputs cannot be found
+	ch = testChain(t, dir, &NewChanOpts{BlockFilters: true})
+	if ch.Filters.Get(tip.BlockHash) != nil || ch.BuildFilters() {
+		t.Fatal("BuildFilters should have failed")
+	}
+	if rec := ch.Filters.Get(ch.LastBlock().Parent.Parent.Parent.Parent.BlockHash); rec == nil || rec.Height != 101 {
+		t.Error("BuildFilters should have stopped at block 102")
+	}
+	ch.Close()
+
+	ch = testChain(t, dir, &NewChanOpts{BlockFilters: true, TxIndex: true})
+	if !ch.BuildFilters() {
+		t.Fatal("BuildFilters failed")
+	}
+	rec := ch.Filters.Get(tip.BlockHash)
+	if rec == nil || rec.Header != exp.Header || rec.Hash != exp.Hash {
+		t.Error("Rebuilt filter header mismatch")
+	}
+
+	// new blocks get their filters as before
+	testMine(t, ch, 1, 4)
+	if ch.Filters.Get(ch.LastBlock().BlockHash) == nil {
+		t.Error("Filter of a new block missing")
+	}
+	ch.Close()
+}
@@ -0,0 +1,287 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/script"
+)
+
+// Signet (BIP325) blocks carry a solution of the network's challenge script, inside their coinbase's
+// witness commitment output, in a push that starts with SIGNET_HEADER.
+
+const (
+	DefaultSignetChallengeHex = "512103ad5e0edad18cb1f0fc0d28a3d4f1f3e445640337489abb10404f2d1e086be430210359ef5021964fe22d6f8e05b2463c9540ce96883fe3b278760f048f5189f2e6c452ae"
+	SignetGenesisHash         = "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6"
+
+	signetVerifyFlags = script.VER_P2SH | script.VER_WITNESS | script.VER_DERSIG | script.VER_NULLDUMMY
+)
+
+var SIGNET_HEADER = []byte{0xec, 0xc7, 0xda, 0xa2}
+
+// SignetMagic returns the network's message start bytes for the given challenge.
+func SignetMagic(challenge []byte) (res [4]byte) {
+	var buf bytes.Buffer
+	btc.WriteVlen(&buf, uint64(len(challenge)))
+	buf.Write(challenge)
+	h := btc.Sha2Sum(buf.Bytes())
+	copy(res[:], h[:4])
+	return
+}
+
+// signetPush writes the data push, the same way as Core does it (never as OP_N).
+func signetPush(wr *bytes.Buffer, data []byte) {
+	switch {
+	case len(data) < btc.OP_PUSHDATA1:
+		wr.WriteByte(byte(len(data)))
+	case len(data) <= 0xff:
+		wr.Write([]byte{btc.OP_PUSHDATA1, byte(len(data))})
+	case len(data) <= 0xffff:
+		wr.WriteByte(btc.OP_PUSHDATA2)
+		binary.Write(wr, binary.LittleEndian, uint16(len(data)))
+	default:
+		wr.WriteByte(btc.OP_PUSHDATA4)
+		binary.Write(wr, binary.LittleEndian, uint32(len(data)))
+	}
+	wr.Write(data)
+}
+
+// witnessCommitmentIndex returns index of the coinbase output with the witness commitment (or -1).
+func witnessCommitmentIndex(cb *btc.Tx) int {
+	for i := len(cb.TxOut) - 1; i >= 0; i-- {
+		o := cb.TxOut[i].Pk_script
+		if len(o) >= 38 && bytes.Equal(o[:6], []byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}) {
+			return i
+		}
+	}
+	return -1
+}
+
+// fetchSignetSolution returns the witness commitment script with the signet solution removed,
+// along with the solution (nil if there was none).
+func fetchSignetSolution(commitment []byte) (res, solution []byte, e error) {
+	var wr bytes.Buffer
+	for pc := 0; pc < len(commitment); {
+		opcode, data, le, er := btc.GetOpcode(commitment[pc:])
+		if er != nil {
+			e = er
+			return
+		}
+		pc += le
+		if len(data) == 0 {
+			wr.WriteByte(byte(opcode))
+			continue
+		}
+		if solution == nil && len(data) > len(SIGNET_HEADER) && bytes.Equal(data[:len(SIGNET_HEADER)], SIGNET_HEADER) {
+			solution = data[len(SIGNET_HEADER):]
+			data = SIGNET_HEADER
+		}
+		signetPush(&wr, data)
+	}
+	if solution == nil {
+		res = commitment
+	} else {
+		res = wr.Bytes()
+	}
+	return
+}
+
+// signetTxs returns the virtual transactions, whose input script verification
+// proves that the block (given by its header and transactions) solves the challenge.
+func signetTxs(hdr []byte, txs []*btc.Tx, challenge []byte) (to_spend, to_sign *btc.Tx, e error) {
+	if len(txs) == 0 {
+		e = errors.New("no coinbase")
+		return
+	}
+	cidx := witnessCommitmentIndex(txs[0])
+	if cidx < 0 {
+		e = errors.New("no witness commitment")
+		return
+	}
+
+	// The coinbase without the solution
+	cb := *txs[0]
+	cb.TxOut = make([]*btc.TxOut, len(txs[0].TxOut))
+	copy(cb.TxOut, txs[0].TxOut)
+	commitment, solution, e := fetchSignetSolution(cb.TxOut[cidx].Pk_script)
+	if e != nil {
+		return
+	}
+	cb.TxOut[cidx] = &btc.TxOut{Value: cb.TxOut[cidx].Value, Pk_script: commitment}
+
+	to_sign = &btc.Tx{TxIn: []*btc.TxIn{new(btc.TxIn)}, TxOut: []*btc.TxOut{{Pk_script: []byte{0x6a}}}}
+	if solution != nil {
+		rd := bytes.NewReader(solution)
+		var cnt uint64
+		if to_sign.TxIn[0].ScriptSig, e = readSignetBytes(rd); e != nil {
+			return
+		}
+		if cnt, e = btc.ReadVLen(rd); e != nil {
+			return
+		}
+		if cnt > uint64(len(solution)) {
+			e = errors.New("witness stack too big")
+			return
+		}
+		for ; cnt > 0; cnt-- {
+			var item []byte
+			if item, e = readSignetBytes(rd); e != nil {
+				return
+			}
+			if to_sign.SegWit == nil {
+				to_sign.SegWit = [][][]byte{nil}
+			}
+			to_sign.SegWit[0] = append(to_sign.SegWit[0], item)
+		}
+		if rd.Len() != 0 {
+			e = errors.New("extraneous data in signet solution")
+			return
+		}
+	}
+
+	hashes := make([][32]byte, len(txs))
+	hashes[0] = btc.Sha2Sum(cb.Serialize())
+	for i := 1; i < len(txs); i++ {
+		hashes[i] = txs[i].Hash.Hash
+	}
+	merkle, _ := btc.CalcMerkle(hashes)
+
+	var bd bytes.Buffer
+	bd.Write(hdr[0:36]) // version and previous block hash
+	bd.Write(merkle)
+	bd.Write(hdr[68:72]) // timestamp
+	var sig_scr bytes.Buffer
+	sig_scr.WriteByte(btc.OP_0)
+	signetPush(&sig_scr, bd.Bytes())
+
+	to_spend = &btc.Tx{TxIn: []*btc.TxIn{{ScriptSig: sig_scr.Bytes()}}, TxOut: []*btc.TxOut{{Pk_script: challenge}}}
+	to_spend.TxIn[0].Input.Vout = 0xffffffff
+	to_spend.SetHash(to_spend.Serialize())
+
+	to_sign.TxIn[0].Input.Hash = to_spend.Hash.Hash
+	to_sign.SetHash(to_sign.SerializeNew())
+	return
+}
+
+func readSignetBytes(rd *bytes.Reader) (res []byte, e error) {
+	var le uint64
+	if le, e = btc.ReadVLen(rd); e != nil {
+		return
+	}
+	if le > uint64(rd.Len()) {
+		e = errors.New("signet solution too short")
+		return
+	}
+	res = make([]byte, int(le))
+	rd.Read(res)
+	return
+}
+
+// checkSignetSolution verifies that the block solves the network's challenge.
+func (ch *Chain) checkSignetSolution(bl *btc.Block) error {
+	to_spend, to_sign, e := signetTxs(bl.Raw[:80], bl.Txs, ch.Consensus.SignetChallenge)
+	if e != nil {
+		return errors.New("CheckBlock() : " + e.Error() + " - RPC_Result:bad-signet-blksig")
+	}
+	to_sign.AllocVerVars()
+	if !script.VerifyTxScript(to_spend.TxOut[0].Pk_script, &script.SigChecker{Tx: to_sign, Idx: 0, Amount: 0}, signetVerifyFlags) {
+		return errors.New("CheckBlock() : signet block signature invalid - RPC_Result:bad-signet-blksig")
+	}
+	return nil
+}
+
+// SignetSign puts the challenge's solution into the coinbase (txs[0]) of a new block.
+// The coinbase must have the witness commitment already. hdr is the block's header, with
+// the version, previous block hash and timestamp set. The merkle root has to be calculated afterwards.
+// Supported challenges are: <pubkey> OP_CHECKSIG, 1-of-N multisig, P2WPKH and OP_TRUE.
+func SignetSign(hdr []byte, txs []*btc.Tx, challenge, priv []byte) (e error) {
+	if bytes.Equal(challenge, []byte{btc.OP_TRUE}) {
+		return // no solution needed
+	}
+	if len(priv) != 32 {
+		return errors.New("no private key to sign the signet block")
+	}
+	cidx := witnessCommitmentIndex(txs[0])
+	if cidx < 0 {
+		return errors.New("no witness commitment in coinbase")
+	}
+	cb := txs[0]
+	commitment := cb.TxOut[cidx].Pk_script
+	var buf bytes.Buffer
+	buf.Write(commitment)
+	signetPush(&buf, SIGNET_HEADER) // this is how the commitment looks like when the solution is removed
+	cb.TxOut[cidx].Pk_script = buf.Bytes()
+	cb.SetHash(cb.SerializeNew())
+
+	_, to_sign, e := signetTxs(hdr, txs, challenge)
+	if e != nil {
+		return
+	}
+	to_sign.AllocVerVars()
+
+	var pubkey []byte
+	for _, compr := range []bool{true, false} {
+		if pk := btc.PublicFromPrivate(priv, compr); pk != nil && bytes.Contains(challenge, pk) {
+			pubkey = pk
+			break
+		}
+	}
+	var sig_scr []byte
+	var witness [][]byte
+	switch {
+	case pubkey != nil && len(challenge) == len(pubkey)+2 && challenge[len(challenge)-1] == btc.OP_CHECKSIG:
+		sig_scr = signetSig(to_sign.SignatureHash(challenge, 0, btc.SIGHASH_ALL), priv)
+		sig_scr = append([]byte{byte(len(sig_scr))}, sig_scr...)
+
+	case pubkey != nil && challenge[0] == btc.OP_1 && challenge[len(challenge)-1] == btc.OP_CHECKMULTISIG:
+		sig := signetSig(to_sign.SignatureHash(challenge, 0, btc.SIGHASH_ALL), priv)
+		sig_scr = append([]byte{btc.OP_0, byte(len(sig))}, sig...)
+
+	case len(challenge) == 22 && challenge[0] == 0 && challenge[1] == 20:
+		pubkey = btc.PublicFromPrivate(priv, true)
+		if h160 := btc.Rimp160AfterSha256(pubkey); !bytes.Equal(h160[:], challenge[2:]) {
+			return errors.New("the key does not match the signet challenge")
+		}
+		p2pkh := append(append([]byte{0x76, 0xa9, 20}, challenge[2:]...), 0x88, 0xac)
+		if e = to_sign.SignWitness(0, p2pkh, 0, btc.SIGHASH_ALL, pubkey, priv); e != nil {
+			return
+		}
+		witness = to_sign.SegWit[0]
+
+	case pubkey == nil:
+		return errors.New("the key does not match the signet challenge")
+
+	default:
+		return errors.New("unsupported signet challenge: " + hex.EncodeToString(challenge))
+	}
+
+	buf.Reset()
+	buf.Write(SIGNET_HEADER)
+	btc.WriteVlen(&buf, uint64(len(sig_scr)))
+	buf.Write(sig_scr)
+	btc.WriteVlen(&buf, uint64(len(witness)))
+	for _, w := range witness {
+		btc.WriteVlen(&buf, uint64(len(w)))
+		buf.Write(w)
+	}
+	var scr bytes.Buffer
+	scr.Write(commitment)
+	signetPush(&scr, buf.Bytes())
+	cb.TxOut[cidx].Pk_script = scr.Bytes()
+	cb.SetHash(cb.SerializeNew())
+	return
+}
+
+func signetSig(hash, priv []byte) []byte {
+	r, s, e := btc.EcdsaSign(priv, hash)
+	if e != nil {
+		panic(e.Error())
+	}
+	sig := &btc.Signature{HashType: btc.SIGHASH_ALL}
+	sig.R.Set(r)
+	sig.S.Set(s)
+	return sig.Bytes()
+}
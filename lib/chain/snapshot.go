@@ -0,0 +1,78 @@
+package chain
+
+import (
+	"bufio"
+	"errors"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/utxo"
+)
+
+// LoadUtxoSnapshot replaces the UTXO set with the one from Core's snapshot (assumeutxo)
+// and moves the chain's tip to the snapshot's base block, whose header must already be known.
+// The headers of the blocks below, which have no data, get stored in the block index.
+// It returns hash_serialized_3 of the snapshot. Do not call it with blocks being processed.
+func (ch *Chain) LoadUtxoSnapshot(rd *bufio.Reader, hdr *utxo.SnapshotHeader) (base *BlockTreeNode, hash []byte, e error) {
+	ch.BlockIndexAccess.Lock()
+	base = ch.BlockIndex[hdr.BaseHash.BIdx()]
+	ch.BlockIndexAccess.Unlock()
+	if base == nil {
+		e = errors.New("snapshot's base block " + hdr.BaseHash.String() + " not in the headers chain")
+		return
+	}
+	if base.Height <= ch.LastBlock().Height {
+		e = errors.New("the chain is already at or beyond the snapshot's base block")
+		return
+	}
+
+	if hash, e = ch.Unspent.ImportSnapshot(rd, hdr, base.Height, &AbortNow); e != nil {
+		return
+	}
+
+	for n := base; n.Parent != nil; n = n.Parent {
+		if n.BlockSize == 0 {
+			ch.Blocks.HeaderAdd(n.Height, n.BlockHash, n.BlockHeader[:])
+		}
+	}
+	ch.Blocks.Idle() // the index must be on disk before the new UTXO.db
+
+	ch.SetLast(base)
+	return
+}
+
+// ConnectBlockNoData checks the block, which must extend the chain's tip, and applies it to the UTXO set.
+// Only the header of the block gets stored (used for validating history below a UTXO snapshot).
+func (ch *Chain) ConnectBlockNoData(bl *btc.Block, lknown uint32) (e error) {
+	ch.BlockIndexAccess.Lock()
+	cur := ch.BlockIndex[bl.Hash.BIdx()]
+	if cur == nil {
+		if _, _, e = ch.PreCheckBlock(bl); e == nil {
+			cur = ch.AcceptHeader(bl)
+		}
+	}
+	ch.BlockIndexAccess.Unlock()
+	if e != nil {
+		return
+	}
+	if cur.Parent != ch.LastBlock() {
+		e = errors.New("block " + bl.Hash.String() + " does not extend the chain")
+		return
+	}
+	bl.Height = cur.Height
+	bl.MedianPastTime = cur.Parent.GetMedianTimePast()
+	if e = ch.PostCheckBlock(bl); e != nil {
+		return
+	}
+
+	changes, sigopscost, e := ch.ProcessBlockTransactions(bl, cur.Height, lknown)
+	if e != nil {
+		return
+	}
+	cur.SigopsCost = sigopscost
+	cur.TxCount = uint32(bl.TxCount)
+	ch.Blocks.HeaderAdd(cur.Height, bl.Hash, bl.Raw[:80])
+	ch.Unspent.CommitBlockTxs(changes, bl.Hash.Hash[:])
+	ch.SetLast(cur)
+	bl.Clean()
+	return
+}
@@ -0,0 +1,433 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+/*
+	Transaction index (the "txindex" folder):
+	state.dat - 12 bytes (all values LSB):
+		[0:4] - built till: all the blocks below this height have been indexed
+		[4:8] - live from: the blocks from this height up are being indexed as they get connected
+		[8:12] - height of the last indexed block
+	000.srt ... fff.srt - records of 20 bytes, in a file selected by the first 12 bits of txid,
+	sorted by the first 8 bytes (binary searched):
+		[0:8] - first 8 bytes of txid
+		[8:12] - 32-bit block height
+		[12:16] - 32-bit offset of the tx within the (uncompressed) block
+		[16:20] - 32-bit length of the tx
+	000.dat ... fff.dat - the new records (not sorted), that get merged into the .srt file
+		when there is more than TXINDEX_MERGE_MIN of them and over 1/8 of the .srt file's size.
+	The records are never removed. The ones left by orphaned blocks (or doubled after an unclean
+	shutdown) are harmless, as each tx is verified against its txid when being fetched.
+*/
+
+const (
+	TXINDEX_REC_LEN    = 20
+	TXINDEX_FILES      = 0x1000
+	TXINDEX_FLUSH_SIZE = 4 << 20 // write the pending records to disk when there is this much of them
+	TXINDEX_MERGE_MIN  = 64 << 10
+)
+
+var (
+	ErrTxNotIndexed = errors.New("no such transaction in the index")
+	ErrTxPruned     = errors.New("the block with the transaction has been pruned")
+)
+
+type TxIndexRec struct {
+	Height uint32
+	Offset uint32
+	Length uint32
+}
+
+type TxIndex struct {
+	dir         string
+	BuiltTill   uint32 // the blocks below have been indexed by the background builder
+	LiveFrom    uint32 // the blocks from this height up get indexed when connected
+	Tip         uint32 // height of the last indexed block
+	Records     uint64
+	Skipped     uint32 // number of blocks that the builder could not read (pruned from disk)
+	pending     map[int][]byte
+	pendingSize int
+	closed      bool
+	sync.Mutex
+}
+
+// NewTxIndex opens (or creates) the transaction index in the given folder.
+// tip is the height of the chain's last block.
+func NewTxIndex(dir string, tip uint32) (db *TxIndex, e error) {
+	if e = os.MkdirAll(dir, 0770); e != nil {
+		return
+	}
+	db = &TxIndex{dir: dir, pending: make(map[int][]byte)}
+	if d, er := os.ReadFile(dir + "state.dat"); er == nil && len(d) == 12 {
+		db.BuiltTill = binary.LittleEndian.Uint32(d[0:4])
+		db.LiveFrom = binary.LittleEndian.Uint32(d[4:8])
+		db.Tip = binary.LittleEndian.Uint32(d[8:12])
+		if db.Tip != tip {
+			// Not closed properly - only trust the part up to the lower tip
+			if db.BuiltTill >= db.LiveFrom {
+				if db.Tip > tip {
+					db.Tip = tip
+				}
+				db.BuiltTill = db.Tip + 1
+			}
+			db.LiveFrom = tip + 1
+		}
+	} else {
+		db.BuiltTill = 1 // genesis block's coinbase cannot be spent, nor fetched
+		db.LiveFrom = tip + 1
+	}
+	db.Tip = tip
+	for i := 0; i < TXINDEX_FILES; i++ {
+		db.Records += uint64(fileSize(db.fname(i))/TXINDEX_REC_LEN + fileSize(db.sname(i))/TXINDEX_REC_LEN)
+	}
+	e = db.saveState()
+	return
+}
+
+func (db *TxIndex) fname(i int) string {
+	return fmt.Sprintf("%s%03x.dat", db.dir, i)
+}
+
+func (db *TxIndex) sname(i int) string {
+	return fmt.Sprintf("%s%03x.srt", db.dir, i)
+}
+
+func fileSize(fn string) int64 {
+	if fi, er := os.Stat(fn); er == nil {
+		return fi.Size()
+	}
+	return 0
+}
+
+func txIndexFile(txid *btc.Uint256) int {
+	return int(txid.Hash[0])<<4 | int(txid.Hash[1]>>4)
+}
+
+// flush writes the pending records to disk. Call it with the mutex locked.
+func (db *TxIndex) flush() (e error) {
+	for i, d := range db.pending {
+		f, er := os.OpenFile(db.fname(i), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0660)
+		if er != nil {
+			e = er
+			continue
+		}
+		if _, er = f.Write(d); er != nil {
+			e = er
+		}
+		f.Close()
+		if size := fileSize(db.fname(i)); size > TXINDEX_MERGE_MIN && size > fileSize(db.sname(i))/8 {
+			if er = db.merge(i); er != nil {
+				e = er
+			}
+		}
+	}
+	db.pending = make(map[int][]byte)
+	db.pendingSize = 0
+	return
+}
+
+// merge sorts the new records of the given file into its .srt file. Call it with the mutex locked.
+func (db *TxIndex) merge(i int) (e error) {
+	var srt, d []byte
+	if srt, e = os.ReadFile(db.sname(i)); e != nil && !os.IsNotExist(e) {
+		return
+	}
+	if d, e = os.ReadFile(db.fname(i)); e != nil {
+		return
+	}
+	recs := make([][]byte, 0, (len(srt)+len(d))/TXINDEX_REC_LEN)
+	for ; len(srt) >= TXINDEX_REC_LEN; srt = srt[TXINDEX_REC_LEN:] {
+		recs = append(recs, srt[:TXINDEX_REC_LEN])
+	}
+	for ; len(d) >= TXINDEX_REC_LEN; d = d[TXINDEX_REC_LEN:] {
+		recs = append(recs, d[:TXINDEX_REC_LEN])
+	}
+	sort.SliceStable(recs, func(a, b int) bool {
+		return bytes.Compare(recs[a][:8], recs[b][:8]) < 0
+	})
+	res := make([]byte, 0, len(recs)*TXINDEX_REC_LEN)
+	for _, r := range recs {
+		res = append(res, r...)
+	}
+	// a crash after the rename leaves the new records doubled, which is harmless
+	if e = os.WriteFile(db.dir+"tmp.srt", res, 0660); e != nil {
+		return
+	}
+	if e = os.Rename(db.dir+"tmp.srt", db.sname(i)); e != nil {
+		return
+	}
+	return os.Remove(db.fname(i))
+}
+
+// saveState flushes the pending records and then stores the state. Call it with the mutex locked.
+func (db *TxIndex) saveState() (e error) {
+	if e = db.flush(); e != nil {
+		return
+	}
+	var b [12]byte
+	binary.LittleEndian.PutUint32(b[0:4], db.BuiltTill)
+	binary.LittleEndian.PutUint32(b[4:8], db.LiveFrom)
+	binary.LittleEndian.PutUint32(b[8:12], db.Tip)
+	return os.WriteFile(db.dir+"state.dat", b[:], 0660)
+}
+
+// add stores the records of all the block's transactions. Call it with the mutex locked.
+func (db *TxIndex) add(bl *btc.Block, height uint32) {
+	var b [TXINDEX_REC_LEN]byte
+	offs := uint32(bl.TxOffset)
+	binary.LittleEndian.PutUint32(b[8:12], height)
+	for _, tx := range bl.Txs {
+		copy(b[0:8], tx.Hash.Hash[:8])
+		binary.LittleEndian.PutUint32(b[12:16], offs)
+		binary.LittleEndian.PutUint32(b[16:20], uint32(len(tx.Raw)))
+		i := txIndexFile(&tx.Hash)
+		db.pending[i] = append(db.pending[i], b[:]...)
+		offs += uint32(len(tx.Raw))
+	}
+	db.Records += uint64(len(bl.Txs))
+	db.pendingSize += len(bl.Txs) * TXINDEX_REC_LEN
+	if db.pendingSize >= TXINDEX_FLUSH_SIZE {
+		if e := db.saveState(); e != nil {
+			println("TxIndex:", e.Error())
+		}
+	}
+}
+
+// BlockConnected indexes the transactions of the block that has just become the chain's tip.
+// The block's transactions must have been built (with hashes).
+func (db *TxIndex) BlockConnected(bl *btc.Block, height uint32) {
+	db.Lock()
+	if !db.closed {
+		db.add(bl, height)
+		db.Tip = height
+	}
+	db.Unlock()
+}
+
+// BlockUndone must be called when the chain's tip at the given height is undone.
+// Heights from there up are indexed again, when the new blocks get connected.
+func (db *TxIndex) BlockUndone(height uint32) {
+	db.Lock()
+	if db.LiveFrom > height {
+		db.LiveFrom = height
+	}
+	db.Tip = height - 1
+	db.Unlock()
+}
+
+// Get returns the records of all the transactions whose txid starts with the same 8 bytes.
+func (db *TxIndex) Get(txid *btc.Uint256) (res []TxIndexRec, e error) {
+	db.Lock()
+	defer db.Unlock()
+	if db.closed {
+		e = errors.New("TxIndex closed")
+		return
+	}
+	i := txIndexFile(txid)
+	if res, e = db.search(i, txid.Hash[:8]); e != nil {
+		return
+	}
+	// the new records are not sorted, but there is not many of them
+	d, e := os.ReadFile(db.fname(i))
+	if e != nil {
+		if !os.IsNotExist(e) {
+			return
+		}
+		e = nil
+	}
+	if p := db.pending[i]; len(p) > 0 {
+		d = append(d, p...)
+	}
+	for ; len(d) >= TXINDEX_REC_LEN; d = d[TXINDEX_REC_LEN:] {
+		if bytes.Equal(d[0:8], txid.Hash[0:8]) {
+			res = append(res, newTxIndexRec(d))
+		}
+	}
+	return
+}
+
+func newTxIndexRec(d []byte) TxIndexRec {
+	return TxIndexRec{Height: binary.LittleEndian.Uint32(d[8:12]),
+		Offset: binary.LittleEndian.Uint32(d[12:16]), Length: binary.LittleEndian.Uint32(d[16:20])}
+}
+
+// search binary searches the .srt file for the records with the given key. Call it with the mutex locked.
+func (db *TxIndex) search(i int, key []byte) (res []TxIndexRec, e error) {
+	f, e := os.Open(db.sname(i))
+	if e != nil {
+		if os.IsNotExist(e) {
+			e = nil
+		}
+		return
+	}
+	defer f.Close()
+	fi, e := f.Stat()
+	if e != nil {
+		return
+	}
+	cnt := int(fi.Size() / TXINDEX_REC_LEN)
+	var b [TXINDEX_REC_LEN]byte
+	idx := sort.Search(cnt, func(n int) bool {
+		if _, er := f.ReadAt(b[:], int64(n)*TXINDEX_REC_LEN); er != nil {
+			e = er
+			return true
+		}
+		return bytes.Compare(b[:8], key) >= 0
+	})
+	for ; e == nil && idx < cnt; idx++ {
+		if _, e = f.ReadAt(b[:], int64(idx)*TXINDEX_REC_LEN); e != nil || !bytes.Equal(b[:8], key) {
+			break
+		}
+		res = append(res, newTxIndexRec(b[:]))
+	}
+	return
+}
+
+// Complete returns true if all the chain's blocks have been indexed.
+func (db *TxIndex) Complete() bool {
+	db.Lock()
+	defer db.Unlock()
+	return db.BuiltTill >= db.LiveFrom
+}
+
+func (db *TxIndex) GetStats() string {
+	db.Lock()
+	defer db.Unlock()
+	s := fmt.Sprintf("TXINDEX: Records:%d  Tip:%d  Pending:%d", db.Records, db.Tip, db.pendingSize/TXINDEX_REC_LEN)
+	if db.BuiltTill < db.LiveFrom {
+		s += fmt.Sprintf("  Building:%d/%d", db.BuiltTill, db.LiveFrom)
+	}
+	if db.Skipped > 0 {
+		s += fmt.Sprintf("  Skipped:%d", db.Skipped)
+	}
+	return s + "\n"
+}
+
+func (db *TxIndex) Close() {
+	db.Lock()
+	if !db.closed {
+		if e := db.saveState(); e != nil {
+			println("TxIndex:", e.Error())
+		}
+		db.closed = true
+	}
+	db.Unlock()
+}
+
+// openTxIndex opens the transaction index, for the current tip of the chain.
+func (ch *Chain) openTxIndex(dir string) {
+	db, e := NewTxIndex(dir, ch.LastBlock().Height)
+	if e != nil {
+		fmt.Println("Transaction index disabled:", e.Error())
+		return
+	}
+	ch.TxIndex = db
+}
+
+// BuildTxIndex indexes the blocks that were connected before the index was enabled.
+// The blocks whose data is not on disk (pruned with DataFilesKeep, or below a UTXO snapshot)
+// are skipped. It returns when the index is complete, closed or AbortNow gets set,
+// so run it in a goroutine.
+func (ch *Chain) BuildTxIndex() {
+	db := ch.TxIndex
+	if db == nil {
+		return
+	}
+	for !AbortNow {
+		db.Lock()
+		from, till, closed := db.BuiltTill, db.LiveFrom, db.closed
+		db.Unlock()
+		if closed || from >= till {
+			return
+		}
+
+		// collect the main chain's blocks to index (from the top, down to from)
+		var nodes []*BlockTreeNode
+		ch.BlockIndexAccess.Lock()
+		for n := ch.LastBlock(); n != nil && n.Height >= from; n = n.Parent {
+			if n.Height < till {
+				nodes = append(nodes, n)
+			}
+		}
+		ch.BlockIndexAccess.Unlock()
+
+		for i := len(nodes) - 1; i >= 0 && !AbortNow; i-- {
+			n := nodes[i]
+			var bl *btc.Block
+			crec, _, er := ch.Blocks.BlockGetInternal(n.BlockHash, true)
+			if er == nil {
+				if bl, er = btc.NewBlock(crec.Data); er == nil {
+					er = bl.BuildTxList()
+				}
+			}
+
+			db.Lock()
+			// a block from this height up may have been undone in the meantime
+			if db.closed || n.Height != db.BuiltTill || n.Height >= db.LiveFrom {
+				db.Unlock()
+				break
+			}
+			if er == nil {
+				db.add(bl, n.Height)
+			} else {
+				db.Skipped++
+			}
+			db.BuiltTill = n.Height + 1
+			if db.BuiltTill >= db.LiveFrom {
+				if e := db.saveState(); e != nil {
+					println("TxIndex:", e.Error())
+				}
+			}
+			db.Unlock()
+		}
+	}
+}
+
+// GetIndexedTx finds a transaction of the main chain by its txid, using the transaction index.
+func (ch *Chain) GetIndexedTx(txid *btc.Uint256) (tx *btc.Tx, node *BlockTreeNode, e error) {
+	if ch.TxIndex == nil {
+		e = errors.New("transaction index not enabled")
+		return
+	}
+	recs, e := ch.TxIndex.Get(txid)
+	if e != nil {
+		return
+	}
+	e = ErrTxNotIndexed
+	for _, rec := range recs {
+		if node = ch.BlockAtHeight(rec.Height); node == nil {
+			continue
+		}
+
+		raw, _, er := ch.Blocks.BlockGet(node.BlockHash)
+		if er != nil {
+			e = ErrTxPruned
+			continue
+		}
+		end := uint64(rec.Offset) + uint64(rec.Length)
+		if end > uint64(len(raw)) {
+			continue
+		}
+		var n int
+		if tx, n = btc.NewTx(raw[rec.Offset:end]); tx == nil || n != int(rec.Length) {
+			continue
+		}
+		tx.SetHash(raw[rec.Offset:end])
+		if tx.Hash.Equal(txid) {
+			e = nil
+			return
+		}
+	}
+	tx, node = nil, nil
+	return
+}
@@ -0,0 +1,86 @@
+package chain
+
+import (
+	"os"
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// testMergeTxIndex flushes the pending records and sorts them all into the .srt files.
+func testMergeTxIndex(t *testing.T, db *TxIndex) {
+	db.Lock()
+	defer db.Unlock()
+	if e := db.flush(); e != nil {
+		t.Fatal(e.Error())
+	}
+	for i := 0; i < TXINDEX_FILES; i++ {
+		if fileSize(db.fname(i)) > 0 {
+			if e := db.merge(i); e != nil {
+				t.Fatal(e.Error())
+			}
+		}
+	}
+}
+
+func testIndexedTx(t *testing.T, ch *Chain, tx *btc.Tx, height uint32) {
+	res, node, e := ch.GetIndexedTx(&tx.Hash)
+	if e != nil {
+		t.Fatal("GetIndexedTx:", e.Error())
+	}
+	if node.Height != height || !res.Hash.Equal(&tx.Hash) {
+		t.Error("Bad indexed tx at height", node.Height)
+	}
+}
+
+func TestTxIndex(t *testing.T) {
+	dir := t.TempDir() + string(os.PathSeparator)
+	ch := testChain(t, dir, &NewChanOpts{TxIndex: true})
+	testMine(t, ch, 101, 4)
+	out, val := testCoinbase(t, ch, 1)
+	tx1 := testSpend([]*btc.TxPrevOut{out}, val, 2)
+	testAccept(t, ch, testBlock(ch, ch.LastBlock(), 4, []*btc.Tx{tx1}))
+
+	// from the pending records
+	testIndexedTx(t, ch, tx1, 102)
+	cb, _ := testCoinbase(t, ch, 50)
+	if _, node, e := ch.GetIndexedTx(btc.NewUint256(cb.Hash[:])); e != nil || node.Height != 50 {
+		t.Error("Coinbase of block 50 not found")
+	}
+
+	// from the sorted files, with the next block's records in the unsorted ones
+	testMergeTxIndex(t, ch.TxIndex)
+	testMine(t, ch, 1, 4)
+	ch.Close()
+	ch = testChain(t, dir, &NewChanOpts{TxIndex: true})
+	if ch.TxIndex.Records != 104 {
+		t.Error("Bad number of records", ch.TxIndex.Records)
+	}
+	testIndexedTx(t, ch, tx1, 102)
+	cb, _ = testCoinbase(t, ch, 103)
+	if _, _, e := ch.GetIndexedTx(btc.NewUint256(cb.Hash[:])); e != nil {
+		t.Error("Coinbase of block 103 not found:", e.Error())
+	}
+
+	// undo the block with tx1 and connect a different one in its place
+	ch.UndoLastBlock()
+	ch.UndoLastBlock()
+	if _, _, e := ch.GetIndexedTx(&tx1.Hash); e != ErrTxNotIndexed {
+		t.Error("Undone tx still found:", e)
+	}
+	tx2 := testSpend([]*btc.TxPrevOut{out}, val, 3)
+	testAccept(t, ch, testBlock(ch, ch.LastBlock(), 4, []*btc.Tx{tx2}))
+	testIndexedTx(t, ch, tx2, 102)
+	if ch.BlockAtHeight(102) != ch.LastBlock() || ch.BlockAtHeight(103) != nil || ch.BlockAtHeight(101) != ch.LastBlock().Parent {
+		t.Error("Main chain index not updated")
+	}
+	if _, _, e := ch.GetIndexedTx(&tx1.Hash); e != ErrTxNotIndexed {
+		t.Error("Orphaned tx found:", e)
+	}
+	if _, _, e := ch.GetIndexedTx(btc.NewUint256(cb.Hash[:])); e != ErrTxNotIndexed {
+		t.Error("Orphaned coinbase found:", e)
+	}
+	testMergeTxIndex(t, ch.TxIndex)
+	testIndexedTx(t, ch, tx2, 102)
+	ch.Close()
+}
@@ -0,0 +1,265 @@
+package chain
+
+import (
+	"sync"
+)
+
+// BIP9 (and BIP8) soft fork deployments, with their states calculated from the block tree.
+
+type ThresholdState int
+
+const (
+	THRESHOLD_DEFINED ThresholdState = iota
+	THRESHOLD_STARTED
+	THRESHOLD_MUST_SIGNAL // BIP8 only
+	THRESHOLD_LOCKED_IN
+	THRESHOLD_ACTIVE
+	THRESHOLD_FAILED
+)
+
+const (
+	VERSIONBITS_TOP_BITS = 0x20000000
+	VERSIONBITS_TOP_MASK = 0xE0000000
+	VERSIONBITS_NUM_BITS = 29
+
+	VB_ALWAYS_ACTIVE = -1 // use it as Deployment.StartTime, for the deployment to be active from the genesis
+	VB_NEVER_ACTIVE  = -2 // use it as Deployment.StartTime, for the deployment to be always failed
+	VB_NO_TIMEOUT    = int64(^uint64(0) >> 1)
+)
+
+func (s ThresholdState) String() string {
+	switch s {
+	case THRESHOLD_DEFINED:
+		return "defined"
+	case THRESHOLD_STARTED:
+		return "started"
+	case THRESHOLD_MUST_SIGNAL:
+		return "must_signal"
+	case THRESHOLD_LOCKED_IN:
+		return "locked_in"
+	case THRESHOLD_ACTIVE:
+		return "active"
+	case THRESHOLD_FAILED:
+		return "failed"
+	}
+	return "unknown"
+}
+
+type Deployment struct {
+	Name                string
+	Bit                 uint8
+	StartTime           int64  // BIP9: median time past from which signalling starts (or VB_ALWAYS_ACTIVE / VB_NEVER_ACTIVE)
+	Timeout             int64  // BIP9: median time past after which the deployment fails, if not locked in
+	MinActivationHeight uint32 // it does not get active before this height, even if locked in
+
+	BIP8            bool   // use heights, instead of times, for the start and timeout
+	StartHeight     uint32 // BIP8: signalling starts at this height
+	TimeoutHeight   uint32 // BIP8: the deployment fails at this height, if not locked in
+	LockinOnTimeout bool   // BIP8: blocks in the last period before TimeoutHeight must signal
+
+	VerifyFlags uint32 // script verification flags, enforced once the deployment is active (for custom networks)
+
+	cache map[*BlockTreeNode]ThresholdState // the state of the period following the key block
+}
+
+type DeploymentStats struct {
+	Period, Threshold, Elapsed, Count uint32
+	Possible                          bool // if the threshold can still be reached in the current period
+}
+
+var versionBitsMutex sync.Mutex // protects the deployments' cache
+
+// Signals returns true if the given block version signals for the deployment.
+func (d *Deployment) Signals(ver uint32) bool {
+	return (ver&VERSIONBITS_TOP_MASK) == VERSIONBITS_TOP_BITS && (ver>>d.Bit)&1 != 0
+}
+
+// ancestor returns the parent of n at the given height.
+func (n *BlockTreeNode) ancestor(height int) *BlockTreeNode {
+	if height < 0 || n == nil || int(n.Height) < height {
+		return nil
+	}
+	for int(n.Height) > height {
+		n = n.Parent
+	}
+	return n
+}
+
+// periodEnd returns the last block of the period before the one that follows prev.
+func (ch *Chain) periodEnd(prev *BlockTreeNode) *BlockTreeNode {
+	if prev == nil {
+		return nil
+	}
+	period := int(ch.Consensus.Window)
+	return prev.ancestor(int(prev.Height) - (int(prev.Height)+1)%period)
+}
+
+// forgetDeploymentStates removes the cached deployment states of the node and all its children.
+// Make sure ch.BlockIndexAccess is locked before calling it.
+func (ch *Chain) forgetDeploymentStates(n *BlockTreeNode) {
+	versionBitsMutex.Lock()
+	ch.forgetStates(n)
+	versionBitsMutex.Unlock()
+}
+
+func (ch *Chain) forgetStates(n *BlockTreeNode) {
+	if (uint(n.Height)+1)%ch.Consensus.Window == 0 {
+		for _, d := range ch.Consensus.Deployments {
+			delete(d.cache, n)
+		}
+	}
+	for _, c := range n.Childs {
+		ch.forgetStates(c)
+	}
+}
+
+// DeploymentState returns the state of the deployment for the block that follows prev.
+func (ch *Chain) DeploymentState(d *Deployment, prev *BlockTreeNode) ThresholdState {
+	versionBitsMutex.Lock()
+	defer versionBitsMutex.Unlock()
+	return ch.deploymentState(d, prev)
+}
+
+func (ch *Chain) deploymentState(d *Deployment, prev *BlockTreeNode) (state ThresholdState) {
+	if !d.BIP8 {
+		if d.StartTime == VB_ALWAYS_ACTIVE {
+			return THRESHOLD_ACTIVE
+		}
+		if d.StartTime == VB_NEVER_ACTIVE {
+			return THRESHOLD_FAILED
+		}
+	}
+	if d.cache == nil {
+		d.cache = make(map[*BlockTreeNode]ThresholdState)
+	}
+	period := int(ch.Consensus.Window)
+
+	// Walk back to the first period with known state
+	var to_compute []*BlockTreeNode
+	prev = ch.periodEnd(prev)
+	for {
+		var ok bool
+		if state, ok = d.cache[prev]; ok {
+			break
+		}
+		if prev == nil {
+			state = THRESHOLD_DEFINED // the genesis block is by definition defined
+			d.cache[prev] = state
+			break
+		}
+		if d.BIP8 && prev.Height+1 < d.StartHeight || !d.BIP8 && int64(prev.GetMedianTimePast()) < d.StartTime {
+			state = THRESHOLD_DEFINED // optimization: it cannot have changed before the start
+			d.cache[prev] = state
+			break
+		}
+		to_compute = append(to_compute, prev)
+		prev = prev.ancestor(int(prev.Height) - period)
+	}
+
+	// Now go forward and figure out the state of each following period
+	for i := len(to_compute) - 1; i >= 0; i-- {
+		prev = to_compute[i]
+		height := prev.Height + 1 // height of the first block of the period
+		switch state {
+		case THRESHOLD_DEFINED:
+			if d.BIP8 && height >= d.StartHeight || !d.BIP8 && int64(prev.GetMedianTimePast()) >= d.StartTime {
+				state = THRESHOLD_STARTED
+			}
+
+		case THRESHOLD_STARTED:
+			var count uint32
+			for n, j := prev, 0; j < period; j++ {
+				if d.Signals(n.BlockVersion()) {
+					count++
+				}
+				n = n.Parent
+			}
+			if count >= ch.Consensus.BIP9_Treshold {
+				state = THRESHOLD_LOCKED_IN
+			} else if d.BIP8 {
+				if d.LockinOnTimeout && height+uint32(period) >= d.TimeoutHeight {
+					state = THRESHOLD_MUST_SIGNAL
+				} else if height >= d.TimeoutHeight {
+					state = THRESHOLD_FAILED
+				}
+			} else if int64(prev.GetMedianTimePast()) >= d.Timeout {
+				state = THRESHOLD_FAILED
+			}
+
+		case THRESHOLD_MUST_SIGNAL:
+			state = THRESHOLD_LOCKED_IN
+
+		case THRESHOLD_LOCKED_IN:
+			if height >= d.MinActivationHeight {
+				state = THRESHOLD_ACTIVE
+			}
+		}
+		d.cache[prev] = state
+	}
+	return
+}
+
+// DeploymentSince returns the height of the first block, from which the deployment has its current state.
+func (ch *Chain) DeploymentSince(d *Deployment, prev *BlockTreeNode) uint32 {
+	versionBitsMutex.Lock()
+	defer versionBitsMutex.Unlock()
+	if !d.BIP8 && (d.StartTime == VB_ALWAYS_ACTIVE || d.StartTime == VB_NEVER_ACTIVE) {
+		return 0
+	}
+	state := ch.deploymentState(d, prev)
+	if state == THRESHOLD_DEFINED {
+		return 0
+	}
+	period := int(ch.Consensus.Window)
+	prev = ch.periodEnd(prev)
+	for {
+		earlier := prev.ancestor(int(prev.Height) - period)
+		if earlier == nil || ch.deploymentState(d, earlier) != state {
+			break
+		}
+		prev = earlier
+	}
+	return prev.Height + 1
+}
+
+// DeploymentStatistics returns the signalling numbers of the current period (the one that contains prev).
+func (ch *Chain) DeploymentStatistics(d *Deployment, prev *BlockTreeNode) (res DeploymentStats) {
+	res.Period = uint32(ch.Consensus.Window)
+	res.Threshold = ch.Consensus.BIP9_Treshold
+	if prev == nil {
+		res.Possible = true
+		return
+	}
+	res.Elapsed = 1 + prev.Height%res.Period
+	for n, i := prev, uint32(0); n != nil && i < res.Elapsed; i++ {
+		if d.Signals(n.BlockVersion()) {
+			res.Count++
+		}
+		n = n.Parent
+	}
+	res.Possible = res.Period-res.Threshold >= res.Elapsed-res.Count
+	return
+}
+
+// ComputeBlockVersion returns the version of a new block, that is to follow prev,
+// with bits set for the deployments that we signal.
+func (ch *Chain) ComputeBlockVersion(prev *BlockTreeNode) (ver uint32) {
+	ver = VERSIONBITS_TOP_BITS
+	for _, d := range ch.Consensus.Deployments {
+		switch ch.DeploymentState(d, prev) {
+		case THRESHOLD_STARTED, THRESHOLD_MUST_SIGNAL, THRESHOLD_LOCKED_IN:
+			ver |= 1 << d.Bit
+		}
+	}
+	return
+}
+
+// DeploymentFlags returns the script verification flags of the active deployments, for the block that follows prev.
+func (ch *Chain) DeploymentFlags(prev *BlockTreeNode) (flags uint32) {
+	for _, d := range ch.Consensus.Deployments {
+		if d.VerifyFlags != 0 && ch.DeploymentState(d, prev) == THRESHOLD_ACTIVE {
+			flags |= d.VerifyFlags
+		}
+	}
+	return
+}
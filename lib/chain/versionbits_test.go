@@ -0,0 +1,175 @@
+package chain
+
+import (
+	"strings"
+	"testing"
+)
+
+// testPeriod mines the next period (or the rest of the current one), with the first signalling blocks signalling for d.
+func testPeriod(t *testing.T, ch *Chain, d *Deployment, signalling int) {
+	for {
+		ver := uint32(VERSIONBITS_TOP_BITS)
+		if signalling > 0 {
+			ver |= 1 << d.Bit
+			signalling--
+		}
+		testMine(t, ch, 1, ver)
+		if (uint(ch.LastBlock().Height)+1)%ch.Consensus.Window == 0 {
+			return
+		}
+	}
+}
+
+// testState checks the deployment's state for the block following the chain's tip.
+func testState(t *testing.T, ch *Chain, d *Deployment, exp ThresholdState) {
+	t.Helper()
+	last := ch.LastBlock()
+	if state := ch.DeploymentState(d, last); state != exp {
+		t.Fatal("Height", last.Height+1, "state", state, "- expected", exp)
+	}
+}
+
+func TestVersionBitsActivation(t *testing.T) {
+	// the first period's MTP is over StartTime, so the second one is STARTED
+	d := &Deployment{Name: "test", Bit: 1, StartTime: testStartTime + 100, Timeout: VB_NO_TIMEOUT}
+	ch := testChain(t, "", &NewChanOpts{Deployments: []*Deployment{d}})
+	defer ch.Close()
+	testState(t, ch, d, THRESHOLD_DEFINED)
+	testMine(t, ch, 142, VERSIONBITS_TOP_BITS)
+	testState(t, ch, d, THRESHOLD_DEFINED)
+	if ch.ComputeBlockVersion(ch.LastBlock())&(1<<d.Bit) != 0 {
+		t.Error("Signalling in DEFINED state")
+	}
+	testMine(t, ch, 1, VERSIONBITS_TOP_BITS)
+	testState(t, ch, d, THRESHOLD_STARTED)
+	if ch.ComputeBlockVersion(ch.LastBlock())&(1<<d.Bit) == 0 {
+		t.Error("Not signalling in STARTED state")
+	}
+
+	// one block below the threshold (108 of 144 on regtest)
+	testPeriod(t, ch, d, int(ch.Consensus.BIP9_Treshold)-1)
+	testState(t, ch, d, THRESHOLD_STARTED)
+	testPeriod(t, ch, d, int(ch.Consensus.BIP9_Treshold))
+	testState(t, ch, d, THRESHOLD_LOCKED_IN)
+	if since := ch.DeploymentSince(d, ch.LastBlock()); since != 432 {
+		t.Error("LOCKED_IN since", since)
+	}
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_ACTIVE)
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_ACTIVE)
+	if since := ch.DeploymentSince(d, ch.LastBlock()); since != 576 {
+		t.Error("ACTIVE since", since)
+	}
+	if ch.ComputeBlockVersion(ch.LastBlock())&(1<<d.Bit) != 0 {
+		t.Error("Signalling in ACTIVE state")
+	}
+}
+
+func TestVersionBitsTimeout(t *testing.T) {
+	// MTP of the second period's last block is 1700000281, and of the third one's 1700000425
+	d := &Deployment{Name: "test", Bit: 2, StartTime: testStartTime + 100, Timeout: testStartTime + 300}
+	ch := testChain(t, "", &NewChanOpts{Deployments: []*Deployment{d}})
+	defer ch.Close()
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_STARTED)
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_STARTED)
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_FAILED)
+	// signalling does not matter anymore
+	testPeriod(t, ch, d, int(ch.Consensus.Window))
+	testState(t, ch, d, THRESHOLD_FAILED)
+}
+
+func TestVersionBitsMustSignal(t *testing.T) {
+	d := &Deployment{Name: "test", Bit: 3, BIP8: true, StartHeight: 144, TimeoutHeight: 576, LockinOnTimeout: true}
+	ch := testChain(t, "", &NewChanOpts{Deployments: []*Deployment{d}})
+	defer ch.Close()
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_STARTED)
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_STARTED)
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_MUST_SIGNAL)
+	if ch.ComputeBlockVersion(ch.LastBlock())&(1<<d.Bit) == 0 {
+		t.Error("Not signalling in MUST_SIGNAL state")
+	}
+
+	// a block that does not signal is rejected
+	bl := testBlock(ch, ch.LastBlock(), VERSIONBITS_TOP_BITS, nil)
+	ch.BlockIndexAccess.Lock()
+	_, _, e := ch.PreCheckBlock(bl)
+	ch.BlockIndexAccess.Unlock()
+	if e == nil || !strings.Contains(e.Error(), "bad-version-bip8-must-signal") {
+		t.Fatal("Block not signalling in MUST_SIGNAL period accepted:", e)
+	}
+
+	testPeriod(t, ch, d, int(ch.Consensus.Window))
+	testState(t, ch, d, THRESHOLD_LOCKED_IN)
+	// no more mandatory signalling
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_ACTIVE)
+}
+
+func TestVersionBitsMinActivationHeight(t *testing.T) {
+	d := &Deployment{Name: "test", Bit: 4, StartTime: testStartTime + 100, Timeout: VB_NO_TIMEOUT, MinActivationHeight: 600}
+	ch := testChain(t, "", &NewChanOpts{Deployments: []*Deployment{d}})
+	defer ch.Close()
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_STARTED)
+	testPeriod(t, ch, d, int(ch.Consensus.Window))
+	testState(t, ch, d, THRESHOLD_LOCKED_IN)
+	// would be ACTIVE from 432, if not the MinActivationHeight
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_LOCKED_IN)
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_LOCKED_IN)
+	testPeriod(t, ch, d, 0)
+	testState(t, ch, d, THRESHOLD_ACTIVE)
+	if since := ch.DeploymentSince(d, ch.LastBlock()); since != 720 {
+		t.Error("ACTIVE since", since)
+	}
+}
+
+func TestVersionBitsCacheEviction(t *testing.T) {
+	d := &Deployment{Name: "test", Bit: 5, StartTime: testStartTime + 100, Timeout: VB_NO_TIMEOUT}
+	ch := testChain(t, "", &NewChanOpts{Deployments: []*Deployment{d}})
+	defer ch.Close()
+	testPeriod(t, ch, d, 0)
+	testPeriod(t, ch, d, 0)
+	end := ch.LastBlock()
+	testState(t, ch, d, THRESHOLD_STARTED)
+
+	// a side branch block, at the same period end
+	bl := testBlock(ch, end.Parent, VERSIONBITS_TOP_BITS|1<<d.Bit, nil)
+	testAccept(t, ch, bl)
+	ch.BlockIndexAccess.Lock()
+	side := ch.BlockIndex[bl.Hash.BIdx()]
+	ch.BlockIndexAccess.Unlock()
+	if side == nil || ch.LastBlock() != end {
+		t.Fatal("Side branch block not stored")
+	}
+	ch.DeploymentState(d, side)
+	if _, ok := d.cache[side]; !ok {
+		t.Fatal("Side branch state not cached")
+	}
+	ch.DeleteBranch(side, nil)
+	if _, ok := d.cache[side]; ok {
+		t.Error("State of deleted block still cached")
+	}
+	if _, ok := d.cache[end]; !ok {
+		t.Error("State of main chain removed")
+	}
+
+	if e := ch.InvalidateBlock(end.BlockHash); e != nil {
+		t.Fatal(e.Error())
+	}
+	if _, ok := d.cache[end]; ok || ch.LastBlock() != end.Parent {
+		t.Error("State of invalidated block still cached")
+	}
+	// the previous period's state stays
+	if _, ok := d.cache[ch.periodEnd(end.Parent)]; !ok {
+		t.Error("State of previous period removed")
+	}
+}
@@ -0,0 +1,51 @@
+package chacha20
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrAuth = errors.New("chacha20poly1305: message authentication failed")
+
+func aeadTag(key *[KeySize]byte, nonce *[NonceSize]byte, aad, ct []byte) []byte {
+	var polykey [BlockSize]byte
+	var pad [TagSize]byte
+	var lens [16]byte
+	Block(key, nonce, 0, &polykey)
+	p := NewPoly1305(polykey[:32])
+	p.Write(aad)
+	if r := len(aad) % TagSize; r != 0 {
+		p.Write(pad[:TagSize-r])
+	}
+	p.Write(ct)
+	if r := len(ct) % TagSize; r != 0 {
+		p.Write(pad[:TagSize-r])
+	}
+	binary.LittleEndian.PutUint64(lens[0:8], uint64(len(aad)))
+	binary.LittleEndian.PutUint64(lens[8:16], uint64(len(ct)))
+	p.Write(lens[:])
+	return p.Sum(nil)
+}
+
+// Seal encrypts and authenticates plain text, appending the result (with the tag) to dst.
+func Seal(dst []byte, key *[KeySize]byte, nonce *[NonceSize]byte, plain, aad []byte) []byte {
+	off := len(dst)
+	dst = append(dst, make([]byte, len(plain))...)
+	NewCipher(key[:], nonce[:], 1).XORKeyStream(dst[off:], plain)
+	return append(dst, aeadTag(key, nonce, aad, dst[off:])...)
+}
+
+// Open authenticates and decrypts cipher text (with the tag at its end), appending the result to dst.
+func Open(dst []byte, key *[KeySize]byte, nonce *[NonceSize]byte, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < TagSize {
+		return nil, ErrAuth
+	}
+	ct := ciphertext[:len(ciphertext)-TagSize]
+	if !Poly1305Verify(aeadTag(key, nonce, aad, ct), ciphertext[len(ct):]) {
+		return nil, ErrAuth
+	}
+	off := len(dst)
+	dst = append(dst, make([]byte, len(ct))...)
+	NewCipher(key[:], nonce[:], 1).XORKeyStream(dst[off:], ct)
+	return dst, nil
+}
@@ -0,0 +1,101 @@
+// Package chacha20 implements ChaCha20 stream cipher, Poly1305 authenticator
+// and ChaCha20-Poly1305 AEAD, as specified in RFC 8439, plus the forward-secure
+// (rekeying) wrappers used by BIP324 encrypted P2P transport.
+package chacha20
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	KeySize   = 32
+	NonceSize = 12
+	BlockSize = 64
+)
+
+// Block computes one 64 bytes block of ChaCha20 keystream.
+func Block(key *[KeySize]byte, nonce *[NonceSize]byte, counter uint32, out *[BlockSize]byte) {
+	var s, x [16]uint32
+	s[0], s[1], s[2], s[3] = 0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	for i := 0; i < 8; i++ {
+		s[4+i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	s[12] = counter
+	s[13] = binary.LittleEndian.Uint32(nonce[0:])
+	s[14] = binary.LittleEndian.Uint32(nonce[4:])
+	s[15] = binary.LittleEndian.Uint32(nonce[8:])
+	x = s
+	for i := 0; i < 10; i++ {
+		quarterRound(&x, 0, 4, 8, 12)
+		quarterRound(&x, 1, 5, 9, 13)
+		quarterRound(&x, 2, 6, 10, 14)
+		quarterRound(&x, 3, 7, 11, 15)
+		quarterRound(&x, 0, 5, 10, 15)
+		quarterRound(&x, 1, 6, 11, 12)
+		quarterRound(&x, 2, 7, 8, 13)
+		quarterRound(&x, 3, 4, 9, 14)
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[4*i:], x[i]+s[i])
+	}
+}
+
+func quarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+}
+
+// Cipher is a ChaCha20 keystream generator that can be used across many calls.
+type Cipher struct {
+	key     [KeySize]byte
+	nonce   [NonceSize]byte
+	counter uint32
+	buf     [BlockSize]byte
+	left    int // unused bytes at the end of buf
+}
+
+// NewCipher returns ChaCha20 cipher set at the given block counter.
+func NewCipher(key []byte, nonce []byte, counter uint32) (c *Cipher) {
+	c = new(Cipher)
+	copy(c.key[:], key)
+	copy(c.nonce[:], nonce)
+	c.counter = counter
+	return
+}
+
+// XORKeyStream xors src with the keystream and puts the result in dst.
+// The keystream continues across subsequent calls.
+func (c *Cipher) XORKeyStream(dst, src []byte) {
+	for len(src) > 0 {
+		if c.left == 0 {
+			Block(&c.key, &c.nonce, c.counter, &c.buf)
+			c.counter++
+			c.left = BlockSize
+		}
+		ks := c.buf[BlockSize-c.left:]
+		n := len(src)
+		if n > len(ks) {
+			n = len(ks)
+		}
+		for i := 0; i < n; i++ {
+			dst[i] = src[i] ^ ks[i]
+		}
+		c.left -= n
+		src, dst = src[n:], dst[n:]
+	}
+}
+
+// Keystream fills the buffer with the next keystream bytes.
+func (c *Cipher) Keystream(out []byte) {
+	for i := range out {
+		out[i] = 0
+	}
+	c.XORKeyStream(out, out)
+}
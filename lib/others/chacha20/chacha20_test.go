@@ -0,0 +1,137 @@
+package chacha20
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// Test vectors from RFC 8439
+
+func TestBlock(t *testing.T) {
+	var key [KeySize]byte
+	var nonce [NonceSize]byte
+	var out [BlockSize]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	copy(nonce[:], unhex("000000090000004a00000000"))
+	Block(&key, &nonce, 1, &out)
+	if !bytes.Equal(out[:16], unhex("10f1e7e4d13b5915500fdd1fa32071c4")) {
+		t.Error("Block mismatch", hex.EncodeToString(out[:]))
+	}
+}
+
+func TestPoly1305(t *testing.T) {
+	p := NewPoly1305(unhex("85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b"))
+	p.Write([]byte("Cryptographic Forum "))
+	p.Write([]byte("Research Group"))
+	if !bytes.Equal(p.Sum(nil), unhex("a8061dc1305136c6c22b8baf0c0127a9")) {
+		t.Error("Poly1305 mismatch")
+	}
+}
+
+func TestAEAD(t *testing.T) {
+	var key [KeySize]byte
+	var nonce [NonceSize]byte
+	copy(key[:], unhex("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f"))
+	copy(nonce[:], unhex("070000004041424344454647"))
+	aad := unhex("50515253c0c1c2c3c4c5c6c7")
+	plain := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	ct := Seal(nil, &key, &nonce, plain, aad)
+	if !bytes.Equal(ct[len(ct)-TagSize:], unhex("1ae10b594f09e26a7e902ecbd0600691")) {
+		t.Error("Tag mismatch")
+	}
+	if !bytes.Equal(ct[:8], unhex("d31a8d34648e60db")) {
+		t.Error("Ciphertext mismatch")
+	}
+	res, er := Open(nil, &key, &nonce, ct, aad)
+	if er != nil || !bytes.Equal(res, plain) {
+		t.Error("Open failed")
+	}
+	ct[0] ^= 1
+	if _, er = Open(nil, &key, &nonce, ct, aad); er != ErrAuth {
+		t.Error("Open should have failed")
+	}
+}
+
+func TestFSChaCha20Poly1305(t *testing.T) {
+	key := unhex("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	enc := NewFSChaCha20Poly1305(key)
+	dec := NewFSChaCha20Poly1305(key)
+	lenc := NewFSChaCha20(key)
+	ldec := NewFSChaCha20(key)
+	for i := 0; i < 3*RekeyInterval; i++ {
+		msg := bytes.Repeat([]byte{byte(i)}, i%50)
+		var l, ll [3]byte
+		l[0] = byte(len(msg))
+		lenc.Crypt(ll[:], l[:])
+		ldec.Crypt(ll[:], ll[:])
+		if ll != l {
+			t.Fatal("FSChaCha20 mismatch", i)
+		}
+		ct := enc.Seal(nil, msg, []byte{byte(i)})
+		res, er := dec.Open(nil, ct, []byte{byte(i)})
+		if er != nil || !bytes.Equal(res, msg) {
+			t.Fatal("FSChaCha20Poly1305 mismatch", i)
+		}
+	}
+}
+
+// encodeV2Packet does BIP324 packet encryption (as client/network does it).
+func encodeV2Packet(l *FSChaCha20, p *FSChaCha20Poly1305, contents, aad []byte, ignore bool) []byte {
+	var hdr [3]byte
+	hdr[0], hdr[1], hdr[2] = byte(len(contents)), byte(len(contents)>>8), byte(len(contents)>>16)
+	l.Crypt(hdr[:], hdr[:])
+	plain := make([]byte, 1, 1+len(contents))
+	if ignore {
+		plain[0] = 0x80
+	}
+	return p.Seal(hdr[:], append(plain, contents...), aad)
+}
+
+func TestBIP324PacketVectors(t *testing.T) {
+	f, er := os.Open("../../test/bip324_packet_encoding_test_vectors.csv")
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	tas, er := csv.NewReader(f).ReadAll()
+	f.Close()
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	for i := range tas {
+		if i == 0 {
+			continue // skip column names
+		}
+		v := tas[i]
+		var l *FSChaCha20
+		var p *FSChaCha20Poly1305
+		if v[4] == "1" { // initiating
+			l, p = NewFSChaCha20(unhex(v[13])), NewFSChaCha20Poly1305(unhex(v[14]))
+		} else {
+			l, p = NewFSChaCha20(unhex(v[15])), NewFSChaCha20Poly1305(unhex(v[16]))
+		}
+		idx, _ := strconv.Atoi(v[0])
+		for j := 0; j < idx; j++ {
+			encodeV2Packet(l, p, nil, nil, false)
+		}
+		mul, _ := strconv.Atoi(v[6])
+		ct := hex.EncodeToString(encodeV2Packet(l, p, bytes.Repeat(unhex(v[5]), mul), unhex(v[7]), v[8] == "1"))
+		if v[20] != "" && ct != v[20] {
+			t.Error(i, "Ciphertext mismatch", ct)
+		}
+		if v[21] != "" && !strings.HasSuffix(ct, v[21]) {
+			t.Error(i, "Ciphertext ending mismatch")
+		}
+	}
+}
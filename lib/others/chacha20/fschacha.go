@@ -0,0 +1,81 @@
+package chacha20
+
+import (
+	"encoding/binary"
+)
+
+// RekeyInterval is the number of messages after which BIP324 ciphers change their keys.
+const RekeyInterval = 224
+
+// FSChaCha20 is the BIP324 rekeying wrapper around ChaCha20 (used for the length fields).
+type FSChaCha20 struct {
+	c            *Cipher
+	chunkCounter uint32
+	rekeyCounter uint64
+}
+
+func makeNonce(lo uint32, hi uint64) (nonce [NonceSize]byte) {
+	binary.LittleEndian.PutUint32(nonce[0:4], lo)
+	binary.LittleEndian.PutUint64(nonce[4:12], hi)
+	return
+}
+
+func NewFSChaCha20(key []byte) (f *FSChaCha20) {
+	f = new(FSChaCha20)
+	nonce := makeNonce(0, 0)
+	f.c = NewCipher(key, nonce[:], 0)
+	return
+}
+
+// Crypt encrypts (or decrypts) one chunk.
+func (f *FSChaCha20) Crypt(dst, src []byte) {
+	f.c.XORKeyStream(dst, src)
+	if f.chunkCounter++; f.chunkCounter == RekeyInterval {
+		var newkey [KeySize]byte
+		f.c.Keystream(newkey[:])
+		f.chunkCounter = 0
+		f.rekeyCounter++
+		nonce := makeNonce(0, f.rekeyCounter)
+		f.c = NewCipher(newkey[:], nonce[:], 0)
+	}
+}
+
+// FSChaCha20Poly1305 is the BIP324 rekeying wrapper around ChaCha20-Poly1305 AEAD.
+type FSChaCha20Poly1305 struct {
+	key           [KeySize]byte
+	packetCounter uint32
+	rekeyCounter  uint64
+}
+
+func NewFSChaCha20Poly1305(key []byte) (f *FSChaCha20Poly1305) {
+	f = new(FSChaCha20Poly1305)
+	copy(f.key[:], key)
+	return
+}
+
+func (f *FSChaCha20Poly1305) nextPacket() {
+	if f.packetCounter++; f.packetCounter == RekeyInterval {
+		var zero [KeySize]byte
+		nonce := makeNonce(0xffffffff, f.rekeyCounter)
+		newkey := Seal(nil, &f.key, &nonce, zero[:], nil)
+		copy(f.key[:], newkey[:KeySize])
+		f.packetCounter = 0
+		f.rekeyCounter++
+	}
+}
+
+// Seal encrypts the packet and appends the result to dst.
+func (f *FSChaCha20Poly1305) Seal(dst, plain, aad []byte) (res []byte) {
+	nonce := makeNonce(f.packetCounter, f.rekeyCounter)
+	res = Seal(dst, &f.key, &nonce, plain, aad)
+	f.nextPacket()
+	return
+}
+
+// Open decrypts the packet and appends the result to dst.
+func (f *FSChaCha20Poly1305) Open(dst, ciphertext, aad []byte) (res []byte, e error) {
+	nonce := makeNonce(f.packetCounter, f.rekeyCounter)
+	res, e = Open(dst, &f.key, &nonce, ciphertext, aad)
+	f.nextPacket()
+	return
+}
@@ -0,0 +1,128 @@
+package chacha20
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"math/bits"
+)
+
+const TagSize = 16
+
+// Poly1305 is a one-time authenticator (the key must never be reused).
+type Poly1305 struct {
+	h      [3]uint64
+	r      [2]uint64
+	s      [2]uint64
+	buf    [TagSize]byte
+	buflen int
+}
+
+// NewPoly1305 initializes the authenticator with the given 32 bytes key.
+func NewPoly1305(key []byte) (p *Poly1305) {
+	p = new(Poly1305)
+	p.r[0] = binary.LittleEndian.Uint64(key[0:8]) & 0x0FFFFFFC0FFFFFFF
+	p.r[1] = binary.LittleEndian.Uint64(key[8:16]) & 0x0FFFFFFC0FFFFFFC
+	p.s[0] = binary.LittleEndian.Uint64(key[16:24])
+	p.s[1] = binary.LittleEndian.Uint64(key[24:32])
+	return
+}
+
+// block processes one 16 bytes block. hibit is 1 for full blocks.
+func (p *Poly1305) block(m []byte, hibit uint64) {
+	var c uint64
+	h0, h1, h2 := p.h[0], p.h[1], p.h[2]
+	r0, r1 := p.r[0], p.r[1]
+
+	h0, c = bits.Add64(h0, binary.LittleEndian.Uint64(m[0:8]), 0)
+	h1, c = bits.Add64(h1, binary.LittleEndian.Uint64(m[8:16]), c)
+	h2 += c + hibit
+
+	// h * r (h2 is only few bits long and r is clamped, so no overflows here)
+	h0r0hi, h0r0lo := bits.Mul64(h0, r0)
+	h1r0hi, h1r0lo := bits.Mul64(h1, r0)
+	h0r1hi, h0r1lo := bits.Mul64(h0, r1)
+	h1r1hi, h1r1lo := bits.Mul64(h1, r1)
+	h2r0 := h2 * r0
+	h2r1 := h2 * r1
+
+	m1lo, c := bits.Add64(h1r0lo, h0r1lo, 0)
+	m1hi, _ := bits.Add64(h1r0hi, h0r1hi, c)
+	m2lo, c := bits.Add64(h2r0, h1r1lo, 0)
+	m2hi, _ := bits.Add64(0, h1r1hi, c)
+	m3 := h2r1
+
+	t0 := h0r0lo
+	t1, c := bits.Add64(m1lo, h0r0hi, 0)
+	t2, c := bits.Add64(m2lo, m1hi, c)
+	t3, _ := bits.Add64(m3, m2hi, c)
+
+	// reduce modulo 2^130 - 5: h = t mod 2^130 + 5 * (t >> 130)
+	h0, h1, h2 = t0, t1, t2&3
+	cc0, cc1 := t2&^3, t3 // (t >> 130) * 4
+	h0, c = bits.Add64(h0, cc0, 0)
+	h1, c = bits.Add64(h1, cc1, c)
+	h2 += c
+	cc0, cc1 = (cc0>>2)|(cc1<<62), cc1>>2 // (t >> 130)
+	h0, c = bits.Add64(h0, cc0, 0)
+	h1, c = bits.Add64(h1, cc1, c)
+	h2 += c
+
+	p.h[0], p.h[1], p.h[2] = h0, h1, h2
+}
+
+// Write adds more data to be authenticated.
+func (p *Poly1305) Write(m []byte) (int, error) {
+	n := len(m)
+	if p.buflen > 0 {
+		k := copy(p.buf[p.buflen:], m)
+		p.buflen += k
+		m = m[k:]
+		if p.buflen < TagSize {
+			return n, nil
+		}
+		p.block(p.buf[:], 1)
+		p.buflen = 0
+	}
+	for len(m) >= TagSize {
+		p.block(m[:TagSize], 1)
+		m = m[TagSize:]
+	}
+	if len(m) > 0 {
+		p.buflen = copy(p.buf[:], m)
+	}
+	return n, nil
+}
+
+// Sum returns the authentication tag.
+func (p *Poly1305) Sum(out []byte) []byte {
+	var c, b uint64
+	pp := *p
+	if pp.buflen > 0 {
+		var last [TagSize]byte
+		copy(last[:], pp.buf[:pp.buflen])
+		last[pp.buflen] = 1
+		pp.block(last[:], 0)
+	}
+	h0, h1, h2 := pp.h[0], pp.h[1], pp.h[2]
+
+	// h - p = h + 5 - 2^130 (if it does not borrow, use it)
+	t0, b := bits.Sub64(h0, 0xFFFFFFFFFFFFFFFB, 0)
+	t1, b := bits.Sub64(h1, 0xFFFFFFFFFFFFFFFF, b)
+	_, b = bits.Sub64(h2, 3, b)
+	if b == 0 {
+		h0, h1 = t0, t1
+	}
+
+	h0, c = bits.Add64(h0, pp.s[0], 0)
+	h1, _ = bits.Add64(h1, pp.s[1], c)
+
+	var tag [TagSize]byte
+	binary.LittleEndian.PutUint64(tag[0:8], h0)
+	binary.LittleEndian.PutUint64(tag[8:16], h1)
+	return append(out, tag[:]...)
+}
+
+// Poly1305Verify checks the tag in constant time.
+func Poly1305Verify(tag, expected []byte) bool {
+	return subtle.ConstantTimeCompare(tag, expected) == 1
+}
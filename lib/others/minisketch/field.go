@@ -0,0 +1,117 @@
+package minisketch
+
+// Arithmetic in GF(2^32), with the field elements in polynomial basis,
+// modulo x^32 + x^7 + x^3 + x^2 + 1 (the same as libminisketch uses).
+
+const modulus = 0x8D // the modulus bits below x^32
+
+func gfMul(a, b uint32) (r uint32) {
+	for b != 0 {
+		if (b & 1) != 0 {
+			r ^= a
+		}
+		b >>= 1
+		if (a & 0x80000000) != 0 {
+			a = (a << 1) ^ modulus
+		} else {
+			a <<= 1
+		}
+	}
+	return
+}
+
+func gfSqr(a uint32) uint32 {
+	return gfMul(a, a)
+}
+
+// gfInv returns the multiplicative inverse of a (which must not be zero), as a^(2^32-2).
+func gfInv(a uint32) (r uint32) {
+	r = 1
+	for i := 0; i < 31; i++ {
+		a = gfSqr(a)
+		r = gfMul(r, a)
+	}
+	return
+}
+
+// Polynomials over GF(2^32) are kept as slices of coefficients, the lowest degree first.
+
+func polyTrim(p []uint32) []uint32 {
+	for len(p) > 0 && p[len(p)-1] == 0 {
+		p = p[:len(p)-1]
+	}
+	return p
+}
+
+// polyMonic makes the leading coefficient to be 1 (in place).
+func polyMonic(p []uint32) []uint32 {
+	p = polyTrim(p)
+	if len(p) == 0 || p[len(p)-1] == 1 {
+		return p
+	}
+	inv := gfInv(p[len(p)-1])
+	for i := range p {
+		p[i] = gfMul(p[i], inv)
+	}
+	return p
+}
+
+// polyMod returns a mod m, where m must be monic. Modifies a.
+func polyMod(a, m []uint32) []uint32 {
+	a = polyTrim(a)
+	dm := len(m) - 1
+	for len(a) > dm {
+		if lead := a[len(a)-1]; lead != 0 {
+			off := len(a) - 1 - dm
+			for i := 0; i < dm; i++ {
+				a[off+i] ^= gfMul(lead, m[i])
+			}
+		}
+		a = a[:len(a)-1]
+	}
+	return polyTrim(a)
+}
+
+// polyDiv returns a / m (m must be monic and divide a, which is not checked).
+func polyDiv(a, m []uint32) []uint32 {
+	a = append([]uint32{}, a...)
+	dm := len(m) - 1
+	if len(a) <= dm {
+		return nil
+	}
+	q := make([]uint32, len(a)-dm)
+	for len(a) > dm {
+		lead := a[len(a)-1]
+		off := len(a) - 1 - dm
+		q[off] = lead
+		if lead != 0 {
+			for i := 0; i < dm; i++ {
+				a[off+i] ^= gfMul(lead, m[i])
+			}
+		}
+		a = a[:len(a)-1]
+	}
+	return q
+}
+
+// polySqrMod returns a^2 mod m.
+func polySqrMod(a, m []uint32) []uint32 {
+	if len(a) == 0 {
+		return nil
+	}
+	r := make([]uint32, 2*len(a)-1)
+	for i, v := range a {
+		r[2*i] = gfSqr(v) // in characteristic 2 all the cross terms cancel out
+	}
+	return polyMod(r, m)
+}
+
+// polyGcd returns the monic gcd of a and b.
+func polyGcd(a, b []uint32) []uint32 {
+	a = polyMonic(append([]uint32{}, a...))
+	b = polyMonic(append([]uint32{}, b...))
+	for len(b) > 0 {
+		a, b = b, polyMonic(polyMod(a, b))
+	}
+	return a
+}
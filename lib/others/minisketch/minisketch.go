@@ -0,0 +1,211 @@
+// Package minisketch is a pure Go implementation of PinSketch based set reconciliation,
+// compatible with libminisketch for the 32 bit field elements, as used by Erlay (BIP330).
+package minisketch
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const ElementSize = 4 // bytes per serialized syndrome
+
+// Sketch holds the odd power sums (x, x^3, x^5...) of the elements added to it.
+// Adding the same element twice removes it, so merging two sketches gives a sketch of the symmetric difference.
+type Sketch struct {
+	syn []uint32
+}
+
+// New returns an empty sketch that is able to decode up to capacity elements.
+func New(capacity int) *Sketch {
+	return &Sketch{syn: make([]uint32, capacity)}
+}
+
+// Deserialize creates a sketch from bytes produced by Serialize. The capacity is implied by the length.
+func Deserialize(b []byte) (*Sketch, error) {
+	if len(b)%ElementSize != 0 {
+		return nil, errors.New("minisketch: bad length")
+	}
+	s := New(len(b) / ElementSize)
+	for i := range s.syn {
+		s.syn[i] = binary.LittleEndian.Uint32(b[ElementSize*i:])
+	}
+	return s, nil
+}
+
+func (s *Sketch) Capacity() int {
+	return len(s.syn)
+}
+
+// Add adds (or removes, if already there) the element. Zero is not a valid element and it is ignored.
+func (s *Sketch) Add(e uint32) {
+	if e == 0 {
+		return
+	}
+	e2 := gfSqr(e)
+	p := e
+	for i := range s.syn {
+		s.syn[i] ^= p
+		p = gfMul(p, e2)
+	}
+}
+
+// Merge adds all the elements of the other sketch (so common elements are removed).
+// The capacity of the result is the smaller of the two.
+func (s *Sketch) Merge(o *Sketch) {
+	if len(o.syn) < len(s.syn) {
+		s.syn = s.syn[:len(o.syn)]
+	}
+	for i := range s.syn {
+		s.syn[i] ^= o.syn[i]
+	}
+}
+
+func (s *Sketch) Serialize() (b []byte) {
+	b = make([]byte, ElementSize*len(s.syn))
+	for i, v := range s.syn {
+		binary.LittleEndian.PutUint32(b[ElementSize*i:], v)
+	}
+	return
+}
+
+// Decode returns the elements of the sketch, if there is no more of them than max (and the capacity).
+// Returns false if the sketch cannot be decoded (most likely because it has too many elements).
+func (s *Sketch) Decode(max int) (res []uint32, ok bool) {
+	if max > len(s.syn) {
+		max = len(s.syn)
+	}
+	// recover the even power sums: s[2k] = s[k]^2
+	all := make([]uint32, 2*len(s.syn))
+	for i := range all {
+		if (i & 1) == 0 {
+			all[i] = s.syn[i/2]
+		} else {
+			all[i] = gfSqr(all[i/2])
+		}
+	}
+
+	poly := berlekampMassey(all, max)
+	if poly == nil {
+		return
+	}
+	if len(poly) == 1 {
+		return nil, true // empty set
+	}
+
+	// reverse the connection polynomial, so its roots are the elements themselves (not their inverses)
+	for i, j := 0, len(poly)-1; i < j; i, j = i+1, j-1 {
+		poly[i], poly[j] = poly[j], poly[i]
+	}
+	res, ok = findRoots(polyMonic(poly))
+	if ok && len(res) != len(poly)-1 {
+		res, ok = nil, false
+	}
+	return
+}
+
+// berlekampMassey returns the shortest linear recurrence generating the sequence,
+// as a connection polynomial with the constant term of 1. Returns nil if its degree exceeds max.
+func berlekampMassey(seq []uint32, max int) []uint32 {
+	c := []uint32{1}
+	b := []uint32{1}
+	bb := uint32(1)
+	var l int
+	m := 1
+	for n := range seq {
+		d := seq[n]
+		for i := 1; i <= l && i < len(c); i++ {
+			d ^= gfMul(c[i], seq[n-i])
+		}
+		if d == 0 {
+			m++
+			continue
+		}
+		coef := gfMul(d, gfInv(bb))
+		nc := make([]uint32, max2(len(c), len(b)+m))
+		copy(nc, c)
+		for i, v := range b {
+			nc[i+m] ^= gfMul(coef, v)
+		}
+		if 2*l <= n {
+			if n+1-l > max {
+				return nil
+			}
+			l = n + 1 - l
+			b = c
+			bb = d
+			m = 1
+		} else {
+			m++
+		}
+		c = nc
+	}
+	for len(c) < l+1 {
+		c = append(c, 0)
+	}
+	c = c[:l+1]
+	if c[l] == 0 {
+		return nil // zero would be a root, which is not a valid element
+	}
+	return c
+}
+
+func max2(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// findRoots returns the roots of the monic polynomial f, as long as it splits into distinct linear factors.
+func findRoots(f []uint32) (roots []uint32, ok bool) {
+	if len(f) <= 1 {
+		return nil, true
+	}
+	// all the roots are distinct and in the field if, and only if, x^(2^32) == x (mod f)
+	t := polyMod([]uint32{0, 1}, f)
+	x := append([]uint32{}, t...)
+	for i := 0; i < 32; i++ {
+		t = polySqrMod(t, f)
+	}
+	if len(t) != len(x) {
+		return
+	}
+	for i := range t {
+		if t[i] != x[i] {
+			return
+		}
+	}
+	roots = make([]uint32, 0, len(f)-1)
+	ok = splitRoots(f, 0, &roots)
+	return
+}
+
+// splitRoots uses Berlekamp trace algorithm, with the polynomial basis elements as the betas.
+// Once a beta splits the polynomial, it will not split any of its factors, so the recursion starts from the next one.
+func splitRoots(f []uint32, bi int, roots *[]uint32) bool {
+	switch len(f) {
+	case 1:
+		return true
+	case 2:
+		*roots = append(*roots, f[0]) // x + a has the root a
+		return true
+	}
+	for ; bi < 32; bi++ {
+		// tr = Tr(beta*x) mod f
+		u := polyMod([]uint32{0, uint32(1) << bi}, f)
+		tr := append([]uint32{}, u...)
+		for i := 1; i < 32; i++ {
+			u = polySqrMod(u, f)
+			for len(tr) < len(u) {
+				tr = append(tr, 0)
+			}
+			for j, v := range u {
+				tr[j] ^= v
+			}
+		}
+		if g := polyGcd(f, tr); len(g) > 1 && len(g) < len(f) {
+			return splitRoots(g, bi+1, roots) && splitRoots(polyDiv(f, g), bi+1, roots)
+		}
+	}
+	return false
+}
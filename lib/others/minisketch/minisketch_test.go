@@ -0,0 +1,125 @@
+package minisketch
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestField(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		a := r.Uint32() | 1
+		if gfMul(a, gfInv(a)) != 1 {
+			t.Fatal("Bad inverse of", a)
+		}
+		b, c := r.Uint32(), r.Uint32()
+		if gfMul(a, b^c) != gfMul(a, b)^gfMul(a, c) {
+			t.Fatal("Not distributive", a, b, c)
+		}
+	}
+	if gfMul(0x80000000, 2) != modulus {
+		t.Error("Bad reduction")
+	}
+}
+
+func randSet(r *rand.Rand, n int) (res []uint32) {
+	m := make(map[uint32]bool)
+	for len(res) < n {
+		if v := r.Uint32(); v != 0 && !m[v] {
+			m[v] = true
+			res = append(res, v)
+		}
+	}
+	return
+}
+
+func TestDecode(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, capacity := range []int{1, 2, 5, 20, 64} {
+		for n := 0; n <= capacity; n++ {
+			set := randSet(r, n)
+			s := New(capacity)
+			for _, v := range set {
+				s.Add(v)
+			}
+			res, ok := s.Decode(capacity)
+			if !ok {
+				t.Fatal("Decode failed", capacity, n)
+			}
+			slices.Sort(set)
+			slices.Sort(res)
+			if !slices.Equal(set, res) {
+				t.Fatal("Decoded wrong set", capacity, n, set, res)
+			}
+		}
+	}
+}
+
+func TestOverCapacity(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 50; i++ {
+		s := New(10)
+		for _, v := range randSet(r, 11+r.Intn(20)) {
+			s.Add(v)
+		}
+		if _, ok := s.Decode(10); ok {
+			t.Fatal("Decode should fail")
+		}
+	}
+	s := New(10)
+	for _, v := range randSet(r, 8) {
+		s.Add(v)
+	}
+	if _, ok := s.Decode(7); ok {
+		t.Error("Decode should fail with max below the set size")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	common := randSet(r, 1000)
+	only_a := randSet(r, 7)
+	only_b := randSet(r, 5)
+
+	a, b := New(16), New(16)
+	for _, v := range common {
+		a.Add(v)
+		b.Add(v)
+	}
+	for _, v := range only_a {
+		a.Add(v)
+	}
+	for _, v := range only_b {
+		b.Add(v)
+	}
+
+	b, e := Deserialize(b.Serialize())
+	if e != nil {
+		t.Fatal(e)
+	}
+	a.Merge(b)
+	diff, ok := a.Decode(16)
+	if !ok {
+		t.Fatal("Decode failed")
+	}
+	exp := append(append([]uint32{}, only_a...), only_b...)
+	slices.Sort(exp)
+	slices.Sort(diff)
+	if !slices.Equal(exp, diff) {
+		t.Error("Bad difference", exp, diff)
+	}
+
+	// adding an element twice removes it
+	s := New(4)
+	s.Add(123)
+	s.Add(456)
+	s.Add(123)
+	if res, ok := s.Decode(4); !ok || len(res) != 1 || res[0] != 456 {
+		t.Error("Bad result after removal", res, ok)
+	}
+
+	if _, e = Deserialize([]byte{1, 2, 3}); e == nil {
+		t.Error("Error expected for bad length")
+	}
+}
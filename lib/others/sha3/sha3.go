@@ -0,0 +1,121 @@
+// Package sha3 implements SHA3-256 hash function (FIPS 202),
+// as needed for the checksum of TorV3 onion addresses.
+package sha3
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+const (
+	Size256 = 32
+	rate256 = 136 // 1600 bits of the state, minus two times the output size
+)
+
+var rc = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808a, 0x8000000080008000,
+	0x000000000000808b, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008a, 0x0000000000000088, 0x0000000080008009, 0x000000008000000a,
+	0x000000008000808b, 0x800000000000008b, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800a, 0x800000008000000a,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+var rotc = [24]int{1, 3, 6, 10, 15, 21, 28, 36, 45, 55, 2, 14, 27, 41, 56, 8, 25, 43, 62, 18, 39, 61, 20, 44}
+
+var piln = [24]int{10, 7, 11, 17, 18, 3, 5, 16, 8, 21, 24, 4, 15, 23, 19, 13, 12, 2, 20, 14, 22, 9, 6, 1}
+
+// keccakF1600 applies the Keccak permutation to the state.
+func keccakF1600(a *[25]uint64) {
+	var bc [5]uint64
+	for round := 0; round < 24; round++ {
+		// theta
+		for i := 0; i < 5; i++ {
+			bc[i] = a[i] ^ a[i+5] ^ a[i+10] ^ a[i+15] ^ a[i+20]
+		}
+		for i := 0; i < 5; i++ {
+			t := bc[(i+4)%5] ^ bits.RotateLeft64(bc[(i+1)%5], 1)
+			for j := 0; j < 25; j += 5 {
+				a[j+i] ^= t
+			}
+		}
+		// rho and pi
+		t := a[1]
+		for i := 0; i < 24; i++ {
+			j := piln[i]
+			t, a[j] = a[j], bits.RotateLeft64(t, rotc[i])
+		}
+		// chi
+		for j := 0; j < 25; j += 5 {
+			copy(bc[:], a[j:j+5])
+			for i := 0; i < 5; i++ {
+				a[j+i] ^= ^bc[(i+1)%5] & bc[(i+2)%5]
+			}
+		}
+		// iota
+		a[0] ^= rc[round]
+	}
+}
+
+type digest struct {
+	a   [25]uint64
+	buf [rate256]byte
+	n   int // bytes in buf
+}
+
+// New256 returns a new hash.Hash computing SHA3-256 checksum.
+func New256() hash.Hash {
+	return new(digest)
+}
+
+func (d *digest) Size() int      { return Size256 }
+func (d *digest) BlockSize() int { return rate256 }
+
+func (d *digest) Reset() {
+	*d = digest{}
+}
+
+func (d *digest) absorb() {
+	for i := 0; i < rate256/8; i++ {
+		d.a[i] ^= binary.LittleEndian.Uint64(d.buf[8*i:])
+	}
+	keccakF1600(&d.a)
+	d.n = 0
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	l := len(p)
+	for len(p) > 0 {
+		c := copy(d.buf[d.n:], p)
+		d.n += c
+		p = p[c:]
+		if d.n == rate256 {
+			d.absorb()
+		}
+	}
+	return l, nil
+}
+
+func (d *digest) Sum(in []byte) []byte {
+	dd := *d // so the caller can keep writing
+	for i := dd.n; i < rate256; i++ {
+		dd.buf[i] = 0
+	}
+	dd.buf[dd.n] ^= 0x06 // SHA3 domain separation and the first bit of the padding
+	dd.buf[rate256-1] ^= 0x80
+	dd.absorb()
+	var out [Size256]byte
+	for i := 0; i < Size256/8; i++ {
+		binary.LittleEndian.PutUint64(out[8*i:], dd.a[i])
+	}
+	return append(in, out[:]...)
+}
+
+// Sum256 returns SHA3-256 checksum of the data.
+func Sum256(data []byte) (res [Size256]byte) {
+	d := new(digest)
+	d.Write(data)
+	copy(res[:], d.Sum(nil))
+	return
+}
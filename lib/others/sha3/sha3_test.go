@@ -0,0 +1,39 @@
+package sha3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors from NIST (FIPS 202 examples)
+
+func TestSum256(t *testing.T) {
+	tests := []struct {
+		in  []byte
+		out string
+	}{
+		{nil, "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a"},
+		{[]byte("abc"), "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+		{[]byte("abcdbcdecdefdefgefghfghighijhijkijkljklmklmnlmnomnopnopq"), "41c0dba2a9d6240849100376a8235e2c82e1b9998a999e21db32dd97496d3376"},
+		{bytes.Repeat([]byte{0xa3}, 200), "79f38adec5c20307a98ef76e8324afbfd46cfd81b22e3973c65fa1bd9de31787"},
+	}
+	for _, tt := range tests {
+		res := Sum256(tt.in)
+		if hex.EncodeToString(res[:]) != tt.out {
+			t.Error("Sum256 mismatch", len(tt.in), hex.EncodeToString(res[:]))
+		}
+		// the same, written in pieces
+		h := New256()
+		for i := 0; i < len(tt.in); i += 7 {
+			end := i + 7
+			if end > len(tt.in) {
+				end = len(tt.in)
+			}
+			h.Write(tt.in[i:end])
+		}
+		if hex.EncodeToString(h.Sum(nil)) != tt.out {
+			t.Error("New256 mismatch", len(tt.in))
+		}
+	}
+}
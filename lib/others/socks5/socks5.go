@@ -0,0 +1,188 @@
+// Package socks5 implements a simple SOCKS5 client (RFC 1928), with the optional
+// username/password authentication (RFC 1929), as used by Tor for stream isolation.
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	CMD_CONNECT = 0x01
+	CMD_RESOLVE = 0xF0 // Tor extension
+
+	ATYP_IPV4   = 0x01
+	ATYP_DOMAIN = 0x03
+	ATYP_IPV6   = 0x04
+
+	AUTH_NONE     = 0x00
+	AUTH_PASSWORD = 0x02
+	AUTH_NO_MATCH = 0xFF
+)
+
+var replyErrors = []string{"succeeded", "general SOCKS server failure", "connection not allowed by ruleset",
+	"network unreachable", "host unreachable", "connection refused", "TTL expired",
+	"command not supported", "address type not supported"}
+
+// Auth holds the credentials. Tor uses different circuits for different credentials.
+type Auth struct {
+	User, Pass string
+}
+
+func handshake(conn net.Conn, auth *Auth) (e error) {
+	var buf [2]byte
+	if auth != nil {
+		_, e = conn.Write([]byte{5, 2, AUTH_NONE, AUTH_PASSWORD})
+	} else {
+		_, e = conn.Write([]byte{5, 1, AUTH_NONE})
+	}
+	if e != nil {
+		return
+	}
+	if _, e = io.ReadFull(conn, buf[:]); e != nil {
+		return
+	}
+	if buf[0] != 5 {
+		return errors.New("not a SOCKS5 proxy")
+	}
+	switch buf[1] {
+	case AUTH_NONE:
+		return
+	case AUTH_PASSWORD:
+		if auth == nil || len(auth.User) == 0 || len(auth.User) > 255 || len(auth.Pass) > 255 {
+			return errors.New("SOCKS5 proxy requires a valid username/password")
+		}
+		req := make([]byte, 0, 3+len(auth.User)+len(auth.Pass))
+		req = append(req, 1, byte(len(auth.User)))
+		req = append(req, auth.User...)
+		req = append(req, byte(len(auth.Pass)))
+		req = append(req, auth.Pass...)
+		if _, e = conn.Write(req); e != nil {
+			return
+		}
+		if _, e = io.ReadFull(conn, buf[:]); e != nil {
+			return
+		}
+		if buf[1] != 0 {
+			return errors.New("SOCKS5 proxy authentication failed")
+		}
+		return
+	}
+	return errors.New("SOCKS5 proxy refused the authentication method")
+}
+
+// request sends the command and returns the bound address from the reply.
+func request(conn net.Conn, cmd byte, host string, port uint16) (ip net.IP, e error) {
+	if len(host) > 255 {
+		return nil, errors.New("host name too long")
+	}
+	req := make([]byte, 0, 7+len(host))
+	req = append(req, 5, cmd, 0)
+	if ip4 := net.ParseIP(host).To4(); ip4 != nil {
+		req = append(req, ATYP_IPV4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, ATYP_DOMAIN, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, e = conn.Write(req); e != nil {
+		return
+	}
+
+	var hdr [4]byte
+	if _, e = io.ReadFull(conn, hdr[:]); e != nil {
+		return
+	}
+	if hdr[0] != 5 {
+		return nil, errors.New("not a SOCKS5 proxy")
+	}
+	if hdr[1] != 0 {
+		if int(hdr[1]) < len(replyErrors) {
+			return nil, errors.New("SOCKS5: " + replyErrors[hdr[1]])
+		}
+		return nil, errors.New("SOCKS5: error " + strconv.Itoa(int(hdr[1])))
+	}
+	var alen int
+	switch hdr[3] {
+	case ATYP_IPV4:
+		alen = 4
+	case ATYP_IPV6:
+		alen = 16
+	case ATYP_DOMAIN:
+		if _, e = io.ReadFull(conn, hdr[:1]); e != nil {
+			return
+		}
+		alen = int(hdr[0])
+	default:
+		return nil, errors.New("SOCKS5: unknown address type in reply")
+	}
+	addr := make([]byte, alen+2) // address and port
+	if _, e = io.ReadFull(conn, addr); e != nil {
+		return
+	}
+	if alen == 4 || alen == 16 {
+		ip = net.IP(addr[:alen])
+	}
+	return
+}
+
+func splitHostPort(dest string) (host string, port uint16, e error) {
+	var ps string
+	var p uint64
+	if host, ps, e = net.SplitHostPort(dest); e != nil {
+		return
+	}
+	if p, e = strconv.ParseUint(ps, 10, 16); e != nil {
+		return
+	}
+	port = uint16(p)
+	return
+}
+
+// Dial connects to dest (host:port) via the SOCKS5 proxy.
+// Host names are resolved by the proxy. Pass nil auth for no authentication.
+func Dial(proxy, dest string, auth *Auth, timeout time.Duration) (conn net.Conn, e error) {
+	host, port, e := splitHostPort(dest)
+	if e != nil {
+		return
+	}
+	if conn, e = net.DialTimeout("tcp", proxy, timeout); e != nil {
+		return
+	}
+	if timeout != 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if e = handshake(conn, auth); e == nil {
+		_, e = request(conn, CMD_CONNECT, host, port)
+	}
+	if e != nil {
+		conn.Close()
+		return nil, e
+	}
+	conn.SetDeadline(time.Time{})
+	return
+}
+
+// Resolve uses Tor's RESOLVE extension to get IP address of the host, without local DNS queries.
+func Resolve(proxy, host string, auth *Auth, timeout time.Duration) (ip net.IP, e error) {
+	var conn net.Conn
+	if conn, e = net.DialTimeout("tcp", proxy, timeout); e != nil {
+		return
+	}
+	defer conn.Close()
+	if timeout != 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if e = handshake(conn, auth); e != nil {
+		return
+	}
+	if ip, e = request(conn, CMD_RESOLVE, host, 0); e == nil && ip == nil {
+		e = errors.New("SOCKS5: no IP address in reply")
+	}
+	return
+}
@@ -0,0 +1,177 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// stand-in SOCKS5 server: connects everything to target, resolves everything to 1.2.3.4
+type testProxy struct {
+	lis    net.Listener
+	target string
+	user   string // if set, require this username
+	hosts  chan string
+	users  chan string
+}
+
+func (p *testProxy) serve(c net.Conn) {
+	defer c.Close()
+	var buf [262]byte
+	if _, e := io.ReadFull(c, buf[:2]); e != nil || buf[0] != 5 {
+		return
+	}
+	methods := make([]byte, buf[1])
+	io.ReadFull(c, methods)
+	if p.user != "" {
+		if bytes.IndexByte(methods, AUTH_PASSWORD) < 0 {
+			c.Write([]byte{5, AUTH_NO_MATCH})
+			return
+		}
+		c.Write([]byte{5, AUTH_PASSWORD})
+		io.ReadFull(c, buf[:2])
+		user := make([]byte, buf[1])
+		io.ReadFull(c, user)
+		io.ReadFull(c, buf[:1])
+		io.ReadFull(c, make([]byte, buf[0]))
+		p.users <- string(user)
+		if string(user) != p.user {
+			c.Write([]byte{1, 1})
+			return
+		}
+		c.Write([]byte{1, 0})
+	} else {
+		c.Write([]byte{5, AUTH_NONE})
+	}
+
+	io.ReadFull(c, buf[:4])
+	cmd := buf[1]
+	var host string
+	switch buf[3] {
+	case ATYP_IPV4:
+		io.ReadFull(c, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case ATYP_DOMAIN:
+		io.ReadFull(c, buf[:1])
+		n := int(buf[0])
+		io.ReadFull(c, buf[:n])
+		host = string(buf[:n])
+	}
+	io.ReadFull(c, buf[:2])
+	p.hosts <- host + ":" + strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2])))
+
+	if cmd == CMD_RESOLVE {
+		c.Write([]byte{5, 0, 0, ATYP_IPV4, 1, 2, 3, 4, 0, 0})
+		return
+	}
+	if host == "refused.onion" {
+		c.Write([]byte{5, 5, 0, ATYP_IPV4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	t, e := net.Dial("tcp", p.target)
+	if e != nil {
+		c.Write([]byte{5, 4, 0, ATYP_IPV4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer t.Close()
+	c.Write([]byte{5, 0, 0, ATYP_DOMAIN, 3, 'x', 'y', 'z', 0, 1})
+	go io.Copy(t, c)
+	io.Copy(c, t)
+}
+
+func newTestProxy(t *testing.T, user string) (p *testProxy) {
+	p = &testProxy{user: user, hosts: make(chan string, 10), users: make(chan string, 10)}
+	var e error
+	if p.lis, e = net.Listen("tcp", "127.0.0.1:0"); e != nil {
+		t.Fatal(e)
+	}
+	echo, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	p.target = echo.Addr().String()
+	go func() {
+		for {
+			c, e := echo.Accept()
+			if e != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	go func() {
+		for {
+			c, e := p.lis.Accept()
+			if e != nil {
+				return
+			}
+			go p.serve(c)
+		}
+	}()
+	return
+}
+
+func TestDial(t *testing.T) {
+	p := newTestProxy(t, "")
+	conn, e := Dial(p.lis.Addr().String(), "abcdef.onion:8333", nil, time.Second)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if h := <-p.hosts; h != "abcdef.onion:8333" {
+		t.Error("Bad host at proxy", h)
+	}
+	conn.Write([]byte("hello"))
+	var buf [5]byte
+	if _, e = io.ReadFull(conn, buf[:]); e != nil || string(buf[:]) != "hello" {
+		t.Error("Echo failed", e)
+	}
+	conn.Close()
+
+	if _, e = Dial(p.lis.Addr().String(), "1.2.3.4:18333", nil, time.Second); e != nil {
+		t.Error(e)
+	}
+	if h := <-p.hosts; h != "1.2.3.4:18333" {
+		t.Error("Bad host at proxy", h)
+	}
+
+	if _, e = Dial(p.lis.Addr().String(), "refused.onion:8333", nil, time.Second); e == nil {
+		t.Error("Error expected")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	p := newTestProxy(t, "good")
+	if _, e := Dial(p.lis.Addr().String(), "x.onion:1", nil, time.Second); e == nil {
+		t.Error("Error expected without auth")
+	}
+	if _, e := Dial(p.lis.Addr().String(), "x.onion:1", &Auth{User: "bad", Pass: "x"}, time.Second); e == nil {
+		t.Error("Error expected with bad auth")
+	}
+	<-p.users
+	conn, e := Dial(p.lis.Addr().String(), "x.onion:1", &Auth{User: "good", Pass: "x"}, time.Second)
+	if e != nil {
+		t.Fatal(e)
+	}
+	conn.Close()
+}
+
+func TestResolve(t *testing.T) {
+	p := newTestProxy(t, "")
+	ip, e := Resolve(p.lis.Addr().String(), "seed.bitcoin.sipa.be", nil, time.Second)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !ip.Equal(net.IPv4(1, 2, 3, 4)) {
+		t.Error("Bad IP", ip.String())
+	}
+	if h := <-p.hosts; h != "seed.bitcoin.sipa.be:0" {
+		t.Error("Bad host at proxy", h)
+	}
+}
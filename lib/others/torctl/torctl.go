@@ -0,0 +1,214 @@
+// Package torctl implements a minimal client of Tor's control protocol,
+// which is enough to authenticate and publish an onion service (ADD_ONION).
+package torctl
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SAFECOOKIE_SERVER_KEY = "Tor safe cookie authentication server-to-controller hash"
+	SAFECOOKIE_CLIENT_KEY = "Tor safe cookie authentication controller-to-server hash"
+)
+
+type Conn struct {
+	net.Conn
+	rd *bufio.Reader
+}
+
+// Dial connects to Tor's control port.
+func Dial(addr string, timeout time.Duration) (t *Conn, e error) {
+	var c net.Conn
+	if c, e = net.DialTimeout("tcp", addr, timeout); e != nil {
+		return
+	}
+	t = &Conn{Conn: c, rd: bufio.NewReader(c)}
+	return
+}
+
+// ReadReply reads one (possibly multi-line) reply. Returns the status code and the lines, without the code.
+func (t *Conn) ReadReply() (code int, lines []string, e error) {
+	for {
+		var l string
+		if l, e = t.rd.ReadString('\n'); e != nil {
+			return
+		}
+		l = strings.TrimRight(l, "\r\n")
+		if len(l) < 4 {
+			e = errors.New("torctl: reply line too short")
+			return
+		}
+		if code, e = strconv.Atoi(l[:3]); e != nil {
+			return
+		}
+		lines = append(lines, l[4:])
+		switch l[3] {
+		case ' ':
+			return
+		case '+': // data follows, until a single dot
+			for {
+				var d string
+				if d, e = t.rd.ReadString('\n'); e != nil {
+					return
+				}
+				if d = strings.TrimRight(d, "\r\n"); d == "." {
+					break
+				}
+				lines[len(lines)-1] += "\n" + strings.TrimPrefix(d, ".")
+			}
+		case '-':
+		default:
+			e = errors.New("torctl: bad reply line")
+			return
+		}
+	}
+}
+
+// Cmd sends the command and returns the lines of its reply. Any status other than 250 is an error.
+func (t *Conn) Cmd(cmd string) (lines []string, e error) {
+	if _, e = t.Write([]byte(cmd + "\r\n")); e != nil {
+		return
+	}
+	var code int
+	if code, lines, e = t.ReadReply(); e == nil && code != 250 {
+		e = errors.New("torctl: " + strconv.Itoa(code) + " " + strings.Join(lines, " "))
+	}
+	return
+}
+
+// parseKeyVals parses space separated KEY=VALUE pairs. Values may be quoted.
+func parseKeyVals(s string) (res map[string]string) {
+	res = make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := s[:eq]
+		s = s[eq+1:]
+		var val string
+		if len(s) > 0 && s[0] == '"' {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(s) {
+				i = len(s) - 1
+			}
+			if uq, er := strconv.Unquote(s[:i+1]); er == nil {
+				val = uq
+			} else {
+				val = s[1:i]
+			}
+			s = s[i+1:]
+		} else if sp := strings.IndexByte(s, ' '); sp >= 0 {
+			val, s = s[:sp], s[sp:]
+		} else {
+			val, s = s, ""
+		}
+		res[key] = val
+	}
+	return
+}
+
+// Authenticate checks PROTOCOLINFO and uses the best available authentication method.
+// The password is only used if the method HASHEDPASSWORD is enabled in Tor.
+func (t *Conn) Authenticate(password string) (e error) {
+	var lines []string
+	if lines, e = t.Cmd("PROTOCOLINFO 1"); e != nil {
+		return
+	}
+	var methods, cookiefile string
+	for _, l := range lines {
+		if strings.HasPrefix(l, "AUTH ") {
+			kv := parseKeyVals(l[5:])
+			methods = "," + kv["METHODS"] + ","
+			cookiefile = kv["COOKIEFILE"]
+		}
+	}
+	switch {
+	case password != "" && strings.Contains(methods, ",HASHEDPASSWORD,"):
+		_, e = t.Cmd("AUTHENTICATE " + strconv.Quote(password))
+	case strings.Contains(methods, ",NULL,"):
+		_, e = t.Cmd("AUTHENTICATE")
+	case strings.Contains(methods, ",SAFECOOKIE,"):
+		e = t.authSafeCookie(cookiefile)
+	case strings.Contains(methods, ",COOKIE,"):
+		var cookie []byte
+		if cookie, e = os.ReadFile(cookiefile); e == nil {
+			_, e = t.Cmd("AUTHENTICATE " + hex.EncodeToString(cookie))
+		}
+	case strings.Contains(methods, ",HASHEDPASSWORD,"):
+		e = errors.New("torctl: Tor control port requires a password")
+	default:
+		e = errors.New("torctl: no supported authentication method in" + methods)
+	}
+	return
+}
+
+func (t *Conn) authSafeCookie(cookiefile string) (e error) {
+	var cookie []byte
+	if cookie, e = os.ReadFile(cookiefile); e != nil {
+		return
+	}
+	var client_nonce [32]byte
+	rand.Read(client_nonce[:])
+	var lines []string
+	if lines, e = t.Cmd("AUTHCHALLENGE SAFECOOKIE " + hex.EncodeToString(client_nonce[:])); e != nil {
+		return
+	}
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "AUTHCHALLENGE ") {
+		return errors.New("torctl: unexpected AUTHCHALLENGE reply")
+	}
+	kv := parseKeyVals(lines[0][14:])
+	server_hash, _ := hex.DecodeString(kv["SERVERHASH"])
+	server_nonce, _ := hex.DecodeString(kv["SERVERNONCE"])
+	msg := append(append(append([]byte{}, cookie...), client_nonce[:]...), server_nonce...)
+
+	h := hmac.New(sha256.New, []byte(SAFECOOKIE_SERVER_KEY))
+	h.Write(msg)
+	if !hmac.Equal(h.Sum(nil), server_hash) {
+		return errors.New("torctl: SAFECOOKIE server hash mismatch")
+	}
+	h = hmac.New(sha256.New, []byte(SAFECOOKIE_CLIENT_KEY))
+	h.Write(msg)
+	_, e = t.Cmd("AUTHENTICATE " + hex.EncodeToString(h.Sum(nil)))
+	return
+}
+
+// AddOnion publishes an onion service that forwards virtport to target (host:port).
+// Pass empty key to create a new one - it is then returned in newkey, in format "ED25519-V3:base64".
+// The service is removed by Tor when this control connection gets closed.
+func (t *Conn) AddOnion(key string, virtport uint16, target string) (onion, newkey string, e error) {
+	if key == "" {
+		key = "NEW:ED25519-V3"
+	}
+	var lines []string
+	if lines, e = t.Cmd("ADD_ONION " + key + " Port=" + strconv.Itoa(int(virtport)) + "," + target); e != nil {
+		return
+	}
+	for _, l := range lines {
+		if strings.HasPrefix(l, "ServiceID=") {
+			onion = l[10:] + ".onion"
+		} else if strings.HasPrefix(l, "PrivateKey=") {
+			newkey = l[11:]
+		}
+	}
+	if onion == "" {
+		e = errors.New("torctl: no ServiceID in ADD_ONION reply")
+	}
+	return
+}
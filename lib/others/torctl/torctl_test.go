@@ -0,0 +1,168 @@
+package torctl
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testServiceID = "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd"
+
+// fake Tor control port
+type fakeTor struct {
+	lis        net.Listener
+	methods    string
+	password   string
+	cookiefile string
+	cookie     []byte
+	cmds       chan string
+}
+
+func (f *fakeTor) serve(c net.Conn) {
+	defer c.Close()
+	rd := bufio.NewReader(c)
+	var authed bool
+	var client_hash []byte
+	reply := func(s string) { c.Write([]byte(s + "\r\n")) }
+	for {
+		l, e := rd.ReadString('\n')
+		if e != nil {
+			return
+		}
+		l = strings.TrimRight(l, "\r\n")
+		f.cmds <- l
+		switch {
+		case l == "PROTOCOLINFO 1":
+			reply("250-PROTOCOLINFO 1")
+			reply("250-AUTH METHODS=" + f.methods + " COOKIEFILE=" + strconv.Quote(f.cookiefile))
+			reply("250-VERSION Tor=\"0.4.8.9\"")
+			reply("250 OK")
+		case strings.HasPrefix(l, "AUTHCHALLENGE SAFECOOKIE "):
+			cn, _ := hex.DecodeString(l[25:])
+			sn := []byte("0123456789abcdef0123456789abcdef")
+			msg := append(append(append([]byte{}, f.cookie...), cn...), sn...)
+			h := hmac.New(sha256.New, []byte(SAFECOOKIE_SERVER_KEY))
+			h.Write(msg)
+			reply("250 AUTHCHALLENGE SERVERHASH=" + hex.EncodeToString(h.Sum(nil)) + " SERVERNONCE=" + hex.EncodeToString(sn))
+			h = hmac.New(sha256.New, []byte(SAFECOOKIE_CLIENT_KEY))
+			h.Write(msg)
+			client_hash = h.Sum(nil)
+		case strings.HasPrefix(l, "AUTHENTICATE"):
+			arg := strings.TrimPrefix(strings.TrimPrefix(l, "AUTHENTICATE"), " ")
+			switch {
+			case strings.Contains(f.methods, "NULL"):
+				authed = true
+			case f.password != "":
+				authed = arg == strconv.Quote(f.password)
+			case client_hash != nil:
+				authed = arg == hex.EncodeToString(client_hash)
+			default:
+				authed = arg == hex.EncodeToString(f.cookie)
+			}
+			if authed {
+				reply("250 OK")
+			} else {
+				reply("515 Authentication failed")
+				return
+			}
+		case strings.HasPrefix(l, "ADD_ONION "):
+			if !authed {
+				reply("514 Authentication required.")
+				return
+			}
+			reply("250-ServiceID=" + testServiceID)
+			if strings.HasPrefix(l, "ADD_ONION NEW:") {
+				reply("250-PrivateKey=ED25519-V3:c2VjcmV0")
+			}
+			reply("250 OK")
+		default:
+			reply("510 Unrecognized command")
+		}
+	}
+}
+
+func newFakeTor(t *testing.T, methods string) (f *fakeTor) {
+	f = &fakeTor{methods: methods, cmds: make(chan string, 100)}
+	f.cookie = []byte("0123456789abcdef0123456789abcdef")
+	f.cookiefile = filepath.Join(t.TempDir(), "control_auth_cookie")
+	os.WriteFile(f.cookiefile, f.cookie, 0600)
+	var e error
+	if f.lis, e = net.Listen("tcp", "127.0.0.1:0"); e != nil {
+		t.Fatal(e)
+	}
+	go func() {
+		for {
+			c, e := f.lis.Accept()
+			if e != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	t.Cleanup(func() { f.lis.Close() })
+	return
+}
+
+func testAddOnion(t *testing.T, f *fakeTor, password string) {
+	c, e := Dial(f.lis.Addr().String(), time.Second)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer c.Close()
+	if e = c.Authenticate(password); e != nil {
+		t.Fatal(f.methods, e)
+	}
+	onion, key, e := c.AddOnion("", 8333, "127.0.0.1:8334")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if onion != testServiceID+".onion" || key != "ED25519-V3:c2VjcmV0" {
+		t.Error("Bad ADD_ONION result", onion, key)
+	}
+	onion, key, e = c.AddOnion(key, 8333, "127.0.0.1:8334")
+	if e != nil || onion != testServiceID+".onion" || key != "" {
+		t.Error("Bad ADD_ONION result with key", onion, key, e)
+	}
+	for len(f.cmds) > 0 {
+		if l := <-f.cmds; strings.HasPrefix(l, "ADD_ONION ED25519-V3:") && l != "ADD_ONION ED25519-V3:c2VjcmV0 Port=8333,127.0.0.1:8334" {
+			t.Error("Bad ADD_ONION command", l)
+		}
+	}
+}
+
+func TestAuthMethods(t *testing.T) {
+	testAddOnion(t, newFakeTor(t, "NULL"), "")
+	testAddOnion(t, newFakeTor(t, "COOKIE"), "")
+	testAddOnion(t, newFakeTor(t, "COOKIE,SAFECOOKIE"), "")
+	f := newFakeTor(t, "HASHEDPASSWORD,COOKIE")
+	f.password = "my \"secret\""
+	testAddOnion(t, f, f.password)
+}
+
+func TestAuthFail(t *testing.T) {
+	f := newFakeTor(t, "HASHEDPASSWORD")
+	f.password = "good"
+	c, e := Dial(f.lis.Addr().String(), time.Second)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer c.Close()
+	if e = c.Authenticate("bad"); e == nil {
+		t.Error("Error expected")
+	}
+}
+
+func TestParseKeyVals(t *testing.T) {
+	kv := parseKeyVals(`METHODS=COOKIE,SAFECOOKIE COOKIEFILE="/var/run/tor/control \"x\".authcookie" X=1`)
+	if kv["METHODS"] != "COOKIE,SAFECOOKIE" || kv["COOKIEFILE"] != `/var/run/tor/control "x".authcookie` || kv["X"] != "1" {
+		t.Error("parseKeyVals failed", kv)
+	}
+}
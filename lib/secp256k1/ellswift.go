@@ -0,0 +1,210 @@
+package secp256k1
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+)
+
+// ElligatorSwift encoding of public keys, as specified in BIP324.
+// Performance is not critical here (it is only used once per connection),
+// so the field operations are done with math/big.
+
+var (
+	ell_p       *big.Int
+	ell_sqrt_m3 *big.Int // sqrt(-3) mod p
+	ell_sqrt_e  *big.Int // (p+1)/4
+	ell_seven   = big.NewInt(7)
+)
+
+func fe_mod(a *big.Int) *big.Int {
+	return a.Mod(a, ell_p)
+}
+
+func fe_mul(a, b *big.Int) *big.Int {
+	return fe_mod(new(big.Int).Mul(a, b))
+}
+
+func fe_add(a, b *big.Int) *big.Int {
+	return fe_mod(new(big.Int).Add(a, b))
+}
+
+func fe_sub(a, b *big.Int) *big.Int {
+	return fe_mod(new(big.Int).Sub(a, b))
+}
+
+func fe_neg(a *big.Int) *big.Int {
+	return fe_mod(new(big.Int).Neg(a))
+}
+
+func fe_inv(a *big.Int) *big.Int {
+	return new(big.Int).ModInverse(a, ell_p)
+}
+
+func fe_div(a, b *big.Int) *big.Int {
+	return fe_mul(a, fe_inv(b))
+}
+
+// fe_sqrt returns nil if a is not a square.
+func fe_sqrt(a *big.Int) *big.Int {
+	r := new(big.Int).Exp(a, ell_sqrt_e, ell_p)
+	if fe_mul(r, r).Cmp(fe_mod(new(big.Int).Set(a))) != 0 {
+		return nil
+	}
+	return r
+}
+
+// x^3 + 7
+func fe_curve(x *big.Int) *big.Int {
+	return fe_add(fe_mul(fe_mul(x, x), x), ell_seven)
+}
+
+func fe_is_valid_x(x *big.Int) bool {
+	return fe_sqrt(fe_curve(x)) != nil
+}
+
+// xswiftec decodes field elements (u, t) to an X coordinate on the curve.
+func xswiftec(u, t *big.Int) *big.Int {
+	if u.Sign() == 0 {
+		u = big.NewInt(1)
+	}
+	if t.Sign() == 0 {
+		t = big.NewInt(1)
+	}
+	if fe_add(fe_curve(u), fe_mul(t, t)).Sign() == 0 {
+		t = fe_add(t, t)
+	}
+	X := fe_div(fe_sub(fe_curve(u), fe_mul(t, t)), fe_add(t, t))
+	Y := fe_div(fe_add(X, t), fe_mul(ell_sqrt_m3, u))
+	half := fe_inv(big.NewInt(2))
+	x := fe_add(u, fe_mul(big.NewInt(4), fe_mul(Y, Y)))
+	if fe_is_valid_x(x) {
+		return x
+	}
+	x = fe_mul(fe_sub(fe_neg(fe_div(X, Y)), u), half)
+	if fe_is_valid_x(x) {
+		return x
+	}
+	x = fe_mul(fe_sub(fe_div(X, Y), u), half)
+	if fe_is_valid_x(x) {
+		return x
+	}
+	panic("xswiftec failed") // cannot happen
+}
+
+// xswiftec_inv finds t such that xswiftec(u, t) = x, or returns nil.
+// Case (0-7) selects which of the up to 8 results to return.
+func xswiftec_inv(x, u *big.Int, c int) *big.Int {
+	var v, s *big.Int
+	two := big.NewInt(2)
+	if c&2 == 0 {
+		if fe_is_valid_x(fe_sub(fe_neg(x), u)) {
+			return nil
+		}
+		v = x
+		s = fe_neg(fe_div(fe_curve(u), fe_add(fe_add(fe_mul(u, u), fe_mul(u, v)), fe_mul(v, v))))
+	} else {
+		s = fe_sub(x, u)
+		if s.Sign() == 0 {
+			return nil
+		}
+		r := fe_sqrt(fe_mul(fe_neg(s), fe_add(fe_mul(big.NewInt(4), fe_curve(u)), fe_mul(fe_mul(big.NewInt(3), s), fe_mul(u, u)))))
+		if r == nil {
+			return nil
+		}
+		if c&1 != 0 && r.Sign() == 0 {
+			return nil
+		}
+		v = fe_div(fe_add(fe_neg(u), fe_div(r, s)), two)
+	}
+	w := fe_sqrt(s)
+	if w == nil {
+		return nil
+	}
+	one := big.NewInt(1)
+	switch c & 5 {
+	case 0:
+		return fe_neg(fe_mul(w, fe_add(fe_div(fe_mul(u, fe_sub(one, ell_sqrt_m3)), two), v)))
+	case 1:
+		return fe_mul(w, fe_add(fe_div(fe_mul(u, fe_add(one, ell_sqrt_m3)), two), v))
+	case 4:
+		return fe_mul(w, fe_add(fe_div(fe_mul(u, fe_sub(one, ell_sqrt_m3)), two), v))
+	default:
+		return fe_neg(fe_mul(w, fe_add(fe_div(fe_mul(u, fe_add(one, ell_sqrt_m3)), two), v)))
+	}
+}
+
+func fe_bytes(a *big.Int) []byte {
+	var b [32]byte
+	return a.FillBytes(b[:])
+}
+
+// EllSwiftDecode returns X coordinate (32 bytes) of the point encoded with ElligatorSwift (64 bytes).
+func EllSwiftDecode(enc []byte) []byte {
+	u := fe_mod(new(big.Int).SetBytes(enc[:32]))
+	t := fe_mod(new(big.Int).SetBytes(enc[32:64]))
+	return fe_bytes(xswiftec(u, t))
+}
+
+// EllSwiftEncode makes a random ElligatorSwift encoding (64 bytes) of the given X coordinate.
+func EllSwiftEncode(xb []byte) (enc []byte) {
+	var rnd [33]byte
+	x := new(big.Int).SetBytes(xb)
+	for {
+		rand.Read(rnd[:])
+		u := fe_mod(new(big.Int).SetBytes(rnd[:32]))
+		if u.Sign() == 0 {
+			continue
+		}
+		t := xswiftec_inv(x, u, int(rnd[32]&7))
+		if t == nil {
+			continue
+		}
+		// verify the result, so a possible arithmetic error would never produce a bad key
+		if xswiftec(u, t).Cmp(x) != 0 {
+			continue
+		}
+		enc = make([]byte, 0, 64)
+		enc = append(enc, fe_bytes(u)...)
+		enc = append(enc, fe_bytes(t)...)
+		return
+	}
+}
+
+// EllSwiftCreate returns ElligatorSwift encoded public key (64 bytes) for the given private key.
+func EllSwiftCreate(seckey []byte) []byte {
+	var pub [33]byte
+	BaseMultiply(seckey, pub[:])
+	return EllSwiftEncode(pub[1:])
+}
+
+// EllSwiftXDH computes BIP324 x-only ECDH shared secret.
+func EllSwiftXDH(ell_a, ell_b, seckey []byte, initiating bool) (secret []byte) {
+	var pub, shared [33]byte
+	var theirs []byte
+	if initiating {
+		theirs = ell_b
+	} else {
+		theirs = ell_a
+	}
+	pub[0] = 0x02
+	copy(pub[1:], EllSwiftDecode(theirs))
+	if !Multiply(pub[:], seckey, shared[:]) {
+		return nil
+	}
+	h := sha256.Sum256([]byte("bip324_ellswift_xonly_ecdh"))
+	sha := sha256.New()
+	sha.Write(h[:])
+	sha.Write(h[:])
+	sha.Write(ell_a)
+	sha.Write(ell_b)
+	sha.Write(shared[1:])
+	return sha.Sum(nil)
+}
+
+func init() {
+	ell_p, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F", 16)
+	ell_sqrt_e = new(big.Int).Add(ell_p, big.NewInt(1))
+	ell_sqrt_e.Rsh(ell_sqrt_e, 2)
+	ell_sqrt_m3 = fe_sqrt(fe_neg(big.NewInt(3)))
+}
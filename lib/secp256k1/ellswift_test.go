@@ -0,0 +1,110 @@
+package secp256k1
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"os"
+	"testing"
+)
+
+func TestEllSwiftRoundtrip(t *testing.T) {
+	var k1, k2 [32]byte
+	for i := 0; i < 20; i++ {
+		rand.Read(k1[:])
+		rand.Read(k2[:])
+		var pub [33]byte
+		BaseMultiply(k1[:], pub[:])
+		ell1 := EllSwiftCreate(k1[:])
+		if !bytes.Equal(EllSwiftDecode(ell1), pub[1:]) {
+			t.Fatal("EllSwiftDecode mismatch", i)
+		}
+		ell2 := EllSwiftCreate(k2[:])
+		s1 := EllSwiftXDH(ell1, ell2, k1[:], true)
+		s2 := EllSwiftXDH(ell1, ell2, k2[:], false)
+		if s1 == nil || !bytes.Equal(s1, s2) {
+			t.Fatal("EllSwiftXDH mismatch", i)
+		}
+	}
+}
+
+func TestEllSwiftDecode(t *testing.T) {
+	// every 64 byte string must decode to a valid X coordinate
+	var enc [64]byte
+	for i := 0; i < 100; i++ {
+		rand.Read(enc[:])
+		if i == 0 {
+			copy(enc[:32], make([]byte, 32)) // u=0
+		} else if i == 1 {
+			copy(enc[32:], make([]byte, 32)) // t=0
+		}
+		var xy [33]byte
+		xy[0] = 2
+		copy(xy[1:], EllSwiftDecode(enc[:]))
+		var out [33]byte
+		if !Multiply(xy[:], []byte{1}, out[:]) {
+			t.Fatal("Invalid X decoded", i)
+		}
+	}
+}
+
+func TestEllSwiftVector(t *testing.T) {
+	// from BIP324 packet_encoding_test_vectors.csv
+	priv, _ := hex.DecodeString("61062ea5071d800bbfd59e2e8b53d47d194b095ae5a4df04936b49772ef0d4d7")
+	ours, _ := hex.DecodeString("ec0adff257bbfe500c188c80b4fdd640f6b45a482bbc15fc7cef5931deff0aa186f6eb9bba7b85dc4dcc28b28722de1e3d9108b985e2967045668f66098e475b")
+	xours, _ := hex.DecodeString("19e965bc20fc40614e33f2f82d4eeff81b5e7516b12a5c6c0d6053527eba0923")
+	if !bytes.Equal(EllSwiftDecode(ours), xours) {
+		t.Error("EllSwiftDecode wrong")
+	}
+	var pub [33]byte
+	BaseMultiply(priv, pub[:])
+	if !bytes.Equal(pub[1:], xours) {
+		t.Error("Public key mismatch")
+	}
+}
+
+func readCSV(t *testing.T, fn string) [][]string {
+	f, er := os.Open(fn)
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	tas, er := csv.NewReader(f).ReadAll()
+	f.Close()
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	return tas[1:] // skip column names
+}
+
+func TestEllSwiftDecodeVectors(t *testing.T) {
+	for i, v := range readCSV(t, "../test/bip324_ellswift_decode_test_vectors.csv") {
+		enc, _ := hex.DecodeString(v[0])
+		if res := hex.EncodeToString(EllSwiftDecode(enc)); res != v[1] {
+			t.Error(i, "EllSwiftDecode mismatch", res, v[2])
+		}
+	}
+}
+
+func TestEllSwiftXDHVectors(t *testing.T) {
+	// columns: in_idx, in_priv_ours, in_ellswift_ours, in_ellswift_theirs, in_initiating, ...
+	// mid_x_ours (9), mid_x_theirs (10), mid_x_shared (11), mid_shared_secret (12)
+	for i, v := range readCSV(t, "../test/bip324_packet_encoding_test_vectors.csv") {
+		priv, _ := hex.DecodeString(v[1])
+		ours, _ := hex.DecodeString(v[2])
+		theirs, _ := hex.DecodeString(v[3])
+		initiating := v[4] == "1"
+		if hex.EncodeToString(EllSwiftDecode(ours)) != v[9] || hex.EncodeToString(EllSwiftDecode(theirs)) != v[10] {
+			t.Error(i, "EllSwiftDecode mismatch")
+		}
+		var secret []byte
+		if initiating {
+			secret = EllSwiftXDH(ours, theirs, priv, true)
+		} else {
+			secret = EllSwiftXDH(theirs, ours, priv, false)
+		}
+		if hex.EncodeToString(secret) != v[12] {
+			t.Error(i, "EllSwiftXDH mismatch", hex.EncodeToString(secret))
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package utxo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+/*
+UTXO.jrn - journal of the changes made to the records since UTXO.db was saved:
+  [0:8] - height of the base block (the highest bit set if the records are compressed)
+  [8:40] - hash of the base block (the state, the journal starts from)
+  And then the entries:
+   [0:4] - length of the payload
+   [4:8] - crc32c of the payload
+   payload:
+    [0] - type: 1 for a block committed, 2 for a block undone
+    [1:5] - height of the block after the change
+    [5:37] - hash of the block after the change
+    Block committed:
+     var_len: number of spent txs, and for each: [32]TXID, var_len outs_cnt, bit mask of the spent outs
+     var_len: number of added records, and for each: var_len length, serialized record
+    Block undone:
+     var_len: number of removed records, and for each: [8]key
+     var_len: number of restored records, and for each: var_len length, serialized record
+  Each entry is synced before the next block is processed.
+  Whatever follows the first incomplete (or corrupt) entry gets dropped.
+  Once UTXO.db is saved, the entries it already contains are removed from the journal.
+*/
+
+const (
+	JOURNAL_FILE_NAME = "UTXO.jrn"
+
+	jrnHeaderLen   = 8 + 32
+	jrnBlockAdded  = 1
+	jrnBlockUndone = 2
+)
+
+var (
+	UTXO_JOURNAL_MAX_SIZE int64 = 256 << 20 // save UTXO.db when the journal grows over this many bytes
+
+	jrnCrcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type journalTip struct {
+	end  int64 // offset right after the entry
+	hash [32]byte
+}
+
+type journal struct {
+	f          *os.File
+	fname      string
+	base       [32]byte
+	baseHeight uint64
+	size       int64
+	tips       []journalTip // the state after each entry
+	sync.Mutex
+}
+
+type journalEntry struct {
+	typ    byte
+	height uint32
+	hash   []byte
+	data   []byte
+}
+
+// createJournal creates a new, empty, journal file starting from the given state.
+func createJournal(fname string, height uint32, compressed bool, hash []byte) (j *journal, e error) {
+	j = &journal{fname: fname, baseHeight: uint64(height)}
+	if compressed {
+		j.baseHeight |= 0x8000000000000000
+	}
+	copy(j.base[:], hash)
+	if e = j.writeFile(fname+".tmp", nil); e != nil {
+		return
+	}
+	if e = os.Rename(fname+".tmp", fname); e != nil {
+		return
+	}
+	j.f, e = os.OpenFile(fname, os.O_RDWR, 0660)
+	j.size = jrnHeaderLen
+	return
+}
+
+// writeFile writes the journal's header and the given entries to a new file, and syncs it.
+func (j *journal) writeFile(fname string, entries []byte) (e error) {
+	var hdr [jrnHeaderLen]byte
+	binary.LittleEndian.PutUint64(hdr[:8], j.baseHeight)
+	copy(hdr[8:], j.base[:])
+	f, e := os.Create(fname)
+	if e != nil {
+		return
+	}
+	if _, e = f.Write(hdr[:]); e == nil {
+		if _, e = f.Write(entries); e == nil {
+			e = f.Sync()
+		}
+	}
+	f.Close()
+	return
+}
+
+// readJournal reads all the valid entries of the journal, truncating the file after the last one.
+func readJournal(fname string) (j *journal, entries []*journalEntry, e error) {
+	var hdr [jrnHeaderLen]byte
+	var eh [8]byte
+
+	j = &journal{fname: fname}
+	if j.f, e = os.OpenFile(fname, os.O_RDWR, 0660); e != nil {
+		return
+	}
+	rd := bufio.NewReaderSize(j.f, 0x100000)
+	if _, e = io.ReadFull(rd, hdr[:]); e != nil {
+		j.f.Close()
+		return
+	}
+	j.baseHeight = binary.LittleEndian.Uint64(hdr[:8])
+	copy(j.base[:], hdr[8:])
+	j.size = jrnHeaderLen
+	for {
+		if _, er := io.ReadFull(rd, eh[:]); er != nil {
+			break
+		}
+		le := binary.LittleEndian.Uint32(eh[:4])
+		if le < 1+4+32 || le > 1<<30 {
+			break
+		}
+		pl := make([]byte, le)
+		if _, er := io.ReadFull(rd, pl); er != nil || crc32.Checksum(pl, jrnCrcTable) != binary.LittleEndian.Uint32(eh[4:]) {
+			break
+		}
+		ent := &journalEntry{typ: pl[0], height: binary.LittleEndian.Uint32(pl[1:5]), hash: pl[5:37], data: pl[37:]}
+		entries = append(entries, ent)
+		j.size += 8 + int64(le)
+		var tip journalTip
+		tip.end = j.size
+		copy(tip.hash[:], ent.hash)
+		j.tips = append(j.tips, tip)
+	}
+	if e = j.f.Truncate(j.size); e != nil {
+		j.f.Close()
+	}
+	return
+}
+
+// append writes the entry to the journal and syncs it.
+func (j *journal) append(typ byte, height uint32, hash []byte, data []byte) (e error) {
+	pl := make([]byte, 8+1+4+32, 8+1+4+32+len(data))
+	pl[8] = typ
+	binary.LittleEndian.PutUint32(pl[9:13], height)
+	copy(pl[13:45], hash)
+	pl = append(pl, data...)
+	binary.LittleEndian.PutUint32(pl[0:4], uint32(len(pl)-8))
+	binary.LittleEndian.PutUint32(pl[4:8], crc32.Checksum(pl[8:], jrnCrcTable))
+
+	j.Lock()
+	defer j.Unlock()
+	if _, e = j.f.WriteAt(pl, j.size); e != nil {
+		return
+	}
+	if e = j.f.Sync(); e != nil {
+		return
+	}
+	j.size += int64(len(pl))
+	var tip journalTip
+	tip.end = j.size
+	copy(tip.hash[:], hash)
+	j.tips = append(j.tips, tip)
+	return
+}
+
+// startAfter returns the index of the first entry to be applied on top of the state with the given hash
+// (-1 if the journal does not cover this state).
+func (j *journal) startAfter(hash []byte) int {
+	for i := len(j.tips) - 1; i >= 0; i-- {
+		if bytes.Equal(j.tips[i].hash[:], hash) {
+			return i + 1
+		}
+	}
+	if bytes.Equal(j.base[:], hash) {
+		return 0
+	}
+	return -1
+}
+
+// compact removes the entries that are already contained in UTXO.db, saved at the given state.
+func (j *journal) compact(height uint32, compressed bool, hash []byte) (e error) {
+	j.Lock()
+	defer j.Unlock()
+	var from int64 = jrnHeaderLen
+	idx := j.startAfter(hash)
+	if idx > 0 {
+		from = j.tips[idx-1].end
+	} else if idx < 0 {
+		from = j.size // the journal does not lead to this state (should not happen)
+		j.tips = j.tips[:0]
+		idx = 0
+	}
+	entries := make([]byte, j.size-from)
+	if _, e = j.f.ReadAt(entries, from); e != nil {
+		return
+	}
+	j.baseHeight = uint64(height)
+	if compressed {
+		j.baseHeight |= 0x8000000000000000
+	}
+	copy(j.base[:], hash)
+	if e = j.writeFile(j.fname+".tmp", entries); e != nil {
+		return
+	}
+	j.f.Close()
+	if e = os.Rename(j.fname+".tmp", j.fname); e != nil {
+		return
+	}
+	if j.f, e = os.OpenFile(j.fname, os.O_RDWR, 0660); e != nil {
+		return
+	}
+	j.tips = append(j.tips[:0], j.tips[idx:]...)
+	for i := range j.tips {
+		j.tips[i].end -= from - jrnHeaderLen
+	}
+	j.size = jrnHeaderLen + int64(len(entries))
+	return
+}
+
+// length returns the size of the journal's file.
+func (j *journal) length() int64 {
+	j.Lock()
+	defer j.Unlock()
+	return j.size
+}
+
+func (j *journal) close() {
+	j.Lock()
+	j.f.Close()
+	j.Unlock()
+}
+
+// serializeRecs appends the records (in the database's format) to the buffer.
+func serializeRecs(bu *bytes.Buffer, recs []*UtxoRec) {
+	var tmp [0x100000]byte // static record for Serialize to serialize to
+	btc.WriteVlen(bu, uint64(len(recs)))
+	for _, rec := range recs {
+		bin := Serialize(rec, tmp[:])
+		if bin == nil {
+			btc.WriteVlen(bu, 0)
+			continue
+		}
+		btc.WriteVlen(bu, uint64(len(*bin)))
+		bu.Write(*bin)
+	}
+}
+
+// deserializeRecs reads the records written by serializeRecs.
+func deserializeRecs(rd *bytes.Reader) (recs []*UtxoRec, e error) {
+	cnt, e := btc.ReadVLen(rd)
+	if e != nil {
+		return
+	}
+	for ; cnt > 0; cnt-- {
+		le, er := btc.ReadVLen(rd)
+		if er != nil {
+			return nil, er
+		}
+		if le == 0 {
+			continue
+		}
+		b := make([]byte, le)
+		if _, e = io.ReadFull(rd, b); e != nil {
+			return
+		}
+		recs = append(recs, FullUtxoRec(b))
+	}
+	return
+}
+
+// journalBlock returns the journal's data of the block's changes.
+// Call it before the changes are committed, as committing may alter the records.
+func journalBlock(changes *BlockChanges) []byte {
+	bu := new(bytes.Buffer)
+	btc.WriteVlen(bu, uint64(len(changes.DeledTxs)))
+	for k, outs := range changes.DeledTxs {
+		bu.Write(k[:])
+		btc.WriteVlen(bu, uint64(len(outs)))
+		mask := make([]byte, (len(outs)+7)/8)
+		for i, spent := range outs {
+			if spent {
+				mask[i>>3] |= 1 << (i & 7)
+			}
+		}
+		bu.Write(mask)
+	}
+	serializeRecs(bu, changes.AddList)
+	return bu.Bytes()
+}
+
+// journalUndo returns the journal's data of the undone block.
+func journalUndo(keys []UtxoKeyType, addback []*UtxoRec) []byte {
+	bu := new(bytes.Buffer)
+	btc.WriteVlen(bu, uint64(len(keys)))
+	for _, k := range keys {
+		bu.Write(k[:])
+	}
+	serializeRecs(bu, addback)
+	return bu.Bytes()
+}
+
+// replayEntry applies the journal's entry to the database.
+func (db *UnspentDB) replayEntry(ent *journalEntry) (e error) {
+	rd := bytes.NewReader(ent.data)
+	cnt, e := btc.ReadVLen(rd)
+	if e != nil {
+		return
+	}
+	switch ent.typ {
+	case jrnBlockAdded:
+		changes := &BlockChanges{Height: ent.height, DeledTxs: make(map[[32]byte][]bool, cnt)}
+		for ; cnt > 0; cnt-- {
+			var k [32]byte
+			if _, e = io.ReadFull(rd, k[:]); e != nil {
+				return
+			}
+			n, er := btc.ReadVLen(rd)
+			if er != nil {
+				return er
+			}
+			mask := make([]byte, (n+7)/8)
+			if _, e = io.ReadFull(rd, mask); e != nil {
+				return
+			}
+			outs := make([]bool, n)
+			for i := range outs {
+				outs[i] = (mask[i>>3] & (1 << (i & 7))) != 0
+			}
+			changes.DeledTxs[k] = outs
+		}
+		if changes.AddList, e = deserializeRecs(rd); e != nil {
+			return
+		}
+		db.commit(changes)
+
+	case jrnBlockUndone:
+		keys := make([]UtxoKeyType, cnt)
+		for i := range keys {
+			if _, e = io.ReadFull(rd, keys[i][:]); e != nil {
+				return
+			}
+		}
+		addback, er := deserializeRecs(rd)
+		if er != nil {
+			return er
+		}
+		mh := NewMuHash()
+		db.undoDel(keys, mh)
+		db.undoAdd(addback, mh)
+		db.muhashCombine(mh)
+
+	default:
+		return fmt.Errorf("unknown entry type %d", ent.typ)
+	}
+	if db.LastBlockHash == nil {
+		db.LastBlockHash = make([]byte, 32)
+	}
+	copy(db.LastBlockHash, ent.hash)
+	db.LastBlockHeight = ent.height
+	return
+}
+
+// openJournal replays the journal on top of the loaded UTXO.db and opens it for appending.
+// The journal gets started from scratch, if it does not match the loaded state.
+func (db *UnspentDB) openJournal() {
+	var hash [32]byte
+	copy(hash[:], db.LastBlockHash)
+	fname := db.dir_utxo + JOURNAL_FILE_NAME
+	j, entries, e := readJournal(fname)
+	if e == nil {
+		if idx := j.startAfter(hash[:]); idx >= 0 && (j.baseHeight&0x8000000000000000 != 0) == db.ComprssedUTXO {
+			for i, ent := range entries[idx:] {
+				if e = db.replayEntry(ent); e != nil {
+					break
+				}
+				fmt.Print("\rReplaying ", JOURNAL_FILE_NAME, " - ", 100*(i+1)/(len(entries)-idx), "% complete ... ")
+			}
+			fmt.Print("\r                                                                 \r")
+			if e == nil {
+				if idx < len(entries) {
+					db.DirtyDB.Set()
+				}
+				db.jrn = j
+				return
+			}
+			// the state is not consistent anymore, so better start from scratch
+			panic("UTXO journal: " + e.Error())
+		}
+		j.close()
+		println("UTXO journal does not match UTXO.db - dropping it")
+	} else if !os.IsNotExist(e) {
+		println("UTXO journal:", e.Error())
+	}
+	if db.jrn, e = createJournal(fname, db.LastBlockHeight, db.ComprssedUTXO, hash[:]); e != nil {
+		println("UTXO journal:", e.Error())
+		db.jrn = nil
+	}
+}
+
+// journalAppend writes the entry to the journal, disabling the journal if it fails.
+func (db *UnspentDB) journalAppend(typ byte, data []byte) {
+	if e := db.jrn.append(typ, db.LastBlockHeight, db.LastBlockHash, data); e != nil {
+		println("UTXO journal disabled:", e.Error())
+		db.jrn.close()
+		os.Remove(db.jrn.fname) // UTXO.db with the undo files are the only source of truth now
+		db.jrn = nil
+		atomic.StoreUint32(&db.CurrentHeightOnDisk, 0) // make sure UTXO.db gets saved
+	}
+}
+
+// journalReset starts the journal from scratch, at the current state (not saved in UTXO.db yet).
+func (db *UnspentDB) journalReset() {
+	if db.jrn == nil {
+		return
+	}
+	db.jrn.close()
+	j, e := createJournal(db.jrn.fname, db.LastBlockHeight, db.ComprssedUTXO, db.LastBlockHash)
+	if e != nil {
+		println("UTXO journal:", e.Error())
+		os.Remove(db.jrn.fname)
+		j = nil
+	}
+	db.jrn = j
+}
+
+func (j *journal) stats() string {
+	j.Lock()
+	defer j.Unlock()
+	return fmt.Sprintf(" Journal: %d entries, %d KB  (UTXO.db saved when over %d MB)\n",
+		len(j.tips), j.size>>10, UTXO_JOURNAL_MAX_SIZE>>20)
+}
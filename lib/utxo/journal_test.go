@@ -0,0 +1,73 @@
+package utxo
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir() + string(os.PathSeparator)
+	db := NewUnspentDb(&NewUnspentOpts{Dir: dir, Rescan: true, Journal: true})
+	if db.jrn == nil {
+		t.Fatal("journal not created")
+	}
+	testDiskBlocks(db)
+	muhash := db.MuHash()
+	db.jrn.close() // crash, without saving UTXO.db
+
+	db = NewUnspentDb(&NewUnspentOpts{Dir: dir, Journal: true})
+	testDiskCheck(t, db)
+	if !bytes.Equal(db.MuHash(), muhash) {
+		t.Error("MuHash mismatch after replay")
+	}
+	if len(db.jrn.tips) != 2 {
+		t.Error("bad number of entries", len(db.jrn.tips))
+	}
+
+	// once UTXO.db is saved, the journal gets emptied
+	db.HurryUp()
+	db.Save()
+	db.writingDone.Wait()
+	db.lastFileClosed.Wait()
+	if len(db.jrn.tips) != 0 || db.jrn.length() != jrnHeaderLen {
+		t.Error("journal not compacted", len(db.jrn.tips), db.jrn.length())
+	}
+
+	// undo the second block, then crash leaving a torn entry behind
+	mh := NewMuHash()
+	keys := []UtxoKeyType{{4}}
+	addback := []*UtxoRec{testDiskRec(2, 1, 3000), testDiskRec(3, 1, 0, 5000, 0)}
+	addback[1].Outs[0], addback[1].Outs[2] = nil, nil
+	jrn_data := journalUndo(keys, addback) // before undoAdd modifies addback
+	db.undoDel(keys, mh)
+	db.undoAdd(addback, mh)
+	db.muhashCombine(mh)
+	db.LastBlockHeight = 1
+	copy(db.LastBlockHash, bytes.Repeat([]byte{0x11}, 32))
+	db.journalAppend(jrnBlockUndone, jrn_data)
+	muhash = db.MuHash()
+	size := db.jrn.length()
+	db.jrn.f.WriteAt([]byte{100, 0, 0, 0, 1, 2, 3, 4, 5}, size)
+	db.jrn.close()
+
+	db = NewUnspentDb(&NewUnspentOpts{Dir: dir, Journal: true})
+	defer db.Close()
+	if db.LastBlockHeight != 1 || !bytes.Equal(db.MuHash(), muhash) {
+		t.Error("bad state after replaying undo", db.LastBlockHeight)
+	}
+	if db.jrn.length() != size {
+		t.Error("torn entry not dropped", db.jrn.length(), size)
+	}
+	for id, val := range map[byte]uint64{2: 3000, 4: 0} {
+		out := db.UnspentGet(&btc.TxPrevOut{Hash: testDiskRec(id, 0).TxID})
+		if val == 0 && out != nil || val != 0 && (out == nil || out.Value != val) {
+			t.Error("bad output of tx", id, out)
+		}
+	}
+	if out := db.UnspentGet(&btc.TxPrevOut{Hash: testDiskRec(3, 0).TxID, Vout: 1}); out == nil || out.Value != 5000 {
+		t.Error("spent output not restored", out)
+	}
+}
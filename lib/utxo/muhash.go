@@ -0,0 +1,217 @@
+package utxo
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/piotrnar/gocoin/lib/others/chacha20"
+)
+
+/*
+MuHash3072 - a rolling hash of a set, as used by Core's "gettxoutsetinfo muhash".
+Each element is hashed with SHA256, expanded with ChaCha20 into a 3072 bits number
+and multiplied into the numerator (when added) or the denominator (when removed),
+modulo 2^3072 - 1103717.
+*/
+
+const MUHASH_SIZE = 384 // bytes of the 3072 bits number
+
+var muhashPrime *big.Int
+
+func init() {
+	muhashPrime = new(big.Int).Lsh(big.NewInt(1), 3072)
+	muhashPrime.Sub(muhashPrime, big.NewInt(1103717))
+}
+
+type MuHash struct {
+	num, den big.Int
+}
+
+// NewMuHash returns the hash of an empty set.
+func NewMuHash() (m *MuHash) {
+	m = new(MuHash)
+	m.num.SetInt64(1)
+	m.den.SetInt64(1)
+	return
+}
+
+// muhashNum converts a set's element into the 3072 bits number.
+func muhashNum(data []byte) *big.Int {
+	var nonce [chacha20.NonceSize]byte
+	var tmp [MUHASH_SIZE]byte
+	key := sha256.Sum256(data)
+	chacha20.NewCipher(key[:], nonce[:], 0).Keystream(tmp[:])
+	return new(big.Int).SetBytes(reverse(tmp[:]))
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// Insert adds an element to the set.
+func (m *MuHash) Insert(data []byte) {
+	m.num.Mul(&m.num, muhashNum(data))
+	m.num.Mod(&m.num, muhashPrime)
+}
+
+// Remove removes an element from the set.
+func (m *MuHash) Remove(data []byte) {
+	m.den.Mul(&m.den, muhashNum(data))
+	m.den.Mod(&m.den, muhashPrime)
+}
+
+// Combine adds all the changes recorded in o.
+func (m *MuHash) Combine(o *MuHash) {
+	m.num.Mul(&m.num, &o.num)
+	m.num.Mod(&m.num, muhashPrime)
+	m.den.Mul(&m.den, &o.den)
+	m.den.Mod(&m.den, muhashPrime)
+}
+
+// Bytes returns the state of the hash, as 384 bytes little endian number.
+func (m *MuHash) Bytes() (res []byte) {
+	if m.den.Cmp(big.NewInt(1)) != 0 {
+		m.num.Mul(&m.num, new(big.Int).ModInverse(&m.den, muhashPrime))
+		m.num.Mod(&m.num, muhashPrime)
+		m.den.SetInt64(1)
+	}
+	res = make([]byte, MUHASH_SIZE)
+	m.num.FillBytes(res)
+	return reverse(res)
+}
+
+// SetBytes restores the state of the hash, as returned by Bytes.
+func (m *MuHash) SetBytes(b []byte) {
+	tmp := make([]byte, MUHASH_SIZE)
+	copy(tmp, b)
+	m.num.SetBytes(reverse(tmp))
+	m.num.Mod(&m.num, muhashPrime)
+	m.den.SetInt64(1)
+}
+
+// Finalize returns the 32 bytes hash of the set.
+func (m *MuHash) Finalize() []byte {
+	h := sha256.Sum256(m.Bytes())
+	return h[:]
+}
+
+// updateRec adds (or removes) the record's outputs, selected by outs (nil for all of them).
+// The outputs that Core does not keep in its UTXO set are ignored.
+func (m *MuHash) updateRec(rec *UtxoRec, outs []bool, remove bool) {
+	var buf [0x100]byte
+	for vout, out := range rec.Outs {
+		if out == nil || !InCoreSet(out) || outs != nil && (vout >= len(outs) || !outs[vout]) {
+			continue
+		}
+		d := coinSer(buf[:0], rec.TxID[:], uint32(vout), rec.InBlock, rec.Coinbase, out)
+		if remove {
+			m.Remove(d)
+		} else {
+			m.Insert(d)
+		}
+	}
+}
+
+// calcMuHash calculates MuHash of the entire database, from scratch.
+func (db *UnspentDB) calcMuHash() {
+	var wg sync.WaitGroup
+	next := int32(-1)
+	res := NewMuHash()
+	var mut sync.Mutex
+	for n := runtime.NumCPU(); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			m := NewMuHash()
+			for i := atomic.AddInt32(&next, 1); i < int32(len(db.HashMap)); i = atomic.AddInt32(&next, 1) {
+				db.BrowseRecords(int(i), func(v *[]byte) bool {
+					m.updateRec(NewUtxoRec(*v), nil, false)
+					return true
+				})
+			}
+			mut.Lock()
+			res.Combine(m)
+			mut.Unlock()
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	db.muMutex.Lock()
+	db.muhash = res
+	db.muMutex.Unlock()
+}
+
+// muhashCombine applies the changes recorded in m to the database's MuHash.
+func (db *UnspentDB) muhashCombine(m *MuHash) {
+	db.muMutex.Lock()
+	db.muhash.Combine(m)
+	db.muMutex.Unlock()
+}
+
+// MuHash returns MuHash3072 of the UTXO set (as reported by Core's "gettxoutsetinfo muhash").
+func (db *UnspentDB) MuHash() []byte {
+	db.muMutex.Lock()
+	defer db.muMutex.Unlock()
+	return db.muhash.Finalize()
+}
+
+// TxOutSetInfo is the UTXO set's summary, as reported by Core's "gettxoutsetinfo".
+type TxOutSetInfo struct {
+	Height         uint32
+	BlockHash      []byte
+	Txs            uint64 // number of transactions with unspent outputs
+	TxOuts         uint64
+	BogoSize       uint64
+	TotalAmount    uint64
+	MuHash         []byte
+	HashSerialized []byte // hash_serialized_3 (only if requested)
+}
+
+// GetTxOutSetInfo returns the summary of the UTXO set, with hash_serialized_3 if serialized is true
+// (it takes a while). Only the outputs that Core keeps in its UTXO set are counted.
+func (db *UnspentDB) GetTxOutSetInfo(serialized bool) (res *TxOutSetInfo) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	res = &TxOutSetInfo{Height: db.LastBlockHeight, BlockHash: make([]byte, 32), MuHash: db.MuHash()}
+	copy(res.BlockHash, db.LastBlockHash)
+
+	var wg sync.WaitGroup
+	for i := range db.HashMap {
+		wg.Add(1)
+		go func(i int) {
+			var txs, outs, bogo, amount uint64
+			db.BrowseRecords(i, func(v *[]byte) bool {
+				var any bool
+				for _, out := range NewUtxoRec(*v).Outs {
+					if out != nil && InCoreSet(out) {
+						outs++
+						amount += out.Value
+						bogo += 32 + 4 + 4 + 8 + 2 + uint64(len(out.PKScr))
+						any = true
+					}
+				}
+				if any {
+					txs++
+				}
+				return true
+			})
+			atomic.AddUint64(&res.Txs, txs)
+			atomic.AddUint64(&res.TxOuts, outs)
+			atomic.AddUint64(&res.BogoSize, bogo)
+			atomic.AddUint64(&res.TotalAmount, amount)
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	if serialized {
+		res.HashSerialized = db.SnapshotHash()
+	}
+	return
+}
@@ -0,0 +1,83 @@
+package utxo
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+func muhashFromInt(i byte) []byte {
+	var tmp [32]byte
+	tmp[0] = i
+	return tmp[:]
+}
+
+func TestMuHash(t *testing.T) {
+	// test vector from Bitcoin Core's crypto_tests.cpp
+	m := NewMuHash()
+	m.Insert(muhashFromInt(0))
+	m.Insert(muhashFromInt(1))
+	m.Remove(muhashFromInt(2))
+	if res := btc.NewUint256(m.Finalize()).String(); res != "10d312b100cbd32ada024a6646e40d3482fcff103668d2625f10002a607d5863" {
+		t.Error("Bad result", res)
+	}
+}
+
+func TestMuHashBytes(t *testing.T) {
+	m := NewMuHash()
+	m.Insert(muhashFromInt(1))
+	m.Insert(muhashFromInt(2))
+	m.Remove(muhashFromInt(3))
+	m2 := NewMuHash()
+	m2.SetBytes(m.Bytes())
+	if !bytes.Equal(m.Finalize(), m2.Finalize()) {
+		t.Error("SetBytes/Bytes mismatch")
+	}
+	m2.Insert(muhashFromInt(3))
+	m2.Remove(muhashFromInt(1))
+	m2.Remove(muhashFromInt(2))
+	if !bytes.Equal(m2.Finalize(), NewMuHash().Finalize()) {
+		t.Error("Empty set expected")
+	}
+}
+
+func TestUnspentMuHash(t *testing.T) {
+	dir := t.TempDir() + string(os.PathSeparator)
+	db := NewUnspentDb(&NewUnspentOpts{Dir: dir, Rescan: true})
+	empty := db.MuHash()
+
+	rec1 := &UtxoRec{TxID: [32]byte{1}, InBlock: 1, Coinbase: true,
+		Outs: []*UtxoTxOut{{Value: 5000000000, PKScr: []byte{0x51}}, {Value: 0, PKScr: []byte{0x6a, 0x01, 0x02}}}}
+	rec2 := &UtxoRec{TxID: [32]byte{2}, InBlock: 1,
+		Outs: []*UtxoTxOut{{Value: 1000, PKScr: []byte{0x52}}, {Value: 2000, PKScr: []byte{0x53}}}}
+	db.CommitBlockTxs(&BlockChanges{Height: 1, AddList: []*UtxoRec{rec1, rec2}}, make([]byte, 32))
+	added := db.MuHash()
+	db.calcMuHash()
+	if !bytes.Equal(added, db.MuHash()) {
+		t.Error("MuHash mismatch after adding")
+	}
+
+	db.CommitBlockTxs(&BlockChanges{Height: 2, DeledTxs: map[[32]byte][]bool{rec2.TxID: {true, false}}}, make([]byte, 32))
+	deled := db.MuHash()
+	db.calcMuHash()
+	if !bytes.Equal(deled, db.MuHash()) {
+		t.Error("MuHash mismatch after deleting")
+	}
+	if bytes.Equal(deled, added) || bytes.Equal(deled, empty) {
+		t.Error("MuHash not changed")
+	}
+
+	info := db.GetTxOutSetInfo(false)
+	if info.Height != 2 || info.Txs != 2 || info.TxOuts != 2 || info.TotalAmount != 5000002000 {
+		t.Error("Bad info", info.Height, info.Txs, info.TxOuts, info.TotalAmount)
+	}
+	db.Close()
+
+	db = NewUnspentDb(&NewUnspentOpts{Dir: dir})
+	if db.LastBlockHeight != 2 || !bytes.Equal(deled, db.MuHash()) {
+		t.Error("MuHash not restored from disk")
+	}
+	db.Close()
+}
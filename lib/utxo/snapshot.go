@@ -0,0 +1,465 @@
+package utxo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"sync/atomic"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/script"
+)
+
+/*
+Bitcoin Core's UTXO set snapshot file (as made by "dumptxoutset" RPC):
+  [0:5] - "utxo\xff"
+  [5:7] - version (2)
+  [7:11] - network magic
+  [11:43] - hash of the block, the snapshot was taken at
+  [43:51] - number of coins that follow
+  And then, for each transaction:
+   [0:32] - TXID
+   var_len: number of its outputs (coins)
+   And for each output:
+    var_len: Output index
+    var_int: 2*BlockHeight + is_coinbase
+    var_int: Compressed value
+    var_int: Script type or (6 + length of not compressed script)
+    Script
+*/
+
+const SNAPSHOT_VERSION = 2
+
+var SnapshotMagic = []byte("utxo\xff")
+
+type SnapshotHeader struct {
+	Version    uint16
+	Network    [4]byte
+	BaseHash   *btc.Uint256
+	CoinsCount uint64
+}
+
+// ReadSnapshotHeader reads the header of Core's UTXO snapshot.
+func ReadSnapshotHeader(rd io.Reader) (hdr *SnapshotHeader, e error) {
+	var b [5 + 2 + 4 + 32 + 8]byte
+	if _, e = io.ReadFull(rd, b[:]); e != nil {
+		return
+	}
+	if !bytes.Equal(b[:5], SnapshotMagic) {
+		e = errors.New("not a UTXO snapshot file")
+		return
+	}
+	hdr = new(SnapshotHeader)
+	hdr.Version = binary.LittleEndian.Uint16(b[5:7])
+	if hdr.Version != SNAPSHOT_VERSION {
+		e = fmt.Errorf("unsupported UTXO snapshot version %d", hdr.Version)
+		return
+	}
+	copy(hdr.Network[:], b[7:11])
+	hdr.BaseHash = btc.NewUint256(b[11:43])
+	hdr.CoinsCount = binary.LittleEndian.Uint64(b[43:51])
+	return
+}
+
+// coinSer appends the output, serialized the way Core does it for hash_serialized_3 and MuHash.
+func coinSer(b []byte, txid []byte, vout uint32, height uint32, coinbase bool, out *UtxoTxOut) []byte {
+	var tmp [4 + 4 + 8]byte
+	b = append(b, txid...)
+	binary.LittleEndian.PutUint32(tmp[0:4], vout)
+	binary.LittleEndian.PutUint32(tmp[4:8], height<<1)
+	if coinbase {
+		tmp[4] |= 1
+	}
+	binary.LittleEndian.PutUint64(tmp[8:16], out.Value)
+	b = append(b, tmp[:]...)
+	var vl [9]byte
+	b = append(b, vl[:btc.PutULe(vl[:], uint64(len(out.PKScr)))]...)
+	return append(b, out.PKScr...)
+}
+
+// hashCoin adds one output to hash_serialized_3 calculation.
+func hashCoin(sha hash.Hash, txid []byte, vout uint32, height uint32, coinbase bool, out *UtxoTxOut) {
+	var buf [0x100]byte
+	sha.Write(coinSer(buf[:0], txid, vout, height, coinbase, out))
+}
+
+// InCoreSet returns false for the outputs that Core does not keep in its UTXO set
+// (OP_RETURN or too long scripts), so they are not included in the set's hashes and stats.
+func InCoreSet(out *UtxoTxOut) bool {
+	return !(len(out.PKScr) > 0 && out.PKScr[0] == 0x6a) && len(out.PKScr) <= script.MAX_SCRIPT_SIZE
+}
+
+// readSnapshotTx reads all the coins of one transaction from Core's UTXO snapshot.
+func readSnapshotTx(rd *bufio.Reader, sha hash.Hash, coins_left uint64) (rec *UtxoRec, cnt uint64, e error) {
+	var buf [1 + 32]byte
+	rec = new(UtxoRec)
+	if _, e = io.ReadFull(rd, rec.TxID[:]); e != nil {
+		return
+	}
+	if cnt, e = btc.ReadVLen(rd); e != nil {
+		return
+	}
+	if cnt == 0 || cnt > coins_left {
+		e = fmt.Errorf("bad number of coins (%d) for tx %s", cnt, btc.NewUint256(rec.TxID[:]).String())
+		return
+	}
+	for i := uint64(0); i < cnt; i++ {
+		var vout, code, val, typ uint64
+		var scr []byte
+		if vout, e = btc.ReadVLen(rd); e != nil {
+			return
+		}
+		if code, e = btc.ReadVarInt(rd); e != nil {
+			return
+		}
+		if val, e = btc.ReadVarInt(rd); e != nil {
+			return
+		}
+		if typ, e = btc.ReadVarInt(rd); e != nil {
+			return
+		}
+		if typ < script.SPECIAL_SCRIPTS_COUNT {
+			compr := buf[:1+script.GetSpecialScriptSize(int(typ))]
+			compr[0] = byte(typ)
+			if _, e = io.ReadFull(rd, compr[1:]); e != nil {
+				return
+			}
+			scr = script.DecompressScript(compr)
+		} else {
+			if typ -= script.SPECIAL_SCRIPTS_COUNT; typ > btc.MAX_BLOCK_WEIGHT {
+				e = fmt.Errorf("script too long (%d) in tx %s", typ, btc.NewUint256(rec.TxID[:]).String())
+				return
+			}
+			scr = make([]byte, typ)
+			if _, e = io.ReadFull(rd, scr); e != nil {
+				return
+			}
+		}
+
+		if i == 0 {
+			rec.InBlock = uint32(code >> 1)
+			rec.Coinbase = (code & 1) != 0
+		} else if rec.InBlock != uint32(code>>1) || rec.Coinbase != ((code&1) != 0) {
+			e = fmt.Errorf("inconsistent coins of tx %s", btc.NewUint256(rec.TxID[:]).String())
+			return
+		}
+		if vout >= uint64(len(rec.Outs)) {
+			if vout >= 0x100000 {
+				e = fmt.Errorf("output index %d too high in tx %s", vout, btc.NewUint256(rec.TxID[:]).String())
+				return
+			}
+			rec.Outs = append(rec.Outs, make([]*UtxoTxOut, int(vout)+1-len(rec.Outs))...)
+		}
+		if rec.Outs[vout] != nil {
+			e = fmt.Errorf("duplicate coin %s-%d", btc.NewUint256(rec.TxID[:]).String(), vout)
+			return
+		}
+		rec.Outs[vout] = &UtxoTxOut{Value: btc.DecompressAmount(val), PKScr: scr}
+		hashCoin(sha, rec.TxID[:], uint32(vout), rec.InBlock, rec.Coinbase, rec.Outs[vout])
+	}
+	return
+}
+
+// ImportSnapshot replaces the content of the database with the UTXO set from Core's snapshot.
+// The snapshot's header must have already been read from rd (see ReadSnapshotHeader).
+// height is the height of the snapshot's base block. The undo data gets removed.
+// It returns hash_serialized_3 of the imported set (the value that Core's "dumptxoutset" reports).
+// The callbacks (CB) are not being called.
+// In disk mode the records go to a new folder, which replaces the database's one at the end.
+func (db *UnspentDB) ImportSnapshot(rd *bufio.Reader, hdr *SnapshotHeader, height uint32, abort *bool) (hash []byte, e error) {
+	var hmap [256](map[UtxoKeyType]*[]byte)
+	var data_size, txs_cnt int64
+	var perc uint64
+	var tmp *diskStore
+	var mh *MuHash
+
+	ser := SerializeU
+	if db.ComprssedUTXO {
+		ser = SerializeC
+	}
+	dir := db.dir_utxo + DISK_DIR + string(os.PathSeparator)
+	tmp_dir := db.dir_utxo + DISK_DIR + ".new" + string(os.PathSeparator)
+	if db.disk != nil {
+		os.RemoveAll(tmp_dir)
+		if tmp, e = openDiskStore(tmp_dir, 0); e != nil {
+			return
+		}
+		mh = NewMuHash()
+	} else {
+		for i := range hmap {
+			hmap[i] = make(map[UtxoKeyType]*[]byte, int(hdr.CoinsCount/2/256))
+		}
+	}
+	free_all := func() {
+		if tmp != nil {
+			tmp.close()
+			os.RemoveAll(tmp_dir)
+			return
+		}
+		for i := range hmap {
+			for _, v := range hmap[i] {
+				Memory_Free(v)
+			}
+		}
+	}
+
+	sha := sha256.New()
+	for coins := uint64(0); coins < hdr.CoinsCount; {
+		if abort != nil && *abort {
+			e = errors.New("aborted")
+			free_all()
+			return
+		}
+		rec, cnt, er := readSnapshotTx(rd, sha, hdr.CoinsCount-coins)
+		if er != nil {
+			e = er
+			free_all()
+			return
+		}
+		coins += cnt
+
+		var ind UtxoKeyType
+		copy(ind[:], rec.TxID[:])
+		if _, ok := hmap[ind[0]][ind]; ok || tmp != nil && tmp.has(ind) {
+			e = fmt.Errorf("duplicate UTXO key for tx %s", btc.NewUint256(rec.TxID[:]).String())
+			free_all()
+			return
+		}
+		v := ser(rec, nil)
+		data_size += int64(len(*v))
+		if tmp != nil {
+			tmp.put(ind, *v, false)
+			mh.updateRec(rec, nil, false)
+			Memory_Free(v)
+		} else {
+			hmap[ind[0]][ind] = v
+		}
+		txs_cnt++
+
+		if p := 100 * coins / hdr.CoinsCount; p != perc {
+			perc = p
+			fmt.Print("\rImporting UTXO snapshot - ", perc, "% complete ... ")
+		}
+	}
+	fmt.Print("\r                                                                 \r")
+	if _, er := rd.ReadByte(); er != io.EOF {
+		e = errors.New("unexpected data after the last coin")
+		free_all()
+		return
+	}
+	hash = sha.Sum(nil)
+	sha.Reset()
+	sha.Write(hash)
+	hash = sha.Sum(nil)
+	if tmp != nil {
+		tmp.commit(&diskState{Height: height, Compressed: db.ComprssedUTXO,
+			Hash: hdr.BaseHash.Hash[:], MuHash: mh.Bytes()})
+	}
+
+	db.Mutex.Lock()
+	db.abortWriting()
+	if tmp != nil {
+		db.disk.close()
+		os.RemoveAll(dir)
+		if e = tmp.moveTo(dir); e != nil {
+			panic("UTXO disk: " + e.Error())
+		}
+		tmp.cacheMax = db.disk.cacheMax
+		db.disk = tmp
+	} else {
+		for i := range db.HashMap {
+			db.MapMutex[i].Lock()
+			for _, v := range db.HashMap[i] {
+				Memory_Free(v)
+			}
+			db.HashMap[i] = hmap[i]
+			db.DeletedRecords[i] = 0
+			db.MapMutex[i].Unlock()
+		}
+	}
+	db.dataSize.Store(data_size)
+	db.totalTxs.Store(txs_cnt)
+	db.LastBlockHash = make([]byte, 32)
+	copy(db.LastBlockHash, hdr.BaseHash.Hash[:])
+	db.LastBlockHeight = height
+	atomic.StoreUint32(&db.CurrentHeightOnDisk, 0)
+	os.RemoveAll(db.dir_undo)
+	db.undo_dir_created = false
+	if db.ComprssedUTXO {
+		NewUtxoRecOwn = NewUtxoRecOwnC
+		OneUtxoRec = OneUtxoRecC
+		Serialize = SerializeC
+	}
+	if tmp != nil {
+		db.muMutex.Lock()
+		db.muhash = mh
+		db.muMutex.Unlock()
+		atomic.StoreUint32(&db.CurrentHeightOnDisk, height) // already committed
+		db.DirtyDB.Clr()
+	} else {
+		db.calcMuHash()
+		db.DirtyDB.Set()
+		db.journalReset() // the journal cannot lead to this state from UTXO.db
+	}
+	db.Mutex.Unlock()
+	return
+}
+
+// SnapshotHash returns hash_serialized_3 of the UTXO set (as calculated by Core's "gettxoutsetinfo").
+// It needs to sort all the records, so it takes a while. Do not modify the database in the meantime.
+func (db *UnspentDB) SnapshotHash() []byte {
+	sha := sha256.New()
+	db.BrowseSorted(func(rec *UtxoRec) {
+		for vout, out := range rec.Outs {
+			if out != nil && InCoreSet(out) {
+				hashCoin(sha, rec.TxID[:], uint32(vout), rec.InBlock, rec.Coinbase, out)
+			}
+		}
+	})
+	hash := sha.Sum(nil)
+	sha.Reset()
+	sha.Write(hash)
+	return sha.Sum(nil)
+}
+
+// rlockAll locks all the maps for reading.
+func (db *UnspentDB) rlockAll() {
+	for i := range db.MapMutex {
+		db.MapMutex[i].RLock()
+	}
+}
+
+func (db *UnspentDB) runlockAll() {
+	for i := range db.MapMutex {
+		db.MapMutex[i].RUnlock()
+	}
+}
+
+// sortedKeys returns the keys of all the records, sorted by TXIDs' bytes
+// (the key is the beginning of TXID). Call it with all the maps locked.
+func (db *UnspentDB) sortedKeys() (keys []UtxoKeyType) {
+	if db.disk != nil {
+		keys = db.disk.keys()
+	} else {
+		keys = make([]UtxoKeyType, 0, db.totalTxs.Load())
+		for i := range db.HashMap {
+			for k := range db.HashMap[i] {
+				keys = append(keys, k)
+			}
+		}
+	}
+	slices.SortFunc(keys, func(a, b UtxoKeyType) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	return
+}
+
+// peekRecord returns the record with the given key. Call it with all the maps locked.
+// In disk mode the record is not being cached.
+func (db *UnspentDB) peekRecord(k UtxoKeyType) []byte {
+	if db.disk != nil {
+		return db.disk.get(k, false)
+	}
+	return *db.HashMap[k[0]][k]
+}
+
+// BrowseSorted calls walk for each record of the database, in the order of TXIDs' bytes
+// (the way they are stored in Core's chainstate). The record passed to walk is static.
+func (db *UnspentDB) BrowseSorted(walk FunctionWalkUnspent) {
+	db.rlockAll()
+	defer db.runlockAll()
+	for _, k := range db.sortedKeys() {
+		walk(NewUtxoRecStatic(db.peekRecord(k)))
+	}
+}
+
+// ExportSnapshot writes the UTXO set to wr, in the format of Core's snapshot (see ReadSnapshotHeader).
+// New blocks cannot be committed to the database until it returns.
+// It returns the snapshot's header and its hash_serialized_3.
+func (db *UnspentDB) ExportSnapshot(wr io.Writer, magic [4]byte, abort *bool) (hdr *SnapshotHeader, hash []byte, e error) {
+	var b [5 + 2 + 4 + 32 + 8]byte
+	var perc uint64
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	db.rlockAll()
+	defer db.runlockAll()
+
+	hdr = &SnapshotHeader{Version: SNAPSHOT_VERSION, Network: magic, BaseHash: btc.NewUint256(db.LastBlockHash)}
+	recs := db.sortedKeys()
+	for _, k := range recs {
+		for _, out := range NewUtxoRecStatic(db.peekRecord(k)).Outs {
+			if out != nil && InCoreSet(out) {
+				hdr.CoinsCount++
+			}
+		}
+	}
+
+	copy(b[:5], SnapshotMagic)
+	binary.LittleEndian.PutUint16(b[5:7], hdr.Version)
+	copy(b[7:11], hdr.Network[:])
+	copy(b[11:43], hdr.BaseHash.Hash[:])
+	binary.LittleEndian.PutUint64(b[43:51], hdr.CoinsCount)
+	bw := bufio.NewWriterSize(wr, 0x100000)
+	bw.Write(b[:])
+
+	sha := sha256.New()
+	for i, k := range recs {
+		if abort != nil && *abort {
+			e = errors.New("aborted")
+			return
+		}
+		rec := NewUtxoRecStatic(db.peekRecord(k))
+		var cnt uint64
+		for _, out := range rec.Outs {
+			if out != nil && InCoreSet(out) {
+				cnt++
+			}
+		}
+		if cnt == 0 {
+			continue
+		}
+		bw.Write(rec.TxID[:])
+		btc.WriteVlen(bw, cnt)
+		code := uint64(rec.InBlock) << 1
+		if rec.Coinbase {
+			code |= 1
+		}
+		for vout, out := range rec.Outs {
+			if out == nil || !InCoreSet(out) {
+				continue
+			}
+			btc.WriteVlen(bw, uint64(vout))
+			btc.WriteVarInt(bw, code)
+			btc.WriteVarInt(bw, btc.CompressAmount(out.Value))
+			if compr := script.CompressScript(out.PKScr); compr != nil {
+				bw.Write(compr)
+			} else {
+				btc.WriteVarInt(bw, uint64(len(out.PKScr)+script.SPECIAL_SCRIPTS_COUNT))
+				bw.Write(out.PKScr)
+			}
+			hashCoin(sha, rec.TxID[:], uint32(vout), rec.InBlock, rec.Coinbase, out)
+		}
+
+		if p := 100 * uint64(i+1) / uint64(len(recs)); p != perc {
+			perc = p
+			fmt.Print("\rExporting UTXO snapshot - ", perc, "% complete ... ")
+		}
+	}
+	fmt.Print("\r                                                                 \r")
+	if e = bw.Flush(); e != nil {
+		return
+	}
+	hash = sha.Sum(nil)
+	sha.Reset()
+	sha.Write(hash)
+	hash = sha.Sum(nil)
+	return
+}
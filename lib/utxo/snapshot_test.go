@@ -0,0 +1,157 @@
+package utxo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+type testCoin struct {
+	vout, height uint32
+	coinbase     bool
+	value        uint64
+	script       string
+}
+
+func testSnapshotFile(base []byte, txs [][32]byte, coins [][]testCoin, trailer []byte) []byte {
+	var cnt uint64
+	for _, c := range coins {
+		cnt += uint64(len(c))
+	}
+	buf := new(bytes.Buffer)
+	wr := bufio.NewWriter(buf)
+	wr.Write(SnapshotMagic)
+	binary.Write(wr, binary.LittleEndian, uint16(SNAPSHOT_VERSION))
+	wr.Write([]byte{0xf9, 0xbe, 0xb4, 0xd9})
+	wr.Write(base)
+	binary.Write(wr, binary.LittleEndian, cnt)
+	for i := range txs {
+		wr.Write(txs[i][:])
+		btc.WriteVlen(wr, uint64(len(coins[i])))
+		for _, c := range coins[i] {
+			btc.WriteVlen(wr, uint64(c.vout))
+			code := uint64(c.height) << 1
+			if c.coinbase {
+				code |= 1
+			}
+			btc.WriteVarInt(wr, code)
+			btc.WriteVarInt(wr, btc.CompressAmount(c.value))
+			scr, _ := hex.DecodeString(c.script)
+			if len(scr) == 25 && scr[0] == 0x76 && scr[1] == 0xa9 && scr[2] == 0x14 {
+				btc.WriteVarInt(wr, 0) // P2KH
+				wr.Write(scr[3:23])
+			} else {
+				btc.WriteVarInt(wr, uint64(len(scr)+6))
+				wr.Write(scr)
+			}
+		}
+	}
+	wr.Write(trailer)
+	wr.Flush()
+	return buf.Bytes()
+}
+
+func TestImportSnapshot(t *testing.T) {
+	base := make([]byte, 32)
+	base[0] = 0xaa
+	txs := [][32]byte{{0x01}, {0x02}}
+	coins := [][]testCoin{
+		{
+			{vout: 0, height: 1000, value: 5000, script: "76a914a25dec4d0011064ef106a983c39c7a540699f22088ac"},
+			{vout: 2, height: 1000, value: 546, script: "00147938e8a1dba0bcd9f9d3b5b7ff4a6cb8ef1a1a7d"},
+		},
+		{
+			{vout: 0, height: 100, coinbase: true, value: 5000000000, script: "51"},
+		},
+	}
+
+	raw := testSnapshotFile(base, txs, coins, nil)
+	rd := bufio.NewReader(bytes.NewReader(raw))
+	hdr, er := ReadSnapshotHeader(rd)
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	if hdr.CoinsCount != 3 || !bytes.Equal(hdr.BaseHash.Hash[:], base) {
+		t.Fatal("bad header", hdr.CoinsCount, hdr.BaseHash.String())
+	}
+
+	db := NewUnspentDb(&NewUnspentOpts{Dir: t.TempDir() + string(os.PathSeparator), Rescan: true})
+	hash, er := db.ImportSnapshot(rd, hdr, 1234, nil)
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	if db.LastBlockHeight != 1234 || !bytes.Equal(db.LastBlockHash, base) {
+		t.Error("last block not set")
+	}
+	if !bytes.Equal(hash, db.SnapshotHash()) {
+		t.Error("SnapshotHash mismatch")
+	}
+
+	for i := range txs {
+		for _, c := range coins[i] {
+			po := &btc.TxPrevOut{Hash: txs[i], Vout: c.vout}
+			out := db.UnspentGet(po)
+			if out == nil {
+				t.Error("coin missing", i, c.vout)
+				continue
+			}
+			if out.Value != c.value || hex.EncodeToString(out.Pk_script) != c.script ||
+				out.BlockHeight != c.height || out.WasCoinbase != c.coinbase {
+				t.Error("coin mismatch", i, c.vout)
+			}
+		}
+	}
+	if db.UnspentGet(&btc.TxPrevOut{Hash: txs[0], Vout: 1}) != nil {
+		t.Error("unexpected coin")
+	}
+
+	out := new(bytes.Buffer)
+	xhdr, xhash, er := db.ExportSnapshot(out, hdr.Network, nil)
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	if xhdr.CoinsCount != hdr.CoinsCount || !xhdr.BaseHash.Equal(hdr.BaseHash) {
+		t.Error("exported header mismatch")
+	}
+	if !bytes.Equal(xhash, hash) {
+		t.Error("exported hash mismatch")
+	}
+	if !bytes.Equal(out.Bytes(), raw) {
+		t.Error("exported snapshot differs from the imported one")
+	}
+	db.Close()
+}
+
+func TestImportSnapshotErrors(t *testing.T) {
+	base := make([]byte, 32)
+	txs := [][32]byte{{0x01}}
+	for _, tc := range []struct {
+		name    string
+		coins   []testCoin
+		trailer []byte
+	}{
+		{"duplicate", []testCoin{{vout: 1, value: 1, script: "51"}, {vout: 1, value: 1, script: "51"}}, nil},
+		{"inconsistent", []testCoin{{vout: 0, height: 1, value: 1, script: "51"}, {vout: 1, height: 2, value: 1, script: "51"}}, nil},
+		{"trailer", []testCoin{{vout: 0, value: 1, script: "51"}}, []byte{0}},
+	} {
+		raw := testSnapshotFile(base, txs, [][]testCoin{tc.coins}, tc.trailer)
+		rd := bufio.NewReader(bytes.NewReader(raw))
+		hdr, er := ReadSnapshotHeader(rd)
+		if er != nil {
+			t.Fatal(tc.name, er.Error())
+		}
+		db := NewUnspentDb(&NewUnspentOpts{Dir: t.TempDir() + string(os.PathSeparator), Rescan: true})
+		if _, er = db.ImportSnapshot(rd, hdr, 1, nil); er == nil {
+			t.Error(tc.name, "error expected")
+		}
+		if db.LastBlockHeight != 0 {
+			t.Error(tc.name, "database modified")
+		}
+		db.Close()
+	}
+}
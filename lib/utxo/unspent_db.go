@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -84,6 +85,14 @@ type UnspentDB struct {
 	recRelocateCnt  atomic.Int64
 	dataSize        atomic.Int64
 	totalTxs        atomic.Int64
+
+	muhash  *MuHash // of all the outputs (in Core's UTXO set terms)
+	muMutex sync.Mutex
+
+	disk     *diskStore // not nil in disk mode (HashMap is not used then)
+	diskLeft bool       // the records have been loaded from the disk mode's folder (remove it after saving)
+
+	jrn *journal // not nil if the changes are being journaled (see journal.go)
 }
 
 type NewUnspentOpts struct {
@@ -93,6 +102,9 @@ type NewUnspentOpts struct {
 	Rescan          bool
 	VolatimeMode    bool
 	CompressRecords bool
+	DiskMode        bool // keep the records on disk, with only their index in memory
+	DiskCache       int  // in disk mode, cache this many bytes of the recently used records
+	Journal         bool // journal the changes in UTXO.jrn, so UTXO.db does not need to be saved that often (ignored in DiskMode)
 }
 
 func NewUnspentDb(opts *NewUnspentOpts) (db *UnspentDB) {
@@ -114,7 +126,23 @@ func NewUnspentDb(opts *NewUnspentOpts) (db *UnspentDB) {
 	}
 
 	db.ComprssedUTXO = opts.CompressRecords
+	db.muhash = NewMuHash()
+	if opts.DiskMode {
+		if opts.Journal {
+			println("WARNING: UTXO journal is not used in disk mode (it commits each block's changes itself)")
+		}
+		db.openDisk(opts)
+		return
+	}
+	if opts.Journal && !opts.VolatimeMode {
+		defer func() {
+			if opts.AbortNow == nil || !*opts.AbortNow {
+				db.openJournal()
+			}
+		}()
+	}
 	if opts.Rescan {
+		os.Remove(db.dir_utxo + JOURNAL_FILE_NAME)
 		for i := range db.HashMap {
 			db.HashMap[i] = make(map[UtxoKeyType]*[]byte, 100e3)
 		}
@@ -142,8 +170,12 @@ func NewUnspentDb(opts *NewUnspentOpts) (db *UnspentDB) {
 	var recpool [BUFFERS_CNT][RECS_PACK_SIZE]one_rec
 	var ch chan []one_rec
 	var recs []one_rec
+	var tmp_muhash [MUHASH_SIZE]byte
 
 	fname := "UTXO.db"
+	if _, er := os.Stat(db.dir_utxo + fname); os.IsNotExist(er) && db.loadFromDisk() {
+		return
+	}
 
 redo:
 	of, er := os.Open(db.dir_utxo + fname)
@@ -243,9 +275,19 @@ redo:
 	}
 	ch <- nil
 	wg.Wait()
+
+	// MuHash follows the records (not present in the files written by older versions)
+	if _, er = io.ReadFull(rd, tmp_muhash[:]); er == nil {
+		db.muhash.SetBytes(tmp_muhash[:])
+	}
 	of.Close()
 
 	fmt.Print("\r                                                                 \r")
+	if er != nil && (opts.AbortNow == nil || !*opts.AbortNow) {
+		fmt.Print("Calculating MuHash of the UTXO set ... ")
+		db.calcMuHash()
+		fmt.Print("\r                                                                 \r")
+	}
 
 	atomic.StoreUint32(&db.CurrentHeightOnDisk, db.LastBlockHeight)
 	if db.ComprssedUTXO {
@@ -271,6 +313,7 @@ fatal_error:
 	for i := range db.HashMap {
 		db.HashMap[i] = make(map[UtxoKeyType]*[]byte, 100e3)
 	}
+	db.muhash = NewMuHash()
 
 	return
 }
@@ -301,6 +344,11 @@ func (db *UnspentDB) save() {
 	binary.Write(buf, binary.LittleEndian, u64)
 	buf.Write(db.LastBlockHash)
 	binary.Write(buf, binary.LittleEndian, uint64(total_records))
+	db.muMutex.Lock()
+	muhash := db.muhash.Bytes()
+	db.muMutex.Unlock()
+	jrn, height, compressed := db.jrn, db.LastBlockHeight, db.ComprssedUTXO
+	hash := append([]byte{}, db.LastBlockHash...)
 
 	// The data is written in a separate process
 	// so we can abort without waiting for disk.
@@ -346,6 +394,15 @@ func (db *UnspentDB) save() {
 			of.Flush()
 			of_.Close()
 			os.Rename(fname, db.dir_utxo+"UTXO.db")
+			if jrn != nil {
+				if er := jrn.compact(height, compressed, hash); er != nil {
+					println("UTXO journal compact:", er.Error())
+				}
+			}
+			if db.diskLeft {
+				os.RemoveAll(db.dir_utxo + DISK_DIR)
+				db.diskLeft = false
+			}
 		}
 		db.lastFileClosed.Done()
 	}(db.dir_utxo + btc.NewUint256(db.LastBlockHash).String() + ".db.tmp")
@@ -399,7 +456,8 @@ func (db *UnspentDB) save() {
 	}
 finito:
 
-	if !abort && buf.Len() > 0 {
+	if !abort {
+		buf.Write(muhash)
 		data_channel <- buf.Bytes()
 	}
 	exit_channel <- abort
@@ -446,6 +504,10 @@ func (db *UnspentDB) CommitBlockTxs(changes *BlockChanges, blhash []byte) (e err
 		}()
 	}
 
+	var jrn_data []byte
+	if db.jrn != nil {
+		jrn_data = journalBlock(changes)
+	}
 	db.commit(changes)
 
 	if db.LastBlockHash == nil {
@@ -459,11 +521,20 @@ func (db *UnspentDB) CommitBlockTxs(changes *BlockChanges, blhash []byte) (e err
 	}
 
 	db.DirtyDB.Set()
+	if db.disk != nil {
+		db.diskCommit(false)
+	}
 	wg.Wait()
+	if db.jrn != nil {
+		db.journalAppend(jrnBlockAdded, jrn_data) // after the undo file, which it relies on
+	}
 	return
 }
 
 func (db *UnspentDB) Relocate(oldRec, newRec *[]byte) {
+	if db.disk != nil {
+		return // the records are not kept in the memory allocated by Memory_Malloc
+	}
 	var ind UtxoKeyType
 	copy(ind[:], *oldRec)
 	db.MapMutex[ind[0]].Lock()
@@ -474,7 +545,7 @@ func (db *UnspentDB) Relocate(oldRec, newRec *[]byte) {
 
 // Only call it from the main thread
 func (db *UnspentDB) DefragMap(force bool) {
-	if db.WritingInProgress.Get() {
+	if db.disk != nil || db.WritingInProgress.Get() {
 		return
 	}
 	//db.Mutex.Lock()
@@ -502,58 +573,105 @@ func (db *UnspentDB) UndoBlockTxs(bl *btc.Block, newhash []byte) {
 	defer db.Mutex.Unlock()
 	db.abortWriting()
 
+	mh := NewMuHash()
+	keys := make([]UtxoKeyType, len(bl.Txs))
+	for i, tx := range bl.Txs {
+		copy(keys[i][:], tx.Hash.Hash[:])
+	}
 	// first we have to delete all bl.Txs from our set
 	if db.CB.NotifyTxDel == nil {
 		// if we don't need to notify the wallet, we can do it quicker
-		for _, tx := range bl.Txs {
-			var ind UtxoKeyType
-			copy(ind[:], tx.Hash.Hash[:])
-			db.MapMutex[ind[0]].Lock()
-			delete(db.HashMap[ind[0]], ind)
-			db.DeletedRecords[ind[0]]++
-			db.MapMutex[ind[0]].Unlock()
-		}
+		db.undoDel(keys, mh)
 	} else {
 		// otherwise do it the slow way, using db.del()
 		outs := make([]bool, 0, 0x10000)
-		var ind UtxoKeyType
-		for _, tx := range bl.Txs {
+		for i, tx := range bl.Txs {
 			for len(outs) < len(tx.TxOut) {
 				outs = append(outs, true)
 			}
-			copy(ind[:], tx.Hash.Hash[:])
-			db.del(ind, outs[:len(tx.TxOut)])
+			db.del(keys[i], outs[:len(tx.TxOut)], mh)
 		}
 	}
 
-	fn := fmt.Sprint(db.dir_undo, db.LastBlockHeight)
-	var addback []*UtxoRec
-
-	dat, er := os.ReadFile(fn)
+	_, addback, er := db.UndoData(db.LastBlockHeight)
 	if er != nil {
 		panic(er.Error())
 	}
 
-	off := 32 // ship the block hash
+	var jrn_data []byte
+	if db.jrn != nil {
+		jrn_data = journalUndo(keys, addback)
+	}
+	db.undoAdd(addback, mh)
+
+	db.muhashCombine(mh)
+
+	//os.Remove(fn) - it may crash while test-doing the undo, so we keep this file just in case
+	db.LastBlockHeight--
+	copy(db.LastBlockHash, newhash)
+	db.DirtyDB.Set()
+	if db.disk != nil {
+		db.diskCommit(false)
+	}
+	if db.jrn != nil {
+		db.journalAppend(jrnBlockUndone, jrn_data)
+	}
+}
+
+// UndoData reads the undo file of the block at the given height.
+// It returns the block's hash and the records of the outputs that the block spent
+// (with only the spent outputs set).
+func (db *UnspentDB) UndoData(height uint32) (blhash []byte, recs []*UtxoRec, e error) {
+	var dat []byte
+	if dat, e = os.ReadFile(fmt.Sprint(db.dir_undo, height)); e != nil {
+		return
+	}
+	if len(dat) < 32 {
+		e = errors.New("undo file too short")
+		return
+	}
+	blhash = dat[:32]
+	off := 32 // skip the block hash
 	for off < len(dat) {
 		le, n := btc.VLen(dat[off:])
+		if n == 0 || off+n+le > len(dat) {
+			e = errors.New("undo file corrupt")
+			return
+		}
 		off += n
-		qr := FullUtxoRec(dat[off : off+le])
+		recs = append(recs, FullUtxoRec(dat[off:off+le]))
 		off += le
-		addback = append(addback, qr)
 	}
+	return
+}
+
+// UndoAvailable returns true if the undo data of the block at the given height is on disk.
+func (db *UnspentDB) UndoAvailable(height uint32) bool {
+	_, er := os.Stat(fmt.Sprint(db.dir_undo, height))
+	return er == nil
+}
+
+// undoDel removes the records of the undone block's transactions.
+func (db *UnspentDB) undoDel(keys []UtxoKeyType, mh *MuHash) {
+	for _, ind := range keys {
+		if v := db.GetRecord(ind); v != nil {
+			mh.updateRec(NewUtxoRec(*v), nil, true)
+		}
+		db.recDel(ind)
+	}
+}
 
+// undoAdd restores the outputs spent by the undone block.
+func (db *UnspentDB) undoAdd(addback []*UtxoRec, mh *MuHash) {
 	for _, rec := range addback {
 		if db.CB.NotifyTxAdd != nil {
 			db.CB.NotifyTxAdd(rec)
 		}
+		mh.updateRec(rec, nil, false)
 
 		var ind UtxoKeyType
 		copy(ind[:], rec.TxID[:])
-		db.MapMutex[ind[0]].RLock()
-		v := db.HashMap[ind[0]][ind]
-		db.MapMutex[ind[0]].RUnlock()
-		if v != nil {
+		if v := db.GetRecord(ind); v != nil {
 			oldrec := NewUtxoRec(*v)
 			for a := range rec.Outs {
 				if rec.Outs[a] == nil {
@@ -561,15 +679,8 @@ func (db *UnspentDB) UndoBlockTxs(bl *btc.Block, newhash []byte) {
 				}
 			}
 		}
-		db.MapMutex[ind[0]].Lock()
-		db.HashMap[ind[0]][ind] = Serialize(rec, nil)
-		db.MapMutex[ind[0]].Unlock()
+		db.recSet(ind, Serialize(rec, nil))
 	}
-
-	//os.Remove(fn) - it may crash while test-doing the undo, so we keep this file just in case
-	db.LastBlockHeight--
-	copy(db.LastBlockHash, newhash)
-	db.DirtyDB.Set()
 }
 
 // Idle should be called when the main thread is idle to trigger UTXO.db saving
@@ -582,13 +693,30 @@ func (db *UnspentDB) Idle() bool {
 	defer db.Mutex.Unlock()
 
 	if db.DirtyDB.Get() && db.LastBlockHeight-atomic.LoadUint32(&db.CurrentHeightOnDisk) > UTXO_SKIP_SAVE_BLOCKS {
+		if db.disk != nil {
+			db.diskCommit(true)
+			return true
+		}
+		if db.jrn != nil && db.jrn.length() < UTXO_JOURNAL_MAX_SIZE {
+			return false // the changes are safe in the journal
+		}
 		return db.Save()
 	}
 
 	return false
 }
 
+// Save starts writing UTXO.db in the background.
+// In disk mode it commits the pending changes instead (do not call it with the mutex locked then).
 func (db *UnspentDB) Save() bool {
+	if db.disk != nil {
+		db.Mutex.Lock()
+		if db.DirtyDB.Get() {
+			db.diskCommit(true)
+		}
+		db.Mutex.Unlock()
+		return true
+	}
 	if db.WritingInProgress.Get() {
 		return false
 	}
@@ -607,45 +735,106 @@ func (db *UnspentDB) HurryUp() {
 
 // Close flushes the data and closes all the files.
 func (db *UnspentDB) Close() {
+	if db.disk != nil {
+		db.Save()
+		db.disk.close()
+		return
+	}
 	db.volatimemode = false
-	if db.DirtyDB.Get() {
+	if db.DirtyDB.Get() && db.jrn == nil {
 		db.HurryUp()
 		db.Save()
+	} else if db.WritingInProgress.Get() {
+		db.HurryUp()
 	}
 	db.writingDone.Wait()
 	db.lastFileClosed.Wait()
+	if db.jrn != nil {
+		db.jrn.close()
+	}
 }
 
 // UnspentGet gets the given unspent output.
 func (db *UnspentDB) UnspentGet(po *btc.TxPrevOut) (res *btc.TxOut) {
 	var ind UtxoKeyType
-	var v *[]byte
 	copy(ind[:], po.Hash[:])
+	if v := db.GetRecord(ind); v != nil {
+		res = OneUtxoRec(*v, po.Vout)
+	}
+
+	return
+}
 
+// GetRecord returns the serialized record with the given key (nil if there is no such).
+// The returned data must not be modified.
+func (db *UnspentDB) GetRecord(ind UtxoKeyType) (v *[]byte) {
+	if db.disk != nil {
+		if b := db.disk.get(ind, true); b != nil {
+			v = &b
+		}
+		return
+	}
 	db.MapMutex[ind[0]].RLock()
 	v = db.HashMap[ind[0]][ind]
 	db.MapMutex[ind[0]].RUnlock()
-	if v != nil {
-		res = OneUtxoRec(*v, po.Vout)
+	return
+}
+
+// BrowseRecords calls walk for each serialized record with the key starting from byte i,
+// until it returns false. The database must not be modified from within walk.
+func (db *UnspentDB) BrowseRecords(i int, walk func(v *[]byte) bool) {
+	if db.disk != nil {
+		db.disk.browse(i, func(v []byte) bool {
+			return walk(&v)
+		})
+		return
 	}
+	db.MapMutex[i].RLock()
+	defer db.MapMutex[i].RUnlock()
+	for _, v := range db.HashMap[i] {
+		if !walk(v) {
+			return
+		}
+	}
+}
 
-	return
+func (db *UnspentDB) recSet(ind UtxoKeyType, v *[]byte) {
+	if db.disk != nil {
+		db.disk.put(ind, *v, true)
+		Memory_Free(v)
+		return
+	}
+	db.MapMutex[ind[0]].Lock()
+	db.HashMap[ind[0]][ind] = v
+	db.MapMutex[ind[0]].Unlock()
+}
+
+func (db *UnspentDB) recDel(ind UtxoKeyType) {
+	if db.disk != nil {
+		db.disk.del(ind)
+		return
+	}
+	db.MapMutex[ind[0]].Lock()
+	delete(db.HashMap[ind[0]], ind)
+	db.DeletedRecords[ind[0]]++
+	db.MapMutex[ind[0]].Unlock()
 }
 
 // TxPresent returns true if gived TXID is in UTXO.
 func (db *UnspentDB) TxPresent(id *btc.Uint256) (res bool) {
 	var ind UtxoKeyType
 	copy(ind[:], id.Hash[:])
+	if db.disk != nil {
+		return db.disk.has(ind)
+	}
 	db.MapMutex[ind[0]].RLock()
 	_, res = db.HashMap[ind[0]][ind]
 	db.MapMutex[ind[0]].RUnlock()
 	return
 }
 
-func (db *UnspentDB) del(ind UtxoKeyType, outs []bool) {
-	db.MapMutex[ind[0]].RLock()
-	v := db.HashMap[ind[0]][ind]
-	db.MapMutex[ind[0]].RUnlock()
+func (db *UnspentDB) del(ind UtxoKeyType, outs []bool, mh *MuHash) {
+	v := db.GetRecord(ind)
 	if v == nil {
 		return // no such txid in UTXO (just ignore delete request)
 	}
@@ -656,24 +845,27 @@ func (db *UnspentDB) del(ind UtxoKeyType, outs []bool) {
 	var anyout bool
 	for i, rm := range outs {
 		if rm || UTXO_PURGE_UNSPENDABLE && rec.Outs[i] != nil && script.IsUnspendable(rec.Outs[i].PKScr) {
+			if rec.Outs[i] != nil && InCoreSet(rec.Outs[i]) {
+				var buf [0x100]byte
+				mh.Remove(coinSer(buf[:0], rec.TxID[:], uint32(i), rec.InBlock, rec.Coinbase, rec.Outs[i]))
+			}
 			rec.Outs[i] = nil
 		} else if !anyout && rec.Outs[i] != nil {
 			anyout = true
 		}
 	}
-	db.MapMutex[ind[0]].Lock()
 	if anyout {
 		vn := Serialize(rec, nil)
-		db.HashMap[ind[0]][ind] = vn
 		db.dataSize.Add(int64(len(*vn)) - int64(len(*v)))
+		db.recSet(ind, vn)
 	} else {
-		delete(db.HashMap[ind[0]], ind)
-		db.DeletedRecords[ind[0]]++
+		db.recDel(ind)
 		db.dataSize.Add(-int64(len(*v)))
 		db.totalTxs.Add(-1)
 	}
-	db.MapMutex[ind[0]].Unlock()
-	Memory_Free(v)
+	if db.disk == nil {
+		Memory_Free(v)
+	}
 }
 
 func (db *UnspentDB) commit(changes *BlockChanges) {
@@ -685,13 +877,16 @@ func (db *UnspentDB) commit(changes *BlockChanges) {
 		k UtxoKeyType
 	}
 	do_del := func(list []one_del_rec) {
+		mh := NewMuHash()
 		for _, rec := range list {
-			db.del(rec.k, rec.v)
+			db.del(rec.k, rec.v, mh)
 		}
+		db.muhashCombine(mh)
 		wg.Done()
 	}
 
 	do_add := func(list []*UtxoRec) {
+		mh := NewMuHash()
 		for _, rec := range list {
 			if db.CB.NotifyTxAdd != nil {
 				db.CB.NotifyTxAdd(rec)
@@ -713,13 +908,13 @@ func (db *UnspentDB) commit(changes *BlockChanges) {
 			if add_this_tx {
 				ind := *(*UtxoKeyType)(unsafe.Pointer(&rec.TxID[0]))
 				v := Serialize(rec, nil)
-				db.MapMutex[ind[0]].Lock()
-				db.HashMap[ind[0]][ind] = v
-				db.MapMutex[ind[0]].Unlock()
 				db.dataSize.Add(int64(len(*v)))
+				db.recSet(ind, v)
 				db.totalTxs.Add(1)
+				mh.updateRec(rec, nil, false)
 			}
 		}
+		db.muhashCombine(mh)
 		wg.Done()
 	}
 
@@ -816,9 +1011,8 @@ func (db *UnspentDB) UTXOStats() string {
 				}
 			)
 			rec := &sta_rec
-			db.MapMutex[_i].RLock()
-			atomic.AddUint64(&lele, uint64(len(db.HashMap[_i])))
-			for _, v := range db.HashMap[_i] {
+			db.BrowseRecords(_i, func(v *[]byte) bool {
+				atomic.AddUint64(&lele, 1)
 				reclen := uint64(len(*v))
 				atomic.AddUint64(&filesize, uint64(btc.VLenSize(reclen))+reclen)
 				NewUtxoRecOwn(*v, rec, &sta_cbs)
@@ -841,8 +1035,8 @@ func (db *UnspentDB) UTXOStats() string {
 				if !spendable_found {
 					atomic.AddUint64(&unspendable_recs, 1)
 				}
-			}
-			db.MapMutex[_i].RUnlock()
+				return true
+			})
 			wg.Done()
 		}(_i)
 	}
@@ -861,6 +1055,9 @@ func (db *UnspentDB) UTXOStats() string {
 		db.LastBlockHeight)
 	fmt.Fprintf(o, " Unspendable Outputs: %d (%dKB)  txs:%d    UTXO.db file size: %d (%d)\n",
 		unspendable, unspendable_bytes>>10, unspendable_recs, filesize, db.dataSize.Load())
+	if db.disk != nil {
+		o.WriteString(db.disk.stats())
+	}
 
 	return o.String()
 }
@@ -869,6 +1066,10 @@ func (db *UnspentDB) UTXOStats() string {
 func (db *UnspentDB) GetStats() (s string) {
 	var hml, dels int
 	for i := range db.HashMap {
+		if db.disk != nil {
+			hml += db.disk.count(i)
+			continue
+		}
 		db.MapMutex[i].RLock()
 		hml += len(db.HashMap[i])
 		dels += db.DeletedRecords[i]
@@ -885,9 +1086,16 @@ func (db *UnspentDB) GetStats() (s string) {
 		len(db.abortwritingnow) > 0, db.ComprssedUTXO)
 	fmt.Fprintf(wr, " Last Block : %s @ %d\n", btc.NewUint256(db.LastBlockHash).String(),
 		db.LastBlockHeight)
-	fmt.Fprintf(wr, " Defrags:  Maps:%d (%d no) in %s (%d%% dels),  Relocations: %d recs\n",
-		db.mapDefragsCnt, db.mapNoDefragsCnt, db.mapDefragsTime.String(), 100*dels/hml,
-		db.recRelocateCnt.Load())
+	if db.jrn != nil {
+		wr.WriteString(db.jrn.stats())
+	}
+	if db.disk != nil {
+		wr.WriteString(db.disk.stats())
+	} else {
+		fmt.Fprintf(wr, " Defrags:  Maps:%d (%d no) in %s (%d%% dels),  Relocations: %d recs\n",
+			db.mapDefragsCnt, db.mapNoDefragsCnt, db.mapDefragsTime.String(), 100*dels/hml,
+			db.recRelocateCnt.Load())
+	}
 	data, count, fprint := db.GetUTXOSize()
 	fmt.Fprintf(wr, " UTXO data size: %d bytes + (%d txs x %d) = %d bytes of footprint\n",
 		data, count, DATA_SIZE_EXTRA_PER_RECORD, fprint)
@@ -900,6 +1108,10 @@ func (db *UnspentDB) PurgeUnspendable(all bool) {
 	db.abortWriting()
 
 	for _i := range db.HashMap {
+		if db.disk != nil {
+			db.purgeDisk(_i, all, &unspendable_txs, &unspendable_recs)
+			continue
+		}
 		db.MapMutex[_i].Lock()
 		for k, v := range db.HashMap[_i] {
 			rec := NewUtxoRecStatic(*v)
@@ -931,6 +1143,12 @@ func (db *UnspentDB) PurgeUnspendable(all bool) {
 		db.MapMutex[_i].Unlock()
 	}
 
+	if unspendable_txs+unspendable_recs > 0 {
+		db.calcMuHash() // invalid P2PK outputs could have been removed
+	}
+	if db.disk != nil {
+		db.diskCommit(true)
+	}
 	db.Mutex.Unlock()
 
 	fmt.Println("Purged", unspendable_txs, "transactions and", unspendable_recs, "extra records")
@@ -938,6 +1156,10 @@ func (db *UnspentDB) PurgeUnspendable(all bool) {
 
 func (db *UnspentDB) GetUTXOSize() (data, count, footprint int) {
 	data, count = int(db.dataSize.Load()), int(db.totalTxs.Load())
+	if db.disk != nil {
+		footprint = count*DISK_INDEX_SIZE_PER_RECORD + db.disk.cached()
+		return
+	}
 	footprint = data + count*DATA_SIZE_EXTRA_PER_RECORD
 	return
 }
@@ -0,0 +1,873 @@
+package utxo
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/script"
+)
+
+/*
+Disk mode of the UTXO database (the "utxodisk" folder):
+  00000001.dat, 00000002.dat, ... - log files, made of these entries:
+    0x01, var_len, record - the record has been added or modified (it starts with its key)
+    0x02, [8]key - the record has been removed
+    0x03, [4]height, [1]flags, [32]block_hash, [384]MuHash - the entries before make the state after this block
+  Only the positions of the records are kept in memory, along with a cache of the recently used ones.
+  The changes are appended to the last file and made durable (followed by a commit entry) every few blocks.
+  After an unclean shutdown, whatever follows the last commit entry gets dropped.
+  When the files take over twice the size of the records in use, the oldest file is compacted
+  (the records still in use are appended to the last file) and removed.
+*/
+
+const (
+	DISK_DIR                   = "utxodisk"
+	DISK_FLUSH_SIZE            = 16 << 20       // commit the pending changes when there is this much of them
+	DISK_FILES_SLOTS           = 1 << 14        // numbers of the files in use must fit in a window of this size
+	DISK_INDEX_SIZE_PER_RECORD = UtxoIdxLen + 8 // key + position
+	DISK_CACHE_EXTRA           = 96             // approximate memory used by a cache entry, besides the record
+
+	diskPut            = 1
+	diskDel            = 2
+	diskCommit         = 3
+	diskCommitLen      = 1 + 4 + 1 + 32 + MUHASH_SIZE
+	diskFlagCompressed = 1
+)
+
+var diskFileSize int64 = 1 << 28 // start a new file when the last one would grow over it (256MB max)
+
+// diskLoc is position of a record: file slot (14 bits), offset of its entry (28 bits) and its length (22 bits).
+type diskLoc uint64
+
+func newDiskLoc(seq uint32, offs int64, le int) diskLoc {
+	return diskLoc(uint64(seq%DISK_FILES_SLOTS)<<50 | uint64(offs)<<22 | uint64(le))
+}
+
+func (l diskLoc) slot() uint32 {
+	return uint32(l >> 50)
+}
+
+func (l diskLoc) offs() int64 {
+	return int64(l>>22) & (1<<28 - 1)
+}
+
+func (l diskLoc) len() int {
+	return int(l & (1<<22 - 1))
+}
+
+// size returns the size of the record's entry.
+func (l diskLoc) size() int64 {
+	return int64(1 + btc.VLenSize(uint64(l.len())) + l.len())
+}
+
+type diskFile struct {
+	f    *os.File
+	seq  uint32
+	size int64 // written to the file
+	live int64 // size of the entries of the records in use
+}
+
+type diskState struct {
+	Height     uint32
+	Compressed bool
+	Hash       []byte // nil if nothing has been committed yet
+	MuHash     []byte
+}
+
+type diskRec struct {
+	v []byte
+	k UtxoKeyType
+}
+
+type diskStore struct {
+	dir         string
+	index       [256]map[UtxoKeyType]diskLoc
+	files       [DISK_FILES_SLOTS]*diskFile
+	first, last uint32 // numbers of the oldest and the newest file
+	buf         []byte // entries waiting to be written to the last file
+	uncommitted int    // size of the entries appended since the last commit
+	state       diskState
+	records     int64
+	dataSize    int64 // of the records in use
+	totalSize   int64 // of the files, with the pending entries
+	liveSize    int64 // of the entries of the records in use
+	compactions int
+
+	cache     map[UtxoKeyType]*list.Element
+	lru       list.List
+	cacheSize int
+	cacheMax  int
+
+	mut  sync.Mutex   // protects everything, but the content of the files
+	fmut sync.RWMutex // protects the files from being removed while they are read
+}
+
+func (st *diskStore) fname(seq uint32) string {
+	return fmt.Sprintf("%s%08x.dat", st.dir, seq)
+}
+
+// openDiskStore opens (or creates) the database in the given folder,
+// dropping the changes that have not been committed.
+func openDiskStore(dir string, cacheMax int) (st *diskStore, e error) {
+	if e = os.MkdirAll(dir, 0770); e != nil {
+		return
+	}
+	st = &diskStore{dir: dir, cacheMax: cacheMax, cache: make(map[UtxoKeyType]*list.Element)}
+	for i := range st.index {
+		st.index[i] = make(map[UtxoKeyType]diskLoc)
+	}
+
+	var seqs []uint32
+	names, _ := filepath.Glob(dir + "*.dat")
+	for _, n := range names {
+		var seq uint32
+		if _, er := fmt.Sscanf(filepath.Base(n), "%08x.dat", &seq); er == nil && seq > 0 {
+			seqs = append(seqs, seq)
+		}
+	}
+	slices.Sort(seqs)
+	if len(seqs) > 0 && seqs[len(seqs)-1]-seqs[0] >= DISK_FILES_SLOTS {
+		e = errors.New("too many files in " + dir)
+		return
+	}
+	for _, seq := range seqs {
+		f, er := os.OpenFile(st.fname(seq), os.O_RDWR, 0660)
+		if er != nil {
+			st.close()
+			e = er
+			return
+		}
+		st.files[seq%DISK_FILES_SLOTS] = &diskFile{f: f, seq: seq}
+	}
+
+	type diskOp struct {
+		k   UtxoKeyType
+		loc diskLoc // zero for removal
+	}
+	var ops []diskOp
+	var commit_seq uint32
+	var commit_pos, done, total int64
+	var rec []byte
+	for _, seq := range seqs {
+		if fi, er := st.files[seq%DISK_FILES_SLOTS].f.Stat(); er == nil {
+			total += fi.Size()
+		}
+	}
+
+replay:
+	for _, seq := range seqs {
+		df := st.files[seq%DISK_FILES_SLOTS]
+		rd := bufio.NewReaderSize(df.f, 0x100000)
+		for offs := int64(0); ; {
+			typ, er := rd.ReadByte()
+			if er != nil {
+				break // end of the file
+			}
+			n := int64(1)
+			switch typ {
+			case diskPut:
+				le, er := btc.ReadVLen(rd)
+				if er != nil || le < UtxoIdxLen || le >= 1<<22 {
+					break replay
+				}
+				if cap(rec) < int(le) {
+					rec = make([]byte, le)
+				}
+				rec = rec[:le]
+				if _, er = io.ReadFull(rd, rec); er != nil {
+					break replay
+				}
+				var k UtxoKeyType
+				copy(k[:], rec)
+				ops = append(ops, diskOp{k: k, loc: newDiskLoc(seq, offs, int(le))})
+				n += int64(btc.VLenSize(le)) + int64(le)
+			case diskDel:
+				var k UtxoKeyType
+				if _, er = io.ReadFull(rd, k[:]); er != nil {
+					break replay
+				}
+				ops = append(ops, diskOp{k: k})
+				n += UtxoIdxLen
+			case diskCommit:
+				var b [diskCommitLen - 1]byte
+				if _, er = io.ReadFull(rd, b[:]); er != nil {
+					break replay
+				}
+				for _, op := range ops {
+					st.setLoc(op.k, op.loc)
+				}
+				ops = ops[:0]
+				st.state.Height = binary.LittleEndian.Uint32(b[0:4])
+				st.state.Compressed = (b[4] & diskFlagCompressed) != 0
+				st.state.Hash = append([]byte{}, b[5:37]...)
+				st.state.MuHash = append([]byte{}, b[37:]...)
+				n += diskCommitLen - 1
+				commit_seq, commit_pos = seq, offs+n
+			default:
+				break replay
+			}
+			offs += n
+			df.size = offs
+			if done += n; total > 0 && (done-n)*100/total != done*100/total {
+				fmt.Print("\rLoading UTXO index from ", DISK_DIR, " - ", done*100/total, "% complete ... ")
+			}
+		}
+	}
+	fmt.Print("\r                                                                 \r")
+
+	// drop the changes that have not been committed
+	for _, seq := range seqs {
+		df := st.files[seq%DISK_FILES_SLOTS]
+		if seq > commit_seq {
+			df.f.Close()
+			os.Remove(st.fname(seq))
+			st.files[seq%DISK_FILES_SLOTS] = nil
+			continue
+		}
+		if seq == commit_seq {
+			if e = df.f.Truncate(commit_pos); e != nil {
+				st.close()
+				return
+			}
+			df.size = commit_pos
+		}
+		if st.first == 0 {
+			st.first = seq
+		}
+		st.last = seq
+		st.totalSize += df.size
+	}
+	if st.last == 0 {
+		st.first = 1
+		if e = st.createFile(1); e != nil {
+			st.close()
+		}
+	}
+	return
+}
+
+// createFile creates a new last file. Call it with the mutex locked.
+func (st *diskStore) createFile(seq uint32) (e error) {
+	if st.files[seq%DISK_FILES_SLOTS] != nil {
+		return errors.New("too many files in " + st.dir)
+	}
+	f, e := os.OpenFile(st.fname(seq), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0660)
+	if e != nil {
+		return
+	}
+	// the records committed to the new file would be lost in a crash, if its directory entry was
+	if e = syncDir(st.dir); e != nil {
+		f.Close()
+		return
+	}
+	st.files[seq%DISK_FILES_SLOTS] = &diskFile{f: f, seq: seq}
+	st.last = seq
+	return
+}
+
+// syncDir flushes the directory's entries to disk (not supported on Windows, where it is not needed).
+func syncDir(dir string) (e error) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	d, e := os.Open(dir)
+	if e != nil {
+		return
+	}
+	e = d.Sync()
+	d.Close()
+	return
+}
+
+// setLoc sets the position of the record with the given key (zero to remove it). Call it with the mutex locked.
+func (st *diskStore) setLoc(k UtxoKeyType, loc diskLoc) {
+	if old, ok := st.index[k[0]][k]; ok {
+		st.files[old.slot()].live -= old.size()
+		st.liveSize -= old.size()
+		st.dataSize -= int64(old.len())
+		st.records--
+	}
+	if loc == 0 {
+		delete(st.index[k[0]], k)
+		return
+	}
+	st.index[k[0]][k] = loc
+	st.files[loc.slot()].live += loc.size()
+	st.liveSize += loc.size()
+	st.dataSize += int64(loc.len())
+	st.records++
+}
+
+// append adds an entry to the pending ones and returns its offset within the last file.
+// Call it with the mutex locked.
+func (st *diskStore) append(typ byte, data []byte) (offs int64) {
+	var hdr [1 + 5]byte
+	hdr[0] = typ
+	n := 1
+	if typ == diskPut {
+		n += int(btc.PutVlen(hdr[1:], len(data)))
+	}
+	df := st.files[st.last%DISK_FILES_SLOTS]
+	if offs = df.size + int64(len(st.buf)); offs > 0 && offs+int64(n+len(data)) > diskFileSize {
+		st.write(true)
+		if e := st.createFile(st.last + 1); e != nil {
+			panic("UTXO disk: " + e.Error())
+		}
+		offs = 0
+	}
+	st.buf = append(st.buf, hdr[:n]...)
+	st.buf = append(st.buf, data...)
+	st.totalSize += int64(n + len(data))
+	st.uncommitted += n + len(data)
+	if len(st.buf) >= DISK_FLUSH_SIZE {
+		st.write(false) // not committed yet, so it will be dropped if we crash
+	}
+	return
+}
+
+// write stores the pending entries in the last file. Call it with the mutex locked.
+func (st *diskStore) write(sync bool) {
+	df := st.files[st.last%DISK_FILES_SLOTS]
+	if len(st.buf) > 0 {
+		if _, e := df.f.WriteAt(st.buf, df.size); e != nil {
+			panic("UTXO disk: " + e.Error())
+		}
+		df.size += int64(len(st.buf))
+		st.buf = st.buf[:0]
+	}
+	if sync {
+		if e := df.f.Sync(); e != nil {
+			panic("UTXO disk: " + e.Error())
+		}
+	}
+}
+
+// commit writes the pending changes to disk, as the state after the given block.
+func (st *diskStore) commit(s *diskState) {
+	var b [diskCommitLen - 1]byte
+	binary.LittleEndian.PutUint32(b[0:4], s.Height)
+	if s.Compressed {
+		b[4] = diskFlagCompressed
+	}
+	copy(b[5:37], s.Hash)
+	copy(b[37:], s.MuHash)
+	st.mut.Lock()
+	st.append(diskCommit, b[:])
+	st.write(true)
+	st.uncommitted = 0
+	st.state = diskState{Height: s.Height, Compressed: s.Compressed,
+		Hash: append([]byte{}, b[5:37]...), MuHash: append([]byte{}, b[37:]...)}
+	st.mut.Unlock()
+}
+
+// pending returns the size of the changes that have not been committed yet.
+func (st *diskStore) pending() int {
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	return st.uncommitted
+}
+
+// readLoc reads the record from the given position. Call it with fmut locked for reading.
+func (st *diskStore) readLoc(loc diskLoc) (v []byte) {
+	v = make([]byte, loc.len())
+	offs := loc.offs() + int64(1+btc.VLenSize(uint64(loc.len())))
+	st.mut.Lock()
+	df := st.files[loc.slot()]
+	if pos := offs - df.size; pos >= 0 { // not written yet
+		copy(v, st.buf[pos:])
+		st.mut.Unlock()
+		return
+	}
+	st.mut.Unlock()
+	if _, e := df.f.ReadAt(v, offs); e != nil {
+		panic("UTXO disk: " + e.Error())
+	}
+	return
+}
+
+// get returns the record with the given key (nil if there is no such).
+// If cache is set, the record stays in the memory as the most recently used one.
+func (st *diskStore) get(k UtxoKeyType, cache bool) (v []byte) {
+	st.fmut.RLock()
+	defer st.fmut.RUnlock()
+	st.mut.Lock()
+	if el := st.cache[k]; el != nil {
+		st.lru.MoveToFront(el)
+		v = el.Value.(*diskRec).v
+		st.mut.Unlock()
+		return
+	}
+	loc, ok := st.index[k[0]][k]
+	st.mut.Unlock()
+	if !ok {
+		return
+	}
+	v = st.readLoc(loc)
+	if cache {
+		st.mut.Lock()
+		if st.index[k[0]][k] == loc {
+			st.addCache(k, v)
+		}
+		st.mut.Unlock()
+	}
+	return
+}
+
+// has returns true if there is a record with the given key.
+func (st *diskStore) has(k UtxoKeyType) (ok bool) {
+	st.mut.Lock()
+	_, ok = st.index[k[0]][k]
+	st.mut.Unlock()
+	return
+}
+
+// put adds (or replaces) the record. The data is copied.
+func (st *diskStore) put(k UtxoKeyType, v []byte, cache bool) {
+	st.mut.Lock()
+	offs := st.append(diskPut, v)
+	st.setLoc(k, newDiskLoc(st.last, offs, len(v)))
+	if cache {
+		st.addCache(k, append([]byte{}, v...))
+	} else {
+		st.uncache(k)
+	}
+	st.mut.Unlock()
+}
+
+// del removes the record with the given key.
+func (st *diskStore) del(k UtxoKeyType) {
+	st.mut.Lock()
+	if _, ok := st.index[k[0]][k]; ok {
+		st.append(diskDel, k[:])
+		st.setLoc(k, 0)
+		st.uncache(k)
+	}
+	st.mut.Unlock()
+}
+
+// addCache puts the record into the cache, removing the least recently used ones if needed.
+// Call it with the mutex locked.
+func (st *diskStore) addCache(k UtxoKeyType, v []byte) {
+	if st.cacheMax <= 0 {
+		return
+	}
+	if el := st.cache[k]; el != nil {
+		r := el.Value.(*diskRec)
+		st.cacheSize += len(v) - len(r.v)
+		r.v = v
+		st.lru.MoveToFront(el)
+	} else {
+		st.cache[k] = st.lru.PushFront(&diskRec{k: k, v: v})
+		st.cacheSize += len(v) + DISK_CACHE_EXTRA
+	}
+	for st.cacheSize > st.cacheMax {
+		st.uncache(st.lru.Back().Value.(*diskRec).k)
+	}
+}
+
+// uncache removes the record from the cache. Call it with the mutex locked.
+func (st *diskStore) uncache(k UtxoKeyType) {
+	if el := st.cache[k]; el != nil {
+		st.cacheSize -= len(el.Value.(*diskRec).v) + DISK_CACHE_EXTRA
+		st.lru.Remove(el)
+		delete(st.cache, k)
+	}
+}
+
+// browse calls walk for each record with the key starting from the given byte, until it returns false.
+// The records are not cached. The ones modified in the meantime may be seen in their previous versions.
+func (st *diskStore) browse(i int, walk func(v []byte) bool) {
+	st.fmut.RLock()
+	defer st.fmut.RUnlock()
+	st.mut.Lock()
+	locs := make([]diskLoc, 0, len(st.index[i]))
+	for _, loc := range st.index[i] {
+		locs = append(locs, loc)
+	}
+	st.mut.Unlock()
+	slices.Sort(locs) // to read each file from its beginning
+	for _, loc := range locs {
+		if !walk(st.readLoc(loc)) {
+			break
+		}
+	}
+}
+
+// keys returns the keys of all the records.
+func (st *diskStore) keys() (res []UtxoKeyType) {
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	res = make([]UtxoKeyType, 0, st.records)
+	for i := range st.index {
+		for k := range st.index[i] {
+			res = append(res, k)
+		}
+	}
+	return
+}
+
+// count returns the number of records with the key starting from the given byte.
+func (st *diskStore) count(i int) int {
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	return len(st.index[i])
+}
+
+// cached returns the (approximate) size of the memory used by the cache.
+func (st *diskStore) cached() int {
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	return st.cacheSize
+}
+
+// needCompact returns true if the oldest file should be compacted.
+func (st *diskStore) needCompact() bool {
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	return st.first != st.last && st.totalSize > 2*st.liveSize+diskFileSize
+}
+
+// compact moves the records in use from the oldest file to the last one and removes the oldest file.
+// Call it right after commit, so nothing is pending.
+func (st *diskStore) compact() {
+	st.mut.Lock()
+	df := st.files[st.first%DISK_FILES_SLOTS]
+	s := st.state
+	st.mut.Unlock()
+
+	rd := bufio.NewReaderSize(io.NewSectionReader(df.f, 0, df.size), 0x100000)
+	var rec []byte
+	for offs := int64(0); offs < df.size; {
+		typ, e := rd.ReadByte()
+		if e != nil {
+			panic("UTXO disk: " + e.Error())
+		}
+		switch typ {
+		case diskPut:
+			le, e := btc.ReadVLen(rd)
+			if e != nil {
+				panic("UTXO disk: " + e.Error())
+			}
+			if cap(rec) < int(le) {
+				rec = make([]byte, le)
+			}
+			rec = rec[:le]
+			if _, e = io.ReadFull(rd, rec); e != nil {
+				panic("UTXO disk: " + e.Error())
+			}
+			var k UtxoKeyType
+			copy(k[:], rec)
+			loc := newDiskLoc(df.seq, offs, int(le))
+			st.mut.Lock()
+			if st.index[k[0]][k] == loc {
+				o := st.append(diskPut, rec)
+				st.setLoc(k, newDiskLoc(st.last, o, len(rec)))
+			}
+			st.mut.Unlock()
+			offs += loc.size()
+		case diskDel:
+			rd.Discard(UtxoIdxLen)
+			offs += 1 + UtxoIdxLen
+		case diskCommit:
+			rd.Discard(diskCommitLen - 1)
+			offs += diskCommitLen
+		default:
+			panic(fmt.Sprint("UTXO disk: bad entry in ", st.fname(df.seq), " at offset ", offs))
+		}
+	}
+	st.commit(&s)
+
+	st.fmut.Lock()
+	st.mut.Lock()
+	df.f.Close()
+	os.Remove(st.fname(df.seq))
+	st.files[df.seq%DISK_FILES_SLOTS] = nil
+	st.totalSize -= df.size
+	for st.first++; st.files[st.first%DISK_FILES_SLOTS] == nil; st.first++ {
+	}
+	st.compactions++
+	st.mut.Unlock()
+	st.fmut.Unlock()
+}
+
+// moveTo renames the database's folder.
+func (st *diskStore) moveTo(dir string) (e error) {
+	st.fmut.Lock()
+	defer st.fmut.Unlock()
+	for _, df := range st.files {
+		if df != nil {
+			df.f.Close()
+		}
+	}
+	if e = os.Rename(filepath.Clean(st.dir), filepath.Clean(dir)); e == nil {
+		st.dir = dir
+	}
+	for _, df := range st.files {
+		if df != nil {
+			if f, er := os.OpenFile(st.fname(df.seq), os.O_RDWR, 0660); er == nil {
+				df.f = f
+			} else if e == nil {
+				e = er
+			}
+		}
+	}
+	return
+}
+
+// close closes the files (the pending changes are lost).
+func (st *diskStore) close() {
+	st.fmut.Lock()
+	for _, df := range st.files {
+		if df != nil {
+			df.f.Close()
+		}
+	}
+	st.fmut.Unlock()
+}
+
+func (st *diskStore) stats() string {
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	return fmt.Sprintf(" Disk: %d files (%d MB, %d MB in use)  Pending: %d KB  Compactions: %d  Cache: %d recs (%d MB)\n",
+		st.last-st.first+1, st.totalSize>>20, st.liveSize>>20, st.uncommitted>>10, st.compactions,
+		len(st.cache), st.cacheSize>>20)
+}
+
+// openDisk opens the database in disk mode, converting UTXO.db if the disk mode's folder is empty.
+func (db *UnspentDB) openDisk(opts *NewUnspentOpts) {
+	dir := db.dir_utxo + DISK_DIR + string(os.PathSeparator)
+	if opts.Rescan {
+		os.RemoveAll(dir)
+	}
+	st, e := openDiskStore(dir, opts.DiskCache)
+	if e != nil {
+		panic("UTXO disk: " + e.Error())
+	}
+	db.disk = st
+	if st.state.Hash == nil && !opts.Rescan {
+		if e = db.diskImport(opts.AbortNow); e != nil {
+			println("UTXO disk:", e.Error())
+			st.close()
+			os.RemoveAll(dir)
+			if st, e = openDiskStore(dir, opts.DiskCache); e != nil {
+				panic("UTXO disk: " + e.Error())
+			}
+			db.disk = st
+		}
+	}
+	if st.state.Hash != nil {
+		db.LastBlockHeight = st.state.Height
+		db.LastBlockHash = st.state.Hash
+		db.ComprssedUTXO = st.state.Compressed
+		db.muhash.SetBytes(st.state.MuHash)
+	}
+	db.totalTxs.Store(st.records)
+	db.dataSize.Store(st.dataSize)
+	atomic.StoreUint32(&db.CurrentHeightOnDisk, db.LastBlockHeight)
+	if db.ComprssedUTXO {
+		NewUtxoRecOwn = NewUtxoRecOwnC
+		OneUtxoRec = OneUtxoRecC
+		Serialize = SerializeC
+	}
+}
+
+// diskImport moves the records from UTXO.db (or UTXO.old) to the empty disk mode's folder.
+func (db *UnspentDB) diskImport(abort *bool) (e error) {
+	var u64, cnt uint64
+	var muhash [MUHASH_SIZE]byte
+	var rec []byte
+	var perc uint64
+
+	fname := db.dir_utxo + "UTXO.db"
+	if _, er := os.Stat(fname); er != nil {
+		fname = db.dir_utxo + "UTXO.old"
+	}
+	f, e := os.Open(fname)
+	if e != nil {
+		if os.IsNotExist(e) {
+			e = nil // nothing to import
+		}
+		return
+	}
+	defer f.Close()
+	rd := bufio.NewReaderSize(f, 0x100000)
+
+	s := diskState{Hash: make([]byte, 32)}
+	if e = binary.Read(rd, binary.LittleEndian, &u64); e != nil {
+		return
+	}
+	s.Height = uint32(u64)
+	s.Compressed = (u64 & 0x8000000000000000) != 0
+	if _, e = io.ReadFull(rd, s.Hash); e != nil {
+		return
+	}
+	if e = binary.Read(rd, binary.LittleEndian, &cnt); e != nil {
+		return
+	}
+	for i := uint64(0); i < cnt; i++ {
+		if abort != nil && *abort {
+			return errors.New("aborted")
+		}
+		le, er := btc.ReadVLen(rd)
+		if er != nil {
+			return er
+		}
+		if cap(rec) < int(le) {
+			rec = make([]byte, le)
+		}
+		rec = rec[:le]
+		if _, e = io.ReadFull(rd, rec); e != nil {
+			return
+		}
+		var k UtxoKeyType
+		copy(k[:], rec)
+		db.disk.put(k, rec, false)
+		if p := 100 * (i + 1) / cnt; p != perc {
+			perc = p
+			fmt.Print("\rMoving ", cnt, " txs from ", filepath.Base(fname), " to ", DISK_DIR, " - ", perc, "% complete ... ")
+		}
+	}
+	fmt.Print("\r                                                                 \r")
+	if _, er := io.ReadFull(rd, muhash[:]); er == nil {
+		s.MuHash = muhash[:]
+	} else {
+		// older versions did not store MuHash in UTXO.db
+		if s.Compressed {
+			NewUtxoRecOwn = NewUtxoRecOwnC
+			OneUtxoRec = OneUtxoRecC
+			Serialize = SerializeC
+		}
+		fmt.Print("Calculating MuHash of the UTXO set ... ")
+		db.calcMuHash()
+		fmt.Print("\r                                                                 \r")
+		s.MuHash = db.muhash.Bytes()
+	}
+	db.disk.commit(&s)
+	f.Close()
+	os.Remove(db.dir_utxo + "UTXO.db")
+	os.Remove(db.dir_utxo + "UTXO.old")
+	return
+}
+
+// loadFromDisk loads the records from the disk mode's folder, if there is one,
+// so the database can switch back to memory mode. UTXO.db replaces the folder once it is saved.
+func (db *UnspentDB) loadFromDisk() bool {
+	dir := db.dir_utxo + DISK_DIR + string(os.PathSeparator)
+	if _, er := os.Stat(dir); er != nil {
+		return false
+	}
+	st, e := openDiskStore(dir, 0)
+	if e != nil || st.state.Hash == nil {
+		if st != nil {
+			st.close()
+		}
+		return false
+	}
+	defer st.close()
+	for i := range db.HashMap {
+		db.HashMap[i] = make(map[UtxoKeyType]*[]byte, st.count(i))
+		st.browse(i, func(v []byte) bool {
+			var k UtxoKeyType
+			copy(k[:], v)
+			p := Memory_Malloc(len(v))
+			copy(*p, v)
+			db.HashMap[i][k] = p
+			return true
+		})
+		if (i+1)%16 == 0 {
+			fmt.Print("\rLoading UTXO records from ", DISK_DIR, " - ", 100*(i+1)/len(db.HashMap), "% complete ... ")
+		}
+	}
+	fmt.Print("\r                                                                 \r")
+	db.LastBlockHeight = st.state.Height
+	db.LastBlockHash = st.state.Hash
+	db.ComprssedUTXO = st.state.Compressed
+	db.muhash.SetBytes(st.state.MuHash)
+	db.totalTxs.Store(st.records)
+	db.dataSize.Store(st.dataSize)
+	if db.ComprssedUTXO {
+		NewUtxoRecOwn = NewUtxoRecOwnC
+		OneUtxoRec = OneUtxoRecC
+		Serialize = SerializeC
+	}
+	db.diskLeft = true
+	db.DirtyDB.Set() // CurrentHeightOnDisk stays zero, so UTXO.db gets written soon
+	return true
+}
+
+// diskCommit makes the pending changes durable, if there is enough of them or if force is set.
+// Call it with the mutex locked.
+func (db *UnspentDB) diskCommit(force bool) {
+	if !force && db.disk.pending() < DISK_FLUSH_SIZE {
+		return
+	}
+	db.muMutex.Lock()
+	muhash := db.muhash.Bytes()
+	db.muMutex.Unlock()
+	db.disk.commit(&diskState{Height: db.LastBlockHeight, Compressed: db.ComprssedUTXO,
+		Hash: db.LastBlockHash, MuHash: muhash})
+	atomic.StoreUint32(&db.CurrentHeightOnDisk, db.LastBlockHeight)
+	db.DirtyDB.Clr()
+	if db.disk.needCompact() {
+		db.disk.compact()
+	}
+}
+
+// purgeDisk removes the unspendable outputs from the records with the key starting from byte i
+// (only the records with no spendable outputs, unless all is set). Call it with the mutex locked.
+func (db *UnspentDB) purgeDisk(i int, all bool, txs, recs *uint64) {
+	type change struct {
+		v *[]byte // nil to remove the record
+		k UtxoKeyType
+	}
+	var list []change
+	db.disk.browse(i, func(v []byte) bool {
+		rec := NewUtxoRecStatic(v)
+		var spendable_found bool
+		var record_removed uint64
+		for idx, r := range rec.Outs {
+			if r != nil {
+				if script.IsUnspendable(r.PKScr) {
+					if all {
+						rec.Outs[idx] = nil
+						record_removed++
+					}
+				} else {
+					spendable_found = true
+				}
+			}
+		}
+		var k UtxoKeyType
+		copy(k[:], v)
+		if !spendable_found {
+			list = append(list, change{k: k})
+			db.dataSize.Add(-int64(len(v)))
+			db.totalTxs.Add(-1)
+			*txs++
+		} else if record_removed > 0 {
+			nv := Serialize(rec, nil)
+			list = append(list, change{k: k, v: nv})
+			db.dataSize.Add(int64(len(*nv)) - int64(len(v)))
+			*recs += record_removed
+		}
+		return true
+	})
+	for _, c := range list {
+		if c.v == nil {
+			db.recDel(c.k)
+		} else {
+			db.recSet(c.k, c.v)
+		}
+	}
+}
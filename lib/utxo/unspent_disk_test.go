@@ -0,0 +1,162 @@
+package utxo
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+func testDiskRec(id byte, height uint32, values ...uint64) *UtxoRec {
+	rec := &UtxoRec{InBlock: height}
+	rec.TxID[0] = id
+	rec.TxID[31] = id
+	for _, v := range values {
+		rec.Outs = append(rec.Outs, &UtxoTxOut{Value: v, PKScr: []byte{0x51}})
+	}
+	return rec
+}
+
+// testDiskBlocks applies the same two blocks to the database.
+func testDiskBlocks(db *UnspentDB) {
+	db.CommitBlockTxs(&BlockChanges{Height: 1, AddList: []*UtxoRec{
+		testDiskRec(1, 1, 1000, 2000), testDiskRec(2, 1, 3000), testDiskRec(3, 1, 4000, 5000, 6000)}},
+		bytes.Repeat([]byte{0x11}, 32))
+	db.CommitBlockTxs(&BlockChanges{Height: 2, AddList: []*UtxoRec{testDiskRec(4, 2, 7000)},
+		DeledTxs: map[[32]byte][]bool{
+			testDiskRec(2, 0).TxID: {true},
+			testDiskRec(3, 0).TxID: {false, true, false},
+		}}, bytes.Repeat([]byte{0x22}, 32))
+}
+
+func testDiskCheck(t *testing.T, db *UnspentDB) {
+	if db.LastBlockHeight != 2 || !bytes.Equal(db.LastBlockHash, bytes.Repeat([]byte{0x22}, 32)) {
+		t.Error("bad last block", db.LastBlockHeight)
+	}
+	if _, cnt, _ := db.GetUTXOSize(); cnt != 3 {
+		t.Error("bad txs count", cnt)
+	}
+	for _, c := range []struct {
+		id    byte
+		vout  uint32
+		value uint64 // zero if spent
+	}{{1, 0, 1000}, {1, 1, 2000}, {2, 0, 0}, {3, 0, 4000}, {3, 1, 0}, {3, 2, 6000}, {4, 0, 7000}} {
+		po := &btc.TxPrevOut{Hash: testDiskRec(c.id, 0).TxID, Vout: c.vout}
+		out := db.UnspentGet(po)
+		if c.value == 0 && out != nil || c.value != 0 && (out == nil || out.Value != c.value) {
+			t.Error("bad output", c.id, c.vout, out)
+		}
+	}
+}
+
+func TestDiskMode(t *testing.T) {
+	dir := t.TempDir() + string(os.PathSeparator)
+	db := NewUnspentDb(&NewUnspentOpts{Dir: dir, DiskMode: true, DiskCache: 1 << 20})
+	testDiskBlocks(db)
+	testDiskCheck(t, db)
+	db.Close()
+
+	mem := NewUnspentDb(&NewUnspentOpts{Dir: t.TempDir() + string(os.PathSeparator), Rescan: true})
+	testDiskBlocks(mem)
+
+	db = NewUnspentDb(&NewUnspentOpts{Dir: dir, DiskMode: true})
+	testDiskCheck(t, db)
+	if !bytes.Equal(db.MuHash(), mem.MuHash()) {
+		t.Error("MuHash mismatch")
+	}
+	if !bytes.Equal(db.SnapshotHash(), mem.SnapshotHash()) {
+		t.Error("SnapshotHash mismatch")
+	}
+	db.Close()
+
+	// switch back to memory mode
+	db = NewUnspentDb(&NewUnspentOpts{Dir: dir})
+	testDiskCheck(t, db)
+	if !bytes.Equal(db.MuHash(), mem.MuHash()) {
+		t.Error("MuHash mismatch in memory mode")
+	}
+	db.HurryUp()
+	db.Close()
+	if _, er := os.Stat(dir + DISK_DIR); !os.IsNotExist(er) {
+		t.Error(DISK_DIR, "not removed after saving UTXO.db")
+	}
+
+	// and to disk mode again, importing UTXO.db
+	db = NewUnspentDb(&NewUnspentOpts{Dir: dir, DiskMode: true})
+	testDiskCheck(t, db)
+	if !bytes.Equal(db.MuHash(), mem.MuHash()) {
+		t.Error("MuHash mismatch after import")
+	}
+	db.Close()
+	if _, er := os.Stat(dir + "UTXO.db"); !os.IsNotExist(er) {
+		t.Error("UTXO.db not removed after import")
+	}
+}
+
+func TestDiskStoreRecovery(t *testing.T) {
+	dir := t.TempDir() + string(os.PathSeparator)
+	st, er := openDiskStore(dir, 0)
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	k1, k2 := UtxoKeyType{1}, UtxoKeyType{2}
+	st.put(k1, append(k1[:], 1, 2, 3), false)
+	st.commit(&diskState{Height: 5, Hash: make([]byte, 32), MuHash: make([]byte, MUHASH_SIZE)})
+	st.put(k2, append(k2[:], 4, 5, 6), false)
+	st.del(k1)
+	st.write(false) // written, but never committed
+	st.close()
+
+	if st, er = openDiskStore(dir, 0); er != nil {
+		t.Fatal(er.Error())
+	}
+	defer st.close()
+	if st.state.Height != 5 || st.records != 1 {
+		t.Fatal("bad state after recovery", st.state.Height, st.records)
+	}
+	if v := st.get(k1, false); !bytes.Equal(v, append(k1[:], 1, 2, 3)) {
+		t.Error("committed record lost")
+	}
+	if st.has(k2) {
+		t.Error("uncommitted record present")
+	}
+}
+
+func TestDiskStoreCompact(t *testing.T) {
+	defer func(s int64) { diskFileSize = s }(diskFileSize)
+	diskFileSize = 1000
+
+	dir := t.TempDir() + string(os.PathSeparator)
+	st, er := openDiskStore(dir, 100)
+	if er != nil {
+		t.Fatal(er.Error())
+	}
+	s := &diskState{Height: 1, Hash: make([]byte, 32), MuHash: make([]byte, MUHASH_SIZE)}
+	for i := 0; i < 200; i++ {
+		k := UtxoKeyType{byte(i % 10)}
+		st.put(k, append(k[:], byte(i), byte(i>>8)), i%3 == 0)
+		st.commit(s)
+		for st.needCompact() {
+			st.compact()
+		}
+	}
+	if st.compactions == 0 {
+		t.Error("nothing compacted")
+	}
+	st.close()
+
+	if st, er = openDiskStore(dir, 0); er != nil {
+		t.Fatal(er.Error())
+	}
+	defer st.close()
+	if st.records != 10 {
+		t.Error("bad records count", st.records)
+	}
+	for i := 190; i < 200; i++ {
+		k := UtxoKeyType{byte(i % 10)}
+		if v := st.get(k, false); !bytes.Equal(v, append(k[:], byte(i), byte(i>>8))) {
+			t.Error("bad record", i, v)
+		}
+	}
+}
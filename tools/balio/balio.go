@@ -2,15 +2,13 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
-	"errors"
 	"fmt"
 	"github.com/piotrnar/gocoin"
 	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/lib/others/ltc"
+	"github.com/piotrnar/gocoin/lib/others/socks5"
 	"github.com/piotrnar/gocoin/lib/others/utils"
 	"github.com/piotrnar/gocoin/lib/utxo"
-	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -43,62 +41,7 @@ func print_help() {
 
 func dials5(tcp, dest string) (conn net.Conn, err error) {
 	//println("Tor'ing to", dest, "via", proxy)
-	var buf [10]byte
-	var host, ps string
-	var port uint64
-
-	conn, err = net.Dial(tcp, proxy)
-	if err != nil {
-		return
-	}
-
-	_, err = conn.Write([]byte{5, 1, 0})
-	if err != nil {
-		return
-	}
-
-	_, err = io.ReadFull(conn, buf[:2])
-	if err != nil {
-		return
-	}
-
-	if buf[0] != 5 {
-		err = errors.New("We only support SOCKS5 proxy.")
-	} else if buf[1] != 0 {
-		err = errors.New("SOCKS proxy connection refused.")
-		return
-	}
-
-	host, ps, err = net.SplitHostPort(dest)
-	if err != nil {
-		return
-	}
-
-	port, err = strconv.ParseUint(ps, 10, 16)
-	if err != nil {
-		return
-	}
-
-	req := make([]byte, 5+len(host)+2)
-	copy(req[:4], []byte{5, 1, 0, 3})
-	req[4] = byte(len(host))
-	copy(req[5:], []byte(host))
-	binary.BigEndian.PutUint16(req[len(req)-2:], uint16(port))
-	_, err = conn.Write(req)
-	if err != nil {
-		return
-	}
-
-	_, err = io.ReadFull(conn, buf[:])
-	if err != nil {
-		return
-	}
-
-	if buf[1] != 0 {
-		err = errors.New("SOCKS proxy connection terminated.")
-	}
-
-	return
+	return socks5.Dial(proxy, dest, nil, 0)
 }
 
 func splitHostPort(addr string) (host string, port uint16, err error) {